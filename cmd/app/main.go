@@ -2,54 +2,250 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"jokedb/intetnal/app"
 	"jokedb/intetnal/compute"
 	"jokedb/intetnal/config"
+	"jokedb/intetnal/httpapi"
 	"jokedb/intetnal/logger"
+	"jokedb/intetnal/notify"
+	"jokedb/intetnal/raft"
+	"jokedb/intetnal/slowlog"
 	"jokedb/intetnal/storage"
 	"jokedb/intetnal/storage/engine"
 	"jokedb/intetnal/tcp"
 	"jokedb/intetnal/wal"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+const httpReadHeaderTimeout = 10 * time.Second
+
 func runApp() error {
-	appConfig, err := config.Init(app.ConfigPah)
+	flags := config.RegisterFlags(flag.CommandLine, app.ConfigPah)
+	flag.Parse()
+
+	appConfig, err := config.Load(*flags.ConfigFile, flags.Overrides())
 	if err != nil {
 		return err
 	}
-	if err = logger.Init(appConfig.DevMode, app.Name, appConfig.Log.Level); err != nil {
+	if *flags.PrintConfig {
+		out, errMarshal := config.Marshal(appConfig)
+		if errMarshal != nil {
+			return errMarshal
+		}
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	if err = config.CheckDirs(appConfig); err != nil {
 		return err
 	}
 
-	wallog, err := wal.Open(
-		wal.WithDirPath(appConfig.WAL.DirPath),
-		wal.WithMaxSizeSegment(appConfig.WAL.MaxSizeSegment),
-	)
-	if err != nil {
+	if err = logger.Init(appConfig.DevMode, app.Name, appConfig.Log.LoggerOptions()); err != nil {
 		return err
 	}
+	go reopenLogsOnSignal()
 
-	s, err := storage.New(engine.New(), wallog, appConfig.WAL.FlushingBatchSize, appConfig.WAL.FlushingBatchTimeout)
+	var wallog *wal.WAL
+	var node *raft.Node
+	opts := []storage.Option{storage.WithDatabases(appConfig.Databases)}
+	if appConfig.Raft.Enabled {
+		if node, err = newRaftNode(appConfig.Raft); err != nil {
+			return err
+		}
+		// a replicated write waits for raft.minReplicas followers, a majority by default
+		opts = append(opts, storage.WithReplicator(node), storage.WithReplicas(node, appConfig.Raft.Replicas()))
+	} else {
+		wallog, err = wal.Open(
+			wal.WithDirPath(appConfig.WAL.DirPath),
+			wal.WithMaxSizeSegment(appConfig.WAL.MaxSizeSegment),
+			appConfig.WAL.SyncOption(),
+		)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, storage.WithRecoveryTarget(appConfig.WAL.RecoveryTarget()), appConfig.WAL.PendingQueueOption())
+	}
+
+	s, err := storage.New(
+		engine.New(), wallog,
+		appConfig.WAL.FlushingBatchSize,
+		appConfig.WAL.FlushingBatchTimeout,
+		opts...,
+	)
 	if err != nil {
 		return err
 	}
+	if r := s.Recovery(); r.Stopped {
+		logger.L().Infof("recovery stopped at %s: replayed %d records up to LSN %d", appConfig.WAL.RecoveryTarget(), r.Records, r.LSN)
+		if r.Timeline != nil {
+			logger.L().Infof("new timeline from LSN %d, the later segments are archived in %s", r.LSN, r.Timeline.Archive)
+		}
+	}
+	var raftStatus func() raft.Status
+	if node != nil {
+		if err = startRaftNode(appConfig.Raft, node, s); err != nil {
+			return err
+		}
+		raftStatus = node.Status
+	}
 
-	db := app.New(compute.New(), s)
+	// serv is set below, the INFO command reads its connection counts only while it is serving
+	var serv *tcp.Server
+	clients := tcp.NewRegistry()
+	slowLog := slowlog.New(appConfig.SlowLog.Threshold, appConfig.SlowLog.MaxLen, appConfig.SlowLog.MaxArgLen)
+	hub := notify.NewHub(appConfig.Notifications.Options())
+	appOpts := []app.Option{
+		app.WithSlowLog(slowLog),
+		app.WithNotifications(hub, appConfig.Notifications.BufferSize),
+		app.WithClients(clients),
+		app.WithScriptTimeout(appConfig.Scripting.Timeout),
+		app.WithScriptMaxValueSize(appConfig.Scripting.MaxValueSize),
+		app.WithInfo(app.InfoOptions{
+			ConfigFile: *flags.ConfigFile,
+			Addr:       appConfig.Addr,
+			ConnStats:  func() tcp.ConnStats { return serv.ConnStats() },
+			Raft:       raftStatus,
+		}),
+	}
+	if appConfig.Cluster.Enabled {
+		state, errCluster := appConfig.Cluster.State()
+		if errCluster != nil {
+			return errCluster
+		}
+		appOpts = append(appOpts, app.WithCluster(state))
+		logger.L().Infof("cluster node %s serving slots of %d nodes", state.Self(), len(state.Nodes()))
+	}
+	db := app.New(compute.New(), s, appOpts...)
 
-	serv, err := tcp.NewServer(appConfig.Addr, appConfig.MaxConnections, logger.L(), db.Handle)
+	serv, err = tcp.NewServer(appConfig.Addr, appConfig.MaxConnections, logger.L(), db.Handle,
+		tcp.WithTimeouts(appConfig.Connections.Timeouts()),
+		tcp.WithAdmission(appConfig.Connections.AdmissionOptions()),
+		tcp.WithMaxRequestSize(appConfig.Connections.MaxRequestSize),
+		tcp.WithRegistry(clients),
+	)
 	if err != nil {
 		return err
 	}
 
+	watcher := config.NewWatcher(*flags.ConfigFile, flags.Overrides(), appConfig, logger.L())
+	watcher.OnReload(func(c *config.Config) {
+		if errLevel := logger.SetLevel(c.Log.Level); errLevel != nil {
+			logger.L().Errorf("config reload: log.level: %v", errLevel)
+		}
+		serv.SetMaxConnections(c.MaxConnections)
+		serv.SetTimeouts(c.Connections.Timeouts())
+		serv.SetAdmission(c.Connections.AdmissionOptions())
+		serv.SetMaxRequestSize(c.Connections.MaxRequestSize)
+		s.SetFlushing(c.WAL.FlushingBatchSize, c.WAL.FlushingBatchTimeout)
+		slowLog.Configure(c.SlowLog.Threshold, c.SlowLog.MaxLen, c.SlowLog.MaxArgLen)
+		hub.Configure(c.Notifications.Options())
+		db.SetNotifyBuffer(c.Notifications.BufferSize)
+		db.SetScriptTimeout(c.Scripting.Timeout)
+		db.SetScriptMaxValueSize(c.Scripting.MaxValueSize)
+	})
+	if err = watcher.Watch(); err != nil {
+		return err
+	}
+
+	go closeOnSignal(s, wallog, node)
+
+	if appConfig.HTTP.Enabled {
+		go serveHTTP(appConfig.HTTP.Addr, db)
+	}
+
 	logger.L().Infof("DB listening addr: %s", appConfig.Addr)
 	serv.Listen(context.Background())
 
 	return nil
 }
 
+func newRaftNode(c config.Raft) (*raft.Node, error) {
+	store, err := raft.OpenFileStore(c.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return raft.NewNode(c.NodeConfig(), store, raft.NewRPCTransport(c.Peers()), logger.L())
+}
+
+// startRaftNode restores the storage from the raft snapshot and serves the raft RPCs.
+func startRaftNode(c config.Raft, node *raft.Node, s *storage.Storage) error {
+	l, err := net.Listen("tcp", c.Self().Addr)
+	if err != nil {
+		return err
+	}
+	if err = node.Start(s); err != nil {
+		l.Close()
+		return err
+	}
+
+	logger.L().Infof("raft node %s listening addr: %s", c.ID, c.Self().Addr)
+	go func() {
+		if errServe := raft.Serve(l, node); errServe != nil {
+			logger.L().Errorf("raft: %v", errServe)
+		}
+	}()
+
+	return nil
+}
+
+// serveHTTP runs the HTTP/JSON gateway, the TCP server keeps running if it fails.
+func serveHTTP(addr string, db *app.App) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           httpapi.New(db, logger.L()),
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+	}
+
+	logger.L().Infof("HTTP gateway listening addr: %s", addr)
+	if err := srv.ListenAndServe(); err != nil {
+		logger.L().Errorf("HTTP gateway: %v", err)
+	}
+}
+
+// reopenLogsOnSignal reopens the log files on SIGHUP sent by an external logrotate.
+func reopenLogsOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := logger.Reopen(); err != nil {
+			logger.L().Errorf("reopen log files: %v", err)
+		}
+	}
+}
+
+// closeOnSignal flushes the pending writes and syncs the WAL on SIGINT and SIGTERM,
+// the fsync policies interval and never rely on it to not lose the acknowledged writes.
+// The raft node, if any, is stopped first so no committed write is applied during the close.
+func closeOnSignal(s *storage.Storage, w *wal.WAL, node *raft.Node) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+
+	logger.L().Infof("%s received, closing the storage", sig)
+	if node != nil {
+		node.Stop()
+	}
+	s.Close()
+	if w == nil {
+		os.Exit(0)
+	}
+	if err := w.Close(); err != nil {
+		logger.L().Errorf("close WAL: %v", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
 func main() {
 	if err := runApp(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
@@ -2,17 +2,26 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"jokedb/intetnal/app"
+	"jokedb/intetnal/cluster"
 	"jokedb/intetnal/config"
 	"jokedb/intetnal/logger"
+	"jokedb/intetnal/reply"
 	"jokedb/intetnal/tcp"
 	"os"
+	"strings"
 )
 
 func runClient() error {
 	addr := flag.String("addr", app.Addr, "listening addr")
+	durability := flag.String("durability", "", "durability level of the writes: async, local or replicated")
+	subscribe := flag.String("subscribe", "", "print the notifications of the space separated channel patterns")
+	clusterMode := flag.Bool("cluster", false, "send the commands on a key to the cluster node serving its slot")
+	migrateSlot := flag.Int("migrate-slot", -1, "move the slot with its keys to the cluster node -to and exit")
+	migrateTo := flag.String("to", "", "id of the cluster node -migrate-slot moves the slot to")
 	flag.Parse()
 
 	conf, err := config.Init(app.ConfigPah)
@@ -20,32 +29,134 @@ func runClient() error {
 		return err
 	}
 
-	if err = logger.Init(conf.DevMode, app.Name, conf.Log.Level); err != nil {
+	if err = logger.Init(conf.DevMode, app.Name, logger.Options{Level: conf.Log.Level}); err != nil {
 		return err
 	}
 
+	if *migrateSlot >= 0 {
+		return migrate(*addr, *migrateSlot, *migrateTo)
+	}
+	if *clusterMode {
+		return runClusterClient(*addr)
+	}
+
 	cl, err := tcp.NewClient(*addr, logger.L())
 	if err != nil {
 		return err
 	}
 	defer cl.Close()
 
+	if *durability != "" {
+		if err = cl.SetDurability(*durability); err != nil {
+			logger.L().Error(err)
+			return err
+		}
+	}
+
+	if *subscribe != "" {
+		return receive(cl, "PSUBSCRIBE "+*subscribe)
+	}
+
 	sc := bufio.NewScanner(os.Stdin)
 	sc.Split(bufio.ScanLines)
 
 	for sc.Scan() {
-		resp, errPub := cl.Send(sc.Bytes())
-		if errPub != nil {
+		if errPub := printReply(cl.Do(sc.Text())); errPub != nil {
 			logger.L().Error(errPub)
 			return errPub
 		}
+	}
+
+	return nil
+}
+
+// runClusterClient sends each line to the node serving the slot of its key, the second word.
+// The lines without a key go to addr.
+func runClusterClient(addr string) error {
+	cl, err := cluster.NewClient([]string{addr}, logger.L())
+	if err != nil {
+		logger.L().Error(err)
+		return err
+	}
+	defer cl.Close()
 
-		fmt.Fprintln(os.Stdout, string(resp))
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		key := ""
+		if len(fields) > 1 {
+			key = fields[1]
+		}
+		if errDo := printReply(cl.Do(key, sc.Text())); errDo != nil {
+			logger.L().Error(errDo)
+			return errDo
+		}
 	}
 
 	return nil
 }
 
+// printReply writes the reply, an error reply as "(error) CODE message". The other errors are returned.
+func printReply(r reply.Reply, err error) error {
+	var replyErr *reply.Error
+	switch {
+	case errors.As(err, &replyErr):
+		fmt.Fprintln(os.Stdout, "(error) "+replyErr.Error())
+	case err != nil:
+		return err
+	default:
+		fmt.Fprintln(os.Stdout, r.String())
+	}
+
+	return nil
+}
+
+// migrate moves slot to the node to, the nodes of the cluster are read from the node at addr.
+func migrate(addr string, slot int, to string) error {
+	cl, err := tcp.NewClient(addr, logger.L())
+	if err != nil {
+		return err
+	}
+	r, err := cl.Do("CLUSTER NODES")
+	cl.Close()
+	if err != nil {
+		return err
+	}
+	nodes, err := cluster.ParseNodes(r.Text)
+	if err != nil {
+		logger.L().Error(err)
+		return err
+	}
+	if err = cluster.MigrateSlot(slot, to, nodes, logger.L()); err != nil {
+		logger.L().Error(err)
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "slot %d moved to %s\n", slot, to)
+
+	return nil
+}
+
+// receive prints a "message <channel> <payload>" line per notification.
+func receive(cl *tcp.Client, command string) error {
+	r, err := cl.Do(command)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, r.String())
+
+	for {
+		msg, errRecv := cl.ReceiveReply()
+		if errRecv != nil {
+			return errRecv
+		}
+		fields := make([]string, len(msg.Array))
+		for i, item := range msg.Array {
+			fields[i] = item.Text
+		}
+		fmt.Fprintln(os.Stdout, strings.Join(fields, " "))
+	}
+}
+
 func main() {
 	if err := runClient(); err != nil {
 		os.Exit(1)
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"jokedb/intetnal/backup"
+	"os"
+)
+
+func runRestore() error {
+	backupDir := flag.String("backup", "", "backup directory created by the BACKUP command")
+	walDir := flag.String("wal-dir", "", "empty WAL directory to restore to, wal.dirPath of the server")
+	compact := flag.Bool("compact", false, "restore only the snapshot as a single segment instead of the WAL history")
+	verify := flag.Bool("verify", false, "only verify the backup")
+	flag.Parse()
+
+	if *backupDir == "" {
+		return fmt.Errorf("-backup is required")
+	}
+
+	if *verify {
+		m, err := backup.Verify(*backupDir)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "backup %s is valid: %d keys, %d segments, created at %s\n",
+			*backupDir, m.Keys, len(m.Segments), m.CreatedAt)
+		return nil
+	}
+
+	if *walDir == "" {
+		return fmt.Errorf("-wal-dir is required")
+	}
+	m, err := backup.Restore(*backupDir, *walDir, *compact)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "restored %d keys in %d databases to %s\n", m.Keys, m.Databases, *walDir)
+
+	return nil
+}
+
+func main() {
+	if err := runRestore(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
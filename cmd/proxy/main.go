@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"jokedb/intetnal/logger"
+	"jokedb/intetnal/proxy"
+	"jokedb/intetnal/tcp"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const (
+	name       = "jokedb-proxy"
+	configPath = "./config/proxy.yaml"
+)
+
+func runProxy() error {
+	configFile := flag.String("config", configPath, "proxy config file, reloaded on SIGHUP")
+	flag.Parse()
+
+	c, err := proxy.LoadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	if err = logger.Init(false, name, logger.Options{Level: c.LogLevel}); err != nil {
+		return err
+	}
+
+	p := proxy.New(c.Backends, logger.L(), c.Options())
+	defer p.Close()
+	serv, err := tcp.NewServer(c.Addr, c.MaxConnections, logger.L(), p.Handle)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+	go reloadOnSignal(*configFile, p)
+
+	logger.L().Infof("proxy listening addr: %s, backends: %v", c.Addr, c.Backends)
+	serv.Listen(ctx)
+
+	return nil
+}
+
+// reloadOnSignal reconfigures the ring on SIGHUP, an invalid config is logged and the ring is kept.
+func reloadOnSignal(configFile string, p *proxy.Proxy) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		c, err := proxy.LoadConfig(configFile)
+		if err != nil {
+			logger.L().Errorf("config reload: %v", err)
+			continue
+		}
+		if errLevel := logger.SetLevel(c.LogLevel); errLevel != nil {
+			logger.L().Errorf("config reload: logLevel: %v", errLevel)
+		}
+		p.Configure(c.Backends, c.Options())
+		logger.L().Infof("config reload: backends: %v", c.Backends)
+	}
+}
+
+func main() {
+	if err := runProxy(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"jokedb/intetnal/wal"
+	"os"
+	"path"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: walctl <command> -dir <wal dir> [flags]
+
+commands:
+  list     show the segments with their sizes and record counts
+  dump     print the records as JSON lines, -archives adds the segments archived by the cuts
+  verify   decode every segment and report the first bad offset
+  repair   copy the segments to -out, truncating at the first bad offset
+`
+
+var errCorrupt = errors.New("wal is corrupt")
+
+// currentTimeline names the segments of the WAL directory in the dump, the archived segments
+// are named by their directory.
+const currentTimeline = "current"
+
+// record is a line of the dump. The LSNs after the target of a cut are given again to the writes
+// of the new timeline, Timeline tells the segment set of the record.
+type record struct {
+	Timeline  string `json:"timeline"`
+	Segment   uint   `json:"segment"`
+	Offset    int64  `json:"offset"`
+	LSN       uint64 `json:"lsn"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Action    string `json:"action"`
+	DB        int    `json:"db"`
+	TargetDB  int    `json:"target_db,omitempty"`
+	Key       string `json:"key,omitempty"`
+	Value     string `json:"value,omitempty"`
+}
+
+func runWalctl(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	dir := fs.String("dir", "./db/wal", "WAL directory")
+	keyPattern := fs.String("key", "", "dump only the keys matching the glob pattern")
+	from := fs.Uint("from", 0, "first segment id to dump")
+	to := fs.Uint("to", 0, "last segment id to dump, 0 for the last segment")
+	out := fs.String("out", "", "empty directory for the repaired segments")
+	archives := fs.Bool("archives", false, "dump also the segments archived by the timeline cuts, the oldest first")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	// the directory is only read, wal.Open would create a segment and finish a pending cut
+	w, err := wal.OpenDir(*dir)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		return list(w, stdout)
+	case "dump":
+		return dumpAll(w, stdout, *keyPattern, *from, *to, *archives)
+	case "verify":
+		return verify(w, stdout)
+	case "repair":
+		if *out == "" {
+			return errors.New("repair: -out is required")
+		}
+		return repair(w, stdout, *out)
+	default:
+		return errors.New(usage)
+	}
+}
+
+func list(w *wal.Dir, stdout io.Writer) error {
+	infos, err := w.Inspect()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tFILE\tSIZE\tBATCHES\tRECORDS\tSTATUS")
+	for _, info := range infos {
+		status := "ok"
+		if info.Corrupt != nil {
+			status = info.Corrupt.Error()
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%d\t%s\n",
+			info.ID, filepath.Base(info.Path), info.Size, info.Batches, info.Records, status)
+	}
+	if err = tw.Flush(); err != nil {
+		return err
+	}
+
+	t, err := w.Timeline()
+	if err != nil || t == nil {
+		return err
+	}
+	pending := ""
+	if t.Pending {
+		pending = ", pending until the server opens the WAL"
+	}
+	fmt.Fprintf(stdout, "cut at LSN %d (target %s) on %s, the segments from %d on are in %s%s\n",
+		t.LSN, t.Target, t.CreatedAt.Format(time.RFC3339), t.Segment, filepath.Base(t.Archive), pending)
+
+	return nil
+}
+
+// dumpAll dumps the segments of w, after the archived ones with archives.
+func dumpAll(w *wal.Dir, stdout io.Writer, keyPattern string, from, to uint, archives bool) error {
+	enc := json.NewEncoder(stdout)
+	if archives {
+		dirs, err := w.Archives()
+		if err != nil {
+			return err
+		}
+		for _, dir := range dirs {
+			archive, err := wal.OpenDir(dir)
+			if err != nil {
+				return err
+			}
+			if err = dump(archive, enc, filepath.Base(dir), keyPattern, from, to); err != nil {
+				return err
+			}
+		}
+	}
+
+	return dump(w, enc, currentTimeline, keyPattern, from, to)
+}
+
+func dump(w *wal.Dir, enc *json.Encoder, timeline, keyPattern string, from, to uint) error {
+	ids, err := w.SegmentIDs()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id < from || (to > 0 && id > to) {
+			continue
+		}
+
+		batches, err := w.ReadSegment(id)
+		for _, b := range batches {
+			for _, log := range b.Logs {
+				if keyPattern != "" {
+					if ok, _ := path.Match(keyPattern, log.Key); !ok {
+						continue
+					}
+				}
+				r := record{
+					Timeline: timeline,
+					Segment:  id,
+					Offset:   b.Offset,
+					LSN:      log.LSN,
+					Action:   log.Action.String(),
+					DB:       log.DB,
+					TargetDB: log.TargetDB,
+					Key:      log.Key,
+					Value:    log.Value,
+				}
+				if log.Timestamp != 0 {
+					// the format of --recover-to-time
+					r.Timestamp = time.Unix(0, log.Timestamp).UTC().Format(time.RFC3339Nano)
+				}
+				if errEnc := enc.Encode(r); errEnc != nil {
+					return errEnc
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s segment %d: %w", timeline, id, err)
+		}
+	}
+
+	return nil
+}
+
+func verify(w *wal.Dir, stdout io.Writer) error {
+	infos, err := w.Inspect()
+	if err != nil {
+		return err
+	}
+
+	var records int
+	for _, info := range infos {
+		records += info.Records
+		if info.Corrupt != nil {
+			fmt.Fprintf(stdout, "segment %d (%s): first bad offset %d: %v\n",
+				info.ID, info.Path, info.Corrupt.Offset, info.Corrupt.Err)
+			return errCorrupt
+		}
+	}
+	fmt.Fprintf(stdout, "ok: %d segments, %d records\n", len(infos), records)
+
+	return nil
+}
+
+// repair copies the segments to out up to the first corrupt batch. The
+// segment with the corrupt batch is truncated at its offset and the
+// following segments are left out, the originals are not changed. The
+// TIMELINE marker is copied as well, so a restart with the same recovery
+// target does not cut the new timeline again; its archive stays in the
+// original directory.
+func repair(w *wal.Dir, stdout io.Writer, out string) error {
+	timeline, err := w.Timeline()
+	if err != nil {
+		return err
+	}
+	if timeline != nil && timeline.Pending {
+		// Open would move the segments of out into the archive of the original directory
+		return errors.New("repair: the last cut is pending, open the WAL with the server to finish it first")
+	}
+
+	if err = os.MkdirAll(out, os.ModePerm); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(out)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("repair: %s is not empty", out)
+	}
+
+	if timeline != nil {
+		marker, errRead := os.ReadFile(filepath.Join(w.Path(), wal.TimelineFile))
+		if errRead != nil {
+			return errRead
+		}
+		if err = os.WriteFile(filepath.Join(out, wal.TimelineFile), marker, 0644); err != nil {
+			return err
+		}
+	}
+
+	infos, err := w.Inspect()
+	if err != nil {
+		return err
+	}
+	for i, info := range infos {
+		data, errRead := os.ReadFile(info.Path)
+		if errRead != nil {
+			return errRead
+		}
+
+		if info.Corrupt != nil {
+			data = data[:info.Corrupt.Offset]
+		}
+		if err = os.WriteFile(wal.SegmentFileName(out, info.ID), data, 0644); err != nil {
+			return err
+		}
+
+		if info.Corrupt != nil {
+			fmt.Fprintf(stdout, "segment %d truncated at offset %d, %d following segments dropped\n",
+				info.ID, info.Corrupt.Offset, len(infos)-i-1)
+			return nil
+		}
+	}
+	fmt.Fprintf(stdout, "no corruption found, %d segments copied\n", len(infos))
+
+	return nil
+}
+
+func main() {
+	if err := runWalctl(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"jokedb/intetnal/storage/engine"
+	"jokedb/intetnal/wal"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// readTree returns the files of dir by their relative paths.
+func readTree(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	files := map[string][]byte{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[rel], err = os.ReadFile(path)
+		return err
+	})
+	require.NoError(t, err)
+	return files
+}
+
+func TestWalctl_ReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	w, err := wal.Open(wal.WithDirPath(dir))
+	require.NoError(t, err)
+	require.NoError(t, w.Write([]wal.LogData{{Action: engine.SET, Key: "a", Value: "1"}}))
+	require.NoError(t, w.NewActiveSegment())
+	require.NoError(t, w.Write([]wal.LogData{{Action: engine.DEL, Key: "a"}}))
+	require.NoError(t, w.Close())
+
+	// a pending cut is finished by wal.Open only, the tools leave it to the server
+	marker, err := json.Marshal(wal.Timeline{LSN: 1, Archive: filepath.Join(dir, "timeline-x"), Segment: 2, Pending: true})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, wal.TimelineFile), marker, 0644))
+
+	before := readTree(t, dir)
+	for _, cmd := range []string{"list", "dump", "verify"} {
+		var out bytes.Buffer
+		require.NoError(t, runWalctl([]string{cmd, "-dir", dir}, &out), cmd)
+		require.NotEmpty(t, out.String(), cmd)
+	}
+	out := filepath.Join(t.TempDir(), "repaired")
+	require.ErrorContains(t, runWalctl([]string{"repair", "-dir", dir, "-out", out}, &bytes.Buffer{}), "pending")
+	require.NoDirExists(t, out)
+	require.Equal(t, before, readTree(t, dir))
+
+	missing := filepath.Join(t.TempDir(), "wal")
+	require.Error(t, runWalctl([]string{"dump", "-dir", missing}, &bytes.Buffer{}))
+	require.NoDirExists(t, missing)
+}
+
+func TestWalctl_DumpTimelines(t *testing.T) {
+	dir := t.TempDir()
+	w, err := wal.Open(wal.WithDirPath(dir))
+	require.NoError(t, err)
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, w.Write([]wal.LogData{{Action: engine.SET, Key: key, Value: "1"}}))
+	}
+	cut, err := w.Truncate(1, "lsn 1")
+	require.NoError(t, err)
+	require.NotNil(t, cut)
+	require.NoError(t, w.Write([]wal.LogData{{Action: engine.SET, Key: "d", Value: "2"}}))
+	require.NoError(t, w.Close())
+
+	var out bytes.Buffer
+	require.NoError(t, runWalctl([]string{"dump", "-dir", dir, "-archives"}, &out))
+	var got []string
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var r record
+		require.NoError(t, dec.Decode(&r))
+		_, err = time.Parse(time.RFC3339Nano, r.Timestamp)
+		require.NoError(t, err)
+		got = append(got, fmt.Sprintf("%s %d %s", r.Timeline, r.LSN, r.Key))
+	}
+	archive := filepath.Base(cut.Archive)
+	// the LSN 2 of the new timeline is not the one of the archived segments
+	require.Equal(t, []string{
+		archive + " 1 a", archive + " 2 b", archive + " 3 c",
+		"current 1 a", "current 2 d",
+	}, got)
+
+	out.Reset()
+	require.NoError(t, runWalctl([]string{"list", "-dir", dir}, &out))
+	require.Contains(t, out.String(), "cut at LSN 1 (target lsn 1)")
+
+	// the repaired directory keeps the marker, the recovery knows the WAL was cut
+	repaired := filepath.Join(t.TempDir(), "repaired")
+	require.NoError(t, runWalctl([]string{"repair", "-dir", dir, "-out", repaired}, &bytes.Buffer{}))
+	files := readTree(t, dir)
+	require.Equal(t, files[wal.TimelineFile], readTree(t, repaired)[wal.TimelineFile])
+	w, err = wal.Open(wal.WithDirPath(repaired))
+	require.NoError(t, err)
+	timeline, err := w.Timeline()
+	require.NoError(t, err)
+	require.NotNil(t, timeline)
+	require.Equal(t, cut.LSN, timeline.LSN)
+	require.Equal(t, cut.Target, timeline.Target)
+	require.NoError(t, w.Close())
+}
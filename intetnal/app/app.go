@@ -2,10 +2,24 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"jokedb/intetnal/backup"
+	"jokedb/intetnal/cluster"
 	"jokedb/intetnal/compute/analyzer"
 	"jokedb/intetnal/logger"
+	"jokedb/intetnal/notify"
+	"jokedb/intetnal/raft"
+	"jokedb/intetnal/reply"
+	"jokedb/intetnal/slowlog"
+	"jokedb/intetnal/storage"
 	"jokedb/intetnal/storage/engine"
+	"jokedb/intetnal/tcp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -13,76 +27,262 @@ const (
 	Addr      = "127.0.0.1:3002"
 	MaxConn   = 100
 	ConfigPah = "./config/app.yaml"
+
+	defaultSlowLogGet = 10
 )
 
+// QueryError is returned for a query that cannot be parsed or has wrong arguments.
+type QueryError struct {
+	Err error
+}
+
+func (e *QueryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
 type Processor interface {
 	ParseQuery(q string) (analyzer.Action, error)
+	Registry() *analyzer.Registry
 }
 
 type Storage interface {
-	Put(ctx context.Context, kv engine.KV) error
-	Get(ctx context.Context, kv engine.KV) (string, error)
-	Del(ctx context.Context, kv engine.KV) error
+	Put(ctx context.Context, db int, kv engine.KV) error
+	Get(ctx context.Context, db int, kv engine.KV) (string, error)
+	Keys(ctx context.Context, db int, pattern string) ([]string, error)
+	Del(ctx context.Context, db int, kv engine.KV) error
+	Move(ctx context.Context, db int, key string, targetDB int) error
+	FlushDB(ctx context.Context, db int) error
+	SwapDB(ctx context.Context, db, targetDB int) error
+	Databases() int
+	Checkpoint(ctx context.Context) (storage.Checkpoint, error)
+	LSN() uint64
+	Stats() storage.Stats
+	Begin(ctx context.Context, db int) (*storage.Tx, error)
+	Scan(ctx context.Context, db int, owner any, cursor uint64, pattern string, count int, withValues bool) (uint64, []engine.KV, error)
+	SnapshotStats() storage.SnapshotStats
+}
+
+type SlowLog interface {
+	Record(at time.Time, duration time.Duration, clientAddr string, args []string)
+	Get(n int) []slowlog.Entry
+	Len() int
+	Reset()
 }
 
 type App struct {
-	processor Processor
-	storage   Storage
+	processor    Processor
+	storage      Storage
+	slowLog      SlowLog
+	hub          *notify.Hub
+	clients      Clients
+	info         InfoOptions
+	startedAt    time.Time
+	commands     *commandStats
+	notifyBuffer *atomic.Int64
+	cluster      *cluster.State
+	migrateMu    *sync.RWMutex
+	scripts      *scripting
+	handlers     *handlers
+}
+
+type Option func(a *App)
+
+func WithSlowLog(l SlowLog) Option {
+	return func(a *App) {
+		a.slowLog = l
+	}
 }
 
-func New(p Processor, s Storage) *App {
-	return &App{
-		processor: p,
-		storage:   s,
+func New(p Processor, s Storage, opts ...Option) *App {
+	a := &App{
+		processor:    p,
+		storage:      s,
+		slowLog:      slowlog.New(-1, 0, 0),
+		notifyBuffer: &atomic.Int64{},
+		startedAt:    time.Now(),
+		commands:     newCommandStats(),
+		scripts:      newScripting(),
+		handlers:     newHandlers(),
+	}
+	a.notifyBuffer.Store(defaultNotifyBuffer)
+	for _, opt := range opts {
+		opt(a)
 	}
+
+	return a
 }
 
+// DoRawCommand runs a command and returns its reply as text, see reply.Reply.String.
 func (a App) DoRawCommand(ctx context.Context, c string) (string, error) {
-	actionType, err := a.processor.ParseQuery(c)
+	r, err := a.Do(ctx, c)
 	if err != nil {
-		return "", fmt.Errorf("parse query :%w", err)
+		return "", err
 	}
+	return r.String(), nil
+}
 
-	var result string
-	switch actionType.Type {
-	case engine.SET:
-		err = a.storage.Put(ctx, actionType.KV)
-		if err != nil {
-			err = fmt.Errorf("SET query :%w", err)
-		} else {
-			result = "SET ok"
+// Do runs a command and returns its reply, a failed command returns an error and no reply.
+func (a App) Do(ctx context.Context, c string) (reply.Reply, error) {
+	start := time.Now()
+	defer func() {
+		var clientAddr string
+		if conn, ok := tcp.ConnFromContext(ctx); ok {
+			clientAddr = conn.RemoteAddr()
 		}
-	case engine.GET:
-		result, err = a.storage.Get(ctx, actionType.KV)
-		if err != nil {
-			err = fmt.Errorf("GET query :%w", err)
+		a.slowLog.Record(start, time.Since(start), clientAddr, strings.Split(c, " "))
+	}()
+
+	actionType, err := a.processor.ParseQuery(c)
+	if err != nil {
+		return reply.Reply{}, fmt.Errorf("parse query :%w", &QueryError{Err: err})
+	}
+	cmd, _ := a.processor.Registry().ByType(actionType.Type)
+	name := cmd.Name
+	defer func() {
+		a.commands.record(name, err)
+	}()
+
+	sess := session(ctx)
+	db := sess.DB()
+
+	durability := sess.Durability()
+	if actionType.Durability != "" {
+		durability, _ = storage.ParseDurability(actionType.Durability)
+	}
+	ctx = storage.WithDurability(ctx, durability)
+
+	if inScript(ctx) {
+		// the script was routed and holds the script lock
+		if cmd.Flags.Has(analyzer.NoScript) {
+			err = fmt.Errorf("%s query :%w", name, errNotInScript)
+			return reply.Reply{}, err
 		}
-	case engine.DEL:
-		err = a.storage.Del(ctx, actionType.KV)
-		if err != nil {
-			err = fmt.Errorf("DEL query :%w", err)
-		} else {
-			result = "DEL ok"
+	} else {
+		// the script lock is taken before migrateMu, as EVAL does
+		defer a.scripts.lock(actionType.Type)()
+
+		switch {
+		case a.cluster != nil && len(actionType.Keys) > 0:
+			a.migrateMu.RLock()
+			defer a.migrateMu.RUnlock()
+			if err = a.route(ctx, db, actionType.Keys, sess.takeAsking()); err != nil {
+				return reply.Reply{}, err
+			}
+		case actionType.Type != engine.ASKING:
+			sess.takeAsking()
 		}
 	}
 
-	return result, err
+	if tx := sess.transaction(); tx != nil && isTxCommand(actionType.Type) {
+		var result reply.Reply
+		result, err = a.txCommand(ctx, sess, tx, name, actionType)
+		return result, err
+	}
+
+	handle, ok := a.handlers.get(actionType.Type)
+	if !ok {
+		err = fmt.Errorf("%s query :%w", name, errNoHandler)
+		return reply.Reply{}, err
+	}
+	result, err := handle(ctx, a, Call{Name: name, Action: actionType, DB: db, Session: sess})
+	if err != nil {
+		err = fmt.Errorf("%s query :%w", name, err)
+		return reply.Reply{}, err
+	}
+	a.notifyWrite(db, actionType)
+
+	return result, nil
 }
 
+// Handle runs a command of a client and returns its encoded reply.
 func (a App) Handle(ctx context.Context, s string) string {
-	logger.L().Infof("handler get command: %s", s)
-	res, err := a.DoRawCommand(ctx, s)
-	if err != nil {
+	start := time.Now()
+	res, err := a.Do(ctx, s)
+	var redirect *cluster.RedirectError
+	switch {
+	case err == nil, errors.Is(err, engine.ErrNoKey), errors.As(err, &redirect):
+	case errors.Is(err, storage.ErrBusy):
+		logger.L().Warn(err)
+	default:
 		logger.L().Error(err)
 	}
-	resp := response(err, res)
-	logger.L().Infof("handler response: %s", resp)
-	return resp
+	if err != nil {
+		res = ErrorReply(err)
+	}
+	logger.Access().Infow("command", "command", s, "duration", time.Since(start), "error", err)
+	return string(res.Encode())
 }
 
-func response(err error, res string) string {
+// backup writes a consistent copy of the data to dir without stopping the writes for longer
+// than it takes to seal the WAL segment and copy the databases in memory.
+func (a App) backup(ctx context.Context, dir string) (reply.Reply, error) {
+	checkpoint, err := a.storage.Checkpoint(ctx)
+	if err != nil {
+		return reply.Reply{}, err
+	}
+	m, err := backup.Create(dir, checkpoint)
 	if err != nil {
-		return err.Error()
+		return reply.Reply{}, err
+	}
+
+	return reply.OK(fmt.Sprintf("BACKUP ok %d keys, %d segments", m.Keys, len(m.Segments))), nil
+}
+
+func (a App) slowLogCommand(args []string) reply.Reply {
+	switch args[0] {
+	case "LEN":
+		return reply.Int(int64(a.slowLog.Len()))
+	case "RESET":
+		a.slowLog.Reset()
+		return reply.OK("SLOWLOG ok")
+	}
+
+	n := defaultSlowLogGet
+	if len(args) > 1 {
+		n, _ = strconv.Atoi(args[1])
+	}
+	entries := a.slowLog.Get(n)
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		lines = append(lines, fmt.Sprintf("%d %s %s %s %s",
+			e.ID, e.Time.Format(time.RFC3339Nano), e.Duration, e.ClientAddr, strings.Join(e.Args, " ")))
+	}
+
+	return reply.Values(lines)
+}
+
+// ErrorReply is the error reply of a failed command, its code tells the kind of failure. The busy,
+// redirect and cluster down errors are answered without the command that failed.
+func ErrorReply(err error) reply.Reply {
+	var (
+		busy      *storage.BusyError
+		redirect  *cluster.RedirectError
+		down      *cluster.DownError
+		notLeader *raft.NotLeaderError
+		query     *QueryError
+		coded     *reply.Error
+	)
+	switch {
+	case errors.As(err, &busy):
+		return reply.Fail(reply.Busy, strings.TrimPrefix(busy.Error(), string(reply.Busy)+" "))
+	case errors.As(err, &redirect):
+		return reply.Fail(reply.Code(redirect.Kind), strconv.Itoa(redirect.Slot)+" "+redirect.Addr)
+	case errors.As(err, &down):
+		return reply.Fail(reply.ClusterDown, strings.TrimPrefix(down.Error(), string(reply.ClusterDown)+" "))
+	case errors.As(err, &coded):
+		// the code is not repeated in the message
+		return reply.Fail(coded.Code, strings.Replace(err.Error(), coded.Error(), coded.Message, 1))
+	case errors.Is(err, engine.ErrNoKey):
+		return reply.Fail(reply.NotFound, err.Error())
+	case errors.As(err, &query):
+		return reply.Fail(reply.Syntax, err.Error())
+	case errors.As(err, &notLeader):
+		return reply.Fail(reply.ReadOnly, err.Error())
+	default:
+		return reply.Fail(reply.CodeErr, err.Error())
 	}
-	return res
 }
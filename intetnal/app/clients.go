@@ -0,0 +1,72 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jokedb/intetnal/reply"
+	"jokedb/intetnal/tcp"
+	"strconv"
+	"time"
+)
+
+var errNoClients = errors.New("client commands need a client connection")
+
+type Clients interface {
+	Clients() []tcp.ClientInfo
+	Kill(f tcp.ClientFilter) int
+}
+
+// WithClients enables the CLIENT commands over the registry of the server.
+func WithClients(c Clients) Option {
+	return func(a *App) {
+		a.clients = c
+	}
+}
+
+func (a App) client(ctx context.Context, args []string) (reply.Reply, error) {
+	conn, ok := tcp.ConnFromContext(ctx)
+	if !ok || a.clients == nil {
+		return reply.Reply{}, errNoClients
+	}
+
+	switch args[0] {
+	case "ID":
+		return reply.Int(int64(conn.ID())), nil
+	case "GETNAME":
+		if conn.Name() == "" {
+			return reply.Nil(), nil
+		}
+		return reply.Value(conn.Name()), nil
+	case "SETNAME":
+		conn.SetName(args[1])
+		return reply.OK("CLIENT SETNAME ok"), nil
+	case "LIST":
+		return reply.Values(clientList(a.clients.Clients())), nil
+	default:
+		var f tcp.ClientFilter
+		for i := 1; i < len(args); i += 2 {
+			switch args[i] {
+			case "ID":
+				f.ID, _ = strconv.ParseUint(args[i+1], 10, 64)
+			case "ADDR":
+				f.Addr = args[i+1]
+			case "NAME":
+				f.Name = args[i+1]
+			}
+		}
+		return reply.OK(fmt.Sprintf("CLIENT KILL ok %d", a.clients.Kill(f))), nil
+	}
+}
+
+// clientList formats one "id=1 addr=... name=... age=... idle=... cmd=... in=... out=..." line per client.
+func clientList(clients []tcp.ClientInfo) []string {
+	lines := make([]string, 0, len(clients))
+	for _, c := range clients {
+		lines = append(lines, fmt.Sprintf("id=%d addr=%s name=%s age=%d idle=%d cmd=%s in=%d out=%d",
+			c.ID, c.Addr, c.Name, int(time.Since(c.ConnectedAt).Seconds()), int(c.Idle.Seconds()),
+			c.LastCommand, c.BytesIn, c.BytesOut))
+	}
+
+	return lines
+}
@@ -0,0 +1,174 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jokedb/intetnal/cluster"
+	"jokedb/intetnal/compute/parser"
+	"jokedb/intetnal/logger"
+	"jokedb/intetnal/reply"
+	"jokedb/intetnal/storage/engine"
+	"jokedb/intetnal/tcp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMigrateTimeout limits a MIGRATE without a context deadline, the commands on the
+// migrated slots wait for it.
+const defaultMigrateTimeout = 5 * time.Second
+
+var (
+	errCrossSlot = errors.New("keys of a command must be in one slot")
+	// errClusterDB is returned for the commands on the other databases, MIGRATE moves only the keys
+	// of the database 0, so theirs would be left behind on the old owner of a slot.
+	errClusterDB = errors.New("cluster mode serves the database 0 only")
+)
+
+// WithCluster serves only the keys of the slots the node owns in c and redirects the others.
+func WithCluster(c *cluster.State) Option {
+	return func(a *App) {
+		a.cluster = c
+		a.migrateMu = &sync.RWMutex{}
+	}
+}
+
+// route checks that the keys of a command are in one slot served by this node. A key of a slot being
+// migrated is served while it has not been moved, the caller holds migrateMu so MIGRATE waits for it.
+func (a App) route(ctx context.Context, db int, keys []string, asking bool) error {
+	slot := cluster.Slot(keys[0])
+	for _, key := range keys[1:] {
+		if cluster.Slot(key) != slot {
+			return errCrossSlot
+		}
+	}
+
+	return a.cluster.Route(slot, asking, func() (bool, error) {
+		_, err := a.storage.Get(ctx, db, engine.KV{Key: keys[0]})
+		if errors.Is(err, engine.ErrNoKey) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+}
+
+func (a App) clusterCommand(ctx context.Context, args []string) (reply.Reply, error) {
+	if args[0] == "KEYSLOT" {
+		return reply.Int(int64(cluster.Slot(args[1]))), nil
+	}
+	if a.cluster == nil {
+		return reply.Reply{}, cluster.ErrDisabled
+	}
+
+	switch args[0] {
+	case "MYID":
+		return reply.Value(a.cluster.Self()), nil
+	case "SLOTS":
+		slots := a.cluster.Slots()
+		if len(slots) == 0 {
+			return reply.Array(), nil
+		}
+		return reply.Values(strings.Split(cluster.FormatSlots(slots), "\n")), nil
+	case "NODES":
+		return reply.Value(cluster.FormatNodes(a.cluster.Nodes())), nil
+	case "COUNTKEYSINSLOT":
+		slot, _ := strconv.Atoi(args[1])
+		keys, err := a.keysInSlot(ctx, slot, -1)
+		if err != nil {
+			return reply.Reply{}, err
+		}
+		return reply.Int(int64(len(keys))), nil
+	case "GETKEYSINSLOT":
+		slot, _ := strconv.Atoi(args[1])
+		count, _ := strconv.Atoi(args[2])
+		keys, err := a.keysInSlot(ctx, slot, count)
+		if err != nil {
+			return reply.Reply{}, err
+		}
+		return reply.Values(keys), nil
+	}
+
+	// SETSLOT
+	slot, _ := strconv.Atoi(args[1])
+	var id string
+	if len(args) > 3 {
+		id = args[3]
+	}
+	if err := a.cluster.SetSlot(slot, args[2], id); err != nil {
+		return reply.Reply{}, err
+	}
+	return reply.OK("CLUSTER ok"), nil
+}
+
+// keysInSlot returns up to count keys of slot, count -1 returns all of them.
+func (a App) keysInSlot(ctx context.Context, slot, count int) ([]string, error) {
+	keys, err := a.storage.Keys(ctx, 0, "*")
+	if err != nil {
+		return nil, err
+	}
+	var inSlot []string
+	for _, key := range keys {
+		if count >= 0 && len(inSlot) == count {
+			break
+		}
+		if cluster.Slot(key) == slot {
+			inSlot = append(inSlot, key)
+		}
+	}
+
+	return inSlot, nil
+}
+
+// migrate moves the keys to the node at addr: each is written there after ASKING and then deleted here.
+// The keys that do not exist are skipped.
+func (a App) migrate(ctx context.Context, addr string, keys []string) (reply.Reply, error) {
+	if a.cluster == nil {
+		return reply.Reply{}, cluster.ErrDisabled
+	}
+	a.migrateMu.Lock()
+	defer a.migrateMu.Unlock()
+
+	target, err := tcp.NewClient(addr, logger.L())
+	if err != nil {
+		return reply.Reply{}, err
+	}
+	defer target.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultMigrateTimeout)
+	}
+	if err = target.SetDeadline(deadline); err != nil {
+		return reply.Reply{}, err
+	}
+
+	for _, key := range keys {
+		value, errGet := a.storage.Get(ctx, 0, engine.KV{Key: key})
+		if errors.Is(errGet, engine.ErrNoKey) {
+			continue
+		}
+		if errGet != nil {
+			return reply.Reply{}, errGet
+		}
+		// a long SET is framed by the client, so the value is moved whatever its size
+		for _, cmd := range []struct{ name, query string }{
+			{"ASKING", "ASKING"},
+			{"SET", "SET " + parser.Quote(key) + " " + parser.Quote(value)},
+		} {
+			resp, errDo := target.Do(cmd.query)
+			if errDo != nil {
+				return reply.Reply{}, fmt.Errorf("%s: %w", addr, errDo)
+			}
+			if resp.Text != cmd.name+" ok" {
+				return reply.Reply{}, fmt.Errorf("%s: %s", addr, resp)
+			}
+		}
+		if err = a.storage.Del(ctx, 0, engine.KV{Key: key}); err != nil {
+			return reply.Reply{}, err
+		}
+		a.notifyDel(0, key)
+	}
+
+	return reply.OK("MIGRATE ok"), nil
+}
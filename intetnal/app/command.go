@@ -0,0 +1,340 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jokedb/intetnal/compute/analyzer"
+	"jokedb/intetnal/reply"
+	"jokedb/intetnal/storage"
+	"jokedb/intetnal/storage/engine"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	errNoHandler   = errors.New("command has no handler")
+	errUnknownHelp = errors.New("unknown command, HELP lists the commands")
+)
+
+// Call is a command of a client to run: its canonical name, its action and the database and the
+// session it runs on.
+type Call struct {
+	Name    string
+	Action  analyzer.Action
+	DB      int
+	Session *Session
+}
+
+// Handler runs a command and returns its reply, an error is answered as an error reply
+// "<CODE> <NAME> query :<error>".
+type Handler func(ctx context.Context, a App, c Call) (reply.Reply, error)
+
+// Command is a command an embedder adds to the App: how it is analyzed and classified, and how it runs.
+type Command struct {
+	analyzer.Command
+	Handle Handler
+}
+
+type handlers struct {
+	mu sync.RWMutex
+	m  map[engine.ActionType]Handler
+}
+
+func (h *handlers) get(t engine.ActionType) (Handler, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	handle, ok := h.m[t]
+	return handle, ok
+}
+
+func (h *handlers) set(t engine.ActionType, handle Handler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.m[t] = handle
+}
+
+// Register adds a command, its name and aliases must not be taken by another command. The command
+// runs under the cluster routing of its keys and is answered like the builtin commands. A write
+// command cannot be called by a script, its writes would not be part of the script transaction.
+func (a App) Register(c Command) error {
+	if c.Handle == nil {
+		return errNoHandler
+	}
+	if c.Flags.Has(analyzer.Write) {
+		c.Flags |= analyzer.NoScript
+	}
+	registered, err := a.processor.Registry().Register(c.Command)
+	if err != nil {
+		return err
+	}
+	a.handlers.set(registered.Type, c.Handle)
+
+	return nil
+}
+
+// Storage returns the storage of the App for the handlers of the registered commands.
+func (a App) Storage() Storage {
+	return a.storage
+}
+
+func newHandlers() *handlers {
+	return &handlers{m: map[engine.ActionType]Handler{
+		engine.SET: func(ctx context.Context, a App, c Call) (reply.Reply, error) {
+			if err := a.storage.Put(ctx, c.DB, c.Action.KV); err != nil {
+				return reply.Reply{}, err
+			}
+			return reply.OK("SET ok"), nil
+		},
+		engine.GET: func(ctx context.Context, a App, c Call) (reply.Reply, error) {
+			value, err := a.storage.Get(ctx, c.DB, c.Action.KV)
+			if err != nil {
+				return reply.Reply{}, err
+			}
+			return reply.Value(value), nil
+		},
+		engine.DEL: func(ctx context.Context, a App, c Call) (reply.Reply, error) {
+			_, errGet := a.storage.Get(ctx, c.DB, c.Action.KV)
+			if err := a.storage.Del(ctx, c.DB, c.Action.KV); err != nil {
+				return reply.Reply{}, err
+			}
+			if errGet == nil {
+				a.notifyDel(c.DB, c.Action.Key)
+			}
+			return reply.OK("DEL ok"), nil
+		},
+		engine.SLOWLOG: func(_ context.Context, a App, c Call) (reply.Reply, error) {
+			return a.slowLogCommand(c.Action.Args), nil
+		},
+		engine.SELECT: func(_ context.Context, a App, c Call) (reply.Reply, error) {
+			index, _ := strconv.Atoi(c.Action.Args[0])
+			if index > 0 && a.cluster != nil {
+				return reply.Reply{}, errClusterDB
+			}
+			if index >= a.storage.Databases() {
+				return reply.Reply{}, storage.ErrInvalidDB
+			}
+			c.Session.setDB(index)
+			return reply.OK("SELECT ok"), nil
+		},
+		engine.MOVE: func(ctx context.Context, a App, c Call) (reply.Reply, error) {
+			if a.cluster != nil {
+				return reply.Reply{}, errClusterDB
+			}
+			target, _ := strconv.Atoi(c.Action.Args[0])
+			if err := a.storage.Move(ctx, c.DB, c.Action.Key, target); err != nil {
+				return reply.Reply{}, err
+			}
+			return reply.OK("MOVE ok"), nil
+		},
+		engine.FLUSHDB: func(ctx context.Context, a App, c Call) (reply.Reply, error) {
+			if err := a.storage.FlushDB(ctx, c.DB); err != nil {
+				return reply.Reply{}, err
+			}
+			return reply.OK("FLUSHDB ok"), nil
+		},
+		engine.SWAPDB: func(ctx context.Context, a App, c Call) (reply.Reply, error) {
+			if a.cluster != nil {
+				return reply.Reply{}, errClusterDB
+			}
+			first, _ := strconv.Atoi(c.Action.Args[0])
+			second, _ := strconv.Atoi(c.Action.Args[1])
+			if err := a.storage.SwapDB(ctx, first, second); err != nil {
+				return reply.Reply{}, err
+			}
+			return reply.OK("SWAPDB ok"), nil
+		},
+		engine.SUBSCRIBE:   subscribeHandler,
+		engine.PSUBSCRIBE:  subscribeHandler,
+		engine.UNSUBSCRIBE: subscribeHandler,
+		engine.BACKUP: func(ctx context.Context, a App, c Call) (reply.Reply, error) {
+			return a.backup(ctx, c.Action.Args[0])
+		},
+		engine.LSN: func(_ context.Context, a App, _ Call) (reply.Reply, error) {
+			return reply.Int(int64(a.storage.LSN())), nil
+		},
+		engine.CLIENT: func(ctx context.Context, a App, c Call) (reply.Reply, error) {
+			return a.client(ctx, c.Action.Args)
+		},
+		engine.KEYS: func(ctx context.Context, a App, c Call) (reply.Reply, error) {
+			keys, err := a.storage.Keys(ctx, c.DB, keysPattern(c.Action.Args))
+			if err != nil {
+				return reply.Reply{}, err
+			}
+			return reply.Values(keys), nil
+		},
+		engine.SCAN: func(ctx context.Context, a App, c Call) (reply.Reply, error) {
+			return scan(ctx, c.Action.Args, func(ctx context.Context, cursor uint64, pattern string, count int, withValues bool) (uint64, []engine.KV, error) {
+				return a.storage.Scan(ctx, c.DB, c.Session.cursorOwner(), cursor, pattern, count, withValues)
+			})
+		},
+		engine.BEGIN: func(ctx context.Context, a App, c Call) (reply.Reply, error) {
+			if err := a.begin(ctx, c.Session, c.DB); err != nil {
+				return reply.Reply{}, err
+			}
+			return reply.OK("BEGIN ok"), nil
+		},
+		engine.COMMIT:   noTxHandler,
+		engine.ROLLBACK: noTxHandler,
+		engine.EVAL:     evalHandler,
+		engine.EVALSHA:  evalHandler,
+		engine.SCRIPT: func(_ context.Context, a App, c Call) (reply.Reply, error) {
+			return a.scriptCommand(c.Action.Args)
+		},
+		engine.INFO: func(_ context.Context, a App, c Call) (reply.Reply, error) {
+			return reply.Value(a.infoCommand(c.Action.Args)), nil
+		},
+		engine.ASKING: func(_ context.Context, _ App, c Call) (reply.Reply, error) {
+			c.Session.setAsking()
+			return reply.OK("ASKING ok"), nil
+		},
+		engine.CLUSTER: func(ctx context.Context, a App, c Call) (reply.Reply, error) {
+			return a.clusterCommand(ctx, c.Action.Args)
+		},
+		engine.MIGRATE: func(ctx context.Context, a App, c Call) (reply.Reply, error) {
+			return a.migrate(ctx, c.Action.Args[0], c.Action.Args[1:])
+		},
+		engine.PING: func(_ context.Context, _ App, c Call) (reply.Reply, error) {
+			if len(c.Action.Args) > 0 {
+				return reply.Value(c.Action.Args[0]), nil
+			}
+			return reply.OK("PONG"), nil
+		},
+		engine.DURABILITY: func(_ context.Context, _ App, c Call) (reply.Reply, error) {
+			if len(c.Action.Args) == 0 {
+				return reply.Value(c.Session.Durability().String()), nil
+			}
+			d, _ := storage.ParseDurability(c.Action.Args[0])
+			c.Session.setDurability(d)
+			return reply.OK("DURABILITY ok"), nil
+		},
+		engine.HELP: func(_ context.Context, a App, c Call) (reply.Reply, error) {
+			return a.help(c.Action.Args)
+		},
+		engine.COMMAND: func(_ context.Context, a App, c Call) (reply.Reply, error) {
+			return a.commandInfo(c.Action.Args), nil
+		},
+	}}
+}
+
+func subscribeHandler(ctx context.Context, a App, c Call) (reply.Reply, error) {
+	return a.subscribe(ctx, c.Session, c.Action)
+}
+
+func evalHandler(ctx context.Context, a App, c Call) (reply.Reply, error) {
+	return a.eval(ctx, c.Session, c.DB, c.Action)
+}
+
+// noTxHandler answers COMMIT and ROLLBACK out of a transaction.
+func noTxHandler(context.Context, App, Call) (reply.Reply, error) {
+	return reply.Reply{}, errNoTx
+}
+
+// help answers HELP with a line per command and HELP command with its usage, aliases and categories.
+func (a App) help(args []string) (reply.Reply, error) {
+	if len(args) == 0 {
+		commands := a.processor.Registry().Commands()
+		lines := make([]string, len(commands))
+		for i, c := range commands {
+			lines[i] = usage(c)
+		}
+		return reply.Values(lines), nil
+	}
+
+	c, ok := a.processor.Registry().Lookup(args[0])
+	if !ok {
+		return reply.Reply{}, errUnknownHelp
+	}
+	lines := []string{usage(c)}
+	if len(c.Aliases) > 0 {
+		lines = append(lines, "aliases: "+strings.Join(c.Aliases, ", "))
+	}
+	if categories := c.Flags.Strings(); len(categories) > 0 {
+		lines = append(lines, "categories: "+strings.Join(categories, ", "))
+	}
+
+	return reply.Values(lines), nil
+}
+
+func usage(c analyzer.Command) string {
+	line := c.Name
+	if c.Usage != "" {
+		line += " " + c.Usage
+	}
+	if c.Summary != "" {
+		line += " - " + c.Summary
+	}
+	return line
+}
+
+// commandInfo answers COMMAND COUNT, COMMAND LIST [category] with the names of the commands and
+// COMMAND [INFO [command...]] with a line per command: its name, arguments, flags, key positions and
+// aliases. An unknown command is nil.
+func (a App) commandInfo(args []string) reply.Reply {
+	registry := a.processor.Registry()
+	if len(args) == 0 {
+		args = []string{"INFO"}
+	}
+
+	switch args[0] {
+	case "COUNT":
+		return reply.Int(int64(len(registry.Commands())))
+	case "LIST":
+		var category analyzer.Flags
+		if len(args) == 2 {
+			category, _ = analyzer.ParseFlag(args[1])
+		}
+		var names []string
+		for _, c := range registry.ByCategory(category) {
+			names = append(names, c.Name)
+		}
+		return reply.Values(names)
+	}
+
+	var commands []analyzer.Command
+	if len(args) == 1 {
+		commands = registry.Commands()
+	}
+	items := make([]reply.Reply, 0, len(commands)+len(args)-1)
+	for _, c := range commands {
+		items = append(items, reply.Value(formatCommand(c)))
+	}
+	for _, name := range args[1:] {
+		if c, ok := registry.Lookup(name); ok {
+			items = append(items, reply.Value(formatCommand(c)))
+		} else {
+			items = append(items, reply.Nil())
+		}
+	}
+
+	return reply.Array(items...)
+}
+
+// formatCommand writes "<NAME> args=<n> flags=<flag,...> keys=<first>,<last>,<step> [aliases=<alias,...>]",
+// args is n, min..max or min+ and the keys of a command whose keys depend on its arguments are movable.
+func formatCommand(c analyzer.Command) string {
+	var args string
+	switch {
+	case c.MaxArgs == analyzer.Variadic:
+		args = fmt.Sprintf("%d+", c.MinArgs)
+	case c.MinArgs == c.MaxArgs:
+		args = strconv.Itoa(c.MinArgs)
+	default:
+		args = fmt.Sprintf("%d..%d", c.MinArgs, c.MaxArgs)
+	}
+	flags := strings.Join(c.Flags.Strings(), ",")
+	if flags == "" {
+		flags = "-"
+	}
+	keys := fmt.Sprintf("%d,%d,%d", c.Keys.First, c.Keys.Last, c.Keys.Step)
+	if c.KeysFunc != nil {
+		keys = "movable"
+	}
+
+	line := fmt.Sprintf("%s args=%s flags=%s keys=%s", c.Name, args, flags, keys)
+	if len(c.Aliases) > 0 {
+		line += " aliases=" + strings.Join(c.Aliases, ",")
+	}
+	return line
+}
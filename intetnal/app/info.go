@@ -0,0 +1,300 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"jokedb/intetnal/raft"
+	"jokedb/intetnal/storage/engine"
+	"jokedb/intetnal/tcp"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	defaultInfoSections = []string{"server", "clients", "memory", "persistence", "replication", "stats", "keyspace"}
+	allInfoSections     = append(append([]string(nil), defaultInfoSections...), "commandstats", "mvcc")
+)
+
+// InfoOptions are the parts of the INFO output the app does not know by itself.
+type InfoOptions struct {
+	ConfigFile string
+	Addr       string
+	// ConnStats returns the connection counts of the server, nil to omit them.
+	ConnStats func() tcp.ConnStats
+	// Raft returns the state of the raft node, nil for a standalone server.
+	Raft func() raft.Status
+}
+
+func WithInfo(opts InfoOptions) Option {
+	return func(a *App) {
+		a.info = opts
+	}
+}
+
+type commandStats struct {
+	mu       sync.Mutex
+	calls    map[string]uint64
+	failures map[string]uint64
+}
+
+func newCommandStats() *commandStats {
+	return &commandStats{calls: map[string]uint64{}, failures: map[string]uint64{}}
+}
+
+func (s *commandStats) record(name string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[name]++
+	if err != nil && !errors.Is(err, engine.ErrNoKey) {
+		s.failures[name]++
+	}
+}
+
+// snapshot returns the command names in order and copies of the counters.
+func (s *commandStats) snapshot() ([]string, map[string]uint64, map[string]uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.calls))
+	calls := make(map[string]uint64, len(s.calls))
+	failures := make(map[string]uint64, len(s.failures))
+	for name, n := range s.calls {
+		names = append(names, name)
+		calls[name] = n
+		failures[name] = s.failures[name]
+	}
+	sort.Strings(names)
+
+	return names, calls, failures
+}
+
+// infoCommand writes the sections in the Redis INFO format: a "# Name" header
+// and "key:value" lines each. No sections means the default ones.
+func (a App) infoCommand(sections []string) string {
+	switch {
+	case len(sections) == 0:
+		sections = defaultInfoSections
+	case len(sections) == 1 && strings.EqualFold(sections[0], "default"):
+		sections = defaultInfoSections
+	case len(sections) == 1 && (strings.EqualFold(sections[0], "all") || strings.EqualFold(sections[0], "everything")):
+		sections = allInfoSections
+	}
+
+	var b strings.Builder
+	for _, section := range sections {
+		section = strings.ToLower(section)
+		lines := a.infoSection(section)
+		if lines == nil {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("# " + strings.ToUpper(section[:1]) + section[1:] + "\n")
+		for _, line := range lines {
+			b.WriteString(line + "\n")
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func (a App) infoSection(section string) []string {
+	switch section {
+	case "server":
+		return a.infoServer()
+	case "clients":
+		return a.infoClients()
+	case "memory":
+		return a.infoMemory()
+	case "persistence":
+		return a.infoPersistence()
+	case "replication":
+		return a.infoReplication()
+	case "stats":
+		return a.infoStats()
+	case "commandstats":
+		return a.infoCommandStats()
+	case "keyspace":
+		return a.infoKeyspace()
+	case "mvcc":
+		return a.infoMVCC()
+	default:
+		return nil
+	}
+}
+
+func (a App) infoServer() []string {
+	version, revision := "(devel)", ""
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		version = bi.Main.Version
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" {
+				revision = s.Value
+			}
+		}
+	}
+
+	return []string{
+		"version:" + version,
+		"revision:" + revision,
+		"go_version:" + runtime.Version(),
+		fmt.Sprintf("pid:%d", os.Getpid()),
+		"addr:" + a.info.Addr,
+		"config_file:" + a.info.ConfigFile,
+		"started_at:" + a.startedAt.UTC().Format(time.RFC3339),
+		fmt.Sprintf("uptime_in_seconds:%d", int(time.Since(a.startedAt).Seconds())),
+	}
+}
+
+func (a App) infoClients() []string {
+	lines := []string{fmt.Sprintf("subscribers:%d", a.subscribers())}
+	if a.info.ConnStats == nil {
+		return lines
+	}
+
+	st := a.info.ConnStats()
+	return append([]string{
+		fmt.Sprintf("connected_clients:%d", st.Active),
+		fmt.Sprintf("queued_clients:%d", st.Queued),
+		fmt.Sprintf("rejected_connections:%d", st.Rejected),
+	}, lines...)
+}
+
+func (a App) infoReplication() []string {
+	if a.info.Raft == nil {
+		return []string{"role:master", "connected_replicas:0"}
+	}
+
+	st := a.info.Raft()
+	lines := []string{
+		"role:" + st.State.String(),
+		"raft_id:" + st.ID,
+		"raft_leader:" + st.Leader,
+		fmt.Sprintf("raft_term:%d", st.Term),
+		fmt.Sprintf("raft_last_index:%d", st.LastIndex),
+		fmt.Sprintf("raft_commit_index:%d", st.CommitIndex),
+		fmt.Sprintf("raft_last_applied:%d", st.LastApplied),
+		fmt.Sprintf("raft_snapshot_index:%d", st.SnapshotIndex),
+	}
+	peers := make([]string, 0, len(st.Match))
+	for id := range st.Match {
+		peers = append(peers, id)
+	}
+	sort.Strings(peers)
+	for _, id := range peers {
+		lines = append(lines, fmt.Sprintf("peer_%s:match=%d,lag=%d", id, st.Match[id], st.LastIndex-st.Match[id]))
+	}
+
+	return lines
+}
+
+func (a App) infoMemory() []string {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	var dataset int
+	for _, db := range a.storage.Stats().Databases {
+		dataset += db.Bytes
+	}
+
+	return []string{
+		fmt.Sprintf("used_memory:%d", m.HeapAlloc),
+		fmt.Sprintf("used_memory_sys:%d", m.Sys),
+		fmt.Sprintf("dataset_bytes:%d", dataset),
+		fmt.Sprintf("gc_cycles:%d", m.NumGC),
+	}
+}
+
+func (a App) infoPersistence() []string {
+	st := a.storage.Stats()
+	if st.WAL == nil {
+		return []string{"wal_enabled:0"}
+	}
+
+	lastSync := "-"
+	if !st.WAL.LastSync.IsZero() {
+		lastSync = st.WAL.LastSync.UTC().Format(time.RFC3339Nano)
+	}
+	lines := []string{
+		"wal_enabled:1",
+		"wal_fsync:" + st.WAL.SyncPolicy.String(),
+		fmt.Sprintf("wal_last_lsn:%d", st.WAL.LastLSN),
+		"wal_last_fsync:" + lastSync,
+		fmt.Sprintf("wal_segments:%d", len(st.WAL.Segments)),
+	}
+	for i, seg := range st.WAL.Segments {
+		lines = append(lines, fmt.Sprintf("segment%d:id=%d,size=%d", i, seg.ID, seg.Size))
+	}
+
+	return append(lines,
+		fmt.Sprintf("pending_queue_depth:%d", st.Pending.Depth),
+		fmt.Sprintf("pending_queue_capacity:%d", st.Pending.Capacity),
+		fmt.Sprintf("pending_blocked:%d", st.Pending.Blocked),
+		fmt.Sprintf("pending_rejected:%d", st.Pending.Rejected),
+		fmt.Sprintf("pending_failed:%d", st.Pending.Failed),
+		fmt.Sprintf("recovery_records:%d", st.Recovery.Records),
+		fmt.Sprintf("recovery_lsn:%d", st.Recovery.LSN),
+	)
+}
+
+func (a App) infoStats() []string {
+	names, calls, failures := a.commands.snapshot()
+	var total, failed uint64
+	for _, name := range names {
+		total += calls[name]
+		failed += failures[name]
+	}
+
+	return []string{
+		fmt.Sprintf("total_commands_processed:%d", total),
+		fmt.Sprintf("total_command_errors:%d", failed),
+		fmt.Sprintf("slowlog_len:%d", a.slowLog.Len()),
+		fmt.Sprintf("scripts_cached:%d", a.scripts.cache.Len()),
+		fmt.Sprintf("scripts_run:%d", a.scripts.runs.Load()),
+		fmt.Sprintf("scripts_killed:%d", a.scripts.killed.Load()),
+		fmt.Sprintf("scripts_timed_out:%d", a.scripts.timedOut.Load()),
+	}
+}
+
+func (a App) infoCommandStats() []string {
+	names, calls, failures := a.commands.snapshot()
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("cmdstat_%s:calls=%d,failed=%d", name, calls[name], failures[name]))
+	}
+
+	return lines
+}
+
+func (a App) infoKeyspace() []string {
+	var lines []string
+	for i, db := range a.storage.Stats().Databases {
+		if db.Keys > 0 {
+			lines = append(lines, fmt.Sprintf("db%d:keys=%d,bytes=%d", i, db.Keys, db.Bytes))
+		}
+	}
+	if lines == nil {
+		return []string{}
+	}
+
+	return lines
+}
+
+func (a App) infoMVCC() []string {
+	st := a.storage.SnapshotStats()
+	lines := []string{
+		fmt.Sprintf("open_transactions:%d", st.Transactions),
+		fmt.Sprintf("open_cursors:%d", st.Cursors),
+	}
+	for i, db := range st.Databases {
+		lines = append(lines, fmt.Sprintf("db%d:seq=%d,versions=%d,views=%d,garbage=%d", i, db.Seq, db.Versions, db.Views, db.Garbage))
+	}
+
+	return lines
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jokedb/intetnal/compute/analyzer"
+	"jokedb/intetnal/notify"
+	"jokedb/intetnal/reply"
+	"jokedb/intetnal/storage/engine"
+	"jokedb/intetnal/tcp"
+	"strconv"
+)
+
+const defaultNotifyBuffer = 128
+
+var errNoConnection = errors.New("subscriptions need a client connection")
+
+func WithNotifications(hub *notify.Hub, bufferSize int) Option {
+	return func(a *App) {
+		a.hub = hub
+		a.notifyBuffer.Store(int64(bufferSize))
+	}
+}
+
+func (a App) subscribers() int {
+	if a.hub == nil {
+		return 0
+	}
+	return a.hub.Subscribers()
+}
+
+// SetNotifyBuffer changes the buffer size of the new subscribers.
+func (a App) SetNotifyBuffer(bufferSize int) {
+	a.notifyBuffer.Store(int64(bufferSize))
+}
+
+// notifyWrite emits the keyspace notifications of a successful write. A DEL is notified by
+// notifyDel, once the key is known to be removed.
+func (a App) notifyWrite(db int, action analyzer.Action) {
+	if a.hub == nil {
+		return
+	}
+
+	switch action.Type {
+	case engine.SET:
+		a.hub.Notify(db, notify.EventSet, action.Key)
+	case engine.MOVE:
+		target, _ := strconv.Atoi(action.Args[0])
+		a.hub.Notify(db, notify.EventMoveFrom, action.Key)
+		a.hub.Notify(target, notify.EventMoveTo, action.Key)
+	case engine.FLUSHDB:
+		a.hub.Notify(db, notify.EventFlushDB, "")
+	case engine.SWAPDB:
+		first, _ := strconv.Atoi(action.Args[0])
+		second, _ := strconv.Atoi(action.Args[1])
+		a.hub.Notify(first, notify.EventSwapDB, "")
+		a.hub.Notify(second, notify.EventSwapDB, "")
+	}
+}
+
+// notifyDel emits the del notification of a removed key.
+func (a App) notifyDel(db int, key string) {
+	if a.hub != nil {
+		a.hub.Notify(db, notify.EventDel, key)
+	}
+}
+
+func (a App) subscribe(ctx context.Context, sess *Session, action analyzer.Action) (reply.Reply, error) {
+	conn, ok := tcp.ConnFromContext(ctx)
+	if !ok || a.hub == nil {
+		return reply.Reply{}, errNoConnection
+	}
+
+	sub := sess.getSubscriber(func() *notify.Subscriber {
+		sub := a.hub.Subscribe(int(a.notifyBuffer.Load()))
+		conn.OnClose(sub.Close)
+		conn.DisableIdleTimeout()
+		go forward(conn, sub)
+		return sub
+	})
+
+	var count int
+	switch action.Type {
+	case engine.SUBSCRIBE:
+		count = sub.Subscribe(action.Args...)
+	case engine.PSUBSCRIBE:
+		count = sub.PSubscribe(action.Args...)
+	default:
+		count = sub.Unsubscribe(action.Args...)
+	}
+
+	cmd, _ := a.processor.Registry().ByType(action.Type)
+	return reply.OK(fmt.Sprintf("%s ok %d", cmd.Name, count)), nil
+}
+
+// forward pushes the notifications to the client, each is an array of message, the channel and the payload.
+func forward(conn *tcp.HandlerConn, sub *notify.Subscriber) {
+	for msg := range sub.C() {
+		push := reply.Array(reply.Value("message"), reply.Value(msg.Channel), reply.Value(msg.Payload))
+		if _, err := conn.Write(push.Encode()); err != nil {
+			sub.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jokedb/intetnal/compute/analyzer"
+	"jokedb/intetnal/compute/parser"
+	"jokedb/intetnal/reply"
+	"jokedb/intetnal/script"
+	"jokedb/intetnal/storage"
+	"jokedb/intetnal/storage/engine"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultScriptTimeout is how long a script runs before it is stopped and its writes are dropped.
+	DefaultScriptTimeout = 5 * time.Second
+	// DefaultScriptMaxValueSize is the largest value in bytes a script may make or read.
+	DefaultScriptMaxValueSize = 64 * 1024 * 1024
+	// scriptCacheSize is the bytes of the sources of the cached scripts, a new script evicts others over it.
+	scriptCacheSize = 64 * 1024 * 1024
+)
+
+var (
+	errNotInScript     = errors.New("command is not allowed in a script")
+	errNoScriptRunning = errors.New("no script is running")
+	errScriptKilled    = errors.New("script killed by SCRIPT KILL")
+	errScriptTimeout   = errors.New("script timed out")
+	errScriptWrite     = errors.New("scripts cannot write in raft mode")
+)
+
+// scripting runs the EVAL scripts one at a time: a script holds mu exclusively and the other commands
+// hold it shared, so no other command runs in the middle of a script.
+type scripting struct {
+	mu           sync.RWMutex
+	cache        *script.Cache
+	timeout      atomic.Int64
+	maxValueSize atomic.Int64
+
+	runMu sync.Mutex
+	// kill stops the running script, nil while none is running.
+	kill context.CancelCauseFunc
+
+	runs     atomic.Uint64
+	killed   atomic.Uint64
+	timedOut atomic.Uint64
+}
+
+func newScripting() *scripting {
+	s := &scripting{cache: script.NewCache(scriptCacheSize)}
+	s.timeout.Store(int64(DefaultScriptTimeout))
+	s.maxValueSize.Store(DefaultScriptMaxValueSize)
+	return s
+}
+
+// WithScriptTimeout limits the run time of the scripts.
+func WithScriptTimeout(d time.Duration) Option {
+	return func(a *App) {
+		a.scripts.timeout.Store(int64(d))
+	}
+}
+
+// SetScriptTimeout changes the run time limit of the next scripts.
+func (a App) SetScriptTimeout(d time.Duration) {
+	a.scripts.timeout.Store(int64(d))
+}
+
+// WithScriptMaxValueSize limits the bytes of a value a script makes or reads, a script over it fails.
+func WithScriptMaxValueSize(n int) Option {
+	return func(a *App) {
+		a.scripts.maxValueSize.Store(int64(n))
+	}
+}
+
+// SetScriptMaxValueSize changes the value size limit of the next scripts.
+func (a App) SetScriptMaxValueSize(n int) {
+	a.scripts.maxValueSize.Store(int64(n))
+}
+
+// lock takes the script lock for a command of a client. SCRIPT does not wait for a running script
+// so that SCRIPT KILL can stop it.
+func (s *scripting) lock(t engine.ActionType) func() {
+	switch t {
+	case engine.EVAL, engine.EVALSHA:
+		s.mu.Lock()
+		return s.mu.Unlock
+	case engine.SCRIPT:
+		return func() {}
+	default:
+		s.mu.RLock()
+		return s.mu.RUnlock
+	}
+}
+
+// start returns the context of a script run, it is done on SCRIPT KILL or after the timeout.
+func (s *scripting) start(ctx context.Context) (context.Context, func()) {
+	timeout := time.Duration(s.timeout.Load())
+	runCtx, kill := context.WithCancelCause(ctx)
+	runCtx, cancel := context.WithTimeoutCause(runCtx, timeout, fmt.Errorf("%w after %s", errScriptTimeout, timeout))
+
+	s.runMu.Lock()
+	s.kill = kill
+	s.runMu.Unlock()
+	s.runs.Add(1)
+
+	return runCtx, func() {
+		s.runMu.Lock()
+		s.kill = nil
+		s.runMu.Unlock()
+		cancel()
+		kill(nil)
+	}
+}
+
+func (s *scripting) killRunning() error {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	if s.kill == nil {
+		return errNoScriptRunning
+	}
+	s.kill(errScriptKilled)
+	return nil
+}
+
+type scriptKey struct{}
+
+// inScript reports whether the command is called by a running script.
+func inScript(ctx context.Context) bool {
+	_, ok := ctx.Value(scriptKey{}).(bool)
+	return ok
+}
+
+// eval runs EVAL script numkeys key... arg... and EVALSHA sha numkeys key... arg... in a transaction
+// of its own: the writes of the script are applied together when it ends and dropped when it fails.
+func (a App) eval(ctx context.Context, sess *Session, db int, action analyzer.Action) (reply.Reply, error) {
+	var s *script.Script
+	var err error
+	if action.Type == engine.EVAL {
+		_, s, err = a.scripts.cache.Load(action.Args[0])
+	} else {
+		s, err = a.scripts.cache.Get(action.Args[0])
+	}
+	if err != nil {
+		return reply.Reply{}, err
+	}
+	n, _ := strconv.Atoi(action.Args[1])
+	env := script.Env{
+		Keys:         action.Args[2 : 2+n],
+		Argv:         action.Args[2+n:],
+		MaxValueSize: int(a.scripts.maxValueSize.Load()),
+	}
+
+	tx, err := a.storage.Begin(ctx, db)
+	if err != nil {
+		return reply.Reply{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	runCtx, done := a.scripts.start(ctx)
+	defer done()
+	callCtx := context.WithValue(runCtx, sessionKey{}, &Session{db: db, durability: sess.Durability(), tx: tx, txHooked: true})
+	callCtx = context.WithValue(callCtx, scriptKey{}, true)
+	env.Call = func(_ context.Context, args []string) (script.Value, error) {
+		return a.scriptCall(callCtx, args)
+	}
+
+	v, err := s.Run(runCtx, env)
+	switch {
+	case errors.Is(err, errScriptKilled):
+		a.scripts.killed.Add(1)
+	case errors.Is(err, errScriptTimeout):
+		a.scripts.timedOut.Add(1)
+	}
+	if err != nil {
+		return reply.Reply{}, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return reply.Reply{}, err
+	}
+	a.notifyCommit(db, tx.Writes())
+
+	return toReply(v), nil
+}
+
+// scriptCall runs a command of the call function, a missing key is nil. The writes of a script
+// are a transaction, which the replicated storage does not support.
+func (a App) scriptCall(ctx context.Context, args []string) (script.Value, error) {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = parser.Quote(arg)
+	}
+	r, err := a.Do(ctx, strings.Join(quoted, " "))
+	switch {
+	case errors.Is(err, engine.ErrNoKey):
+		return nil, nil
+	case errors.Is(err, storage.ErrReplicatedTx):
+		return nil, errScriptWrite
+	case err != nil:
+		return nil, err
+	}
+
+	return fromReply(r), nil
+}
+
+// fromReply is the script value of a reply: a status or a value is a string, an array a list.
+func fromReply(r reply.Reply) script.Value {
+	switch r.Kind {
+	case reply.KindNil:
+		return nil
+	case reply.KindInt:
+		return r.Int
+	case reply.KindArray:
+		values := make([]script.Value, len(r.Array))
+		for i, item := range r.Array {
+			values[i] = fromReply(item)
+		}
+		return values
+	default:
+		return r.Text
+	}
+}
+
+// toReply is the reply of the result of a script: true is 1 and false is nil.
+func toReply(v script.Value) reply.Reply {
+	switch v := v.(type) {
+	case nil:
+		return reply.Nil()
+	case bool:
+		if v {
+			return reply.Int(1)
+		}
+		return reply.Nil()
+	case int64:
+		return reply.Int(v)
+	case []script.Value:
+		items := make([]reply.Reply, len(v))
+		for i, item := range v {
+			items[i] = toReply(item)
+		}
+		return reply.Array(items...)
+	default:
+		return reply.Value(script.String(v))
+	}
+}
+
+// scriptCommand runs SCRIPT LOAD | EXISTS | FLUSH | KILL.
+func (a App) scriptCommand(args []string) (reply.Reply, error) {
+	switch args[0] {
+	case "LOAD":
+		sha, _, err := a.scripts.cache.Load(args[1])
+		if err != nil {
+			return reply.Reply{}, err
+		}
+		return reply.Value(sha), nil
+	case "EXISTS":
+		items := make([]reply.Reply, 0, len(args)-1)
+		for _, sha := range args[1:] {
+			if _, err := a.scripts.cache.Get(sha); err != nil {
+				items = append(items, reply.Int(0))
+			} else {
+				items = append(items, reply.Int(1))
+			}
+		}
+		return reply.Array(items...), nil
+	case "FLUSH":
+		a.scripts.cache.Flush()
+		return reply.OK("SCRIPT ok"), nil
+	default:
+		if err := a.scripts.killRunning(); err != nil {
+			return reply.Reply{}, err
+		}
+		return reply.OK("SCRIPT ok"), nil
+	}
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"context"
+	"jokedb/intetnal/notify"
+	"jokedb/intetnal/storage"
+	"jokedb/intetnal/tcp"
+	"sync"
+)
+
+// Session is the state of one client connection.
+type Session struct {
+	mu         sync.Mutex
+	db         int
+	durability storage.Durability
+	subscriber *notify.Subscriber
+	// asking lets the next command reach a slot this node imports, see the ASKING command.
+	asking bool
+	// tx is the transaction begun by BEGIN, txHooked is set once its rollback on close is registered.
+	tx       *storage.Tx
+	txHooked bool
+	// owner owns the SCAN cursors of the session, the session itself unless it is shared.
+	owner any
+}
+
+func (s *Session) DB() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db
+}
+
+func (s *Session) setDB(db int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.db = db
+}
+
+func (s *Session) Durability() storage.Durability {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.durability
+}
+
+func (s *Session) setDurability(d storage.Durability) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.durability = d
+}
+
+func (s *Session) setAsking() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.asking = true
+}
+
+// takeAsking returns and clears the ASKING flag, it applies to one command only.
+func (s *Session) takeAsking() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	asking := s.asking
+	s.asking = false
+	return asking
+}
+
+func (s *Session) transaction() *storage.Tx {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tx
+}
+
+// setTx sets the transaction of the session and reports whether its rollback on close must be registered.
+func (s *Session) setTx(tx *storage.Tx) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tx = tx
+	if tx == nil || s.txHooked {
+		return false
+	}
+	s.txHooked = true
+	return true
+}
+
+// rollback drops the transaction of a closed connection.
+func (s *Session) rollback() {
+	if tx := s.transaction(); tx != nil {
+		s.setTx(nil)
+		_ = tx.Rollback()
+	}
+}
+
+// getSubscriber returns the notification subscriber of the session, creating it with newSubscriber.
+func (s *Session) getSubscriber(newSubscriber func() *notify.Subscriber) *notify.Subscriber {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscriber == nil {
+		s.subscriber = newSubscriber()
+	}
+	return s.subscriber
+}
+
+type sessionKey struct{}
+
+// NewSessionContext returns a context the queries of which share one session,
+// for the callers that have no client connection.
+func NewSessionContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &Session{})
+}
+
+// NewSharedSessionContext is NewSessionContext for a caller that makes a session per request, the SCAN
+// cursors of the session are continued by the other sessions of the comparable owner.
+func NewSharedSessionContext(ctx context.Context, owner any) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &Session{owner: owner})
+}
+
+// cursorOwner returns the owner of the SCAN cursors of the session.
+func (s *Session) cursorOwner() any {
+	if s.owner != nil {
+		return s.owner
+	}
+	return s
+}
+
+// session returns the session of the connection the query came from or of NewSessionContext.
+// Other queries get a new session on the database 0.
+func session(ctx context.Context) *Session {
+	if s, ok := ctx.Value(sessionKey{}).(*Session); ok {
+		return s
+	}
+	conn, ok := tcp.ConnFromContext(ctx)
+	if !ok {
+		return &Session{}
+	}
+
+	if s, isSession := conn.Session().(*Session); isSession {
+		return s
+	}
+	s := &Session{}
+	conn.SetSession(s)
+	return s
+}
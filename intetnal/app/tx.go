@@ -0,0 +1,157 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jokedb/intetnal/compute/analyzer"
+	"jokedb/intetnal/reply"
+	"jokedb/intetnal/storage"
+	"jokedb/intetnal/storage/engine"
+	"jokedb/intetnal/tcp"
+	"strconv"
+)
+
+const defaultScanCount = 10
+
+var (
+	errNoTx      = errors.New("no transaction, BEGIN first")
+	errTxStarted = errors.New("transaction is already begun")
+	errInTx      = errors.New("command is not allowed in a transaction")
+)
+
+// isTxCommand reports whether the command is run by txCommand while the session has a transaction.
+func isTxCommand(t engine.ActionType) bool {
+	switch t {
+	case engine.SET, engine.GET, engine.DEL, engine.KEYS, engine.SCAN, engine.BEGIN, engine.COMMIT, engine.ROLLBACK,
+		engine.SELECT, engine.MOVE, engine.FLUSHDB, engine.SWAPDB, engine.MIGRATE, engine.EVAL, engine.EVALSHA:
+		return true
+	default:
+		return false
+	}
+}
+
+// begin starts a snapshot transaction of the session, it is rolled back when the connection closes.
+func (a App) begin(ctx context.Context, sess *Session, db int) error {
+	tx, err := a.storage.Begin(ctx, db)
+	if err != nil {
+		return err
+	}
+	if sess.setTx(tx) {
+		if conn, ok := tcp.ConnFromContext(ctx); ok {
+			conn.OnClose(sess.rollback)
+		}
+	}
+
+	return nil
+}
+
+// txCommand runs a command of a session in a transaction: the reads see the snapshot of BEGIN and
+// the writes of the transaction, the writes are applied on COMMIT.
+func (a App) txCommand(ctx context.Context, sess *Session, tx *storage.Tx, name string, action analyzer.Action) (reply.Reply, error) {
+	queued := reply.OK("QUEUED")
+	if inScript(ctx) {
+		// the writes of a script are applied when it ends, for the script they are done
+		queued = reply.OK(name + " ok")
+	}
+	var result reply.Reply
+	var err error
+	switch action.Type {
+	case engine.SET:
+		if err = tx.Set(action.Key, action.Value); err == nil {
+			result = queued
+		}
+	case engine.GET:
+		var value string
+		value, err = tx.Get(ctx, action.Key)
+		result = reply.Value(value)
+	case engine.DEL:
+		if err = tx.Del(action.Key); err == nil {
+			result = queued
+		}
+	case engine.KEYS:
+		var keys []string
+		keys, err = tx.Keys(ctx, keysPattern(action.Args))
+		result = reply.Values(keys)
+	case engine.SCAN:
+		result, err = scan(ctx, action.Args, tx.Scan)
+	case engine.COMMIT:
+		sess.setTx(nil)
+		if err = tx.Commit(ctx); err == nil {
+			writes := tx.Writes()
+			result = reply.OK(fmt.Sprintf("COMMIT ok %d writes", len(writes)))
+			a.notifyCommit(tx.DB(), writes)
+		}
+	case engine.ROLLBACK:
+		sess.setTx(nil)
+		if err = tx.Rollback(); err == nil {
+			result = reply.OK("ROLLBACK ok")
+		}
+	case engine.BEGIN:
+		err = errTxStarted
+	default:
+		err = errInTx
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrTxDone) {
+			// the transaction expired, the next command runs without it
+			sess.setTx(nil)
+		}
+		return reply.Reply{}, fmt.Errorf("%s query :%w", name, err)
+	}
+
+	return result, nil
+}
+
+func (a App) notifyCommit(db int, writes []engine.Write) {
+	for _, w := range writes {
+		if w.Deleted {
+			a.notifyDel(db, w.Key)
+			continue
+		}
+		a.notifyWrite(db, analyzer.Action{Type: engine.SET, KV: engine.KV{Key: w.Key}})
+	}
+}
+
+type scanFunc func(ctx context.Context, cursor uint64, pattern string, count int, withValues bool) (uint64, []engine.KV, error)
+
+// scan runs SCAN cursor [MATCH pattern] [COUNT n] [WITHVALUES], the reply is the next cursor and the keys,
+// each followed by its value with WITHVALUES.
+func scan(ctx context.Context, args []string, fn scanFunc) (reply.Reply, error) {
+	cursor, _ := strconv.ParseUint(args[0], 10, 64)
+	pattern, count, withValues := "*", defaultScanCount, false
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "MATCH":
+			pattern = args[i+1]
+			i++
+		case "COUNT":
+			count, _ = strconv.Atoi(args[i+1])
+			i++
+		case "WITHVALUES":
+			withValues = true
+		}
+	}
+
+	next, items, err := fn(ctx, cursor, pattern, count, withValues)
+	if err != nil {
+		return reply.Reply{}, err
+	}
+	result := make([]reply.Reply, 0, 2*len(items)+1)
+	result = append(result, reply.Int(int64(next)))
+	for _, kv := range items {
+		result = append(result, reply.Value(kv.Key))
+		if withValues {
+			result = append(result, reply.Value(kv.Value))
+		}
+	}
+
+	return reply.Array(result...), nil
+}
+
+func keysPattern(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return "*"
+}
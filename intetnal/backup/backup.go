@@ -0,0 +1,236 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"jokedb/intetnal/storage"
+	"jokedb/intetnal/wal"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	ManifestFile = "manifest.json"
+	SnapshotFile = "snapshot.seg"
+	SegmentsDir  = "wal"
+
+	manifestVersion  = 1
+	snapshotBatchLen = 1000
+)
+
+var ErrNotEmpty = errors.New("directory is not empty")
+
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest describes a backup: the snapshot is the state after replaying the segments.
+type Manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Databases int       `json:"databases"`
+	Keys      int       `json:"keys"`
+	LSN       uint64    `json:"lsn,omitempty"`
+	Snapshot  File      `json:"snapshot"`
+	Segments  []File    `json:"segments"`
+}
+
+// Create writes the checkpoint to dir, which must be empty or not exist. The
+// manifest is written last, so a backup without it is incomplete.
+func Create(dir string, c storage.Checkpoint) (*Manifest, error) {
+	if err := prepareDir(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, SegmentsDir), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{
+		Version:   manifestVersion,
+		CreatedAt: time.Now().UTC(),
+		Databases: c.Databases,
+		Keys:      len(c.Snapshot),
+		LSN:       c.LSN,
+	}
+
+	snapshot, err := writeSnapshot(filepath.Join(dir, SnapshotFile), c.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+	m.Snapshot = snapshot
+
+	for _, segment := range c.Segments {
+		name := filepath.Join(SegmentsDir, filepath.Base(segment))
+		f, errCopy := copyFile(segment, filepath.Join(dir, name))
+		if errCopy != nil {
+			return nil, errCopy
+		}
+		f.Name = name
+		m.Segments = append(m.Segments, f)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(filepath.Join(dir, ManifestFile), data, 0644); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Verify checks the checksums of the backup files and decodes every record.
+func Verify(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("manifest: %w", err)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("manifest: unsupported version %d", m.Version)
+	}
+
+	for _, f := range append([]File{m.Snapshot}, m.Segments...) {
+		if err = verifyFile(dir, f); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// ReadSnapshot returns the snapshot records of a verified backup.
+func ReadSnapshot(dir string) ([]wal.LogData, error) {
+	return readRecords(filepath.Join(dir, SnapshotFile))
+}
+
+func verifyFile(dir string, f File) error {
+	path := filepath.Join(dir, f.Name)
+	sum, size, err := checksum(path)
+	if err != nil {
+		return err
+	}
+	if size != f.Size {
+		return fmt.Errorf("%s: size %d, manifest %d", f.Name, size, f.Size)
+	}
+	if sum != f.SHA256 {
+		return fmt.Errorf("%s: checksum mismatch", f.Name)
+	}
+	if _, err = readRecords(path); err != nil {
+		return fmt.Errorf("%s: %w", f.Name, err)
+	}
+
+	return nil
+}
+
+func readRecords(path string) ([]wal.LogData, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	batches, err := wal.DecodeBatches(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var logs []wal.LogData
+	for _, b := range batches {
+		logs = append(logs, b.Logs...)
+	}
+
+	return logs, nil
+}
+
+func writeSnapshot(path string, logs []wal.LogData) (File, error) {
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return File{}, err
+	}
+	defer fd.Close()
+
+	h := sha256.New()
+	w := io.MultiWriter(fd, h)
+	var size int64
+	for start := 0; start < len(logs); start += snapshotBatchLen {
+		data, errEncode := wal.EncodeBatch(logs[start:min(start+snapshotBatchLen, len(logs))])
+		if errEncode != nil {
+			return File{}, errEncode
+		}
+		if _, err = w.Write(data); err != nil {
+			return File{}, err
+		}
+		size += int64(len(data))
+	}
+	if err = fd.Sync(); err != nil {
+		return File{}, err
+	}
+
+	return File{Name: SnapshotFile, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func copyFile(src, dst string) (File, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return File{}, err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return File{}, err
+	}
+	defer out.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, h), in)
+	if err != nil {
+		return File{}, err
+	}
+	if err = out.Sync(); err != nil {
+		return File{}, err
+	}
+
+	return File{Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func checksum(path string) (string, int64, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer fd.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, fd)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+func prepareDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return os.MkdirAll(dir, os.ModePerm)
+		}
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%s: %w", dir, ErrNotEmpty)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package backup_test
+
+import (
+	"context"
+	"jokedb/intetnal/backup"
+	"jokedb/intetnal/storage"
+	"jokedb/intetnal/storage/engine"
+	"jokedb/intetnal/wal"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	w, err := wal.Open(wal.WithDirPath(t.TempDir()))
+	require.NoError(t, err)
+	s, err := storage.New(engine.New(), w, 1, time.Millisecond, storage.WithDatabases(2))
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+
+	require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "key_1", Value: "value_1"}))
+	require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "key_2", Value: "value_2"}))
+	require.NoError(t, s.Move(ctx, 0, "key_2", 1))
+
+	checkpoint, err := s.Checkpoint(ctx)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "key_3", Value: "after_backup"}))
+
+	backupDir := filepath.Join(t.TempDir(), "backup")
+	m, err := backup.Create(backupDir, checkpoint)
+	require.NoError(t, err)
+	require.Equal(t, 2, m.Keys)
+	require.Equal(t, 2, m.Databases)
+
+	_, err = backup.Create(backupDir, checkpoint)
+	require.ErrorIs(t, err, backup.ErrNotEmpty)
+
+	for name, compact := range map[string]bool{"segments": false, "compact": true} {
+		t.Run(name, func(t *testing.T) {
+			walDir := filepath.Join(t.TempDir(), "wal")
+			_, err = backup.Restore(backupDir, walDir, compact)
+			require.NoError(t, err)
+
+			restoredWAL, errOpen := wal.Open(wal.WithDirPath(walDir))
+			require.NoError(t, errOpen)
+			restored, errNew := storage.New(engine.New(), restoredWAL, 1, time.Millisecond, storage.WithDatabases(2))
+			require.NoError(t, errNew)
+			t.Cleanup(restored.Close)
+
+			require.Equal(t, []map[string]string{
+				{"key_1": "value_1"},
+				{"key_2": "value_2"},
+			}, restored.Dump())
+		})
+	}
+
+	t.Run("corrupt", func(t *testing.T) {
+		path := filepath.Join(backupDir, backup.SnapshotFile)
+		data, errRead := os.ReadFile(path)
+		require.NoError(t, errRead)
+		data[len(data)-1] ^= 0xff
+		require.NoError(t, os.WriteFile(path, data, 0600))
+
+		_, err = backup.Verify(backupDir)
+		require.ErrorContains(t, err, "checksum mismatch")
+	})
+}
+
+func TestBackupRestore_Async(t *testing.T) {
+	ctx := context.Background()
+	w, err := wal.Open(wal.WithDirPath(t.TempDir()))
+	require.NoError(t, err)
+	// the async writes stay queued until the checkpoint
+	s, err := storage.New(engine.New(), w, 100, time.Hour)
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+
+	async := storage.WithDurability(ctx, storage.DurabilityAsync)
+	require.NoError(t, s.Put(async, 0, engine.KV{Key: "key_1", Value: "value_1"}))
+	require.NoError(t, s.Put(async, 0, engine.KV{Key: "key_2", Value: "value_2"}))
+	require.Equal(t, uint64(0), s.LSN())
+
+	checkpoint, err := s.Checkpoint(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), checkpoint.LSN)
+
+	backupDir := filepath.Join(t.TempDir(), "backup")
+	_, err = backup.Create(backupDir, checkpoint)
+	require.NoError(t, err)
+
+	walDir := filepath.Join(t.TempDir(), "wal")
+	_, err = backup.Restore(backupDir, walDir, false)
+	require.NoError(t, err)
+	restoredWAL, err := wal.Open(wal.WithDirPath(walDir))
+	require.NoError(t, err)
+	restored, err := storage.New(engine.New(), restoredWAL, 1, time.Millisecond)
+	require.NoError(t, err)
+	t.Cleanup(restored.Close)
+	require.Equal(t, []map[string]string{{"key_1": "value_1", "key_2": "value_2"}}, restored.Dump())
+}
@@ -0,0 +1,70 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"jokedb/intetnal/storage"
+	"jokedb/intetnal/storage/engine"
+	"jokedb/intetnal/wal"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+var ErrSnapshotMismatch = errors.New("replayed segments do not match the snapshot")
+
+// Restore verifies the backup and lays it out in walDir, which must be empty or
+// not exist, so that wal.Open can start from it. The segments are copied as is,
+// or only the snapshot is written as the first segment when compact is set.
+// The restored directory is replayed and compared with the snapshot.
+func Restore(backupDir, walDir string, compact bool) (*Manifest, error) {
+	m, err := Verify(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	if err = prepareDir(walDir); err != nil {
+		return nil, err
+	}
+
+	if compact {
+		if _, err = copyFile(filepath.Join(backupDir, SnapshotFile), wal.SegmentFileName(walDir, 1)); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, f := range m.Segments {
+			if _, err = copyFile(filepath.Join(backupDir, f.Name), filepath.Join(walDir, filepath.Base(f.Name))); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	snapshot, err := ReadSnapshot(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	replayed, err := replay(walDir, m.Databases)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshot) != len(replayed) || (len(snapshot) > 0 && !reflect.DeepEqual(snapshot, replayed)) {
+		return nil, fmt.Errorf("%w: %d keys replayed, %d in the snapshot", ErrSnapshotMismatch, len(replayed), len(snapshot))
+	}
+
+	return m, nil
+}
+
+func replay(walDir string, databases int) ([]wal.LogData, error) {
+	w, err := wal.Open(wal.WithDirPath(walDir))
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+
+	s, err := storage.New(engine.New(), w, 1, time.Second, storage.WithDatabases(databases))
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	return storage.SnapshotLogs(s.Dump()), nil
+}
@@ -0,0 +1,144 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"jokedb/intetnal/reply"
+	"jokedb/intetnal/tcp"
+	"sync"
+)
+
+const maxRedirects = 5
+
+var ErrTooManyRedirects = errors.New("too many cluster redirects")
+
+// Client sends the commands on a key to the node serving its slot. It learns the slot map with
+// CLUSTER SLOTS, updates it on MOVED and sends ASKING before the command on ASK. The client
+// is safe for concurrent use, the commands are sent one at a time.
+type Client struct {
+	mu     sync.Mutex
+	logger tcp.Logger
+	seeds  []string
+	conns  map[string]*tcp.Client
+	slots  [SlotCount]string
+}
+
+// NewClient loads the slot map from the first of seeds that answers.
+func NewClient(seeds []string, logger tcp.Logger) (*Client, error) {
+	if len(seeds) == 0 {
+		return nil, errors.New("no cluster nodes to connect to")
+	}
+	c := &Client{
+		logger: logger,
+		seeds:  seeds,
+		conns:  map[string]*tcp.Client{},
+	}
+	if err := c.Refresh(); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Refresh reloads the slot map from the seeds or the nodes the client has connected to.
+func (c *Client) Refresh() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	addrs := append([]string(nil), c.seeds...)
+	for addr := range c.conns {
+		addrs = append(addrs, addr)
+	}
+	var lastErr error
+	for _, addr := range addrs {
+		r, err := c.send(addr, "CLUSTER SLOTS")
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		owners, err := ParseSlots(r.String())
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %w", r, err)
+			continue
+		}
+		c.slots = [SlotCount]string{}
+		for _, o := range owners {
+			for slot := o.From; slot <= o.To; slot++ {
+				c.slots[slot] = o.Addr
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("load the slot map: %w", lastErr)
+}
+
+// Do sends cmd to the node serving the slot of key and returns its reply, an error reply is returned
+// with its *reply.Error.
+func (c *Client) Do(key, cmd string) (reply.Reply, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	addr := c.slots[Slot(key)]
+	if addr == "" {
+		addr = c.seeds[0]
+	}
+	asking := false
+	for i := 0; i <= maxRedirects; i++ {
+		if asking {
+			if r, err := c.send(addr, "ASKING"); err != nil || r.Text != "ASKING ok" {
+				return r, err
+			}
+		}
+		r, err := c.send(addr, cmd)
+		redirect, ok := redirectOf(err)
+		if !ok {
+			return r, err
+		}
+		addr, asking = redirect.Addr, redirect.Kind == Ask
+		if redirect.Kind == Moved {
+			c.slots[redirect.Slot] = redirect.Addr
+		}
+	}
+
+	return reply.Reply{}, ErrTooManyRedirects
+}
+
+// redirectOf returns the redirect of a MOVED or ASK error reply.
+func redirectOf(err error) (*RedirectError, bool) {
+	var e *reply.Error
+	if !errors.As(err, &e) || (e.Code != reply.Moved && e.Code != reply.Ask) {
+		return nil, false
+	}
+	return ParseRedirect(e.Error())
+}
+
+// send sends cmd to the node at addr, a broken connection is dropped and dialed again by the next call.
+func (c *Client) send(addr, cmd string) (reply.Reply, error) {
+	conn, ok := c.conns[addr]
+	if !ok {
+		var err error
+		if conn, err = tcp.NewClient(addr, c.logger); err != nil {
+			return reply.Reply{}, err
+		}
+		c.conns[addr] = conn
+	}
+	r, err := conn.Do(cmd)
+	var replyErr *reply.Error
+	if err != nil && !errors.As(err, &replyErr) {
+		conn.Close()
+		delete(c.conns, addr)
+	}
+
+	return r, err
+}
+
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for addr, conn := range c.conns {
+		conn.Close()
+		delete(c.conns, addr)
+	}
+}
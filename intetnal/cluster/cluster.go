@@ -0,0 +1,334 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+var ErrDisabled = errors.New("cluster mode is not enabled")
+
+// RedirectKind tells the client whether to update its slot map (MOVED) or to
+// send one command only to the other node (ASK) while the slot is migrated.
+type RedirectKind string
+
+const (
+	Moved RedirectKind = "MOVED"
+	Ask   RedirectKind = "ASK"
+)
+
+// RedirectError is returned for a key of a slot served by the node at Addr.
+type RedirectError struct {
+	Kind RedirectKind
+	Slot int
+	Addr string
+}
+
+func (e *RedirectError) Error() string {
+	return string(e.Kind) + " " + strconv.Itoa(e.Slot) + " " + e.Addr
+}
+
+// ParseRedirect parses a MOVED or ASK response.
+func ParseRedirect(resp string) (*RedirectError, bool) {
+	var kind, addr string
+	var slot int
+	if n, _ := fmt.Sscanf(resp, "%s %d %s", &kind, &slot, &addr); n != 3 {
+		return nil, false
+	}
+	if kind != string(Moved) && kind != string(Ask) {
+		return nil, false
+	}
+
+	return &RedirectError{Kind: RedirectKind(kind), Slot: slot, Addr: addr}, true
+}
+
+// DownError is returned for a key of a slot no node is assigned.
+type DownError struct {
+	Slot int
+}
+
+func (e *DownError) Error() string {
+	return fmt.Sprintf("CLUSTERDOWN slot %d is not served", e.Slot)
+}
+
+// Node is a member of the cluster, Addr is the address its clients connect to.
+type Node struct {
+	ID    string
+	Addr  string
+	Slots []SlotRange
+}
+
+// NodeStatus is a node and the slots it migrates to or imports from the other nodes.
+type NodeStatus struct {
+	Node
+	Myself    bool
+	Migrating map[int]string
+	Importing map[int]string
+}
+
+// SlotOwner is a range of slots and the node serving them.
+type SlotOwner struct {
+	SlotRange
+	ID   string
+	Addr string
+}
+
+// State is the slot map of the cluster as this node knows it. The nodes are static, the slots are
+// moved between them with SetSlot, which is sent to every node since they do not gossip.
+type State struct {
+	mu        sync.RWMutex
+	self      string
+	addrs     map[string]string
+	owners    [SlotCount]string
+	migrating map[int]string
+	importing map[int]string
+	file      string
+}
+
+// stateFile keeps the slot changes made by SetSlot across restarts, it overrides the slots of the config.
+type stateFile struct {
+	Owners    map[string][]string `json:"owners"`
+	Migrating map[int]string      `json:"migrating"`
+	Importing map[int]string      `json:"importing"`
+}
+
+// New returns the state of the node self of the nodes, loaded from file if it exists.
+func New(self string, nodes []Node, file string) (*State, error) {
+	s := &State{
+		self:      self,
+		addrs:     map[string]string{},
+		migrating: map[int]string{},
+		importing: map[int]string{},
+		file:      file,
+	}
+	for _, n := range nodes {
+		s.addrs[n.ID] = n.Addr
+		for _, r := range n.Slots {
+			for slot := r.From; slot <= r.To; slot++ {
+				s.owners[slot] = n.ID
+			}
+		}
+	}
+	if _, ok := s.addrs[self]; !ok {
+		return nil, fmt.Errorf("node %s is not in the cluster", self)
+	}
+	if err := s.load(); err != nil {
+		return nil, fmt.Errorf("load cluster state %s: %w", file, err)
+	}
+
+	return s, nil
+}
+
+func (s *State) load() error {
+	if s.file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var f stateFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+
+	s.owners = [SlotCount]string{}
+	for id, slots := range f.Owners {
+		if err = s.checkNode(id); err != nil {
+			return err
+		}
+		for _, slot := range slots {
+			r, errRange := ParseSlotRange(slot)
+			if errRange != nil {
+				return errRange
+			}
+			for i := r.From; i <= r.To; i++ {
+				s.owners[i] = id
+			}
+		}
+	}
+	for slot, id := range f.Migrating {
+		s.migrating[slot] = id
+	}
+	for slot, id := range f.Importing {
+		s.importing[slot] = id
+	}
+
+	return nil
+}
+
+// save writes the state to a temporary file and renames it, so a crash leaves the old or the new state.
+func (s *State) save() error {
+	if s.file == "" {
+		return nil
+	}
+	f := stateFile{Owners: map[string][]string{}, Migrating: s.migrating, Importing: s.importing}
+	for _, owner := range s.slots() {
+		f.Owners[owner.ID] = append(f.Owners[owner.ID], owner.SlotRange.String())
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.file), 0o755); err != nil {
+		return err
+	}
+	tmp := s.file + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.file)
+}
+
+func (s *State) Self() string {
+	return s.self
+}
+
+// Route checks that the key of slot is served by this node. A migrating slot is served
+// while exists reports the key is still here, an importing one only after ASKING.
+func (s *State) Route(slot int, asking bool, exists func() (bool, error)) error {
+	s.mu.RLock()
+	owner := s.owners[slot]
+	target, migrating := s.migrating[slot]
+	_, importing := s.importing[slot]
+	ownerAddr, targetAddr := s.addrs[owner], s.addrs[target]
+	s.mu.RUnlock()
+
+	switch {
+	case owner == s.self && !migrating:
+		return nil
+	case owner == s.self:
+		ok, err := exists()
+		if err != nil || ok {
+			return err
+		}
+		return &RedirectError{Kind: Ask, Slot: slot, Addr: targetAddr}
+	case importing && asking:
+		return nil
+	case owner == "":
+		return &DownError{Slot: slot}
+	default:
+		return &RedirectError{Kind: Moved, Slot: slot, Addr: ownerAddr}
+	}
+}
+
+// Slot actions of SetSlot.
+const (
+	SetSlotMigrating = "MIGRATING"
+	SetSlotImporting = "IMPORTING"
+	SetSlotStable    = "STABLE"
+	SetSlotNode      = "NODE"
+)
+
+// SetSlot marks slot as migrating to or importing from the node id, clears the marks (STABLE)
+// or assigns the slot to the node id, which also clears the marks.
+func (s *State) SetSlot(slot int, action, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if action != SetSlotStable {
+		if err := s.checkNode(id); err != nil {
+			return err
+		}
+	}
+	switch action {
+	case SetSlotMigrating:
+		if s.owners[slot] != s.self {
+			return fmt.Errorf("slot %d is not served by this node", slot)
+		}
+		if id == s.self {
+			return errors.New("cannot migrate a slot to this node")
+		}
+		s.migrating[slot] = id
+	case SetSlotImporting:
+		if s.owners[slot] == s.self {
+			return fmt.Errorf("slot %d is already served by this node", slot)
+		}
+		if id == s.self {
+			return errors.New("cannot import a slot from this node")
+		}
+		s.importing[slot] = id
+	case SetSlotStable:
+		delete(s.migrating, slot)
+		delete(s.importing, slot)
+	case SetSlotNode:
+		s.owners[slot] = id
+		delete(s.migrating, slot)
+		delete(s.importing, slot)
+	default:
+		return fmt.Errorf("unknown slot action %s", action)
+	}
+
+	return s.save()
+}
+
+func (s *State) checkNode(id string) error {
+	if _, ok := s.addrs[id]; !ok {
+		return fmt.Errorf("unknown node %s", id)
+	}
+	return nil
+}
+
+// Slots returns the served slot ranges in order.
+func (s *State) Slots() []SlotOwner {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.slots()
+}
+
+func (s *State) slots() []SlotOwner {
+	var owners []SlotOwner
+	for slot, id := range s.owners {
+		if id == "" {
+			continue
+		}
+		if n := len(owners); n > 0 && owners[n-1].ID == id && owners[n-1].To == slot-1 {
+			owners[n-1].To = slot
+			continue
+		}
+		owners = append(owners, SlotOwner{SlotRange: SlotRange{From: slot, To: slot}, ID: id, Addr: s.addrs[id]})
+	}
+
+	return owners
+}
+
+// Nodes returns the nodes ordered by ID.
+func (s *State) Nodes() []NodeStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	slots := map[string][]int{}
+	for slot, id := range s.owners {
+		if id != "" {
+			slots[id] = append(slots[id], slot)
+		}
+	}
+	nodes := make([]NodeStatus, 0, len(s.addrs))
+	for id, addr := range s.addrs {
+		n := NodeStatus{Node: Node{ID: id, Addr: addr, Slots: ranges(slots[id])}, Myself: id == s.self}
+		if n.Myself {
+			n.Migrating, n.Importing = copyMap(s.migrating), copyMap(s.importing)
+		}
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+
+	return nodes
+}
+
+func copyMap(m map[int]string) map[int]string {
+	c := make(map[int]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
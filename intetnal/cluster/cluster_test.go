@@ -0,0 +1,315 @@
+package cluster_test
+
+import (
+	"context"
+	"fmt"
+	"jokedb/intetnal/app"
+	"jokedb/intetnal/cluster"
+	"jokedb/intetnal/compute"
+	"jokedb/intetnal/compute/parser"
+	"jokedb/intetnal/reply"
+	"jokedb/intetnal/storage"
+	"jokedb/intetnal/storage/engine"
+	"jokedb/intetnal/tcp"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSlot(t *testing.T) {
+	t.Parallel()
+	require.Equal(t, 12739, cluster.Slot("123456789"))
+	require.Equal(t, 12182, cluster.Slot("foo"))
+	require.Equal(t, cluster.Slot("{user1000}.following"), cluster.Slot("{user1000}.followers"))
+	require.Equal(t, cluster.Slot("user1000"), cluster.Slot("{user1000}.followers"))
+	// an empty tag hashes the whole key
+	require.NotEqual(t, cluster.Slot("bar"), cluster.Slot("{}bar"))
+
+	r, err := cluster.ParseSlotRange("100-200")
+	require.NoError(t, err)
+	require.Equal(t, cluster.SlotRange{From: 100, To: 200}, r)
+	for _, bad := range []string{"200-100", "16384", "-1", "a-b"} {
+		_, err = cluster.ParseSlotRange(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func newState(t *testing.T, self, file string) *cluster.State {
+	t.Helper()
+	s, err := cluster.New(self, []cluster.Node{
+		{ID: "n1", Addr: "127.0.0.1:3001", Slots: []cluster.SlotRange{{From: 0, To: 8191}}},
+		{ID: "n2", Addr: "127.0.0.1:3002", Slots: []cluster.SlotRange{{From: 8192, To: 16382}}},
+	}, file)
+	require.NoError(t, err)
+	return s
+}
+
+func TestState_Route(t *testing.T) {
+	t.Parallel()
+	s := newState(t, "n1", "")
+	exists := func(ok bool) func() (bool, error) {
+		return func() (bool, error) { return ok, nil }
+	}
+
+	require.NoError(t, s.Route(0, false, exists(false)))
+	require.Equal(t, &cluster.RedirectError{Kind: cluster.Moved, Slot: 9000, Addr: "127.0.0.1:3002"},
+		s.Route(9000, false, exists(false)))
+	require.Equal(t, &cluster.DownError{Slot: 16383}, s.Route(16383, false, exists(false)))
+
+	require.NoError(t, s.SetSlot(10, cluster.SetSlotMigrating, "n2"))
+	require.NoError(t, s.Route(10, false, exists(true)))
+	require.Equal(t, &cluster.RedirectError{Kind: cluster.Ask, Slot: 10, Addr: "127.0.0.1:3002"},
+		s.Route(10, false, exists(false)))
+
+	require.NoError(t, s.SetSlot(9000, cluster.SetSlotImporting, "n2"))
+	require.NoError(t, s.Route(9000, true, exists(false)))
+	require.Error(t, s.Route(9000, false, exists(false)))
+
+	require.Error(t, s.SetSlot(9001, cluster.SetSlotMigrating, "n2"))
+	require.Error(t, s.SetSlot(10, cluster.SetSlotNode, "n3"))
+
+	redirect, ok := cluster.ParseRedirect("ASK 10 127.0.0.1:3002")
+	require.True(t, ok)
+	require.Equal(t, &cluster.RedirectError{Kind: cluster.Ask, Slot: 10, Addr: "127.0.0.1:3002"}, redirect)
+	_, ok = cluster.ParseRedirect("SET ok")
+	require.False(t, ok)
+}
+
+func TestState_SetSlot(t *testing.T) {
+	t.Parallel()
+	file := filepath.Join(t.TempDir(), "cluster.json")
+	s := newState(t, "n1", file)
+	require.NoError(t, s.SetSlot(10, cluster.SetSlotMigrating, "n2"))
+	require.NoError(t, s.SetSlot(9000, cluster.SetSlotNode, "n1"))
+	require.NoError(t, s.SetSlot(16383, cluster.SetSlotNode, "n2"))
+
+	// the state file overrides the slots of the config
+	s = newState(t, "n1", file)
+	require.Equal(t, []cluster.SlotOwner{
+		{SlotRange: cluster.SlotRange{From: 0, To: 8191}, ID: "n1", Addr: "127.0.0.1:3001"},
+		{SlotRange: cluster.SlotRange{From: 8192, To: 8999}, ID: "n2", Addr: "127.0.0.1:3002"},
+		{SlotRange: cluster.SlotRange{From: 9000, To: 9000}, ID: "n1", Addr: "127.0.0.1:3001"},
+		{SlotRange: cluster.SlotRange{From: 9001, To: 16383}, ID: "n2", Addr: "127.0.0.1:3002"},
+	}, s.Slots())
+	require.Equal(t, "n1 127.0.0.1:3001 myself 0-8191 9000 [10->-n2]\n"+
+		"n2 127.0.0.1:3002 node 8192-8999 9001-16383", cluster.FormatNodes(s.Nodes()))
+
+	nodes, err := cluster.ParseNodes(cluster.FormatNodes(s.Nodes()))
+	require.NoError(t, err)
+	require.Equal(t, []cluster.SlotRange{{From: 0, To: 8191}, {From: 9000, To: 9000}}, nodes[0].Slots)
+}
+
+// node is a server of the cluster started by startCluster.
+type node struct {
+	addr    string
+	app     *app.App
+	storage *storage.Storage
+}
+
+// startCluster serves the slots split evenly between size nodes.
+func startCluster(t *testing.T, size int) []*node {
+	t.Helper()
+	logger := zap.NewNop().Sugar()
+	nodes := make([]*node, size)
+	var members []cluster.Node
+	for i := range nodes {
+		n := &node{}
+		serv, err := tcp.NewServer("127.0.0.1:0", 10, logger, func(ctx context.Context, q string) string {
+			// as App.Handle without its logging
+			r, err := n.app.Do(ctx, q)
+			if err != nil {
+				r = app.ErrorReply(err)
+			}
+			return string(r.Encode())
+		})
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		go serv.Listen(ctx)
+
+		n.addr = serv.Addr().String()
+		per := cluster.SlotCount / size
+		members = append(members, cluster.Node{
+			ID:    "n" + strconv.Itoa(i+1),
+			Addr:  n.addr,
+			Slots: []cluster.SlotRange{{From: i * per, To: (i+1)*per - 1}},
+		})
+		nodes[i] = n
+	}
+
+	for i, n := range nodes {
+		state, err := cluster.New(members[i].ID, members, "")
+		require.NoError(t, err)
+		// more than one database, as an embedder may set, to check they are refused
+		n.storage, err = storage.New(engine.New(), nil, 1, time.Millisecond, storage.WithDatabases(2))
+		require.NoError(t, err)
+		t.Cleanup(n.storage.Close)
+		n.app = app.New(compute.New(), n.storage, app.WithCluster(state))
+	}
+
+	return nodes
+}
+
+func (n *node) keys(t *testing.T) []string {
+	t.Helper()
+	keys, err := n.storage.Keys(context.Background(), 0, "*")
+	require.NoError(t, err)
+	return keys
+}
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+	nodes := startCluster(t, 3)
+
+	conn, err := tcp.NewClient(nodes[0].addr, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+	_, err = conn.Do("GET foo")
+	require.EqualError(t, err, fmt.Sprintf("MOVED 12182 %s", nodes[2].addr))
+
+	cl, err := cluster.NewClient([]string{nodes[0].addr}, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	t.Cleanup(cl.Close)
+	for i := 0; i < 30; i++ {
+		key := "k" + strconv.Itoa(i)
+		resp, err := cl.Do(key, "SET "+key+" v"+strconv.Itoa(i))
+		require.NoError(t, err)
+		require.Equal(t, reply.OK("SET ok"), resp)
+	}
+	total := 0
+	for i, n := range nodes {
+		for _, key := range n.keys(t) {
+			require.Equal(t, i, cluster.Slot(key)*3/cluster.SlotCount, key)
+		}
+		total += len(n.keys(t))
+	}
+	require.Equal(t, 30, total)
+}
+
+func TestMigrateSlot(t *testing.T) {
+	t.Parallel()
+	nodes := startCluster(t, 2)
+	cl, err := cluster.NewClient([]string{nodes[1].addr}, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	t.Cleanup(cl.Close)
+
+	slot := cluster.Slot("{user}")
+	require.Less(t, slot, cluster.SlotCount/2)
+	for i := 0; i < 250; i++ {
+		key := "{user}" + strconv.Itoa(i)
+		_, err = cl.Do(key, "SET "+key+" v")
+		require.NoError(t, err)
+	}
+
+	// a key moved while the slot is migrating is reached with ASK
+	conn, err := tcp.NewClient(nodes[0].addr, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+	target, err := tcp.NewClient(nodes[1].addr, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	t.Cleanup(target.Close)
+	for _, step := range []struct {
+		conn *tcp.Client
+		cmd  string
+	}{
+		{target, fmt.Sprintf("CLUSTER SETSLOT %d IMPORTING n1", slot)},
+		{conn, fmt.Sprintf("CLUSTER SETSLOT %d MIGRATING n2", slot)},
+		{conn, "MIGRATE " + nodes[1].addr + " {user}0"},
+	} {
+		resp, errDo := step.conn.Do(step.cmd)
+		require.NoError(t, errDo)
+		require.Contains(t, resp.Text, "ok")
+	}
+	_, err = conn.Do("GET {user}0")
+	require.EqualError(t, err, fmt.Sprintf("ASK %d %s", slot, nodes[1].addr))
+	resp, err := conn.Do("GET {user}1")
+	require.NoError(t, err)
+	require.Equal(t, reply.Value("v"), resp)
+	got, err := cl.Do("{user}0", "GET {user}0")
+	require.NoError(t, err)
+	require.Equal(t, reply.Value("v"), got)
+
+	nodesResp, err := conn.Do("CLUSTER NODES")
+	require.NoError(t, err)
+	members, err := cluster.ParseNodes(nodesResp.Text)
+	require.NoError(t, err)
+	require.NoError(t, cluster.MigrateSlot(slot, "n2", members, zap.NewNop().Sugar()))
+
+	require.Empty(t, nodes[0].keys(t))
+	require.Len(t, nodes[1].keys(t), 250)
+	_, err = conn.Do("GET {user}1")
+	require.EqualError(t, err, fmt.Sprintf("MOVED %d %s", slot, nodes[1].addr))
+	got, err = cl.Do("{user}7", "GET {user}7")
+	require.NoError(t, err)
+	require.Equal(t, reply.Value("v"), got)
+}
+
+func TestMigrateSlot_Quoted(t *testing.T) {
+	t.Parallel()
+	nodes := startCluster(t, 2)
+	cl, err := cluster.NewClient([]string{nodes[0].addr}, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	t.Cleanup(cl.Close)
+
+	// the keys of a batch and the values are longer than a read of the server
+	slot := cluster.Slot("{user}")
+	values := map[string]string{}
+	for i := 0; i < 100; i++ {
+		key := "{user} " + strings.Repeat("k", 40) + strconv.Itoa(i)
+		values[key] = `say "hi" \ ` + strings.Repeat("v", 100*1024) + strconv.Itoa(i)
+		_, err = cl.Do(key, "SET "+parser.Quote(key)+" "+parser.Quote(values[key]))
+		require.NoError(t, err)
+	}
+
+	conn, err := tcp.NewClient(nodes[0].addr, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+	nodesResp, err := conn.Do("CLUSTER NODES")
+	require.NoError(t, err)
+	members, err := cluster.ParseNodes(nodesResp.Text)
+	require.NoError(t, err)
+	require.NoError(t, cluster.MigrateSlot(slot, "n2", members, zap.NewNop().Sugar()))
+
+	require.Empty(t, nodes[0].keys(t))
+	require.Len(t, nodes[1].keys(t), len(values))
+	for key, value := range values {
+		got, errGet := nodes[1].storage.Get(context.Background(), 0, engine.KV{Key: key})
+		require.NoError(t, errGet)
+		require.Equal(t, value, got, key)
+	}
+}
+
+func TestCluster_OtherDatabases(t *testing.T) {
+	t.Parallel()
+	nodes := startCluster(t, 2)
+	conn, err := tcp.NewClient(nodes[0].addr, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	t.Cleanup(conn.Close)
+
+	// a key of the database 1 would stay on the source of a migrated slot, so none can be made
+	key := "{user}1"
+	_, err = conn.Do("SET " + key + " v")
+	require.NoError(t, err)
+	for _, cmd := range []string{"SELECT 1", "MOVE " + key + " 1", "SWAPDB 0 1"} {
+		_, err = conn.Do(cmd)
+		require.ErrorContains(t, err, "cluster mode serves the database 0 only", cmd)
+	}
+	_, err = conn.Do("SELECT 0")
+	require.NoError(t, err)
+	keys, err := nodes[0].storage.Keys(context.Background(), 1, "*")
+	require.NoError(t, err)
+	require.Empty(t, keys)
+
+	nodesResp, err := conn.Do("CLUSTER NODES")
+	require.NoError(t, err)
+	members, err := cluster.ParseNodes(nodesResp.Text)
+	require.NoError(t, err)
+	require.NoError(t, cluster.MigrateSlot(cluster.Slot(key), "n2", members, zap.NewNop().Sugar()))
+	require.Empty(t, nodes[0].keys(t))
+	require.Equal(t, []string{key}, nodes[1].keys(t))
+}
@@ -0,0 +1,94 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FormatSlots writes a "from-to id addr" line per slot range, the CLUSTER SLOTS response.
+func FormatSlots(owners []SlotOwner) string {
+	lines := make([]string, 0, len(owners))
+	for _, o := range owners {
+		lines = append(lines, fmt.Sprintf("%d-%d %s %s", o.From, o.To, o.ID, o.Addr))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// ParseSlots parses the CLUSTER SLOTS response.
+func ParseSlots(resp string) ([]SlotOwner, error) {
+	var owners []SlotOwner
+	for _, line := range strings.Split(resp, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("malformed slot range %q", line)
+		}
+		r, err := ParseSlotRange(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		owners = append(owners, SlotOwner{SlotRange: r, ID: fields[1], Addr: fields[2]})
+	}
+
+	return owners, nil
+}
+
+// FormatNodes writes a "id addr flags slots..." line per node, the CLUSTER NODES response. The flags
+// are myself or node, the slots this node migrates are [slot->-id] and the imported ones [slot-<-id].
+func FormatNodes(nodes []NodeStatus) string {
+	lines := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		fields := []string{n.ID, n.Addr, "node"}
+		if n.Myself {
+			fields[2] = "myself"
+		}
+		for _, r := range n.Slots {
+			fields = append(fields, r.String())
+		}
+		fields = append(fields, marks(n.Migrating, "->-")...)
+		fields = append(fields, marks(n.Importing, "-<-")...)
+		lines = append(lines, strings.Join(fields, " "))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func marks(slots map[int]string, arrow string) []string {
+	keys := make([]int, 0, len(slots))
+	for slot := range slots {
+		keys = append(keys, slot)
+	}
+	sort.Ints(keys)
+
+	fields := make([]string, 0, len(keys))
+	for _, slot := range keys {
+		fields = append(fields, fmt.Sprintf("[%d%s%s]", slot, arrow, slots[slot]))
+	}
+	return fields
+}
+
+// ParseNodes parses the CLUSTER NODES response, the migration marks are skipped.
+func ParseNodes(resp string) ([]Node, error) {
+	var nodes []Node
+	for _, line := range strings.Split(resp, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("malformed node %q", line)
+		}
+		n := Node{ID: fields[0], Addr: fields[1]}
+		for _, field := range fields[3:] {
+			if strings.HasPrefix(field, "[") {
+				continue
+			}
+			r, err := ParseSlotRange(field)
+			if err != nil {
+				return nil, err
+			}
+			n.Slots = append(n.Slots, r)
+		}
+		nodes = append(nodes, n)
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,131 @@
+package cluster
+
+import (
+	"fmt"
+	"jokedb/intetnal/compute/parser"
+	"jokedb/intetnal/reply"
+	"jokedb/intetnal/tcp"
+	"strconv"
+	"strings"
+)
+
+const (
+	migrateBatch = 100
+	// migrateBatchSize limits the keys of a MIGRATE in bytes, a longer batch is split.
+	migrateBatchSize = 16 * 1024
+)
+
+// MigrateSlot moves slot with its keys to the node target. nodes is the cluster as CLUSTER NODES
+// reports it. The slot is marked importing on target and migrating on its owner, the keys
+// are moved with MIGRATE, then the slot is assigned to target on every node.
+// The commands on the slot keep being served during the migration, see State.Route.
+func MigrateSlot(slot int, target string, nodes []Node, logger tcp.Logger) error {
+	var source, dest Node
+	for _, n := range nodes {
+		if n.ID == target {
+			dest = n
+		}
+		for _, r := range n.Slots {
+			if r.From <= slot && slot <= r.To {
+				source = n
+			}
+		}
+	}
+	switch {
+	case dest.ID == "":
+		return fmt.Errorf("unknown node %s", target)
+	case source.ID == "":
+		return fmt.Errorf("slot %d is not served", slot)
+	case source.ID == dest.ID:
+		return nil
+	}
+
+	conns := map[string]*tcp.Client{}
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	send := func(n Node, cmd, want string) (reply.Reply, error) {
+		conn, ok := conns[n.ID]
+		if !ok {
+			var err error
+			if conn, err = tcp.NewClient(n.Addr, logger); err != nil {
+				return reply.Reply{}, err
+			}
+			conns[n.ID] = conn
+		}
+		r, err := conn.Do(cmd)
+		if err != nil {
+			return reply.Reply{}, fmt.Errorf("%s: %s: %w", n.ID, cmd, err)
+		}
+		if want != "" && r.Text != want {
+			return reply.Reply{}, fmt.Errorf("%s: %s: %s", n.ID, cmd, r)
+		}
+		return r, nil
+	}
+
+	s := strconv.Itoa(slot)
+	if _, err := send(dest, "CLUSTER SETSLOT "+s+" IMPORTING "+source.ID, "CLUSTER ok"); err != nil {
+		return err
+	}
+	if _, err := send(source, "CLUSTER SETSLOT "+s+" MIGRATING "+dest.ID, "CLUSTER ok"); err != nil {
+		return err
+	}
+	for {
+		r, err := send(source, "CLUSTER GETKEYSINSLOT "+s+" "+strconv.Itoa(migrateBatch), "")
+		if err != nil {
+			return err
+		}
+		if len(r.Array) == 0 {
+			break
+		}
+		keys := make([]string, len(r.Array))
+		for i, item := range r.Array {
+			keys[i] = item.Text
+		}
+		for _, batch := range migrateBatches(keys) {
+			if _, err = send(source, "MIGRATE "+parser.Quote(dest.Addr)+" "+batch, "MIGRATE ok"); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the target first, so the redirects of the source never point back to it
+	ordered := []Node{dest, source}
+	for _, n := range nodes {
+		if n.ID != dest.ID && n.ID != source.ID {
+			ordered = append(ordered, n)
+		}
+	}
+	for _, n := range ordered {
+		if _, err := send(n, "CLUSTER SETSLOT "+s+" NODE "+dest.ID, "CLUSTER ok"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateBatches quotes the keys and joins them into batches of up to migrateBatchSize bytes,
+// a key longer than that is a batch of its own.
+func migrateBatches(keys []string) []string {
+	var batches []string
+	var batch strings.Builder
+	for _, key := range keys {
+		quoted := parser.Quote(key)
+		if batch.Len() > 0 && batch.Len()+1+len(quoted) > migrateBatchSize {
+			batches = append(batches, batch.String())
+			batch.Reset()
+		}
+		if batch.Len() > 0 {
+			batch.WriteByte(' ')
+		}
+		batch.WriteString(quoted)
+	}
+	if batch.Len() > 0 {
+		batches = append(batches, batch.String())
+	}
+
+	return batches
+}
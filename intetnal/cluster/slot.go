@@ -0,0 +1,96 @@
+package cluster
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SlotCount is the number of the hash slots the keyspace is split into.
+const SlotCount = 16384
+
+// Slot returns the hash slot of key. If key has a non-empty {hashtag}, only the tag is hashed,
+// so the keys sharing it are kept in one slot.
+func Slot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	return int(crc16(key)) % SlotCount
+}
+
+// crc16 is CRC-16/XMODEM, the checksum Redis Cluster hashes the keys with.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+// SlotRange is the slots from From to To inclusive.
+type SlotRange struct {
+	From, To int
+}
+
+func (r SlotRange) String() string {
+	if r.From == r.To {
+		return strconv.Itoa(r.From)
+	}
+	return strconv.Itoa(r.From) + "-" + strconv.Itoa(r.To)
+}
+
+// ParseSlotRange parses a slot "n" or a range "from-to".
+func ParseSlotRange(s string) (SlotRange, error) {
+	from, to, isRange := strings.Cut(s, "-")
+	if !isRange {
+		to = from
+	}
+	r := SlotRange{}
+	var err error
+	if r.From, err = ParseSlot(from); err != nil {
+		return r, err
+	}
+	if r.To, err = ParseSlot(to); err != nil {
+		return r, err
+	}
+	if r.From > r.To {
+		return r, fmt.Errorf("slot range %s is reversed", s)
+	}
+
+	return r, nil
+}
+
+// ParseSlot parses a slot number.
+func ParseSlot(s string) (int, error) {
+	slot, err := strconv.Atoi(s)
+	if err != nil || slot < 0 || slot >= SlotCount {
+		return 0, fmt.Errorf("slot must be between 0 and %d, got %s", SlotCount-1, s)
+	}
+
+	return slot, nil
+}
+
+// ranges groups the sorted slots into ranges.
+func ranges(slots []int) []SlotRange {
+	var rs []SlotRange
+	for _, slot := range slots {
+		if n := len(rs); n > 0 && rs[n-1].To == slot-1 {
+			rs[n-1].To = slot
+			continue
+		}
+		rs = append(rs, SlotRange{From: slot, To: slot})
+	}
+
+	return rs
+}
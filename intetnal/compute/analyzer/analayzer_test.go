@@ -23,6 +23,7 @@ func TestAnalyzer_Analyze(t *testing.T) {
 						Key:   "key",
 						Value: "value",
 					},
+					Keys: []string{"key"},
 				},
 				tokens: []string{"SET", "key", "value"}},
 			"get": {
@@ -31,6 +32,7 @@ func TestAnalyzer_Analyze(t *testing.T) {
 					KV: engine.KV{
 						Key: "key",
 					},
+					Keys: []string{"key"},
 				},
 				tokens: []string{"GET", "key"}},
 			"del": {
@@ -39,8 +41,171 @@ func TestAnalyzer_Analyze(t *testing.T) {
 					KV: engine.KV{
 						Key: "key",
 					},
+					Keys: []string{"key"},
 				},
 				tokens: []string{"DEL", "key"}},
+			"slowlog_get": {
+				want: analyzer.Action{
+					Type: engine.SLOWLOG,
+					Args: []string{"GET", "5"},
+				},
+				tokens: []string{"SLOWLOG", "GET", "5"}},
+			"move": {
+				want: analyzer.Action{
+					Type: engine.MOVE,
+					KV:   engine.KV{Key: "key"},
+					Args: []string{"2"},
+					Keys: []string{"key"},
+				},
+				tokens: []string{"MOVE", "key", "2"}},
+			"flushdb": {
+				want: analyzer.Action{
+					Type: engine.FLUSHDB,
+				},
+				tokens: []string{"FLUSHDB"}},
+			"lsn": {
+				want: analyzer.Action{
+					Type: engine.LSN,
+				},
+				tokens: []string{"LSN"}},
+			"ping": {
+				want: analyzer.Action{
+					Type: engine.PING,
+					Args: []string{"hello"},
+				},
+				tokens: []string{"PING", "hello"}},
+			"client_kill": {
+				want: analyzer.Action{
+					Type: engine.CLIENT,
+					Args: []string{"KILL", "ID", "3", "NAME", "loader"},
+				},
+				tokens: []string{"CLIENT", "KILL", "ID", "3", "NAME", "loader"}},
+			"keys": {
+				want: analyzer.Action{
+					Type: engine.KEYS,
+					Args: []string{"user:*"},
+				},
+				tokens: []string{"KEYS", "user:*"}},
+			"info": {
+				want: analyzer.Action{
+					Type: engine.INFO,
+					Args: []string{"server", "keyspace"},
+				},
+				tokens: []string{"INFO", "server", "keyspace"}},
+			"durability": {
+				want: analyzer.Action{
+					Type: engine.DURABILITY,
+					Args: []string{"async"},
+				},
+				tokens: []string{"DURABILITY", "async"}},
+			"durability_modifier": {
+				want: analyzer.Action{
+					Type:       engine.SET,
+					KV:         engine.KV{Key: "key", Value: "value"},
+					Keys:       []string{"key"},
+					Durability: "replicated",
+				},
+				tokens: []string{"DURABILITY", "replicated", "SET", "key", "value"}},
+			"cluster_setslot": {
+				want: analyzer.Action{
+					Type: engine.CLUSTER,
+					Args: []string{"SETSLOT", "100", "MIGRATING", "n2"},
+				},
+				tokens: []string{"CLUSTER", "SETSLOT", "100", "MIGRATING", "n2"}},
+			"migrate": {
+				want: analyzer.Action{
+					Type: engine.MIGRATE,
+					Args: []string{"127.0.0.1:3003", "a", "b"},
+				},
+				tokens: []string{"MIGRATE", "127.0.0.1:3003", "a", "b"}},
+			"scan": {
+				want: analyzer.Action{
+					Type: engine.SCAN,
+					Args: []string{"0", "MATCH", "user:*", "COUNT", "10", "WITHVALUES"},
+				},
+				tokens: []string{"SCAN", "0", "MATCH", "user:*", "COUNT", "10", "WITHVALUES"}},
+			"durability_commit": {
+				want: analyzer.Action{
+					Type:       engine.COMMIT,
+					Durability: "local",
+				},
+				tokens: []string{"DURABILITY", "local", "COMMIT"}},
+			"eval": {
+				want: analyzer.Action{
+					Type: engine.EVAL,
+					KV:   engine.KV{Key: "a"},
+					Args: []string{"(call \"GET\" (nth KEYS 0))", "2", "a", "b", "x"},
+					Keys: []string{"a", "b"},
+				},
+				tokens: []string{"EVAL", "(call \"GET\" (nth KEYS 0))", "2", "a", "b", "x"}},
+			"lower_case": {
+				want: analyzer.Action{
+					Type: engine.GET,
+					KV:   engine.KV{Key: "key"},
+					Keys: []string{"key"},
+				},
+				tokens: []string{"get", "key"}},
+			"alias": {
+				want: analyzer.Action{
+					Type: engine.DEL,
+					KV:   engine.KV{Key: "key"},
+					Keys: []string{"key"},
+				},
+				tokens: []string{"Unlink", "key"}},
+			"command_list": {
+				want: analyzer.Action{
+					Type: engine.COMMAND,
+					Args: []string{"LIST", "write"},
+				},
+				tokens: []string{"COMMAND", "LIST", "write"}},
+			"script_exists": {
+				want: analyzer.Action{
+					Type: engine.SCRIPT,
+					Args: []string{"EXISTS", "a", "b"},
+				},
+				tokens: []string{"SCRIPT", "EXISTS", "a", "b"}},
+			"lower_case_subcommand": {
+				want: analyzer.Action{
+					Type: engine.SLOWLOG,
+					Args: []string{"GET", "5"},
+				},
+				tokens: []string{"slowlog", "get", "5"}},
+			"lower_case_client_kill": {
+				want: analyzer.Action{
+					Type: engine.CLIENT,
+					Args: []string{"KILL", "NAME", "worker", "ID", "7"},
+				},
+				tokens: []string{"client", "Kill", "name", "worker", "id", "7"}},
+			"lower_case_cluster_setslot": {
+				want: analyzer.Action{
+					Type: engine.CLUSTER,
+					Args: []string{"SETSLOT", "1", "NODE", "n1"},
+				},
+				tokens: []string{"cluster", "setslot", "1", "node", "n1"}},
+			"lower_case_scan": {
+				want: analyzer.Action{
+					Type: engine.SCAN,
+					Args: []string{"0", "MATCH", "Key*", "COUNT", "5", "WITHVALUES"},
+				},
+				tokens: []string{"SCAN", "0", "match", "Key*", "Count", "5", "withvalues"}},
+			"lower_case_script": {
+				want: analyzer.Action{
+					Type: engine.SCRIPT,
+					Args: []string{"LOAD", "(call \"get\" \"k\")"},
+				},
+				tokens: []string{"script", "load", "(call \"get\" \"k\")"}},
+			"lower_case_command": {
+				want: analyzer.Action{
+					Type: engine.COMMAND,
+					Args: []string{"INFO", "get"},
+				},
+				tokens: []string{"command", "info", "get"}},
+			"upper_case_durability": {
+				want: analyzer.Action{
+					Type: engine.DURABILITY,
+					Args: []string{"async"},
+				},
+				tokens: []string{"DURABILITY", "ASYNC"}},
 		}
 
 		for name, tt := range cases {
@@ -66,6 +231,54 @@ func TestAnalyzer_Analyze(t *testing.T) {
 				tokens: []string{"GET"}},
 			"del": {
 				tokens: []string{"DEL"}},
+			"slowlog_get": {
+				tokens: []string{"SLOWLOG", "GET", "x"}},
+			"slowlog_unknown": {
+				tokens: []string{"SLOWLOG", "DROP"}},
+			"select": {
+				tokens: []string{"SELECT", "one"}},
+			"swapdb": {
+				tokens: []string{"SWAPDB", "1"}},
+			"lsn": {
+				tokens: []string{"LSN", "1"}},
+			"ping": {
+				tokens: []string{"PING", "a", "b"}},
+			"client_setname": {
+				tokens: []string{"CLIENT", "SETNAME"}},
+			"client_kill": {
+				tokens: []string{"CLIENT", "KILL", "PORT", "1"}},
+			"durability_unknown": {
+				tokens: []string{"DURABILITY", "eventually"}},
+			"durability_read": {
+				tokens: []string{"DURABILITY", "async", "GET", "key"}},
+			"cluster_slot": {
+				tokens: []string{"CLUSTER", "COUNTKEYSINSLOT", "16384"}},
+			"cluster_setslot": {
+				tokens: []string{"CLUSTER", "SETSLOT", "1", "NODE"}},
+			"asking": {
+				tokens: []string{"ASKING", "1"}},
+			"begin": {
+				tokens: []string{"BEGIN", "now"}},
+			"scan_cursor": {
+				tokens: []string{"SCAN", "-1"}},
+			"scan_count": {
+				tokens: []string{"SCAN", "0", "COUNT", "0"}},
+			"scan_match": {
+				tokens: []string{"SCAN", "0", "MATCH"}},
+			"scan_option": {
+				tokens: []string{"SCAN", "0", "TYPE", "string"}},
+			"eval_numkeys": {
+				tokens: []string{"EVALSHA", "abc", "x"}},
+			"eval_too_many_keys": {
+				tokens: []string{"EVAL", "1", "2", "a"}},
+			"script_kill": {
+				tokens: []string{"SCRIPT", "KILL", "now"}},
+			"script_unknown": {
+				tokens: []string{"SCRIPT", "DEBUG"}},
+			"command_category": {
+				tokens: []string{"COMMAND", "LIST", "fast"}},
+			"help": {
+				tokens: []string{"HELP", "GET", "SET"}},
 		}
 
 		for name, tt := range cases {
@@ -76,3 +289,56 @@ func TestAnalyzer_Analyze(t *testing.T) {
 		}
 	})
 }
+
+func TestRegistry_ByCategory(t *testing.T) {
+	r := analyzer.NewRegistry()
+
+	names := func(commands []analyzer.Command) []string {
+		var out []string
+		for _, c := range commands {
+			out = append(out, c.Name)
+		}
+		return out
+	}
+	require.Equal(t, []string{"EVAL", "EVALSHA", "SCRIPT"}, names(r.ByCategory(analyzer.Scripting)))
+	require.Equal(t, []string{"MIGRATE", "SWAPDB"}, names(r.ByCategory(analyzer.Write|analyzer.Admin)))
+	require.Len(t, r.ByCategory(0), len(r.Commands()))
+
+	category, err := analyzer.ParseFlag("PubSub")
+	require.NoError(t, err)
+	require.Equal(t, []string{"PSUBSCRIBE", "SUBSCRIBE", "UNSUBSCRIBE"}, names(r.ByCategory(category)))
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r := analyzer.NewRegistry()
+
+	c, err := r.Register(analyzer.Command{
+		Name:    "getset",
+		Aliases: []string{"swap"},
+		MinArgs: 2,
+		MaxArgs: 2,
+		Flags:   analyzer.Read | analyzer.Write,
+		Keys:    analyzer.Keys{First: 1, Last: 1, Step: 1},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "GETSET", c.Name)
+	require.Greater(t, c.Type, engine.COMMAND)
+
+	got, err := r.Analyze([]string{"Swap", "key", "value"})
+	require.NoError(t, err)
+	require.Equal(t, analyzer.Action{Type: c.Type, Args: []string{"key", "value"}, Keys: []string{"key"}}, got)
+
+	found, ok := r.ByType(c.Type)
+	require.True(t, ok)
+	require.Equal(t, []string{"read", "write"}, found.Flags.Strings())
+
+	_, err = r.Register(analyzer.Command{Name: "get"})
+	require.ErrorIs(t, err, analyzer.ErrCommandExists)
+	_, err = r.Register(analyzer.Command{Name: "other", Aliases: []string{"SWAP"}})
+	require.ErrorIs(t, err, analyzer.ErrCommandExists)
+	_, ok = r.Lookup("other")
+	require.False(t, ok)
+
+	_, err = analyzer.New().Analyze([]string{"GETSET", "key", "value"})
+	require.ErrorIs(t, err, analyzer.ErrUnknownCommand)
+}
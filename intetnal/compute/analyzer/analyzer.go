@@ -2,49 +2,304 @@ package analyzer
 
 import (
 	"errors"
+	"fmt"
+	"jokedb/intetnal/cluster"
 	"jokedb/intetnal/storage/engine"
-)
-
-const (
-	MinTokens = 2
-	MaxTokens = 3
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 type Action struct {
 	Type engine.ActionType
 	engine.KV
+	Args []string
+	// Keys are the keys of the command, a cluster routes the command by them.
+	Keys []string
+	// Durability is set by the DURABILITY modifier of a write command.
+	Durability string
 }
 
-type Analyzer struct{}
+// Analyzer checks the tokens of a command against the commands of its Registry.
+type Analyzer struct {
+	registry *Registry
+}
+
+// defaultRegistry is the registry of the zero Analyzer.
+var defaultRegistry = sync.OnceValue(NewRegistry)
 
 func New() *Analyzer {
-	return &Analyzer{}
+	return NewWithRegistry(NewRegistry())
+}
+
+func NewWithRegistry(r *Registry) *Analyzer {
+	return &Analyzer{registry: r}
+}
+
+func (al Analyzer) Registry() *Registry {
+	if al.registry == nil {
+		return defaultRegistry()
+	}
+	return al.registry
 }
 
 func (al Analyzer) Analyze(tokens []string) (Action, error) {
-	a := Action{}
-	types := map[string]engine.ActionType{
-		"SET": engine.SET,
-		"GET": engine.GET,
-		"DEL": engine.DEL,
+	return al.Registry().Analyze(tokens)
+}
+
+func parseSet(a Action, args []string) (Action, error) {
+	a.Key = args[0]
+	a.Value = args[1]
+	return a, nil
+}
+
+func parseKey(a Action, args []string) (Action, error) {
+	a.Key = args[0]
+	return a, nil
+}
+
+func parseDBIndexes(a Action, args []string) (Action, error) {
+	a.Args = args
+	return a, checkDBIndexes(args...)
+}
+
+func parseMove(a Action, args []string) (Action, error) {
+	a.Key = args[0]
+	a.Args = args[1:]
+	return a, checkDBIndexes(args[1])
+}
+
+// upper returns a copy of args with the keywords at the positions in upper case, the subcommands
+// and options are accepted in any case and the handlers match them in upper case.
+func upper(args []string, positions ...int) []string {
+	args = slices.Clone(args)
+	for _, i := range positions {
+		args[i] = strings.ToUpper(args[i])
+	}
+	return args
+}
+
+func checkDBIndexes(args ...string) error {
+	for _, arg := range args {
+		if n, err := strconv.Atoi(arg); err != nil || n < 0 {
+			return fmt.Errorf("db index must be a non-negative integer, got %s", arg)
+		}
+	}
+
+	return nil
+}
+
+// analyzeDurability checks DURABILITY [level] that reads or sets the level of the
+// connection, and DURABILITY level command... that sets it for one write command.
+func analyzeDurability(r *Registry) ParseFunc {
+	return func(a Action, args []string) (Action, error) {
+		if len(args) == 0 {
+			return a, nil
+		}
+		level := strings.ToLower(args[0])
+		switch level {
+		case "async", "local", "replicated":
+		default:
+			return a, fmt.Errorf("unknown durability %s, want async, local or replicated", args[0])
+		}
+		if len(args) == 1 {
+			a.Args = []string{level}
+			return a, nil
+		}
+
+		cmd, err := r.Analyze(args[1:])
+		if err != nil {
+			return a, err
+		}
+		if c, _ := r.ByType(cmd.Type); !c.Flags.Has(Write) {
+			return a, fmt.Errorf("DURABILITY applies to write commands only, got %s", args[1])
+		}
+		cmd.Durability = level
+
+		return cmd, nil
+	}
+}
+
+// analyzeClient checks CLIENT LIST | ID | GETNAME | SETNAME name | KILL filter value...,
+// the filters are ID, ADDR and NAME.
+func analyzeClient(a Action, args []string) (Action, error) {
+	args = upper(args, 0)
+	a.Args = args
+	switch args[0] {
+	case "LIST", "ID", "GETNAME":
+		if len(args) > 1 {
+			return a, errors.New("CLIENT " + args[0] + " takes no arguments")
+		}
+	case "SETNAME":
+		if len(args) != 2 {
+			return a, errors.New("CLIENT SETNAME takes a name")
+		}
+	case "KILL":
+		if len(args) == 1 || len(args)%2 == 0 {
+			return a, errors.New("CLIENT KILL takes filter and value pairs")
+		}
+		for i := 1; i < len(args); i += 2 {
+			args[i] = strings.ToUpper(args[i])
+			switch args[i] {
+			case "ID":
+				if id, err := strconv.ParseUint(args[i+1], 10, 64); err != nil || id == 0 {
+					return a, fmt.Errorf("client id must be a positive integer, got %s", args[i+1])
+				}
+			case "ADDR", "NAME":
+			default:
+				return a, fmt.Errorf("unknown CLIENT KILL filter %s, want ID, ADDR or NAME", args[i])
+			}
+		}
+	default:
+		return a, errors.New("unknown CLIENT subcommand")
+	}
+
+	return a, nil
+}
+
+// analyzeSlowLog checks SLOWLOG GET [n] | LEN | RESET.
+func analyzeSlowLog(a Action, args []string) (Action, error) {
+	args = upper(args, 0)
+	a.Args = args
+	switch args[0] {
+	case "GET":
+		if len(args) == 2 {
+			if n, err := strconv.Atoi(args[1]); err != nil || n < 0 {
+				return a, errors.New("SLOWLOG GET count must be a non-negative integer")
+			}
+		}
+	case "LEN", "RESET":
+		if len(args) > 1 {
+			return a, errors.New("SLOWLOG " + args[0] + " takes no arguments")
+		}
+	default:
+		return a, errors.New("unknown SLOWLOG subcommand")
+	}
+
+	return a, nil
+}
+
+// analyzeCluster checks CLUSTER SLOTS | NODES | MYID | KEYSLOT key | COUNTKEYSINSLOT slot |
+// GETKEYSINSLOT slot count | SETSLOT slot MIGRATING id | IMPORTING id | NODE id | STABLE.
+func analyzeCluster(a Action, args []string) (Action, error) {
+	args = upper(args, 0)
+	a.Args = args
+	arity := map[string]int{"SLOTS": 1, "NODES": 1, "MYID": 1, "KEYSLOT": 2, "COUNTKEYSINSLOT": 2, "GETKEYSINSLOT": 3}
+	if n, ok := arity[args[0]]; ok && len(args) != n {
+		return a, fmt.Errorf("wrong number of arguments for CLUSTER %s", args[0])
+	}
+	switch args[0] {
+	case "SLOTS", "NODES", "MYID", "KEYSLOT":
+	case "COUNTKEYSINSLOT", "GETKEYSINSLOT":
+		if _, err := cluster.ParseSlot(args[1]); err != nil {
+			return a, err
+		}
+		if len(args) == 3 {
+			if n, err := strconv.Atoi(args[2]); err != nil || n < 0 {
+				return a, errors.New("CLUSTER GETKEYSINSLOT count must be a non-negative integer")
+			}
+		}
+	case "SETSLOT":
+		if len(args) < 3 {
+			return a, errors.New("wrong number of arguments for CLUSTER SETSLOT")
+		}
+		if _, err := cluster.ParseSlot(args[1]); err != nil {
+			return a, err
+		}
+		args[2] = strings.ToUpper(args[2])
+		switch args[2] {
+		case "MIGRATING", "IMPORTING", "NODE":
+			if len(args) != 4 {
+				return a, fmt.Errorf("CLUSTER SETSLOT %s takes a node id", args[2])
+			}
+		case "STABLE":
+			if len(args) != 3 {
+				return a, errors.New("CLUSTER SETSLOT STABLE takes no arguments")
+			}
+		default:
+			return a, fmt.Errorf("unknown CLUSTER SETSLOT action %s, want MIGRATING, IMPORTING, NODE or STABLE", args[2])
+		}
+	default:
+		return a, errors.New("unknown CLUSTER subcommand")
 	}
 
-	if len(tokens) < MinTokens {
-		return a, errors.New("tokens size less than 2")
+	return a, nil
+}
+
+// analyzeScan checks SCAN cursor [MATCH pattern] [COUNT n] [WITHVALUES].
+func analyzeScan(a Action, args []string) (Action, error) {
+	args = slices.Clone(args)
+	a.Args = args
+	if _, err := strconv.ParseUint(args[0], 10, 64); err != nil {
+		return a, fmt.Errorf("SCAN cursor must be a non-negative integer, got %s", args[0])
+	}
+	for i := 1; i < len(args); i++ {
+		args[i] = strings.ToUpper(args[i])
+		switch args[i] {
+		case "MATCH":
+			if i+1 == len(args) {
+				return a, errors.New("SCAN MATCH takes a pattern")
+			}
+			if _, err := path.Match(args[i+1], ""); err != nil {
+				return a, fmt.Errorf("SCAN MATCH pattern: %w", err)
+			}
+			i++
+		case "COUNT":
+			if i+1 == len(args) {
+				return a, errors.New("SCAN COUNT takes a number")
+			}
+			if n, err := strconv.Atoi(args[i+1]); err != nil || n < 1 {
+				return a, errors.New("SCAN COUNT must be a positive integer")
+			}
+			i++
+		case "WITHVALUES":
+		default:
+			return a, fmt.Errorf("unknown SCAN option %s, want MATCH, COUNT or WITHVALUES", args[i])
+		}
 	}
 
-	t, ok := types[tokens[0]]
-	if !ok {
-		return a, errors.New("unkown command")
+	return a, nil
+}
+
+// analyzeEval checks EVAL script numkeys key... arg... and EVALSHA sha numkeys key... arg...,
+// the first key is the Key of the action.
+func analyzeEval(a Action, args []string) (Action, error) {
+	a.Args = args
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 0 {
+		return a, fmt.Errorf("number of keys must be a non-negative integer, got %s", args[1])
+	}
+	if n > len(args)-2 {
+		return a, fmt.Errorf("number of keys %d is greater than the number of arguments %d", n, len(args)-2)
+	}
+	if n > 0 {
+		a.Key = args[2]
 	}
 
-	a.Type = t
-	a.Key = tokens[1]
-	if t == engine.SET {
-		if len(tokens) < MaxTokens {
-			return a, errors.New("no value set for key")
+	return a, nil
+}
+
+// analyzeScript checks SCRIPT LOAD script | EXISTS sha... | FLUSH | KILL.
+func analyzeScript(a Action, args []string) (Action, error) {
+	args = upper(args, 0)
+	a.Args = args
+	switch args[0] {
+	case "LOAD":
+		if len(args) != 2 {
+			return a, errors.New("SCRIPT LOAD takes a script")
+		}
+	case "EXISTS":
+		if len(args) == 1 {
+			return a, errors.New("SCRIPT EXISTS takes at least one sha")
+		}
+	case "FLUSH", "KILL":
+		if len(args) > 1 {
+			return a, errors.New("SCRIPT " + args[0] + " takes no arguments")
 		}
-		a.Value = tokens[2]
+	default:
+		return a, errors.New("unknown SCRIPT subcommand")
 	}
 
 	return a, nil
@@ -0,0 +1,120 @@
+package analyzer
+
+import (
+	"errors"
+	"jokedb/intetnal/storage/engine"
+	"strconv"
+)
+
+// builtins are the commands of the server, DURABILITY analyzes the command it modifies with r.
+func builtins(r *Registry) []Command {
+	return []Command{
+		{Name: "SET", Type: engine.SET, MinArgs: 2, MaxArgs: 2, Flags: Write, Keys: Keys{1, 1, 1},
+			Usage: "key value", Summary: "sets the value of a key", Parse: parseSet},
+		{Name: "GET", Type: engine.GET, MinArgs: 1, MaxArgs: 1, Flags: Read, Keys: Keys{1, 1, 1},
+			Usage: "key", Summary: "returns the value of a key", Parse: parseKey},
+		{Name: "DEL", Aliases: []string{"UNLINK"}, Type: engine.DEL, MinArgs: 1, MaxArgs: 1, Flags: Write, Keys: Keys{1, 1, 1},
+			Usage: "key", Summary: "deletes a key", Parse: parseKey},
+		{Name: "SLOWLOG", Type: engine.SLOWLOG, MinArgs: 1, MaxArgs: 2, Flags: Admin | NoScript,
+			Usage: "GET [n] | LEN | RESET", Summary: "reads or resets the slow log", Parse: analyzeSlowLog},
+		{Name: "SELECT", Type: engine.SELECT, MinArgs: 1, MaxArgs: 1, Flags: Connection | NoScript,
+			Usage: "db", Summary: "selects the database of the connection", Parse: parseDBIndexes},
+		{Name: "MOVE", Type: engine.MOVE, MinArgs: 2, MaxArgs: 2, Flags: Write | NoScript, Keys: Keys{1, 1, 1},
+			Usage: "key db", Summary: "moves a key to another database", Parse: parseMove},
+		{Name: "FLUSHDB", Type: engine.FLUSHDB, Flags: Write | NoScript,
+			Summary: "deletes the keys of the database"},
+		{Name: "SWAPDB", Type: engine.SWAPDB, MinArgs: 2, MaxArgs: 2, Flags: Write | Admin | NoScript,
+			Usage: "db db", Summary: "swaps the data of two databases", Parse: parseDBIndexes},
+
+		{Name: "SUBSCRIBE", Type: engine.SUBSCRIBE, MinArgs: 1, MaxArgs: Variadic, Flags: PubSub | NoScript,
+			Usage: "channel...", Summary: "listens to the notifications of channels"},
+		{Name: "PSUBSCRIBE", Type: engine.PSUBSCRIBE, MinArgs: 1, MaxArgs: Variadic, Flags: PubSub | NoScript,
+			Usage: "pattern...", Summary: "listens to the notifications of the channels matching patterns"},
+		{Name: "UNSUBSCRIBE", Type: engine.UNSUBSCRIBE, MaxArgs: Variadic, Flags: PubSub | NoScript,
+			Usage: "[channel...]", Summary: "stops listening to channels"},
+
+		{Name: "BACKUP", Type: engine.BACKUP, MinArgs: 1, MaxArgs: 1, Flags: Admin | NoScript,
+			Usage: "dir", Summary: "writes a consistent copy of the data to a directory"},
+		{Name: "LSN", Type: engine.LSN, Flags: Admin | NoScript,
+			Summary: "returns the last log sequence number"},
+
+		{Name: "DURABILITY", Type: engine.DURABILITY, MaxArgs: Variadic, Flags: Connection | NoScript,
+			Usage: "[async | local | replicated [command...]]", Summary: "reads or sets the durability of the writes",
+			Parse: analyzeDurability(r)},
+		{Name: "PING", Type: engine.PING, MaxArgs: 1, Flags: Connection,
+			Usage: "[message]", Summary: "returns PONG or the message"},
+		{Name: "CLIENT", Type: engine.CLIENT, MinArgs: 1, MaxArgs: Variadic, Flags: Admin | Connection | NoScript,
+			Usage: "LIST | ID | GETNAME | SETNAME name | KILL filter value...", Summary: "manages the client connections",
+			Parse: analyzeClient},
+		{Name: "INFO", Type: engine.INFO, MaxArgs: Variadic, Flags: Admin | NoScript,
+			Usage: "[section...]", Summary: "returns the state of the server"},
+		{Name: "KEYS", Type: engine.KEYS, MaxArgs: 1, Flags: Read,
+			Usage: "[pattern]", Summary: "returns the keys matching a pattern"},
+
+		{Name: "ASKING", Type: engine.ASKING, Flags: Connection | NoScript,
+			Summary: "allows the next command on a slot being imported"},
+		{Name: "CLUSTER", Type: engine.CLUSTER, MinArgs: 1, MaxArgs: Variadic, Flags: Admin | NoScript,
+			Usage:   "SLOTS | NODES | MYID | KEYSLOT key | COUNTKEYSINSLOT slot | GETKEYSINSLOT slot count | SETSLOT slot action",
+			Summary: "reads or changes the cluster slots", Parse: analyzeCluster},
+		{Name: "MIGRATE", Type: engine.MIGRATE, MinArgs: 2, MaxArgs: Variadic, Flags: Write | Admin | NoScript,
+			Usage: "addr key...", Summary: "moves keys to another node"},
+
+		{Name: "BEGIN", Type: engine.BEGIN, Flags: Transaction | NoScript,
+			Summary: "starts a snapshot transaction"},
+		{Name: "COMMIT", Type: engine.COMMIT, Flags: Write | Transaction | NoScript,
+			Summary: "applies the writes of the transaction"},
+		{Name: "ROLLBACK", Aliases: []string{"ABORT"}, Type: engine.ROLLBACK, Flags: Transaction | NoScript,
+			Summary: "drops the writes of the transaction"},
+		{Name: "SCAN", Type: engine.SCAN, MinArgs: 1, MaxArgs: Variadic, Flags: Read,
+			Usage: "cursor [MATCH pattern] [COUNT n] [WITHVALUES]", Summary: "iterates the keys on a snapshot",
+			Parse: analyzeScan},
+
+		{Name: "EVAL", Type: engine.EVAL, MinArgs: 2, MaxArgs: Variadic, Flags: Scripting | NoScript, KeysFunc: evalKeys,
+			Usage: "script numkeys key... arg...", Summary: "runs a script", Parse: analyzeEval},
+		{Name: "EVALSHA", Type: engine.EVALSHA, MinArgs: 2, MaxArgs: Variadic, Flags: Scripting | NoScript, KeysFunc: evalKeys,
+			Usage: "sha numkeys key... arg...", Summary: "runs a loaded script", Parse: analyzeEval},
+		{Name: "SCRIPT", Type: engine.SCRIPT, MinArgs: 1, MaxArgs: Variadic, Flags: Scripting | NoScript,
+			Usage: "LOAD script | EXISTS sha... | FLUSH | KILL", Summary: "manages the script cache", Parse: analyzeScript},
+
+		{Name: "HELP", Type: engine.HELP, MaxArgs: 1, Flags: Connection | NoScript,
+			Usage: "[command]", Summary: "describes the commands"},
+		{Name: "COMMAND", Type: engine.COMMAND, MaxArgs: Variadic, Flags: Connection | NoScript,
+			Usage: "[COUNT | LIST [category] | INFO [command...]]", Summary: "returns the details of the commands",
+			Parse: analyzeCommand},
+	}
+}
+
+// evalKeys returns the keys of EVAL script numkeys key... arg..., it is called on checked arguments.
+func evalKeys(args []string) []string {
+	n, _ := strconv.Atoi(args[1])
+	return args[2 : 2+n]
+}
+
+// analyzeCommand checks COMMAND [COUNT | LIST [category] | INFO [command...]].
+func analyzeCommand(a Action, args []string) (Action, error) {
+	if len(args) == 0 {
+		return a, nil
+	}
+	args = upper(args, 0)
+	a.Args = args
+	switch args[0] {
+	case "COUNT":
+		if len(args) > 1 {
+			return a, errors.New("COMMAND COUNT takes no arguments")
+		}
+	case "LIST":
+		if len(args) > 2 {
+			return a, errors.New("COMMAND LIST takes an optional category")
+		}
+		if len(args) == 2 {
+			if _, err := ParseFlag(args[1]); err != nil {
+				return a, err
+			}
+		}
+	case "INFO":
+	default:
+		return a, errors.New("unknown COMMAND subcommand")
+	}
+
+	return a, nil
+}
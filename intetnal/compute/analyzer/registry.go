@@ -0,0 +1,256 @@
+package analyzer
+
+import (
+	"errors"
+	"fmt"
+	"jokedb/intetnal/storage/engine"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Variadic is the MaxArgs of a command without a limit on its arguments.
+const Variadic = -1
+
+var (
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrCommandExists  = errors.New("command is registered already")
+	ErrUnknownFlag    = errors.New("unknown command flag")
+)
+
+// Flags classify the commands, the ACL categories of a command are the names of its flags.
+type Flags uint16
+
+const (
+	Read Flags = 1 << iota
+	Write
+	Admin
+	PubSub
+	Connection
+	Transaction
+	Scripting
+	// NoScript commands cannot be called by a script.
+	NoScript
+)
+
+var flagNames = []string{"read", "write", "admin", "pubsub", "connection", "transaction", "scripting", "noscript"}
+
+func (f Flags) Has(flag Flags) bool {
+	return f&flag == flag
+}
+
+// Strings returns the names of the flags.
+func (f Flags) Strings() []string {
+	var names []string
+	for i, name := range flagNames {
+		if f.Has(1 << i) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ParseFlag returns the flag of a name of Flags.Strings, in any case.
+func ParseFlag(name string) (Flags, error) {
+	i := slices.Index(flagNames, strings.ToLower(name))
+	if i < 0 {
+		return 0, fmt.Errorf("%w %s, want one of %s", ErrUnknownFlag, name, strings.Join(flagNames, ", "))
+	}
+	return 1 << i, nil
+}
+
+// Keys are the positions of the keys in the arguments of a command, counted from 1: the First key,
+// the Last one, -1 for the last argument, and the Step between them. First is 0 for no keys.
+type Keys struct {
+	First, Last, Step int
+}
+
+// ParseFunc checks the arguments of a command and fills the action, whose Type is set.
+type ParseFunc func(a Action, args []string) (Action, error)
+
+// Command describes a command: how it is called, how its arguments are analyzed and how it is
+// classified. The Type of a command registered by an embedder is given by Registry.Register.
+type Command struct {
+	Name    string
+	Aliases []string
+	Type    engine.ActionType
+	// MinArgs and MaxArgs count the tokens after the command name, MaxArgs is Variadic for no limit.
+	MinArgs, MaxArgs int
+	Flags            Flags
+	Keys             Keys
+	// KeysFunc returns the keys of a command whose key positions depend on its arguments, Keys is
+	// not used when it is set.
+	KeysFunc func(args []string) []string
+	// Usage are the arguments of the command and Summary what it does, for HELP.
+	Usage   string
+	Summary string
+	// Parse analyzes the arguments, they are the Args of the action when it is nil.
+	Parse ParseFunc
+}
+
+// keysOf returns the keys in the arguments of the command.
+func (c Command) keysOf(args []string) []string {
+	if c.KeysFunc != nil {
+		return c.KeysFunc(args)
+	}
+	if c.Keys.First <= 0 {
+		return nil
+	}
+	last := c.Keys.Last
+	if last < 0 || last > len(args) {
+		last = len(args)
+	}
+	step := max(c.Keys.Step, 1)
+	var keys []string
+	for i := c.Keys.First; i <= last; i += step {
+		keys = append(keys, args[i-1])
+	}
+	return keys
+}
+
+// Registry keeps the commands by name and alias, the lookup ignores the case.
+type Registry struct {
+	mu       sync.RWMutex
+	commands map[string]*Command
+	byType   map[engine.ActionType]*Command
+	next     engine.ActionType
+}
+
+// NewRegistry returns a registry of the builtin commands.
+func NewRegistry() *Registry {
+	r := &Registry{commands: map[string]*Command{}, byType: map[engine.ActionType]*Command{}}
+	for _, c := range builtins(r) {
+		if _, err := r.add(c); err != nil {
+			panic(err)
+		}
+		r.next = max(r.next, c.Type+1)
+	}
+	return r
+}
+
+// Register adds a command of an embedder and returns it with its Type.
+func (r *Registry) Register(c Command) (Command, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.next == math.MaxInt8 {
+		return c, errors.New("too many commands")
+	}
+	c.Type = r.next
+	c, err := r.add(c)
+	if err != nil {
+		return c, err
+	}
+	r.next++
+
+	return c, nil
+}
+
+func (r *Registry) add(c Command) (Command, error) {
+	if c.Name == "" {
+		return c, errors.New("command name is empty")
+	}
+	c.Name = strings.ToUpper(c.Name)
+	c.Aliases = slices.Clone(c.Aliases)
+	for i, alias := range c.Aliases {
+		c.Aliases[i] = strings.ToUpper(alias)
+	}
+	names := append([]string{c.Name}, c.Aliases...)
+	for _, name := range names {
+		if _, ok := r.commands[name]; ok {
+			return c, fmt.Errorf("%w: %s", ErrCommandExists, name)
+		}
+	}
+	for _, name := range names {
+		r.commands[name] = &c
+	}
+	r.byType[c.Type] = &c
+
+	return c, nil
+}
+
+// Lookup returns the command of a name or an alias.
+func (r *Registry) Lookup(name string) (Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.commands[strings.ToUpper(name)]
+	if !ok {
+		return Command{}, false
+	}
+	return *c, true
+}
+
+// ByType returns the command of an action type.
+func (r *Registry) ByType(t engine.ActionType) (Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.byType[t]
+	if !ok {
+		return Command{}, false
+	}
+	return *c, true
+}
+
+// Commands returns the commands sorted by name.
+func (r *Registry) Commands() []Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	commands := make([]Command, 0, len(r.byType))
+	for _, c := range r.byType {
+		commands = append(commands, *c)
+	}
+	slices.SortFunc(commands, func(a, b Command) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return commands
+}
+
+// ByCategory returns the commands sorted by name that have every flag of category, the flags are
+// the categories an access control checks the commands by.
+func (r *Registry) ByCategory(category Flags) []Command {
+	var commands []Command
+	for _, c := range r.Commands() {
+		if c.Flags.Has(category) {
+			commands = append(commands, c)
+		}
+	}
+	return commands
+}
+
+// Analyze checks the tokens of a command and returns its action.
+func (r *Registry) Analyze(tokens []string) (Action, error) {
+	a := Action{}
+	if len(tokens) == 0 {
+		return a, errors.New("empty command")
+	}
+
+	c, ok := r.Lookup(tokens[0])
+	if !ok {
+		return a, ErrUnknownCommand
+	}
+
+	args := tokens[1:]
+	if len(args) < c.MinArgs || (c.MaxArgs != Variadic && len(args) > c.MaxArgs) {
+		return a, fmt.Errorf("wrong number of arguments for %s", c.Name)
+	}
+
+	a.Type = c.Type
+	switch {
+	case c.Parse != nil:
+		var err error
+		if a, err = c.Parse(a, args); err != nil {
+			return a, err
+		}
+	case c.MaxArgs != 0:
+		a.Args = args
+	}
+	if c.KeysFunc != nil || c.Keys.First > 0 {
+		a.Keys = c.keysOf(args)
+	}
+
+	return a, nil
+}
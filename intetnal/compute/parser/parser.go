@@ -5,7 +5,10 @@ import (
 	"strings"
 )
 
-var ErrNotValidSymbol = errors.New("not valid symbol")
+var (
+	ErrNotValidSymbol = errors.New("not valid symbol")
+	ErrUnclosedQuote  = errors.New("unclosed quote")
+)
 
 type Parser struct{}
 
@@ -13,13 +16,31 @@ func New() *Parser {
 	return &Parser{}
 }
 
+// Tokenization splits in by spaces. A part of a token in double quotes may have any symbol,
+// \" and \\ in it stand for the quote and the backslash.
 func (p Parser) Tokenization(in string) ([]string, error) {
 	b := strings.Builder{}
 	var err error
 	var tokens []string
+	var quoted, escaped bool
 
 	for _, v := range in {
 		switch {
+		case escaped:
+			escaped = false
+			_, err = b.WriteRune(v)
+			if err != nil {
+				return nil, err
+			}
+		case quoted && v == '\\':
+			escaped = true
+		case v == '"':
+			quoted = !quoted
+		case quoted:
+			_, err = b.WriteRune(v)
+			if err != nil {
+				return nil, err
+			}
 		case v == ' ':
 			tokens = append(tokens, b.String())
 			b.Reset()
@@ -34,9 +55,29 @@ func (p Parser) Tokenization(in string) ([]string, error) {
 		}
 	}
 
+	if quoted {
+		return nil, ErrUnclosedQuote
+	}
+
 	return append(tokens, b.String()), nil
 }
 
+// Quote returns s as a token of Tokenization, in quotes if it has symbols that are not valid outside them.
+func Quote(s string) string {
+	plain := s != ""
+	for _, r := range s {
+		if !isValid(r) {
+			plain = false
+			break
+		}
+	}
+	if plain {
+		return s
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
 func isValid(r rune) bool {
 	switch {
 	case r >= '0' && r <= '9':
@@ -51,6 +92,11 @@ func isValid(r rune) bool {
 		return true
 	case r == '_':
 		return true
+	case r == ':', r == '@', r == '.', r == '-':
+		return true
+	case r == '{', r == '}':
+		// the hash tags of the cluster keys
+		return true
 	}
 
 	return false
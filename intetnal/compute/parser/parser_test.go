@@ -17,6 +17,28 @@ func TestTokenization(t *testing.T) {
 		require.Equal(t, want, got)
 	})
 
+	t.Run("hash_tag", func(t *testing.T) {
+		got, err := p.Tokenization("GET {user1000}.followers")
+		require.NoError(t, err)
+		require.Equal(t, []string{"GET", "{user1000}.followers"}, got)
+	})
+
+	t.Run("quoted", func(t *testing.T) {
+		got, err := p.Tokenization("SET \"a b\" \"say \\\"hi\\\"\nC:\\\\\" \"\"")
+		require.NoError(t, err)
+		require.Equal(t, []string{"SET", "a b", "say \"hi\"\nC:\\", ""}, got)
+
+		_, err = p.Tokenization(`SET a "b`)
+		require.ErrorIs(t, err, parser.ErrUnclosedQuote)
+
+		for _, s := range []string{"key", "a b", `"quoted" \ (call)`, ""} {
+			got, err = p.Tokenization("SET " + parser.Quote(s))
+			require.NoError(t, err)
+			require.Equal(t, []string{"SET", s}, got)
+		}
+		require.Equal(t, "key", parser.Quote("key"))
+	})
+
 	t.Run("not_valid", func(t *testing.T) {
 		in := "token1 to+ken2 token3"
 		_, err := p.Tokenization(in)
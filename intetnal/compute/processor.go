@@ -16,15 +16,23 @@ type Analyzer interface {
 type Processor struct {
 	parser   Parser
 	analyzer Analyzer
+	registry *analyzer.Registry
 }
 
 func New() *Processor {
+	r := analyzer.NewRegistry()
 	return &Processor{
 		parser:   &parser.Parser{},
-		analyzer: &analyzer.Analyzer{},
+		analyzer: analyzer.NewWithRegistry(r),
+		registry: r,
 	}
 }
 
+// Registry returns the commands the processor knows, an embedder registers its commands in it.
+func (c Processor) Registry() *analyzer.Registry {
+	return c.registry
+}
+
 func (c Processor) ParseQuery(q string) (analyzer.Action, error) {
 	tokens, err := c.parser.Tokenization(q)
 	if err != nil {
@@ -2,24 +2,67 @@ package config
 
 import (
 	"jokedb/intetnal/app"
+	"jokedb/intetnal/cluster"
+	"jokedb/intetnal/logger"
+	"jokedb/intetnal/notify"
+	"jokedb/intetnal/raft"
+	"jokedb/intetnal/storage"
+	"jokedb/intetnal/tcp"
+	"jokedb/intetnal/wal"
+	"reflect"
+	"strings"
 	"time"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
 const (
+	EnvPrefix = "JOKEDB"
+
 	maxSizeSegment       = 1024 * 1024 * 10
 	flushingBatchSize    = 1000
 	flushingBatchTimeout = 10 * time.Millisecond
+	logMaxSize           = 100
+	logMaxBackups        = 10
+	logMaxBackupAge      = 7 * 24 * time.Hour
+	databases            = 16
+	notifyBufferSize     = 128
+	slowLogThreshold     = 10 * time.Millisecond
+	slowLogMaxLen        = 128
+	slowLogMaxArgLen     = 64
+	pendingQueueSize     = 32 * 1024
+	overloadTimeout      = time.Second
+	idleTimeout          = 5 * time.Minute
+	readTimeout          = 10 * time.Second
+	writeTimeout         = 10 * time.Second
+	keepAlive            = 30 * time.Second
+	queueTimeout         = 10 * time.Second
+	requestSizeLimit     = 4 * 1024 * 1024
+	httpAddr             = "127.0.0.1:8080"
+	electionTimeout      = time.Second
+	heartbeatInterval    = 100 * time.Millisecond
+	snapshotThreshold    = 10000
+	scriptTimeout        = 5 * time.Second
+	scriptMaxValueSize   = 64 * 1024 * 1024
 )
 
+// Fields tagged with reload:"live" can be changed without restarting the server.
 type Config struct {
-	Engine         Engine
-	Log            Log
-	WAL            WAL
-	MaxConnections uint
-	Addr           string
-	DevMode        bool
+	Engine         Engine      `mapstructure:"engine"`
+	Log            Log         `mapstructure:"log"`
+	WAL            WAL         `mapstructure:"wal"`
+	SlowLog        SlowLog     `mapstructure:"slowlog"`
+	Scripting      Scripting   `mapstructure:"scripting"`
+	Notifications  Notify      `mapstructure:"notifications"`
+	Connections    Connections `mapstructure:"connections"`
+	HTTP           HTTP        `mapstructure:"http"`
+	Raft           Raft        `mapstructure:"raft"`
+	Cluster        Cluster     `mapstructure:"cluster"`
+	MaxConnections uint        `mapstructure:"max_connections" reload:"live"`
+	Databases      int         `mapstructure:"databases"`
+	Addr           string      `mapstructure:"addr"`
+	DevMode        bool        `mapstructure:"dev_mode"`
 }
 
 type Conn struct {
@@ -27,34 +70,187 @@ type Conn struct {
 	Host string
 }
 
+// Connections holds the timeouts of the client connections, 0 disables a timeout.
+// A negative keepAlive disables TCP keepalive, 0 keeps the OS default period.
+type Connections struct {
+	IdleTimeout time.Duration `mapstructure:"idleTimeout" reload:"live"`
+	// ReadTimeout bounds the read of a request once it started arriving, IdleTimeout the wait for it.
+	ReadTimeout  time.Duration `mapstructure:"readTimeout" reload:"live"`
+	WriteTimeout time.Duration `mapstructure:"writeTimeout" reload:"live"`
+	KeepAlive    time.Duration `mapstructure:"keepAlive" reload:"live"`
+	// Admission is queue or reject for the connections over max_connections,
+	// queued ones wait up to QueueTimeout, 0 for no limit.
+	Admission    string        `mapstructure:"admission" reload:"live"`
+	QueueTimeout time.Duration `mapstructure:"queueTimeout" reload:"live"`
+	// MaxRequestSize is the largest request in bytes, a connection sending a longer one is closed.
+	MaxRequestSize int `mapstructure:"maxRequestSize" reload:"live"`
+}
+
+// HTTP is the HTTP/JSON gateway, it listens on Addr when Enabled.
+type HTTP struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Addr    string `mapstructure:"addr"`
+}
+
+// Raft makes the server the node ID of a Raft cluster of the static Nodes: writes are acknowledged
+// once a majority committed them and refused by the followers. The raft log in Dir replaces the WAL.
+type Raft struct {
+	Enabled           bool          `mapstructure:"enabled"`
+	ID                string        `mapstructure:"id"`
+	Dir               string        `mapstructure:"dir"`
+	Nodes             []RaftNode    `mapstructure:"nodes"`
+	ElectionTimeout   time.Duration `mapstructure:"electionTimeout"`
+	HeartbeatInterval time.Duration `mapstructure:"heartbeatInterval"`
+	SnapshotThreshold uint64        `mapstructure:"snapshotThreshold"`
+	// MinReplicas is the number of followers a write with the replicated durability waits for,
+	// 0 for the followers of a majority of Nodes.
+	MinReplicas int `mapstructure:"minReplicas"`
+}
+
+// RaftNode is a member of the cluster, Addr serves the raft RPCs and ClientAddr the clients.
+// The yaml tags keep the keys of --print-config.
+type RaftNode struct {
+	ID         string `mapstructure:"id" yaml:"id"`
+	Addr       string `mapstructure:"addr" yaml:"addr"`
+	ClientAddr string `mapstructure:"clientAddr" yaml:"clientAddr"`
+}
+
+// Cluster splits the keyspace into hash slots served by the static Nodes, the node ID redirects
+// the commands on the keys of the other slots. The slots moved between the nodes are kept in StateFile.
+type Cluster struct {
+	Enabled   bool          `mapstructure:"enabled"`
+	ID        string        `mapstructure:"id"`
+	StateFile string        `mapstructure:"stateFile"`
+	Nodes     []ClusterNode `mapstructure:"nodes"`
+}
+
+// ClusterNode is a member of the cluster, Addr is the address its clients connect to
+// and Slots are the slots or "from-to" ranges it serves.
+type ClusterNode struct {
+	ID    string   `mapstructure:"id" yaml:"id"`
+	Addr  string   `mapstructure:"addr" yaml:"addr"`
+	Slots []string `mapstructure:"slots" yaml:"slots"`
+}
+
 type Engine struct {
-	Type string
+	Type string `mapstructure:"type"`
 }
 
 type Log struct {
-	Level  string
-	Output string
+	Level          string        `mapstructure:"level" reload:"live"`
+	Output         string        `mapstructure:"output"`
+	ErrorOutput    string        `mapstructure:"errorOutput"`
+	AccessOutput   string        `mapstructure:"accessOutput"`
+	MaxSize        uint          `mapstructure:"maxSize"`
+	RotateInterval time.Duration `mapstructure:"rotateInterval"`
+	MaxBackups     uint          `mapstructure:"maxBackups"`
+	MaxBackupAge   time.Duration `mapstructure:"maxBackupAge"`
+	Compress       bool          `mapstructure:"compress"`
+	AccessSampling Sampling      `mapstructure:"accessSampling"`
+}
+
+type Sampling struct {
+	Initial    int `mapstructure:"initial"`
+	Thereafter int `mapstructure:"thereafter"`
+}
+
+// SlowLog records the commands slower than Threshold, a negative threshold disables it.
+type SlowLog struct {
+	Threshold time.Duration `mapstructure:"threshold" reload:"live"`
+	MaxLen    int           `mapstructure:"maxLen" reload:"live"`
+	MaxArgLen int           `mapstructure:"maxArgLen" reload:"live"`
+}
+
+// Scripting limits the run time of the EVAL scripts, a script over Timeout is stopped and its writes are dropped.
+// A script that makes or reads a value over MaxValueSize bytes fails.
+type Scripting struct {
+	Timeout      time.Duration `mapstructure:"timeout" reload:"live"`
+	MaxValueSize int           `mapstructure:"maxValueSize" reload:"live"`
+}
+
+// Notify selects the keyspace notifications, Events are the names of the emitted
+// events: set, del, move_from, move_to, flushdb and swapdb.
+type Notify struct {
+	Keyspace   bool     `mapstructure:"keyspace" reload:"live"`
+	Keyevent   bool     `mapstructure:"keyevent" reload:"live"`
+	Events     []string `mapstructure:"events" reload:"live"`
+	BufferSize int      `mapstructure:"bufferSize" reload:"live"`
 }
 
 type WAL struct {
-	Enabled              bool
-	DirPath              string
-	MaxSizeSegment       uint32
-	FlushingBatchSize    uint32
-	FlushingBatchTimeout time.Duration
+	Enabled              bool          `mapstructure:"enabled"`
+	DirPath              string        `mapstructure:"dirPath"`
+	MaxSizeSegment       uint32        `mapstructure:"maxSegmentSize"`
+	FlushingBatchSize    uint32        `mapstructure:"flushingBatchSize" reload:"live"`
+	FlushingBatchTimeout time.Duration `mapstructure:"flushingBatchTimeout" reload:"live"`
+	// Fsync is always, batch, interval(<duration>) or never.
+	Fsync string `mapstructure:"fsync"`
+	// PendingQueueSize is the number of writes waiting for the WAL, a write to a full queue
+	// waits up to OverloadTimeout with Overload block or fails with BUSY with reject.
+	PendingQueueSize int           `mapstructure:"pendingQueueSize"`
+	Overload         string        `mapstructure:"overload"`
+	OverloadTimeout  time.Duration `mapstructure:"overloadTimeout"`
+	// RecoveryTargetLSN and RecoveryTargetTime (RFC 3339) stop the replay of the WAL on start,
+	// the WAL continues as a new timeline from there.
+	RecoveryTargetLSN  uint64 `mapstructure:"recoveryTargetLSN"`
+	RecoveryTargetTime string `mapstructure:"recoveryTargetTime"`
 }
 
-func Init(configFile string) (*Config, error) {
-	config := Config{
+func Default() Config {
+	return Config{
 		Addr: app.Addr,
 		Engine: Engine{
 			Type: "in_memory",
 		},
 		MaxConnections: app.MaxConn,
+		Databases:      databases,
 		DevMode:        false,
 		Log: Log{
-			Level:  "error",
-			Output: "./db/log/app.log",
+			Level:        "error",
+			Output:       "./db/log/app.log",
+			MaxSize:      logMaxSize,
+			MaxBackups:   logMaxBackups,
+			MaxBackupAge: logMaxBackupAge,
+			Compress:     true,
+		},
+		SlowLog: SlowLog{
+			Threshold: slowLogThreshold,
+			MaxLen:    slowLogMaxLen,
+			MaxArgLen: slowLogMaxArgLen,
+		},
+		Scripting: Scripting{
+			Timeout:      scriptTimeout,
+			MaxValueSize: scriptMaxValueSize,
+		},
+		Notifications: Notify{
+			Events: []string{
+				notify.EventSet, notify.EventDel, notify.EventMoveFrom, notify.EventMoveTo,
+				notify.EventFlushDB, notify.EventSwapDB,
+			},
+			BufferSize: notifyBufferSize,
+		},
+		Connections: Connections{
+			IdleTimeout:    idleTimeout,
+			ReadTimeout:    readTimeout,
+			WriteTimeout:   writeTimeout,
+			KeepAlive:      keepAlive,
+			Admission:      "queue",
+			QueueTimeout:   queueTimeout,
+			MaxRequestSize: requestSizeLimit,
+		},
+		HTTP: HTTP{
+			Addr: httpAddr,
+		},
+		Raft: Raft{
+			Dir:               "./db/raft",
+			Nodes:             []RaftNode{},
+			ElectionTimeout:   electionTimeout,
+			HeartbeatInterval: heartbeatInterval,
+			SnapshotThreshold: snapshotThreshold,
+		},
+		Cluster: Cluster{
+			StateFile: "./db/cluster.json",
+			Nodes:     []ClusterNode{},
 		},
 		WAL: WAL{
 			Enabled:              true,
@@ -62,16 +258,216 @@ func Init(configFile string) (*Config, error) {
 			DirPath:              "./db/wal",
 			FlushingBatchSize:    flushingBatchSize,
 			FlushingBatchTimeout: flushingBatchTimeout,
+			Fsync:                "batch",
+			PendingQueueSize:     pendingQueueSize,
+			Overload:             "block",
+			OverloadTimeout:      overloadTimeout,
 		},
 	}
-	viper.SetConfigFile(configFile)
-	if err := viper.ReadInConfig(); err != nil {
+}
+
+func Init(configFile string) (*Config, error) {
+	return Load(configFile, nil)
+}
+
+// Load merges the config sources. The precedence is overrides (command line
+// flags) over JOKEDB_* environment variables over the config file over defaults.
+// An empty configFile means that no file is read.
+func Load(configFile string, overrides map[string]string) (*Config, error) {
+	v := viper.New()
+	defaults := Default()
+	var err error
+	walk(reflect.ValueOf(&defaults).Elem(), "", func(path string, field reflect.StructField, value reflect.Value) {
+		v.SetDefault(path, value.Interface())
+		if !hasEnv(field) {
+			return
+		}
+		if errBind := v.BindEnv(path, EnvName(path)); errBind != nil && err == nil {
+			err = errBind
+		}
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	if err := viper.Unmarshal(&config); err != nil {
-		return nil, err
+	problems := &ValidationError{}
+	if configFile != "" {
+		v.SetConfigFile(configFile)
+		if err = v.ReadInConfig(); err != nil {
+			return nil, err
+		}
+		if err = problems.checkUnknownKeys(configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for key, value := range overrides {
+		v.Set(key, value)
+	}
+
+	config := Default()
+	walk(reflect.ValueOf(&config).Elem(), "", func(path string, _ reflect.StructField, value reflect.Value) {
+		if errDecode := decodeField(v.Get(path), value); errDecode != nil {
+			problems.add(path, errDecode.Error())
+		}
+	})
+	if len(problems.Problems) == 0 {
+		problems.validate(&config)
+	}
+	if len(problems.Problems) > 0 {
+		return nil, problems
 	}
 
 	return &config, nil
 }
+
+func decodeField(input any, value reflect.Value) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		WeaklyTypedInput: true,
+		Result:           value.Addr().Interface(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return decoder.Decode(input)
+}
+
+// LoggerOptions converts the log section to the logger options.
+func (l Log) LoggerOptions() logger.Options {
+	return logger.Options{
+		Level:        l.Level,
+		Output:       l.Output,
+		ErrorOutput:  l.ErrorOutput,
+		AccessOutput: l.AccessOutput,
+		Rotate: logger.RotateOptions{
+			MaxSize:        l.MaxSize,
+			RotateInterval: l.RotateInterval,
+			MaxBackups:     l.MaxBackups,
+			MaxBackupAge:   l.MaxBackupAge,
+			Compress:       l.Compress,
+		},
+		AccessSampling: logger.Sampling{
+			Initial:    l.AccessSampling.Initial,
+			Thereafter: l.AccessSampling.Thereafter,
+		},
+	}
+}
+
+// SyncOption converts wal.fsync, it is checked by Validate.
+func (w WAL) SyncOption() wal.Option {
+	policy, interval, _ := wal.ParseSyncPolicy(w.Fsync)
+	return wal.WithSync(policy, interval)
+}
+
+// PendingQueueOption converts the pending queue keys, they are checked by Validate.
+func (w WAL) PendingQueueOption() storage.Option {
+	policy, _ := storage.ParseOverloadPolicy(w.Overload)
+	return storage.WithPendingQueue(w.PendingQueueSize, policy, w.OverloadTimeout)
+}
+
+func (c Connections) Timeouts() tcp.Timeouts {
+	return tcp.Timeouts{
+		Idle:      c.IdleTimeout,
+		Read:      c.ReadTimeout,
+		Write:     c.WriteTimeout,
+		KeepAlive: c.KeepAlive,
+	}
+}
+
+// NodeConfig converts the raft section, it is checked by Validate.
+func (r Raft) NodeConfig() raft.Config {
+	return raft.Config{
+		ID:                r.ID,
+		Peers:             r.Peers(),
+		ElectionTimeout:   r.ElectionTimeout,
+		HeartbeatInterval: r.HeartbeatInterval,
+		SnapshotThreshold: r.SnapshotThreshold,
+	}
+}
+
+func (r Raft) Peers() []raft.Peer {
+	peers := make([]raft.Peer, 0, len(r.Nodes))
+	for _, n := range r.Nodes {
+		peers = append(peers, raft.Peer{ID: n.ID, Addr: n.Addr, ClientAddr: n.ClientAddr})
+	}
+
+	return peers
+}
+
+// Replicas returns the number of followers a replicated write waits for, see MinReplicas.
+func (r Raft) Replicas() int {
+	if r.MinReplicas == 0 {
+		return len(r.Nodes) / 2
+	}
+	return r.MinReplicas
+}
+
+// Self returns the node of ID.
+func (r Raft) Self() RaftNode {
+	for _, n := range r.Nodes {
+		if n.ID == r.ID {
+			return n
+		}
+	}
+
+	return RaftNode{}
+}
+
+// State returns the slot map of the cluster section, it is checked by Validate.
+func (c Cluster) State() (*cluster.State, error) {
+	nodes := make([]cluster.Node, 0, len(c.Nodes))
+	for _, n := range c.Nodes {
+		node := cluster.Node{ID: n.ID, Addr: n.Addr}
+		for _, slot := range n.Slots {
+			r, err := cluster.ParseSlotRange(slot)
+			if err != nil {
+				return nil, err
+			}
+			node.Slots = append(node.Slots, r)
+		}
+		nodes = append(nodes, node)
+	}
+
+	return cluster.New(c.ID, nodes, c.StateFile)
+}
+
+// AdmissionOptions converts the admission keys, they are checked by Validate.
+func (c Connections) AdmissionOptions() tcp.Admission {
+	policy, _ := tcp.ParseAdmissionPolicy(c.Admission)
+	return tcp.Admission{Policy: policy, QueueTimeout: c.QueueTimeout}
+}
+
+// RecoveryTarget converts the recovery target keys, the time is checked by Validate.
+func (w WAL) RecoveryTarget() storage.RecoveryTarget {
+	target := storage.RecoveryTarget{LSN: w.RecoveryTargetLSN}
+	if w.RecoveryTargetTime != "" {
+		target.Time, _ = time.Parse(time.RFC3339Nano, w.RecoveryTargetTime)
+	}
+
+	return target
+}
+
+func (n Notify) Options() notify.Options {
+	return notify.Options{
+		Keyspace: n.Keyspace,
+		Keyevent: n.Keyevent,
+		Events:   n.Events,
+	}
+}
+
+// hasEnv tells whether the key of field can be set by an environment variable. A list of nodes
+// such as raft.nodes cannot be written as one string, it is set in the config file only.
+func hasEnv(field reflect.StructField) bool {
+	return field.Type.Kind() != reflect.Slice || field.Type.Elem().Kind() != reflect.Struct
+}
+
+// EnvName returns the environment variable overriding the config key, e.g.
+// wal.dirPath is JOKEDB_WAL_DIRPATH.
+func EnvName(path string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
@@ -0,0 +1,377 @@
+package config_test
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"jokedb/intetnal/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, path, data string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+}
+
+func TestInit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	writeConfig(t, path, `
+addr: "127.0.0.1:4000"
+max_connections: 7
+dev_mode: true
+wal:
+  flushingBatchSize: 10
+  flushingBatchTimeout: "20ms"
+  maxSegmentSize: 1024
+`)
+
+	c, err := config.Init(path)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1:4000", c.Addr)
+	require.Equal(t, uint(7), c.MaxConnections)
+	require.True(t, c.DevMode)
+	require.Equal(t, uint32(10), c.WAL.FlushingBatchSize)
+	require.Equal(t, 20*time.Millisecond, c.WAL.FlushingBatchTimeout)
+	require.Equal(t, uint32(1024), c.WAL.MaxSizeSegment)
+	require.Equal(t, "./db/wal", c.WAL.DirPath)
+}
+
+func TestDiff(t *testing.T) {
+	prev := config.Default()
+	next := config.Default()
+	next.MaxConnections = 5
+	next.Addr = "127.0.0.1:5000"
+
+	changes := config.Diff(&prev, &next)
+	require.Equal(t, []config.Change{
+		{Path: "max_connections", Old: prev.MaxConnections, New: uint(5), NeedRestart: false},
+		{Path: "addr", Old: prev.Addr, New: "127.0.0.1:5000", NeedRestart: true},
+	}, changes)
+}
+
+type testLogger struct{}
+
+func (testLogger) Infof(string, ...interface{})  {}
+func (testLogger) Warnf(string, ...interface{})  {}
+func (testLogger) Errorf(string, ...interface{}) {}
+
+func TestWatcher_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	writeConfig(t, path, "addr: \"127.0.0.1:4000\"\nlog:\n  level: info\n")
+	c, err := config.Init(path)
+	require.NoError(t, err)
+
+	w := config.NewWatcher(path, nil, c, testLogger{})
+	var reloaded *config.Config
+	w.OnReload(func(c *config.Config) {
+		reloaded = c
+	})
+
+	writeConfig(t, path, "addr: \"127.0.0.1:5000\"\nlog:\n  level: debug\n")
+	w.Reload()
+
+	require.NotNil(t, reloaded)
+	require.Equal(t, "debug", reloaded.Log.Level)
+	require.Equal(t, "127.0.0.1:4000", reloaded.Addr)
+	require.Equal(t, reloaded, w.Current())
+}
+
+type recordingLogger struct {
+	warnings, errors []string
+}
+
+func (l *recordingLogger) Infof(string, ...interface{}) {}
+func (l *recordingLogger) Warnf(template string, args ...interface{}) {
+	l.warnings = append(l.warnings, fmt.Sprintf(template, args...))
+}
+func (l *recordingLogger) Errorf(template string, args ...interface{}) {
+	l.errors = append(l.errors, fmt.Sprintf(template, args...))
+}
+
+func TestWatcher_ReloadRejected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	writeConfig(t, path, "addr: \"127.0.0.1:4000\"\nwal:\n  enabled: false\n")
+	c, err := config.Init(path)
+	require.NoError(t, err)
+
+	logger := &recordingLogger{}
+	w := config.NewWatcher(path, nil, c, logger)
+	reloads := 0
+	w.OnReload(func(*config.Config) {
+		reloads++
+	})
+
+	// a live value that would break the running server is not applied, with the WAL disabled as well
+	for _, timeout := range []string{"0s", "-1s"} {
+		writeConfig(t, path, "addr: \"127.0.0.1:4000\"\nwal:\n  enabled: false\n  flushingBatchTimeout: \""+timeout+"\"\n")
+		w.Reload()
+	}
+	require.Len(t, logger.errors, 2)
+	require.Zero(t, reloads)
+	require.Equal(t, c, w.Current())
+
+	// a restart-only change is warned about once per new value
+	writeConfig(t, path, "addr: \"127.0.0.1:5000\"\nwal:\n  enabled: false\n")
+	w.Reload()
+	w.Reload()
+	require.Len(t, logger.warnings, 1)
+	writeConfig(t, path, "addr: \"127.0.0.1:6000\"\nwal:\n  enabled: false\n")
+	w.Reload()
+	require.Len(t, logger.warnings, 2)
+	writeConfig(t, path, "addr: \"127.0.0.1:4000\"\nwal:\n  enabled: false\n")
+	w.Reload()
+	writeConfig(t, path, "addr: \"127.0.0.1:6000\"\nwal:\n  enabled: false\n")
+	w.Reload()
+	require.Len(t, logger.warnings, 3)
+	require.Zero(t, reloads)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	writeConfig(t, path, "addr: \"file:1\"\nmax_connections: 7\nlog:\n  level: info\n")
+	t.Setenv("JOKEDB_ADDR", "env:1")
+	t.Setenv("JOKEDB_LOG_LEVEL", "warn")
+
+	c, err := config.Load(path, map[string]string{"addr": "flag:1"})
+	require.NoError(t, err)
+	require.Equal(t, "flag:1", c.Addr)
+	require.Equal(t, "warn", c.Log.Level)
+	require.Equal(t, uint(7), c.MaxConnections)
+	require.Equal(t, "./db/wal", c.WAL.DirPath)
+}
+
+func TestRegisterFlags(t *testing.T) {
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	flags := config.RegisterFlags(fs, "")
+	require.NoError(t, fs.Parse([]string{"--dev-mode", "--max-connections", "5", "--recover-to-lsn=42", "--addr", "flag:1"}))
+	require.Equal(t, map[string]string{
+		"dev_mode":              "true",
+		"max_connections":       "5",
+		"wal.recoveryTargetLSN": "42",
+		"addr":                  "flag:1",
+	}, flags.Overrides())
+
+	// the lists of nodes are not read from the environment
+	t.Setenv("JOKEDB_RAFT_NODES", "n1")
+	c, err := config.Load("", flags.Overrides())
+	require.NoError(t, err)
+	require.True(t, c.DevMode)
+	require.Equal(t, uint(5), c.MaxConnections)
+	require.Equal(t, uint64(42), c.WAL.RecoveryTargetLSN)
+	require.Empty(t, c.Raft.Nodes)
+
+	fs = flag.NewFlagSet("app", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	config.RegisterFlags(fs, "")
+	require.Error(t, fs.Parse([]string{"--max-connections", "many"}))
+}
+
+func TestMarshal(t *testing.T) {
+	c := config.Default()
+	out, err := config.Marshal(&c)
+	require.NoError(t, err)
+	require.Contains(t, string(out), "flushingBatchTimeout: 10ms")
+
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	writeConfig(t, path, string(out))
+	got, err := config.Init(path)
+	require.NoError(t, err)
+	require.Equal(t, c, *got)
+}
+
+func TestLoad_Validation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.yaml")
+	writeConfig(t, path, `
+addr: "localhost"
+max_connections: 0
+log:
+  level: "loud"
+wal:
+  FlushingBatchSize: 10
+  flushingBatchSize: 0
+  flushingBatchTimeout: "soon"
+  dirPath: "`+path+`/wal"
+unknown: 1
+`)
+
+	_, err := config.Load(path, nil)
+	var validationErr *config.ValidationError
+	require.ErrorAs(t, err, &validationErr)
+
+	paths := make([]string, 0, len(validationErr.Problems))
+	for _, p := range validationErr.Problems {
+		paths = append(paths, p.Path)
+	}
+	require.Equal(t, []string{
+		"unknown",
+		"wal.FlushingBatchSize",
+		"wal.flushingBatchTimeout",
+	}, paths)
+	require.Contains(t, err.Error(), "did you mean wal.flushingBatchSize?")
+
+	writeConfig(t, path, `
+addr: "localhost"
+max_connections: 0
+log:
+  level: "loud"
+wal:
+  flushingBatchSize: 0
+  flushingBatchTimeout: "0s"
+  recoveryTargetTime: "yesterday"
+  fsync: "sometimes"
+  dirPath: "`+path+`/wal"
+scripting:
+  timeout: "0s"
+  maxValueSize: 0
+connections:
+  maxRequestSize: 100
+`)
+	_, err = config.Load(path, nil)
+	require.ErrorAs(t, err, &validationErr)
+	paths = paths[:0]
+	for _, p := range validationErr.Problems {
+		paths = append(paths, p.Path)
+	}
+	require.Equal(t, []string{
+		"addr",
+		"max_connections",
+		"log.level",
+		"scripting.timeout",
+		"scripting.maxValueSize",
+		"connections.maxRequestSize",
+		"wal.flushingBatchSize",
+		"wal.flushingBatchTimeout",
+		"wal.fsync",
+		"wal.recoveryTargetTime",
+	}, paths)
+}
+
+func TestCheckDirs(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	writeConfig(t, file, "")
+
+	c := config.Default()
+	c.WAL.DirPath = file
+	c.Log.Output = filepath.Join(dir, "logs", "app.log")
+	err := config.CheckDirs(&c)
+	var validationErr *config.ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	require.Equal(t, []config.Problem{{Path: "wal.dirPath", Message: file + " is not a directory"}}, validationErr.Problems)
+
+	// the WAL is not used with raft
+	c.Raft.Enabled = true
+	c.Raft.Dir = filepath.Join(dir, "raft")
+	require.NoError(t, config.CheckDirs(&c))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestLoad_Raft(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.yaml")
+	writeConfig(t, path, `
+raft:
+  enabled: true
+  id: "n2"
+  dir: "`+dir+`/raft"
+  nodes:
+    - id: "n1"
+      addr: "127.0.0.1:4001"
+      clientAddr: "127.0.0.1:3001"
+    - id: "n2"
+      addr: "127.0.0.1:4002"
+      clientAddr: "127.0.0.1:3002"
+    - id: "n3"
+      addr: "127.0.0.1:4003"
+`)
+	c, err := config.Load(path, map[string]string{"raft.id": "n3"})
+	require.NoError(t, err)
+	require.Equal(t, config.RaftNode{ID: "n1", Addr: "127.0.0.1:4001", ClientAddr: "127.0.0.1:3001"}, c.Raft.Nodes[0])
+	require.Equal(t, "127.0.0.1:4003", c.Raft.Self().Addr)
+	require.Len(t, c.Raft.NodeConfig().Peers, 3)
+	// a majority of 3 nodes is the leader and 1 follower
+	require.Equal(t, 1, c.Raft.Replicas())
+	c, err = config.Load(path, map[string]string{"raft.minReplicas": "2"})
+	require.NoError(t, err)
+	require.Equal(t, 2, c.Raft.Replicas())
+
+	writeConfig(t, path, `
+raft:
+  enabled: true
+  id: "n4"
+  dir: "`+dir+`/raft"
+  heartbeatInterval: "2s"
+  minReplicas: 2
+  nodes:
+    - id: "n1"
+      addr: "127.0.0.1:4001"
+    - id: "n1"
+      addr: "4002"
+`)
+	_, err = config.Load(path, nil)
+	var validationErr *config.ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	paths := make([]string, 0, len(validationErr.Problems))
+	for _, p := range validationErr.Problems {
+		paths = append(paths, p.Path)
+	}
+	require.Equal(t, []string{
+		"raft.nodes[1].id",
+		"raft.nodes[1].addr",
+		"raft.id",
+		"raft.heartbeatInterval",
+		"raft.minReplicas",
+	}, paths)
+}
+
+func TestLoad_Cluster(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.yaml")
+	writeConfig(t, path, `
+databases: 1
+cluster:
+  enabled: true
+  id: "n1"
+  stateFile: "`+dir+`/cluster.json"
+  nodes:
+    - {id: "n1", addr: "127.0.0.1:3001", slots: ["0-8191"]}
+    - {id: "n2", addr: "127.0.0.1:3002", slots: ["8192-16383"]}
+`)
+	c, err := config.Load(path, nil)
+	require.NoError(t, err)
+	state, err := c.Cluster.State()
+	require.NoError(t, err)
+	require.Len(t, state.Slots(), 2)
+
+	writeConfig(t, path, `
+cluster:
+  enabled: true
+  id: "n3"
+  stateFile: "`+dir+`/cluster.json"
+  nodes:
+    - {id: "n1", addr: "127.0.0.1:3001", slots: ["0-8191"]}
+    - {id: "n2", addr: "127.0.0.1:3002", slots: ["8000-16383", "16384"]}
+`)
+	_, err = config.Load(path, nil)
+	var validationErr *config.ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	paths := make([]string, 0, len(validationErr.Problems))
+	for _, p := range validationErr.Problems {
+		paths = append(paths, p.Path)
+	}
+	require.Equal(t, []string{
+		"databases",
+		"cluster.nodes[1].slots",
+		"cluster.nodes[1].slots",
+		"cluster.id",
+	}, paths)
+}
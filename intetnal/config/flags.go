@@ -0,0 +1,99 @@
+package config
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Flags struct {
+	fs          *flag.FlagSet
+	ConfigFile  *string
+	PrintConfig *bool
+	keys        map[string]string
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// RegisterFlags defines --config, --print-config and the overrides of common config keys on fs.
+// An override has the type of its key, so a boolean such as --dev-mode may be given without a value.
+func RegisterFlags(fs *flag.FlagSet, defaultConfigFile string) *Flags {
+	f := &Flags{
+		fs:          fs,
+		ConfigFile:  fs.String("config", defaultConfigFile, "path to the config file, empty to use defaults only"),
+		PrintConfig: fs.Bool("print-config", false, "print the effective config and exit"),
+		keys: map[string]string{
+			"addr":            "addr",
+			"max-connections": "max_connections",
+			"log-level":       "log.level",
+			"log-output":      "log.output",
+			"wal-dir":         "wal.dirPath",
+			"recover-to-lsn":  "wal.recoveryTargetLSN",
+			"recover-to-time": "wal.recoveryTargetTime",
+			"http-addr":       "http.addr",
+			"raft-id":         "raft.id",
+			"raft-dir":        "raft.dir",
+			"cluster-id":      "cluster.id",
+			"dev-mode":        "dev_mode",
+		},
+	}
+	defaults := Default()
+	for name, key := range f.keys {
+		usage := "overrides " + key + " (env " + EnvName(key) + ")"
+		// the defaults are not used, only the flags set explicitly override the config
+		switch field := fieldByPath(reflect.ValueOf(&defaults).Elem(), key); {
+		case field.Kind() == reflect.Bool:
+			fs.Bool(name, false, usage)
+		case field.Type() == durationType:
+			fs.Duration(name, 0, usage)
+		case field.CanInt():
+			fs.Int64(name, 0, usage)
+		case field.CanUint():
+			fs.Uint64(name, 0, usage)
+		default:
+			fs.String(name, "", usage)
+		}
+	}
+
+	return f
+}
+
+// Overrides returns the config keys of the flags that were set explicitly.
+func (f *Flags) Overrides() map[string]string {
+	overrides := map[string]string{}
+	f.fs.Visit(func(fl *flag.Flag) {
+		if key, ok := f.keys[fl.Name]; ok {
+			overrides[key] = fl.Value.String()
+		}
+	})
+
+	return overrides
+}
+
+// Marshal encodes the config as YAML with the same keys as the config file.
+func Marshal(c *Config) ([]byte, error) {
+	root := map[string]any{}
+	walk(reflect.ValueOf(c).Elem(), "", func(path string, _ reflect.StructField, value reflect.Value) {
+		node := root
+		keys := strings.Split(path, ".")
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := node[key].(map[string]any)
+			if !ok {
+				child = map[string]any{}
+				node[key] = child
+			}
+			node = child
+		}
+
+		v := value.Interface()
+		if d, ok := v.(time.Duration); ok {
+			v = d.String()
+		}
+		node[keys[len(keys)-1]] = v
+	})
+
+	return yaml.Marshal(root)
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+type Logger interface {
+	Infof(template string, args ...interface{})
+	Warnf(template string, args ...interface{})
+	Errorf(template string, args ...interface{})
+}
+
+type Change struct {
+	Path        string
+	Old         any
+	New         any
+	NeedRestart bool
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.Path, c.Old, c.New)
+}
+
+// Diff returns the changed fields keyed by their YAML path.
+func Diff(prev, next *Config) []Change {
+	var changes []Change
+	nextValue := reflect.ValueOf(next).Elem()
+	walk(reflect.ValueOf(prev).Elem(), "", func(path string, field reflect.StructField, value reflect.Value) {
+		newValue := fieldByPath(nextValue, path)
+		if reflect.DeepEqual(value.Interface(), newValue.Interface()) {
+			return
+		}
+		changes = append(changes, Change{
+			Path:        path,
+			Old:         value.Interface(),
+			New:         newValue.Interface(),
+			NeedRestart: field.Tag.Get("reload") != "live",
+		})
+	})
+
+	return changes
+}
+
+type Watcher struct {
+	path      string
+	overrides map[string]string
+	viper     *viper.Viper
+	logger    Logger
+	mu        sync.Mutex
+	current   *Config
+	onReload  []func(c *Config)
+	// rejected keeps the new value of every rejected restart-only change, it is warned about once.
+	rejected map[string]any
+}
+
+func NewWatcher(configFile string, overrides map[string]string, current *Config, logger Logger) *Watcher {
+	return &Watcher{
+		path:      configFile,
+		overrides: overrides,
+		viper:     viper.New(),
+		logger:    logger,
+		current:   current,
+		rejected:  map[string]any{},
+	}
+}
+
+// OnReload registers fn to be called with the new effective config after live changes are applied.
+func (w *Watcher) OnReload(fn func(c *Config)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onReload = append(w.onReload, fn)
+}
+
+func (w *Watcher) Current() *Config {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current
+}
+
+func (w *Watcher) Watch() error {
+	if w.path == "" {
+		return nil
+	}
+	w.viper.SetConfigFile(w.path)
+	if err := w.viper.ReadInConfig(); err != nil {
+		return err
+	}
+	w.viper.OnConfigChange(func(fsnotify.Event) {
+		w.Reload()
+	})
+	w.viper.WatchConfig()
+	return nil
+}
+
+func (w *Watcher) Reload() {
+	next, err := Load(w.path, w.overrides)
+	if err != nil {
+		w.logger.Errorf("config reload %s: %v", w.path, err)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	effective := *w.current
+	var applied []Change
+	rejected := map[string]any{}
+	for _, change := range Diff(w.current, next) {
+		if change.NeedRestart {
+			rejected[change.Path] = change.New
+			if prev, ok := w.rejected[change.Path]; !ok || !reflect.DeepEqual(prev, change.New) {
+				w.logger.Warnf("config reload: %s rejected, restart required to apply", change)
+			}
+			continue
+		}
+		setByPath(&effective, change.Path, change.New)
+		applied = append(applied, change)
+	}
+	w.rejected = rejected
+	if len(applied) == 0 {
+		return
+	}
+	for _, change := range applied {
+		w.logger.Infof("config reload: %s applied", change)
+	}
+
+	w.current = &effective
+	for _, fn := range w.onReload {
+		fn(w.current)
+	}
+}
+
+func walk(v reflect.Value, prefix string, fn func(path string, field reflect.StructField, value reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		path := field.Tag.Get("mapstructure")
+		if path == "" {
+			continue
+		}
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+
+		value := v.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			walk(value, path, fn)
+			continue
+		}
+		fn(path, field, value)
+	}
+}
+
+func fieldByPath(v reflect.Value, path string) reflect.Value {
+	var found reflect.Value
+	walk(v, "", func(p string, _ reflect.StructField, value reflect.Value) {
+		if p == path {
+			found = value
+		}
+	})
+
+	return found
+}
+
+func setByPath(c *Config, path string, value any) {
+	fieldByPath(reflect.ValueOf(c).Elem(), path).Set(reflect.ValueOf(value))
+}
@@ -0,0 +1,415 @@
+package config
+
+import (
+	"fmt"
+	"jokedb/intetnal/cluster"
+	"jokedb/intetnal/logger"
+	"jokedb/intetnal/notify"
+	"jokedb/intetnal/storage"
+	"jokedb/intetnal/tcp"
+	"jokedb/intetnal/wal"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	minSegmentSize          = 1024
+	maxFlushingBatchSize    = 32 * 1024
+	maxFlushingBatchTimeout = time.Minute
+	maxSlowLogLen           = 100000
+	maxDatabases            = 1024
+	maxPendingQueueSize     = 1024 * 1024
+	// the shorter requests are not framed, so they are never refused
+	minRequestSizeLimit = 1024
+	maxRequestSizeLimit = 1024 * 1024 * 1024
+)
+
+type Problem struct {
+	Path    string
+	Message string
+}
+
+// ValidationError lists every problem found in the config.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	b := strings.Builder{}
+	b.WriteString("invalid config:")
+	for _, p := range e.Problems {
+		b.WriteString("\n  " + p.Path + ": " + p.Message)
+	}
+
+	return b.String()
+}
+
+// Validate checks the ranges, durations and addresses of c. It does not touch the file system,
+// CheckDirs checks the directories at server start.
+func Validate(c *Config) error {
+	problems := &ValidationError{}
+	problems.validate(c)
+	if len(problems.Problems) > 0 {
+		return problems
+	}
+
+	return nil
+}
+
+func (e *ValidationError) add(path, message string) {
+	e.Problems = append(e.Problems, Problem{Path: path, Message: message})
+}
+
+func (e *ValidationError) validate(c *Config) {
+	if c.Engine.Type != "in_memory" {
+		e.add("engine.type", fmt.Sprintf("unsupported engine %q, want in_memory", c.Engine.Type))
+	}
+	if err := checkAddr(c.Addr); err != nil {
+		e.add("addr", err.Error())
+	}
+	if c.MaxConnections == 0 {
+		e.add("max_connections", "must be greater than 0")
+	}
+	if c.Databases < 1 || c.Databases > maxDatabases {
+		e.add("databases", fmt.Sprintf("must be between 1 and %d, got %d", maxDatabases, c.Databases))
+	}
+	if _, err := zapcore.ParseLevel(c.Log.Level); err != nil {
+		e.add("log.level", err.Error())
+	}
+	if c.Log.RotateInterval < 0 {
+		e.add("log.rotateInterval", "must not be negative")
+	}
+	if c.Log.MaxBackupAge < 0 {
+		e.add("log.maxBackupAge", "must not be negative")
+	}
+	if c.Log.AccessSampling.Initial < 0 || c.Log.AccessSampling.Thereafter < 0 {
+		e.add("log.accessSampling", "initial and thereafter must not be negative")
+	}
+	if c.SlowLog.MaxLen < 0 || c.SlowLog.MaxLen > maxSlowLogLen {
+		e.add("slowlog.maxLen", fmt.Sprintf("must be between 0 and %d, got %d", maxSlowLogLen, c.SlowLog.MaxLen))
+	}
+	if c.SlowLog.MaxArgLen < 0 {
+		e.add("slowlog.maxArgLen", "must not be negative")
+	}
+	if c.Scripting.Timeout <= 0 {
+		e.add("scripting.timeout", "must be greater than 0")
+	}
+	if c.Scripting.MaxValueSize < 1 {
+		e.add("scripting.maxValueSize", "must be greater than 0")
+	}
+	for _, event := range c.Notifications.Events {
+		switch event {
+		case notify.EventSet, notify.EventDel, notify.EventMoveFrom, notify.EventMoveTo,
+			notify.EventFlushDB, notify.EventSwapDB:
+		default:
+			e.add("notifications.events", fmt.Sprintf("unknown event %q", event))
+		}
+	}
+	if c.Notifications.BufferSize < 1 {
+		e.add("notifications.bufferSize", "must be greater than 0")
+	}
+
+	if c.Connections.IdleTimeout < 0 {
+		e.add("connections.idleTimeout", fmt.Sprintf("must not be negative, got %s", c.Connections.IdleTimeout))
+	}
+	if c.Connections.ReadTimeout < 0 {
+		e.add("connections.readTimeout", fmt.Sprintf("must not be negative, got %s", c.Connections.ReadTimeout))
+	}
+	if c.Connections.WriteTimeout < 0 {
+		e.add("connections.writeTimeout", fmt.Sprintf("must not be negative, got %s", c.Connections.WriteTimeout))
+	}
+	if _, err := tcp.ParseAdmissionPolicy(c.Connections.Admission); err != nil {
+		e.add("connections.admission", err.Error())
+	}
+	if c.Connections.QueueTimeout < 0 {
+		e.add("connections.queueTimeout", fmt.Sprintf("must not be negative, got %s", c.Connections.QueueTimeout))
+	}
+	if c.Connections.MaxRequestSize < minRequestSizeLimit || c.Connections.MaxRequestSize > maxRequestSizeLimit {
+		e.add("connections.maxRequestSize", fmt.Sprintf("must be between %d and %d, got %d",
+			minRequestSizeLimit, maxRequestSizeLimit, c.Connections.MaxRequestSize))
+	}
+
+	if c.HTTP.Enabled {
+		if err := checkAddr(c.HTTP.Addr); err != nil {
+			e.add("http.addr", err.Error())
+		}
+	}
+
+	if c.Raft.Enabled {
+		e.validateRaft(c.Raft)
+	}
+
+	if c.Cluster.Enabled {
+		e.validateCluster(c)
+	}
+
+	// the storage runs the group commit without the WAL as well
+	if c.WAL.FlushingBatchSize == 0 || c.WAL.FlushingBatchSize > maxFlushingBatchSize {
+		e.add("wal.flushingBatchSize", fmt.Sprintf("must be between 1 and %d, got %d",
+			maxFlushingBatchSize, c.WAL.FlushingBatchSize))
+	}
+	if c.WAL.FlushingBatchTimeout <= 0 || c.WAL.FlushingBatchTimeout > maxFlushingBatchTimeout {
+		e.add("wal.flushingBatchTimeout", fmt.Sprintf("must be between 1ns and %s, got %s",
+			maxFlushingBatchTimeout, c.WAL.FlushingBatchTimeout))
+	}
+	if !c.WAL.Enabled {
+		return
+	}
+	if c.WAL.DirPath == "" {
+		e.add("wal.dirPath", "must be set")
+	}
+	if c.WAL.MaxSizeSegment < minSegmentSize {
+		e.add("wal.maxSegmentSize", fmt.Sprintf("must be at least %d, got %d", minSegmentSize, c.WAL.MaxSizeSegment))
+	}
+	if _, _, err := wal.ParseSyncPolicy(c.WAL.Fsync); err != nil {
+		e.add("wal.fsync", err.Error())
+	}
+	if c.WAL.PendingQueueSize < 1 || c.WAL.PendingQueueSize > maxPendingQueueSize {
+		e.add("wal.pendingQueueSize", fmt.Sprintf("must be between 1 and %d, got %d",
+			maxPendingQueueSize, c.WAL.PendingQueueSize))
+	}
+	if _, err := storage.ParseOverloadPolicy(c.WAL.Overload); err != nil {
+		e.add("wal.overload", err.Error())
+	}
+	if c.WAL.OverloadTimeout < 0 {
+		e.add("wal.overloadTimeout", fmt.Sprintf("must not be negative, got %s", c.WAL.OverloadTimeout))
+	}
+	if c.WAL.RecoveryTargetTime != "" {
+		if _, err := time.Parse(time.RFC3339Nano, c.WAL.RecoveryTargetTime); err != nil {
+			e.add("wal.recoveryTargetTime", fmt.Sprintf("must be an RFC 3339 time, got %q", c.WAL.RecoveryTargetTime))
+		}
+	}
+}
+
+// checkUnknownKeys reports the keys of the config file that do not match a config field exactly.
+func (e *ValidationError) checkUnknownKeys(configFile string) error {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	var raw map[string]any
+	if err = yaml.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	known := map[string]bool{}
+	defaults := Default()
+	walk(reflect.ValueOf(&defaults).Elem(), "", func(path string, _ reflect.StructField, _ reflect.Value) {
+		known[path] = true
+		for i := strings.LastIndexByte(path, '.'); i > 0; i = strings.LastIndexByte(path[:i], '.') {
+			known[path[:i]] = true
+		}
+	})
+
+	e.unknownKeys(raw, "", known)
+	return nil
+}
+
+func (e *ValidationError) unknownKeys(raw map[string]any, prefix string, known map[string]bool) {
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := raw[key]
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		if !known[path] {
+			message := "unknown key"
+			for k := range known {
+				if strings.EqualFold(k, path) {
+					message = fmt.Sprintf("unknown key, did you mean %s?", k)
+				}
+			}
+			e.add(path, message)
+			continue
+		}
+
+		if child, ok := value.(map[string]any); ok {
+			e.unknownKeys(child, path, known)
+		}
+	}
+}
+
+func (e *ValidationError) validateRaft(r Raft) {
+	ids := map[string]bool{}
+	for i, node := range r.Nodes {
+		path := fmt.Sprintf("raft.nodes[%d]", i)
+		switch {
+		case node.ID == "":
+			e.add(path+".id", "must not be empty")
+		case ids[node.ID]:
+			e.add(path+".id", fmt.Sprintf("duplicate node %q", node.ID))
+		}
+		ids[node.ID] = true
+		if err := checkAddr(node.Addr); err != nil {
+			e.add(path+".addr", err.Error())
+		}
+		if node.ClientAddr != "" {
+			if err := checkAddr(node.ClientAddr); err != nil {
+				e.add(path+".clientAddr", err.Error())
+			}
+		}
+	}
+	if !ids[r.ID] {
+		e.add("raft.id", fmt.Sprintf("must be the id of one of raft.nodes, got %q", r.ID))
+	}
+	if r.ElectionTimeout <= 0 {
+		e.add("raft.electionTimeout", "must be greater than 0")
+	}
+	if r.HeartbeatInterval <= 0 || r.HeartbeatInterval >= r.ElectionTimeout {
+		e.add("raft.heartbeatInterval", fmt.Sprintf("must be greater than 0 and less than raft.electionTimeout, got %s", r.HeartbeatInterval))
+	}
+	if followers := len(r.Nodes) - 1; r.MinReplicas < 0 || r.MinReplicas > followers {
+		e.add("raft.minReplicas", fmt.Sprintf("must be between 0 and %d, the followers of raft.nodes, got %d",
+			max(followers, 0), r.MinReplicas))
+	}
+	if r.Dir == "" {
+		e.add("raft.dir", "must be set")
+	}
+}
+
+func (e *ValidationError) validateCluster(c *Config) {
+	if c.Raft.Enabled {
+		e.add("cluster.enabled", "cannot be combined with raft.enabled")
+	}
+	if c.Databases != 1 {
+		e.add("databases", fmt.Sprintf("must be 1 in cluster mode, got %d", c.Databases))
+	}
+
+	ids := map[string]bool{}
+	owners := map[int]string{}
+	for i, node := range c.Cluster.Nodes {
+		path := fmt.Sprintf("cluster.nodes[%d]", i)
+		switch {
+		case node.ID == "":
+			e.add(path+".id", "must not be empty")
+		case ids[node.ID]:
+			e.add(path+".id", fmt.Sprintf("duplicate node %q", node.ID))
+		}
+		ids[node.ID] = true
+		if err := checkAddr(node.Addr); err != nil {
+			e.add(path+".addr", err.Error())
+		}
+		for _, slot := range node.Slots {
+			r, err := cluster.ParseSlotRange(slot)
+			if err != nil {
+				e.add(path+".slots", err.Error())
+				continue
+			}
+			for s := r.From; s <= r.To; s++ {
+				if other, ok := owners[s]; ok {
+					e.add(path+".slots", fmt.Sprintf("slot %d is also served by %q", s, other))
+					break
+				}
+				owners[s] = node.ID
+			}
+		}
+	}
+	if !ids[c.Cluster.ID] {
+		e.add("cluster.id", fmt.Sprintf("must be the id of one of cluster.nodes, got %q", c.Cluster.ID))
+	}
+	if c.Cluster.StateFile == "" {
+		e.add("cluster.stateFile", "must be set")
+	}
+}
+
+// CheckDirs checks that the directories the server writes to are writable. It creates a file in
+// each of them, so the server runs it once at start rather than on every Load.
+func CheckDirs(c *Config) error {
+	problems := &ValidationError{}
+	for _, output := range []struct{ path, file string }{
+		{"log.output", c.Log.Output},
+		{"log.errorOutput", c.Log.ErrorOutput},
+		{"log.accessOutput", c.Log.AccessOutput},
+	} {
+		if output.file == "" || output.file == logger.Stderr || output.file == logger.Stdout {
+			continue
+		}
+		if err := checkWritableDir(filepath.Dir(output.file)); err != nil {
+			problems.add(output.path, err.Error())
+		}
+	}
+	// with raft the log in raft.dir replaces the WAL
+	if c.WAL.Enabled && !c.Raft.Enabled {
+		if err := checkWritableDir(c.WAL.DirPath); err != nil {
+			problems.add("wal.dirPath", err.Error())
+		}
+	}
+	if c.Raft.Enabled {
+		if err := checkWritableDir(c.Raft.Dir); err != nil {
+			problems.add("raft.dir", err.Error())
+		}
+	}
+	if c.Cluster.Enabled {
+		if err := checkWritableDir(filepath.Dir(c.Cluster.StateFile)); err != nil {
+			problems.add("cluster.stateFile", err.Error())
+		}
+	}
+	if len(problems.Problems) > 0 {
+		return problems
+	}
+
+	return nil
+}
+
+func checkAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+
+	return nil
+}
+
+// checkWritableDir checks that dir, or its nearest existing parent if dir will be created, is writable.
+func checkWritableDir(dir string) error {
+	if dir == "" {
+		return fmt.Errorf("directory is not set")
+	}
+
+	existing := dir
+	for {
+		info, err := os.Stat(existing)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", existing)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return err
+		}
+		existing = parent
+	}
+
+	f, err := os.CreateTemp(existing, ".jokedb-write-check-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable", existing)
+	}
+	_ = f.Close()
+
+	return os.Remove(f.Name())
+}
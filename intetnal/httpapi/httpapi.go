@@ -0,0 +1,368 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jokedb/intetnal/app"
+	"jokedb/intetnal/cluster"
+	"jokedb/intetnal/compute/parser"
+	"jokedb/intetnal/raft"
+	"jokedb/intetnal/reply"
+	"jokedb/intetnal/storage"
+	"jokedb/intetnal/storage/engine"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	keysPath  = "/v1/keys"
+	batchPath = "/v1/batch"
+
+	maxBodySize  = 1 << 20
+	maxBatchOps  = 1000
+	defaultLimit = 1000
+)
+
+// Executor runs a query the way the TCP server does.
+type Executor interface {
+	Do(ctx context.Context, c string) (reply.Reply, error)
+}
+
+type Logger interface {
+	Errorf(template string, args ...interface{})
+}
+
+// Gateway serves the keys over HTTP/JSON, every request is turned into the
+// commands of the TCP protocol and run by the Executor:
+//
+//	GET    /v1/keys/{key}            {"key": "k", "value": "v"}, 404 for a missing key
+//	PUT    /v1/keys/{key}            body {"value": "v"}, 204
+//	DELETE /v1/keys/{key}            204
+//	GET    /v1/keys?prefix=p&limit=n {"keys": [...], "truncated": false}
+//	POST   /v1/batch                 body {"ops": [{"op": "get|put|delete", "key": "k", "value": "v"}]}
+//
+// A truncated list has a "cursor", the query parameter cursor continues it with the next keys.
+// The query parameter db selects the database, durability sets the durability of the writes.
+// The batch takes both in its body, its ops run in order and not atomically.
+type Gateway struct {
+	exec   Executor
+	logger Logger
+}
+
+func New(exec Executor, logger Logger) *Gateway {
+	return &Gateway{exec: exec, logger: logger}
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type keyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type putRequest struct {
+	Value string `json:"value"`
+}
+
+type listResponse struct {
+	Keys      []string `json:"keys"`
+	Truncated bool     `json:"truncated"`
+	// Cursor continues a truncated list.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+type batchRequest struct {
+	DB         int       `json:"db"`
+	Durability string    `json:"durability"`
+	Ops        []batchOp `json:"ops"`
+}
+
+type batchOp struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type batchResult struct {
+	Status int    `json:"status"`
+	Value  string `json:"value,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+// httpError is an error of the request itself, answered with its status.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...any) error {
+	return &httpError{status: http.StatusBadRequest, msg: fmt.Sprintf(format, args...)}
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == keysPath:
+		g.route(w, r, map[string]http.HandlerFunc{http.MethodGet: g.list})
+	case strings.HasPrefix(r.URL.Path, keysPath+"/"):
+		g.route(w, r, map[string]http.HandlerFunc{
+			http.MethodGet:    g.get,
+			http.MethodPut:    g.put,
+			http.MethodDelete: g.del,
+		})
+	case r.URL.Path == batchPath:
+		g.route(w, r, map[string]http.HandlerFunc{http.MethodPost: g.batch})
+	default:
+		g.writeJSON(w, http.StatusNotFound, errorResponse{Error: "not found"})
+	}
+}
+
+func (g *Gateway) route(w http.ResponseWriter, r *http.Request, handlers map[string]http.HandlerFunc) {
+	if h, ok := handlers[r.Method]; ok {
+		h(w, r)
+		return
+	}
+
+	allowed := make([]string, 0, len(handlers))
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete, http.MethodPost} {
+		if _, ok := handlers[method]; ok {
+			allowed = append(allowed, method)
+		}
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	g.writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+}
+
+func (g *Gateway) get(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, keysPath+"/")
+	value, err := g.run(r, "GET", key)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	g.writeJSON(w, http.StatusOK, keyValue{Key: key, Value: value.Text})
+}
+
+func (g *Gateway) put(w http.ResponseWriter, r *http.Request) {
+	var req putRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&req); err != nil {
+		g.writeError(w, badRequest("invalid body: %v", err))
+		return
+	}
+
+	if _, err := g.run(r, "SET", strings.TrimPrefix(r.URL.Path, keysPath+"/"), req.Value); err != nil {
+		g.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *Gateway) del(w http.ResponseWriter, r *http.Request) {
+	if _, err := g.run(r, "DEL", strings.TrimPrefix(r.URL.Path, keysPath+"/")); err != nil {
+		g.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// list scans the keys with SCAN, so a request reads at most limit keys. The cursor of a truncated
+// list continues it, the prefix of its first request is kept.
+func (g *Gateway) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultLimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			g.writeError(w, badRequest("limit must be a positive integer, got %s", s))
+			return
+		}
+		limit = n
+	}
+	cursor := "0"
+	if s := query.Get("cursor"); s != "" {
+		if _, err := strconv.ParseUint(s, 10, 64); err != nil {
+			g.writeError(w, badRequest("cursor must be a non-negative integer, got %s", s))
+			return
+		}
+		cursor = s
+	}
+
+	result, err := g.run(r, "SCAN", cursor, "MATCH", prefixPattern(query.Get("prefix")), "COUNT", strconv.Itoa(limit))
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	resp := listResponse{Keys: make([]string, 0, len(result.Array))}
+	for _, item := range result.Array[1:] {
+		resp.Keys = append(resp.Keys, item.Text)
+	}
+	if next := result.Array[0].Int; next != 0 {
+		resp.Cursor, resp.Truncated = strconv.FormatInt(next, 10), true
+	}
+	g.writeJSON(w, http.StatusOK, resp)
+}
+
+// prefixPattern returns the pattern of the keys starting with prefix, its pattern symbols are escaped.
+func prefixPattern(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(prefix) + "*"
+}
+
+func (g *Gateway) batch(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&req); err != nil {
+		g.writeError(w, badRequest("invalid body: %v", err))
+		return
+	}
+	if len(req.Ops) > maxBatchOps {
+		g.writeError(w, badRequest("at most %d ops in a batch, got %d", maxBatchOps, len(req.Ops)))
+		return
+	}
+
+	ctx, err := g.session(r.Context(), req.DB)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+
+	resp := batchResponse{Results: make([]batchResult, 0, len(req.Ops))}
+	for _, op := range req.Ops {
+		var value reply.Reply
+		switch op.Op {
+		case "get":
+			value, err = g.exec1(ctx, "", "GET", op.Key)
+		case "put":
+			_, err = g.exec1(ctx, req.Durability, "SET", op.Key, op.Value)
+		case "delete":
+			_, err = g.exec1(ctx, req.Durability, "DEL", op.Key)
+		default:
+			err = badRequest("unknown op %q, want get, put or delete", op.Op)
+		}
+
+		if err != nil {
+			resp.Results = append(resp.Results, batchResult{Status: g.status(err), Error: err.Error()})
+			continue
+		}
+		status := http.StatusNoContent
+		if op.Op == "get" {
+			status = http.StatusOK
+		}
+		resp.Results = append(resp.Results, batchResult{Status: status, Value: value.Text})
+	}
+
+	g.writeJSON(w, http.StatusOK, resp)
+}
+
+// run executes one command in the database and with the durability of the query parameters.
+func (g *Gateway) run(r *http.Request, command string, args ...string) (reply.Reply, error) {
+	db := 0
+	if s := r.URL.Query().Get("db"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return reply.Reply{}, badRequest("db must be a non-negative integer, got %s", s)
+		}
+		db = n
+	}
+
+	ctx, err := g.session(r.Context(), db)
+	if err != nil {
+		return reply.Reply{}, err
+	}
+
+	var durability string
+	if command != "GET" && command != "SCAN" {
+		durability = r.URL.Query().Get("durability")
+	}
+
+	return g.exec1(ctx, durability, command, args...)
+}
+
+// session returns a context with a session selecting db, a list cursor continues in the next requests.
+func (g *Gateway) session(ctx context.Context, db int) (context.Context, error) {
+	ctx = app.NewSharedSessionContext(ctx, g)
+	if db == 0 {
+		return ctx, nil
+	}
+	if _, err := g.exec.Do(ctx, "SELECT "+strconv.Itoa(db)); err != nil {
+		return nil, err
+	}
+
+	return ctx, nil
+}
+
+// exec1 builds the command from the arguments, each is quoted so it is stored as it is.
+func (g *Gateway) exec1(ctx context.Context, durability, command string, args ...string) (reply.Reply, error) {
+	tokens := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" {
+			return reply.Reply{}, badRequest("keys and values must be non-empty")
+		}
+		tokens[i] = parser.Quote(arg)
+	}
+
+	query := command + " " + strings.Join(tokens, " ")
+	if durability != "" {
+		query = "DURABILITY " + durability + " " + query
+	}
+
+	return g.exec.Do(ctx, query)
+}
+
+func (g *Gateway) status(err error) int {
+	var httpErr *httpError
+	var queryErr *app.QueryError
+	var notLeader *raft.NotLeaderError
+	var redirect *cluster.RedirectError
+	var down *cluster.DownError
+	switch {
+	case errors.As(err, &httpErr):
+		return httpErr.status
+	case errors.Is(err, engine.ErrNoKey):
+		return http.StatusNotFound
+	case errors.As(err, &queryErr), errors.Is(err, storage.ErrInvalidDB), errors.Is(err, storage.ErrNoCursor):
+		return http.StatusBadRequest
+	case errors.As(err, &notLeader), errors.As(err, &redirect):
+		return http.StatusMisdirectedRequest
+	case errors.As(err, &down):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, storage.ErrBusy), errors.Is(err, storage.ErrNoReplication):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (g *Gateway) writeError(w http.ResponseWriter, err error) {
+	status := g.status(err)
+	if status >= http.StatusInternalServerError {
+		g.logger.Errorf("http: %v", err)
+	}
+	if errors.Is(err, storage.ErrBusy) {
+		w.Header().Set("Retry-After", "1")
+	}
+
+	g.writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func (g *Gateway) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		g.logger.Errorf("http: write response: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"io"
+	"jokedb/intetnal/app"
+	"jokedb/intetnal/compute"
+	"jokedb/intetnal/httpapi"
+	"jokedb/intetnal/storage"
+	"jokedb/intetnal/storage/engine"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	s, err := storage.New(engine.New(), nil, 1, time.Millisecond, storage.WithDatabases(2))
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+
+	srv := httptest.NewServer(httpapi.New(app.New(compute.New(), s), zap.NewNop().Sugar()))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func do(t *testing.T, method, url, body string) (int, map[string]any) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	var out map[string]any
+	if len(data) > 0 {
+		require.NoError(t, json.Unmarshal(data, &out))
+	}
+
+	return resp.StatusCode, out
+}
+
+func TestGateway_Keys(t *testing.T) {
+	t.Parallel()
+	srv := newServer(t)
+
+	status, _ := do(t, http.MethodPut, srv.URL+"/v1/keys/user:1", `{"value":"alice"}`)
+	require.Equal(t, http.StatusNoContent, status)
+	status, _ = do(t, http.MethodPut, srv.URL+"/v1/keys/user:2?durability=async", `{"value":"bob"}`)
+	require.Equal(t, http.StatusNoContent, status)
+
+	status, body := do(t, http.MethodGet, srv.URL+"/v1/keys/user:1", "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, map[string]any{"key": "user:1", "value": "alice"}, body)
+
+	status, body = do(t, http.MethodGet, srv.URL+"/v1/keys?prefix=user:&limit=1", "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, []any{"user:1"}, body["keys"])
+	require.Equal(t, true, body["truncated"])
+	status, body = do(t, http.MethodGet, srv.URL+"/v1/keys?limit=1&cursor="+body["cursor"].(string), "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, map[string]any{"keys": []any{"user:2"}, "truncated": false}, body)
+	status, _ = do(t, http.MethodGet, srv.URL+"/v1/keys?cursor=12345", "")
+	require.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = do(t, http.MethodDelete, srv.URL+"/v1/keys/user:1", "")
+	require.Equal(t, http.StatusNoContent, status)
+	status, body = do(t, http.MethodGet, srv.URL+"/v1/keys/user:1", "")
+	require.Equal(t, http.StatusNotFound, status)
+	require.NotEmpty(t, body["error"])
+
+	status, _ = do(t, http.MethodGet, srv.URL+"/v1/keys/user:2?db=1", "")
+	require.Equal(t, http.StatusNotFound, status)
+	status, body = do(t, http.MethodGet, srv.URL+"/v1/keys?prefix=nothing", "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, []any{}, body["keys"])
+}
+
+func TestGateway_Quoting(t *testing.T) {
+	t.Parallel()
+	srv := newServer(t)
+
+	values := []string{`a"b"`, `back\slash\`, "with spaces", `"`, `\`, "line\nbreak", `k"e y\`}
+	for _, value := range values {
+		data, err := json.Marshal(map[string]string{"value": value})
+		require.NoError(t, err)
+		status, _ := do(t, http.MethodPut, srv.URL+"/v1/keys/k", string(data))
+		require.Equal(t, http.StatusNoContent, status, value)
+		status, body := do(t, http.MethodGet, srv.URL+"/v1/keys/k", "")
+		require.Equal(t, http.StatusOK, status, value)
+		require.Equal(t, value, body["value"])
+	}
+
+	// the key is quoted as well
+	status, _ := do(t, http.MethodPut, srv.URL+"/v1/keys/a%22b%20c%5C", `{"value":"v"}`)
+	require.Equal(t, http.StatusNoContent, status)
+	status, body := do(t, http.MethodGet, srv.URL+"/v1/keys/a%22b%20c%5C", "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, map[string]any{"key": `a"b c\`, "value": "v"}, body)
+}
+
+func TestGateway_Errors(t *testing.T) {
+	t.Parallel()
+	srv := newServer(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"empty_value", http.MethodPut, "/v1/keys/a", `{}`, http.StatusBadRequest},
+		{"invalid_json", http.MethodPut, "/v1/keys/a", `{`, http.StatusBadRequest},
+		{"unknown_db", http.MethodGet, "/v1/keys/a?db=7", "", http.StatusBadRequest},
+		{"invalid_durability", http.MethodPut, "/v1/keys/a?durability=bogus", `{"value":"1"}`, http.StatusBadRequest},
+		{"invalid_limit", http.MethodGet, "/v1/keys?limit=0", "", http.StatusBadRequest},
+		{"method", http.MethodPost, "/v1/keys/a", "", http.StatusMethodNotAllowed},
+		{"unknown_path", http.MethodGet, "/v2/keys", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := do(t, tt.method, srv.URL+tt.path, tt.body)
+			require.Equal(t, tt.status, status)
+			require.NotEmpty(t, body["error"])
+		})
+	}
+}
+
+func TestGateway_Batch(t *testing.T) {
+	t.Parallel()
+	srv := newServer(t)
+
+	status, body := do(t, http.MethodPost, srv.URL+"/v1/batch", `{"db":1,"ops":[
+		{"op":"put","key":"a","value":"1"},
+		{"op":"get","key":"a"},
+		{"op":"delete","key":"a"},
+		{"op":"get","key":"a"},
+		{"op":"incr","key":"a"}
+	]}`)
+	require.Equal(t, http.StatusOK, status)
+
+	results := body["results"].([]any)
+	require.Len(t, results, 5)
+	statuses := make([]float64, 0, len(results))
+	for _, r := range results {
+		statuses = append(statuses, r.(map[string]any)["status"].(float64))
+	}
+	require.Equal(t, []float64{204, 200, 204, 404, 400}, statuses)
+	require.Equal(t, "1", results[1].(map[string]any)["value"])
+
+	status, _ = do(t, http.MethodGet, srv.URL+"/v1/keys/a", "")
+	require.Equal(t, http.StatusNotFound, status)
+}
+
+func TestGateway_ListPrefix(t *testing.T) {
+	t.Parallel()
+	srv := newServer(t)
+
+	for _, key := range []string{"a?b", "axb", "[x]1", "x1", `a\b`, "a*b", "a*c"} {
+		status, _ := do(t, http.MethodPut, srv.URL+"/v1/keys/"+url.PathEscape(key), `{"value":"v"}`)
+		require.Equal(t, http.StatusNoContent, status, key)
+	}
+
+	// the pattern symbols of a prefix are matched as they are
+	for prefix, want := range map[string][]any{
+		"a?":   {"a?b"},
+		"[x]":  {"[x]1"},
+		"[":    {"[x]1"},
+		`a\`:   {`a\b`},
+		"a*":   {"a*b", "a*c"},
+		"a*b":  {"a*b"},
+		"none": {},
+	} {
+		status, body := do(t, http.MethodGet, srv.URL+"/v1/keys?prefix="+url.QueryEscape(prefix), "")
+		require.Equal(t, http.StatusOK, status, prefix)
+		require.Equal(t, want, body["keys"], prefix)
+	}
+}
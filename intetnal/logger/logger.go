@@ -1,44 +1,159 @@
 package logger
 
 import (
+	"errors"
+	"os"
+	"time"
+
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+const (
+	Stderr = "stderr"
+	Stdout = "stdout"
+)
+
 //nolint:gochecknoglobals //todo
-var globalSugaredLogger *zap.SugaredLogger
+var (
+	globalSugaredLogger *zap.SugaredLogger
+	globalAccessLogger  *zap.SugaredLogger
+	globalLevel         zap.AtomicLevel
+	globalFiles         []*RotatingFile
+)
 
-func Init(devMode bool, name, level string) error {
-	var config zap.Config
-	levelEncoder := zapcore.CapitalLevelEncoder
+type Options struct {
+	Level string
+	// Output is the main log, empty or "stderr" logs to stderr.
+	Output string
+	// ErrorOutput additionally receives the error level entries when set.
+	ErrorOutput string
+	// AccessOutput receives the access log, the main log is used when empty.
+	AccessOutput string
+	Rotate       RotateOptions
+	// AccessSampling keeps the first Initial entries with the same message
+	// per second and then every Thereafter-th, 0 disables sampling.
+	AccessSampling Sampling
+}
+
+type Sampling struct {
+	Initial    int
+	Thereafter int
+}
+
+func Init(devMode bool, name string, opts Options) error {
+	var encoderConfig zapcore.EncoderConfig
+	var options []zap.Option
 	switch devMode {
 	case true:
-		config = zap.NewDevelopmentConfig()
-		levelEncoder = zapcore.CapitalColorLevelEncoder
+		encoderConfig = zap.NewDevelopmentEncoderConfig()
+		options = append(options, zap.Development(), zap.AddStacktrace(zap.WarnLevel))
 	default:
-		config = zap.NewProductionConfig()
+		encoderConfig = zap.NewProductionEncoderConfig()
+		options = append(options, zap.AddStacktrace(zap.ErrorLevel))
 	}
+	encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
 
-	config.EncoderConfig.EncodeLevel = levelEncoder
-	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-
-	err := config.Level.UnmarshalText([]byte(level))
-	if err != nil || len(level) == 0 {
-		config.Level.SetLevel(zap.DebugLevel)
+	level := zap.NewAtomicLevel()
+	err := level.UnmarshalText([]byte(opts.Level))
+	if err != nil || len(opts.Level) == 0 {
+		level.SetLevel(zap.DebugLevel)
 	}
 
-	config.Sampling = nil
-	logger, err := config.Build()
+	closeFiles()
+	sink, err := openSink(opts.Output, opts.Rotate)
 	if err != nil {
 		return err
 	}
+	cores := []zapcore.Core{zapcore.NewCore(newEncoder(devMode, opts.Output, encoderConfig), sink, level)}
+	if opts.ErrorOutput != "" {
+		errSink, errOpen := openSink(opts.ErrorOutput, opts.Rotate)
+		if errOpen != nil {
+			return errOpen
+		}
+		errLevel := zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+			return l >= zapcore.ErrorLevel && level.Enabled(l)
+		})
+		cores = append(cores, zapcore.NewCore(newEncoder(devMode, opts.ErrorOutput, encoderConfig), errSink, errLevel))
+	}
+
+	accessCore := cores[0]
+	if opts.AccessOutput != "" {
+		accessSink, errOpen := openSink(opts.AccessOutput, opts.Rotate)
+		if errOpen != nil {
+			return errOpen
+		}
+		accessCore = zapcore.NewCore(newEncoder(devMode, opts.AccessOutput, encoderConfig), accessSink, zap.InfoLevel)
+	}
+	if opts.AccessSampling.Initial > 0 || opts.AccessSampling.Thereafter > 0 {
+		accessCore = zapcore.NewSamplerWithOptions(accessCore, time.Second,
+			opts.AccessSampling.Initial, opts.AccessSampling.Thereafter)
+	}
+
+	logger := zap.New(zapcore.NewTee(cores...), append(options, zap.AddCaller())...)
 	logger.Info("Start service",
 		zap.String("name", name),
-		zap.String("service_loglevel", level))
+		zap.String("service_loglevel", opts.Level))
 	globalSugaredLogger = logger.Sugar()
+	globalAccessLogger = zap.New(accessCore).Named("access").Sugar()
+	globalLevel = level
 	return nil
 }
 
+// SetLevel changes the level of the global logger at runtime.
+func SetLevel(level string) error {
+	return globalLevel.UnmarshalText([]byte(level))
+}
+
+// Reopen reopens the log files, it is called on SIGHUP after an external logrotate.
+func Reopen() error {
+	var errs []error
+	for _, f := range globalFiles {
+		errs = append(errs, f.Reopen())
+	}
+
+	return errors.Join(errs...)
+}
+
 func L() *zap.SugaredLogger {
 	return globalSugaredLogger
 }
+
+// Access returns the sampled logger of the executed commands.
+func Access() *zap.SugaredLogger {
+	return globalAccessLogger
+}
+
+func openSink(output string, opts RotateOptions) (zapcore.WriteSyncer, error) {
+	switch output {
+	case "", Stderr:
+		return zapcore.Lock(os.Stderr), nil
+	case Stdout:
+		return zapcore.Lock(os.Stdout), nil
+	}
+
+	f, err := OpenRotatingFile(output, opts)
+	if err != nil {
+		return nil, err
+	}
+	globalFiles = append(globalFiles, f)
+	return f, nil
+}
+
+func newEncoder(devMode bool, output string, config zapcore.EncoderConfig) zapcore.Encoder {
+	if !devMode {
+		return zapcore.NewJSONEncoder(config)
+	}
+	if output == "" || output == Stderr || output == Stdout {
+		config.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	}
+	return zapcore.NewConsoleEncoder(config)
+}
+
+func closeFiles() {
+	for _, f := range globalFiles {
+		_ = f.Close()
+	}
+	globalFiles = nil
+}
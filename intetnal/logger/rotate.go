@@ -0,0 +1,224 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	megabyte        = 1024 * 1024
+	backupTimestamp = "2006-01-02T15-04-05.000"
+	compressSuffix  = ".gz"
+)
+
+type RotateOptions struct {
+	// MaxSize is the size in megabytes after which the file is rotated, 0 disables it.
+	MaxSize uint
+	// RotateInterval is the age after which the file is rotated, 0 disables it.
+	RotateInterval time.Duration
+	// MaxBackups is the number of rotated files to keep, 0 keeps all of them.
+	MaxBackups uint
+	// MaxBackupAge is how long rotated files are kept, 0 keeps them forever.
+	MaxBackupAge time.Duration
+	Compress     bool
+}
+
+// RotatingFile is a zapcore.WriteSyncer that appends to a file and rotates it by size and age.
+type RotatingFile struct {
+	path     string
+	opts     RotateOptions
+	mu       sync.Mutex
+	fd       *os.File
+	size     int64
+	openedAt time.Time
+	cleanup  sync.Mutex
+}
+
+func OpenRotatingFile(path string, opts RotateOptions) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path: path,
+		opts: opts,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.needRotate(len(p)) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.fd.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fd.Sync()
+}
+
+// Reopen closes and reopens the file by path, so the file renamed by an external logrotate is released.
+func (f *RotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.fd.Close(); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fd.Close()
+}
+
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), os.ModePerm); err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return err
+	}
+
+	f.fd = fd
+	f.size = info.Size()
+	f.openedAt = time.Now()
+	return nil
+}
+
+func (f *RotatingFile) needRotate(n int) bool {
+	if f.opts.MaxSize > 0 && f.size > 0 && f.size+int64(n) > int64(f.opts.MaxSize)*megabyte {
+		return true
+	}
+
+	return f.opts.RotateInterval > 0 && time.Since(f.openedAt) >= f.opts.RotateInterval
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.fd.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.path, f.backupName(time.Now())); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	go f.postRotate()
+	return nil
+}
+
+func (f *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(f.path)
+	return strings.TrimSuffix(f.path, ext) + "-" + t.Format(backupTimestamp) + ext
+}
+
+// postRotate compresses the rotated files and removes the ones over MaxBackups or MaxBackupAge.
+func (f *RotatingFile) postRotate() {
+	f.cleanup.Lock()
+	defer f.cleanup.Unlock()
+
+	backups := f.backups()
+	for i, name := range backups {
+		expired := f.opts.MaxBackups > 0 && uint(i) >= f.opts.MaxBackups
+		if info, err := os.Stat(name); err == nil && f.opts.MaxBackupAge > 0 {
+			expired = expired || time.Since(info.ModTime()) > f.opts.MaxBackupAge
+		}
+
+		switch {
+		case expired:
+			_ = os.Remove(name)
+		case f.opts.Compress && !strings.HasSuffix(name, compressSuffix):
+			_ = compress(name)
+		}
+	}
+}
+
+// backups returns the rotated files, newest first.
+func (f *RotatingFile) backups() []string {
+	ext := filepath.Ext(f.path)
+	prefix := filepath.Base(strings.TrimSuffix(f.path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !isBackup(name, prefix, ext) {
+			continue
+		}
+		backups = append(backups, filepath.Join(filepath.Dir(f.path), name))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	return backups
+}
+
+// isBackup reports whether name is prefix, a backup timestamp and ext, optionally
+// compressed, so a live sibling such as app-access.log is never taken for a backup.
+func isBackup(name, prefix, ext string) bool {
+	rest, ok := strings.CutPrefix(name, prefix)
+	if !ok {
+		return false
+	}
+	rest = strings.TrimSuffix(rest, compressSuffix)
+	if rest, ok = strings.CutSuffix(rest, ext); !ok {
+		return false
+	}
+	_, err := time.Parse(backupTimestamp, rest)
+	return err == nil
+}
+
+func compress(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+compressSuffix, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(name)
+}
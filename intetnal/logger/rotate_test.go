@@ -0,0 +1,93 @@
+package logger_test
+
+import (
+	"jokedb/intetnal/logger"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile(t *testing.T) {
+	t.Run("rotate_by_size", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "app.log")
+		f, err := logger.OpenRotatingFile(path, logger.RotateOptions{MaxSize: 1, MaxBackups: 2, Compress: true})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = f.Close() })
+
+		line := []byte(strings.Repeat("x", 512*1024))
+		for i := 0; i < 8; i++ {
+			_, err = f.Write(line)
+			require.NoError(t, err)
+			time.Sleep(2 * time.Millisecond)
+		}
+
+		require.Eventually(t, func() bool {
+			matches, _ := filepath.Glob(filepath.Join(dir, "app-*.log.gz"))
+			plain, _ := filepath.Glob(filepath.Join(dir, "app-*.log"))
+			return len(matches) == 2 && len(plain) == 0
+		}, time.Second, 10*time.Millisecond)
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		require.LessOrEqual(t, info.Size(), int64(1024*1024))
+	})
+
+	t.Run("live_sibling", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "app.log")
+		sibling := filepath.Join(dir, "app-access.log")
+		require.NoError(t, os.WriteFile(sibling, []byte("access\n"), 0644))
+		old := time.Now().Add(-48 * time.Hour)
+		require.NoError(t, os.Chtimes(sibling, old, old))
+
+		f, err := logger.OpenRotatingFile(path, logger.RotateOptions{MaxSize: 1, MaxBackups: 1, MaxBackupAge: time.Hour, Compress: true})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = f.Close() })
+
+		line := []byte(strings.Repeat("x", 512*1024))
+		for i := 0; i < 6; i++ {
+			_, err = f.Write(line)
+			require.NoError(t, err)
+			time.Sleep(2 * time.Millisecond)
+		}
+
+		require.Eventually(t, func() bool {
+			matches, _ := filepath.Glob(filepath.Join(dir, "app-*T*.log.gz"))
+			plain, _ := filepath.Glob(filepath.Join(dir, "app-*T*.log"))
+			return len(matches) == 1 && len(plain) == 0
+		}, time.Second, 10*time.Millisecond)
+
+		data, err := os.ReadFile(sibling)
+		require.NoError(t, err)
+		require.Equal(t, "access\n", string(data))
+		_, err = os.Stat(sibling + ".gz")
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("reopen", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "app.log")
+		f, err := logger.OpenRotatingFile(path, logger.RotateOptions{})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = f.Close() })
+
+		_, err = f.Write([]byte("before\n"))
+		require.NoError(t, err)
+		require.NoError(t, os.Rename(path, path+".1"))
+		require.NoError(t, f.Reopen())
+		_, err = f.Write([]byte("after\n"))
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, "after\n", string(data))
+		data, err = os.ReadFile(path + ".1")
+		require.NoError(t, err)
+		require.Equal(t, "before\n", string(data))
+	})
+}
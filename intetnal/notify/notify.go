@@ -0,0 +1,203 @@
+package notify
+
+import (
+	"path"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	EventSet      = "set"
+	EventDel      = "del"
+	EventMoveFrom = "move_from"
+	EventMoveTo   = "move_to"
+	EventFlushDB  = "flushdb"
+	EventSwapDB   = "swapdb"
+)
+
+type Message struct {
+	Channel string
+	Payload string
+}
+
+type Options struct {
+	Keyspace bool
+	Keyevent bool
+	// Events are the emitted event names, the others are dropped before publishing.
+	Events []string
+}
+
+// Hub delivers keyspace notifications to the subscribers. Publishing never
+// blocks: a message is dropped for a subscriber whose buffer is full.
+type Hub struct {
+	mu          sync.RWMutex
+	opts        Options
+	events      map[string]bool
+	subscribers map[*Subscriber]struct{}
+}
+
+func NewHub(opts Options) *Hub {
+	h := &Hub{
+		subscribers: map[*Subscriber]struct{}{},
+	}
+	h.Configure(opts)
+
+	return h
+}
+
+func (h *Hub) Configure(opts Options) {
+	events := make(map[string]bool, len(opts.Events))
+	for _, e := range opts.Events {
+		events[e] = true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.opts = opts
+	h.events = events
+}
+
+// Notify publishes __keyspace@<db>__:<key> with the event as payload and
+// __keyevent@<db>__:<event> with the key as payload.
+func (h *Hub) Notify(db int, event, key string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if !h.events[event] {
+		return
+	}
+	prefix := "@" + strconv.Itoa(db) + "__:"
+	if h.opts.Keyspace && key != "" {
+		h.publish(Message{Channel: "__keyspace" + prefix + key, Payload: event})
+	}
+	if h.opts.Keyevent {
+		h.publish(Message{Channel: "__keyevent" + prefix + event, Payload: key})
+	}
+}
+
+func (h *Hub) publish(msg Message) {
+	for s := range h.subscribers {
+		if !s.matches(msg.Channel) {
+			continue
+		}
+		select {
+		case s.c <- msg:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribers returns the number of the open subscribers.
+func (h *Hub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers)
+}
+
+func (h *Hub) Subscribe(bufferSize int) *Subscriber {
+	s := &Subscriber{
+		hub:      h,
+		c:        make(chan Message, bufferSize),
+		channels: map[string]bool{},
+		patterns: map[string]bool{},
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[s] = struct{}{}
+
+	return s
+}
+
+type Subscriber struct {
+	hub      *Hub
+	c        chan Message
+	mu       sync.RWMutex
+	channels map[string]bool
+	patterns map[string]bool
+	dropped  atomic.Uint64
+	closed   bool
+}
+
+// C returns the delivered messages, it is closed by Close.
+func (s *Subscriber) C() <-chan Message {
+	return s.c
+}
+
+// Dropped returns the number of messages lost because the buffer was full.
+func (s *Subscriber) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Subscribe adds exact channels and returns the number of subscriptions.
+func (s *Subscriber) Subscribe(channels ...string) int {
+	return s.add(false, channels)
+}
+
+// PSubscribe adds glob patterns of channels and returns the number of subscriptions.
+func (s *Subscriber) PSubscribe(patterns ...string) int {
+	return s.add(true, patterns)
+}
+
+// Unsubscribe removes channels and patterns, all of them when none are given,
+// and returns the number of remaining subscriptions.
+func (s *Subscriber) Unsubscribe(names ...string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(names) == 0 {
+		s.channels = map[string]bool{}
+		s.patterns = map[string]bool{}
+	}
+	for _, name := range names {
+		delete(s.channels, name)
+		delete(s.patterns, name)
+	}
+
+	return len(s.channels) + len(s.patterns)
+}
+
+func (s *Subscriber) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(s.hub.subscribers, s)
+	close(s.c)
+}
+
+func (s *Subscriber) add(pattern bool, names []string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	set := s.channels
+	if pattern {
+		set = s.patterns
+	}
+	for _, name := range names {
+		set[name] = true
+	}
+
+	return len(s.channels) + len(s.patterns)
+}
+
+func (s *Subscriber) matches(channel string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.channels[channel] {
+		return true
+	}
+	for pattern := range s.patterns {
+		if ok, _ := path.Match(pattern, channel); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,54 @@
+package notify_test
+
+import (
+	"jokedb/intetnal/notify"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub(t *testing.T) {
+	t.Run("keyspace_and_keyevent", func(t *testing.T) {
+		h := notify.NewHub(notify.Options{Keyspace: true, Keyevent: true, Events: []string{notify.EventSet}})
+		sub := h.Subscribe(4)
+		t.Cleanup(sub.Close)
+		require.Equal(t, 1, sub.Subscribe("__keyevent@0__:set"))
+		require.Equal(t, 2, sub.PSubscribe("__keyspace@0__:user*"))
+
+		h.Notify(0, notify.EventSet, "user1")
+		h.Notify(0, notify.EventDel, "user1")
+		h.Notify(1, notify.EventSet, "user1")
+
+		require.Equal(t, notify.Message{Channel: "__keyspace@0__:user1", Payload: "set"}, <-sub.C())
+		require.Equal(t, notify.Message{Channel: "__keyevent@0__:set", Payload: "user1"}, <-sub.C())
+		require.Empty(t, sub.C())
+	})
+
+	t.Run("non_blocking", func(t *testing.T) {
+		h := notify.NewHub(notify.Options{Keyevent: true, Events: []string{notify.EventSet}})
+		sub := h.Subscribe(1)
+		t.Cleanup(sub.Close)
+		sub.PSubscribe("*")
+
+		for i := 0; i < 3; i++ {
+			h.Notify(0, notify.EventSet, "key")
+		}
+		require.Len(t, sub.C(), 1)
+		require.Equal(t, uint64(2), sub.Dropped())
+	})
+
+	t.Run("unsubscribe_and_close", func(t *testing.T) {
+		h := notify.NewHub(notify.Options{Keyevent: true, Events: []string{notify.EventDel}})
+		sub := h.Subscribe(1)
+		sub.Subscribe("__keyevent@0__:del")
+		require.Equal(t, 0, sub.Unsubscribe())
+
+		h.Notify(0, notify.EventDel, "key")
+		require.Empty(t, sub.C())
+
+		sub.Close()
+		sub.Close()
+		_, ok := <-sub.C()
+		require.False(t, ok)
+	})
+}
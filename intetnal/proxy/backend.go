@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jokedb/intetnal/reply"
+	"jokedb/intetnal/tcp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errBackendClosed = errors.New("backend is removed from the ring")
+
+// backend is a server of the ring with a pool of idle connections.
+type backend struct {
+	addr    string
+	logger  tcp.Logger
+	healthy atomic.Bool
+
+	mu     sync.Mutex
+	idle   []*backendConn
+	closed bool
+}
+
+// backendConn is a connection to a backend and the database it has selected.
+type backendConn struct {
+	*tcp.Client
+	db int
+}
+
+func newBackend(addr string, logger tcp.Logger) *backend {
+	b := &backend{addr: addr, logger: logger}
+	// a new backend is used until the first health check says otherwise
+	b.healthy.Store(true)
+	return b
+}
+
+// do sends q to the backend on the database db, the connection selects it first if needed. An error
+// reply of the backend is returned as the reply, the error is a failure of the connection.
+func (b *backend) do(ctx context.Context, db int, q string, timeout time.Duration, maxIdle int) (reply.Reply, error) {
+	c, err := b.get()
+	if err != nil {
+		return reply.Reply{}, err
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err = c.SetDeadline(deadline); err != nil {
+		c.Close()
+		return reply.Reply{}, err
+	}
+
+	if c.db != db {
+		r, errSelect := c.do("SELECT " + strconv.Itoa(db))
+		if errSelect != nil {
+			c.Close()
+			return reply.Reply{}, errSelect
+		}
+		if r.Text != "SELECT ok" {
+			b.put(c, maxIdle)
+			return r, nil
+		}
+		c.db = db
+	}
+	r, err := c.do(q)
+	if err != nil {
+		c.Close()
+		return reply.Reply{}, err
+	}
+	b.put(c, maxIdle)
+
+	return r, nil
+}
+
+// do sends q and keeps an error reply as the reply.
+func (c *backendConn) do(q string) (reply.Reply, error) {
+	r, err := c.Do(q)
+	var replyErr *reply.Error
+	if errors.As(err, &replyErr) {
+		return r, nil
+	}
+	return r, err
+}
+
+func (b *backend) get() (*backendConn, error) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil, errBackendClosed
+	}
+	if n := len(b.idle); n > 0 {
+		c := b.idle[n-1]
+		b.idle = b.idle[:n-1]
+		b.mu.Unlock()
+		return c, nil
+	}
+	b.mu.Unlock()
+
+	c, err := tcp.NewClient(b.addr, b.logger)
+	if err != nil {
+		return nil, err
+	}
+	return &backendConn{Client: c}, nil
+}
+
+// put keeps c for the next command unless maxIdle connections are idle already.
+func (b *backend) put(c *backendConn, maxIdle int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || len(b.idle) >= maxIdle {
+		c.Close()
+		return
+	}
+	b.idle = append(b.idle, c)
+}
+
+// check pings the backend on a new connection and records whether it answered.
+func (b *backend) check(timeout time.Duration) {
+	err := b.ping(timeout)
+	if healthy := err == nil; b.healthy.Swap(healthy) != healthy {
+		if healthy {
+			b.logger.Infof("proxy: backend %s is up", b.addr)
+		} else {
+			b.logger.Error(fmt.Errorf("proxy: backend %s is down: %w", b.addr, err))
+		}
+	}
+}
+
+func (b *backend) ping(timeout time.Duration) error {
+	c, err := tcp.NewClient(b.addr, quietLogger{b.logger})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err = c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	return c.Ping()
+}
+
+func (b *backend) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for _, c := range b.idle {
+		c.Close()
+	}
+	b.idle = nil
+}
+
+// quietLogger drops the errors of the health check connections, check logs the state changes only.
+type quietLogger struct {
+	tcp.Logger
+}
+
+func (quietLogger) Error(...interface{}) {}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultAddr           = "127.0.0.1:3100"
+	DefaultMaxConnections = 1000
+)
+
+// Config is the proxy.yaml file. The backends and the options are reloaded on SIGHUP,
+// addr and maxConnections are read on start only.
+type Config struct {
+	Addr           string        `yaml:"addr"`
+	MaxConnections uint          `yaml:"maxConnections"`
+	LogLevel       string        `yaml:"logLevel"`
+	Backends       []string      `yaml:"backends"`
+	VirtualNodes   int           `yaml:"virtualNodes"`
+	Timeout        time.Duration `yaml:"timeout"`
+	HealthInterval time.Duration `yaml:"healthInterval"`
+	MaxIdle        int           `yaml:"maxIdle"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Addr:           DefaultAddr,
+		MaxConnections: DefaultMaxConnections,
+		LogLevel:       "info",
+		VirtualNodes:   DefaultVirtualNodes,
+		Timeout:        DefaultTimeout,
+		HealthInterval: DefaultHealthInterval,
+		MaxIdle:        DefaultMaxIdle,
+	}
+}
+
+// LoadConfig reads file over the defaults and validates it.
+func LoadConfig(file string) (Config, error) {
+	c := DefaultConfig()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return c, err
+	}
+	dec := yaml.NewDecoder(strings.NewReader(string(data)))
+	dec.KnownFields(true)
+	if err = dec.Decode(&c); err != nil {
+		return c, fmt.Errorf("parse %s: %w", file, err)
+	}
+
+	return c, c.Validate()
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("addr: %w", err))
+	}
+	if c.MaxConnections == 0 {
+		errs = append(errs, errors.New("maxConnections: must be greater than 0"))
+	}
+	if len(c.Backends) == 0 {
+		errs = append(errs, errors.New("backends: must not be empty"))
+	}
+	seen := map[string]bool{}
+	for i, b := range c.Backends {
+		if _, _, err := net.SplitHostPort(b); err != nil {
+			errs = append(errs, fmt.Errorf("backends[%d]: %w", i, err))
+		}
+		if seen[b] {
+			errs = append(errs, fmt.Errorf("backends[%d]: duplicate backend %s", i, b))
+		}
+		seen[b] = true
+	}
+	if c.VirtualNodes < 1 {
+		errs = append(errs, errors.New("virtualNodes: must be greater than 0"))
+	}
+	if c.Timeout <= 0 {
+		errs = append(errs, errors.New("timeout: must be greater than 0"))
+	}
+	if c.HealthInterval <= 0 {
+		errs = append(errs, errors.New("healthInterval: must be greater than 0"))
+	}
+	if c.MaxIdle < 0 {
+		errs = append(errs, errors.New("maxIdle: must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c Config) Options() Options {
+	return Options{
+		VirtualNodes:   c.VirtualNodes,
+		Timeout:        c.Timeout,
+		HealthInterval: c.HealthInterval,
+		MaxIdle:        c.MaxIdle,
+	}
+}
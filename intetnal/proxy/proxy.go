@@ -0,0 +1,335 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jokedb/intetnal/compute"
+	"jokedb/intetnal/reply"
+	"jokedb/intetnal/storage/engine"
+	"jokedb/intetnal/tcp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultTimeout        = 2 * time.Second
+	DefaultHealthInterval = time.Second
+	DefaultMaxIdle        = 16
+)
+
+var (
+	errNotSupported = errors.New("command is not supported by the proxy")
+	errNoBackends   = errors.New("no backends")
+)
+
+// Options tune the ring, the backend requests and the health checks, zero values take the defaults.
+type Options struct {
+	VirtualNodes   int
+	Timeout        time.Duration
+	HealthInterval time.Duration
+	// MaxIdle is the number of idle connections kept per backend.
+	MaxIdle int
+}
+
+func (o Options) withDefaults() Options {
+	if o.VirtualNodes < 1 {
+		o.VirtualNodes = DefaultVirtualNodes
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+	if o.HealthInterval <= 0 {
+		o.HealthInterval = DefaultHealthInterval
+	}
+	if o.MaxIdle < 1 {
+		o.MaxIdle = DefaultMaxIdle
+	}
+	return o
+}
+
+// BackendStatus is a backend of the ring and the result of its last health check.
+type BackendStatus struct {
+	Addr    string
+	Healthy bool
+}
+
+// Proxy serves the protocol of tcp.Server over a consistent hash ring of JokeDB servers. The commands
+// on a key go to the backend of the key, KEYS and FLUSHDB go to all of them and their results are merged.
+type Proxy struct {
+	processor *compute.Processor
+	logger    tcp.Logger
+
+	mu       sync.RWMutex
+	ring     *Ring
+	backends map[string]*backend
+	opts     Options
+
+	checks atomic.Uint64
+}
+
+func New(backends []string, logger tcp.Logger, opts Options) *Proxy {
+	p := &Proxy{
+		processor: compute.New(),
+		logger:    logger,
+		backends:  map[string]*backend{},
+	}
+	p.Configure(backends, opts)
+
+	return p
+}
+
+// Configure replaces the backends and the options. The connections to the kept backends stay open,
+// the keys move only between the added or removed backends and their neighbours on the ring.
+func (p *Proxy) Configure(backends []string, opts Options) {
+	opts = opts.withDefaults()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	kept := make(map[string]*backend, len(backends))
+	for _, addr := range backends {
+		if b, ok := p.backends[addr]; ok {
+			kept[addr] = b
+		} else {
+			kept[addr] = newBackend(addr, p.logger)
+		}
+	}
+	for addr, b := range p.backends {
+		if _, ok := kept[addr]; !ok {
+			b.close()
+		}
+	}
+	p.backends = kept
+	p.ring = NewRing(backends, opts.VirtualNodes)
+	p.opts = opts
+}
+
+// Backends returns the backends in the order of the ring.
+func (p *Proxy) Backends() []BackendStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	statuses := make([]BackendStatus, 0, len(p.backends))
+	for _, addr := range p.ring.Backends() {
+		statuses = append(statuses, BackendStatus{Addr: addr, Healthy: p.backends[addr].healthy.Load()})
+	}
+	return statuses
+}
+
+// Run checks the health of the backends every Options.HealthInterval until ctx is done.
+// The commands on the keys of an unhealthy backend fail at once, the keys are not moved to another one.
+func (p *Proxy) Run(ctx context.Context) {
+	for {
+		p.mu.RLock()
+		backends := make([]*backend, 0, len(p.backends))
+		for _, b := range p.backends {
+			backends = append(backends, b)
+		}
+		interval, timeout := p.opts.HealthInterval, p.opts.Timeout
+		p.mu.RUnlock()
+
+		wg := sync.WaitGroup{}
+		for _, b := range backends {
+			wg.Add(1)
+			go func(b *backend) {
+				defer wg.Done()
+				b.check(timeout)
+			}(b)
+		}
+		wg.Wait()
+		p.checks.Add(1)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Close closes the idle connections to the backends.
+func (p *Proxy) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, b := range p.backends {
+		b.close()
+	}
+}
+
+// Handle runs q, it is the handler of the tcp.Server of the proxy. The replies of the backends are
+// relayed as they are, a failure of the proxy is an error reply.
+func (p *Proxy) Handle(ctx context.Context, q string) string {
+	action, err := p.processor.ParseQuery(q)
+	if err != nil {
+		return string(reply.Fail(reply.Syntax, "parse query :"+err.Error()).Encode())
+	}
+	name, _, _ := strings.Cut(strings.TrimSpace(q), " ")
+	sess := session(ctx)
+
+	var res reply.Reply
+	switch action.Type {
+	case engine.SET, engine.GET, engine.DEL, engine.MOVE:
+		res, err = p.forward(ctx, action.Key, sess.DB(), q)
+	case engine.KEYS:
+		res, err = p.keys(ctx, sess.DB(), q)
+	case engine.FLUSHDB:
+		res, err = p.broadcast(ctx, sess.DB(), q)
+	case engine.SELECT:
+		// the database is checked by the backends on the next command
+		index, _ := strconv.Atoi(action.Args[0])
+		sess.setDB(index)
+		res = reply.OK("SELECT ok")
+	case engine.PING:
+		res = reply.OK("PONG")
+		if len(action.Args) > 0 {
+			res = reply.Value(action.Args[0])
+		}
+	case engine.INFO:
+		res = reply.Value(p.info())
+	default:
+		err = errNotSupported
+	}
+	if err != nil {
+		res = reply.Fail(reply.CodeErr, fmt.Sprintf("%s query :%v", name, err))
+	}
+
+	return string(res.Encode())
+}
+
+func (p *Proxy) forward(ctx context.Context, key string, db int, q string) (reply.Reply, error) {
+	p.mu.RLock()
+	addr := p.ring.Get(key)
+	b, opts := p.backends[addr], p.opts
+	p.mu.RUnlock()
+
+	if b == nil {
+		return reply.Reply{}, errNoBackends
+	}
+	return p.send(ctx, b, db, q, opts)
+}
+
+func (p *Proxy) send(ctx context.Context, b *backend, db int, q string, opts Options) (reply.Reply, error) {
+	if !b.healthy.Load() {
+		return reply.Reply{}, fmt.Errorf("backend %s is down", b.addr)
+	}
+	res, err := b.do(ctx, db, q, opts.Timeout, opts.MaxIdle)
+	if err != nil {
+		return reply.Reply{}, fmt.Errorf("backend %s: %w", b.addr, err)
+	}
+	return res, nil
+}
+
+// fanOut sends q to every backend in parallel and returns their replies in the order of the ring.
+func (p *Proxy) fanOut(ctx context.Context, db int, q string) ([]reply.Reply, error) {
+	p.mu.RLock()
+	addrs := p.ring.Backends()
+	backends := make([]*backend, 0, len(addrs))
+	for _, addr := range addrs {
+		backends = append(backends, p.backends[addr])
+	}
+	opts := p.opts
+	p.mu.RUnlock()
+
+	if len(backends) == 0 {
+		return nil, errNoBackends
+	}
+	responses := make([]reply.Reply, len(backends))
+	errs := make([]error, len(backends))
+	wg := sync.WaitGroup{}
+	for i, b := range backends {
+		wg.Add(1)
+		go func(i int, b *backend) {
+			defer wg.Done()
+			responses[i], errs[i] = p.send(ctx, b, db, q, opts)
+		}(i, b)
+	}
+	wg.Wait()
+
+	return responses, errors.Join(errs...)
+}
+
+// keys merges the sorted keys of the backends, the first error reply is answered instead.
+func (p *Proxy) keys(ctx context.Context, db int, q string) (reply.Reply, error) {
+	responses, err := p.fanOut(ctx, db, q)
+	if err != nil {
+		return reply.Reply{}, err
+	}
+	var keys []string
+	for _, res := range responses {
+		if res.Kind == reply.KindError {
+			return res, nil
+		}
+		for _, item := range res.Array {
+			keys = append(keys, item.Text)
+		}
+	}
+	sort.Strings(keys)
+
+	return reply.Values(keys), nil
+}
+
+// broadcast answers the reply the backends agree on or the first that differs from the others.
+func (p *Proxy) broadcast(ctx context.Context, db int, q string) (reply.Reply, error) {
+	responses, err := p.fanOut(ctx, db, q)
+	if err != nil {
+		return reply.Reply{}, err
+	}
+	first := string(responses[0].Encode())
+	for _, res := range responses[1:] {
+		if string(res.Encode()) != first {
+			return res, nil
+		}
+	}
+
+	return responses[0], nil
+}
+
+// info writes the proxy section in the format of the INFO command.
+func (p *Proxy) info() string {
+	backends := p.Backends()
+	lines := []string{
+		"# Proxy",
+		"backends:" + strconv.Itoa(len(backends)),
+		"health_checks:" + strconv.FormatUint(p.checks.Load(), 10),
+	}
+	for _, b := range backends {
+		state := "up"
+		if !b.Healthy {
+			state = "down"
+		}
+		lines = append(lines, "backend_"+b.Addr+":"+state)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// clientSession is the database the connection to the proxy has selected.
+type clientSession struct {
+	db atomic.Int64
+}
+
+func (s *clientSession) DB() int {
+	return int(s.db.Load())
+}
+
+func (s *clientSession) setDB(db int) {
+	s.db.Store(int64(db))
+}
+
+func session(ctx context.Context) *clientSession {
+	conn, ok := tcp.ConnFromContext(ctx)
+	if !ok {
+		return &clientSession{}
+	}
+	if s, isSession := conn.Session().(*clientSession); isSession {
+		return s
+	}
+	s := &clientSession{}
+	conn.SetSession(s)
+	return s
+}
@@ -0,0 +1,212 @@
+package proxy_test
+
+import (
+	"context"
+	"errors"
+	"jokedb/intetnal/app"
+	"jokedb/intetnal/compute"
+	"jokedb/intetnal/proxy"
+	"jokedb/intetnal/reply"
+	"jokedb/intetnal/storage"
+	"jokedb/intetnal/storage/engine"
+	"jokedb/intetnal/tcp"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRing(t *testing.T) {
+	t.Parallel()
+	backends := []string{"10.0.0.1:3002", "10.0.0.2:3002", "10.0.0.3:3002"}
+	r := proxy.NewRing(backends, proxy.DefaultVirtualNodes)
+
+	const n = 30000
+	owners := make(map[string]string, n)
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		key := "key:" + strconv.Itoa(i)
+		owners[key] = r.Get(key)
+		counts[owners[key]]++
+	}
+	for _, b := range backends {
+		require.InDelta(t, n/len(backends), counts[b], 0.15*n/float64(len(backends)), b)
+	}
+
+	// a new backend takes its keys from the others, the rest stay
+	grown := proxy.NewRing(append(backends, "10.0.0.4:3002"), proxy.DefaultVirtualNodes)
+	moved := 0
+	for key, owner := range owners {
+		if got := grown.Get(key); got != owner {
+			require.Equal(t, "10.0.0.4:3002", got)
+			moved++
+		}
+	}
+	require.InDelta(t, n/4, moved, 0.15*n/4)
+
+	// the keys of a removed backend go to the others, the rest stay
+	shrunk := proxy.NewRing(backends[1:], proxy.DefaultVirtualNodes)
+	for key, owner := range owners {
+		if owner != backends[0] {
+			require.Equal(t, owner, shrunk.Get(key))
+		}
+	}
+
+	require.Empty(t, proxy.NewRing(nil, 0).Get("key"))
+}
+
+// startBackend serves a JokeDB app on a random port.
+func startBackend(t *testing.T) (string, *storage.Storage) {
+	t.Helper()
+	s, err := storage.New(engine.New(), nil, 1, time.Millisecond, storage.WithDatabases(2))
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+	a := app.New(compute.New(), s)
+
+	return serve(t, func(ctx context.Context, q string) string {
+		// as App.Handle without its logging
+		r, errDo := a.Do(ctx, q)
+		if errDo != nil {
+			r = app.ErrorReply(errDo)
+		}
+		return string(r.Encode())
+	}), s
+}
+
+func serve(t *testing.T, handler tcp.HandelQuery) string {
+	t.Helper()
+	serv, err := tcp.NewServer("127.0.0.1:0", 100, zap.NewNop().Sugar(), handler)
+	require.NoError(t, err)
+	go serv.Listen(context.Background())
+
+	return serv.Addr().String()
+}
+
+// send returns the reply as text, an error reply is its message.
+func send(t *testing.T, c *tcp.Client, q string) string {
+	t.Helper()
+	r, err := c.Do(q)
+	var replyErr *reply.Error
+	if errors.As(err, &replyErr) {
+		return replyErr.Message
+	}
+	require.NoError(t, err)
+	return r.String()
+}
+
+func TestProxy(t *testing.T) {
+	t.Parallel()
+	var backends []string
+	stores := map[string]*storage.Storage{}
+	for i := 0; i < 3; i++ {
+		addr, s := startBackend(t)
+		backends = append(backends, addr)
+		stores[addr] = s
+	}
+	p := proxy.New(backends, zap.NewNop().Sugar(), proxy.Options{HealthInterval: 10 * time.Millisecond})
+	t.Cleanup(p.Close)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go p.Run(ctx)
+
+	c, err := tcp.NewClient(serve(t, p.Handle), zap.NewNop().Sugar())
+	require.NoError(t, err)
+	t.Cleanup(c.Close)
+
+	var keys []string
+	for i := 0; i < 60; i++ {
+		key := "k" + strconv.Itoa(i)
+		keys = append(keys, key)
+		require.Equal(t, "SET ok", send(t, c, "SET "+key+" v"+strconv.Itoa(i)))
+	}
+	require.Equal(t, "v7", send(t, c, "GET k7"))
+	require.Equal(t, "DEL ok", send(t, c, "DEL k7"))
+	require.Equal(t, "GET query :no key", send(t, c, "GET k7"))
+	_, err = c.Do("GET k7")
+	require.ErrorIs(t, err, reply.ErrNotFound)
+	keys = append(keys[:7], keys[8:]...)
+
+	// every backend has the keys the ring gives it
+	ring := proxy.NewRing(backends, proxy.DefaultVirtualNodes)
+	for addr, s := range stores {
+		got, errKeys := s.Keys(context.Background(), 0, "*")
+		require.NoError(t, errKeys)
+		require.NotEmpty(t, got)
+		for _, key := range got {
+			require.Equal(t, addr, ring.Get(key))
+		}
+	}
+	sort.Strings(keys)
+	require.Equal(t, strings.Join(keys, "\n"), send(t, c, "KEYS"))
+
+	require.Equal(t, "SELECT ok", send(t, c, "SELECT 1"))
+	require.Equal(t, "(empty)", send(t, c, "KEYS"))
+	require.Equal(t, "SET ok", send(t, c, "SET a 1"))
+	require.Equal(t, "a", send(t, c, "KEYS"))
+	require.Equal(t, "FLUSHDB ok", send(t, c, "FLUSHDB"))
+	require.Equal(t, "(empty)", send(t, c, "KEYS"))
+	require.Equal(t, "SELECT ok", send(t, c, "SELECT 0"))
+	require.Equal(t, "v1", send(t, c, "GET k1"))
+
+	require.Equal(t, "PONG", send(t, c, "PING"))
+	require.Equal(t, "BACKUP query :command is not supported by the proxy", send(t, c, "BACKUP /tmp/x"))
+
+	// a backend that does not answer the health checks fails its keys at once
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dead := l.Addr().String()
+	require.NoError(t, l.Close())
+	p.Configure(append(backends, dead), proxy.Options{HealthInterval: 10 * time.Millisecond})
+	require.Eventually(t, func() bool {
+		return strings.Contains(send(t, c, "INFO"), "backend_"+dead+":down")
+	}, 5*time.Second, 10*time.Millisecond)
+	deadRing := proxy.NewRing(append(backends, dead), proxy.DefaultVirtualNodes)
+	for _, key := range keys {
+		if deadRing.Get(key) == dead {
+			require.Equal(t, "GET query :backend "+dead+" is down", send(t, c, "GET "+key))
+			break
+		}
+	}
+
+	// removing it sends the keys back where they were
+	p.Configure(backends, proxy.Options{})
+	for _, key := range keys {
+		require.True(t, strings.HasPrefix(send(t, c, "GET "+key), "v"), key)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "proxy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+addr: "127.0.0.1:3100"
+timeout: "500ms"
+backends: ["127.0.0.1:3001", "127.0.0.1:3002"]
+`), 0o644))
+	c, err := proxy.LoadConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, 500*time.Millisecond, c.Timeout)
+	require.Equal(t, proxy.DefaultVirtualNodes, c.VirtualNodes)
+	require.Len(t, c.Backends, 2)
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+backend: ["127.0.0.1:3001"]
+`), 0o644))
+	_, err = proxy.LoadConfig(path)
+	require.ErrorContains(t, err, "field backend not found")
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+backends: ["127.0.0.1:3001", "127.0.0.1:3001"]
+healthInterval: "0s"
+`), 0o644))
+	_, err = proxy.LoadConfig(path)
+	require.ErrorContains(t, err, "duplicate backend")
+	require.ErrorContains(t, err, "healthInterval")
+}
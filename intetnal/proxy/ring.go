@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// DefaultVirtualNodes is the number of points of a backend on the ring, the keys of a backend are
+// within about 10% of an even split with it.
+const DefaultVirtualNodes = 160
+
+// Ring maps the keys onto the backends by consistent hashing: each backend is hashed onto
+// the ring at virtual points and a key belongs to the first point after its hash. Adding or removing
+// a backend moves only the keys of its points. A Ring is immutable.
+type Ring struct {
+	points   []uint32
+	owners   map[uint32]string
+	backends []string
+}
+
+func NewRing(backends []string, virtualNodes int) *Ring {
+	if virtualNodes < 1 {
+		virtualNodes = DefaultVirtualNodes
+	}
+	r := &Ring{owners: map[uint32]string{}}
+	for _, b := range backends {
+		r.backends = append(r.backends, b)
+		for i := 0; i < virtualNodes; i++ {
+			h := hash(b + "#" + strconv.Itoa(i))
+			// on a collision the point stays with the backend added first
+			if _, ok := r.owners[h]; ok {
+				continue
+			}
+			r.owners[h] = b
+			r.points = append(r.points, h)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+
+	return r
+}
+
+// Get returns the backend of key, empty for a ring without backends.
+func (r *Ring) Get(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.owners[r.points[i]]
+}
+
+// Backends returns the backends in the order they were given.
+func (r *Ring) Backends() []string {
+	return append([]string(nil), r.backends...)
+}
+
+// hash is the first 4 bytes of MD5 as ketama has it, the short similar names of the virtual points
+// are spread evenly by it.
+func hash(s string) uint32 {
+	sum := md5.Sum([]byte(s))
+	return binary.LittleEndian.Uint32(sum[:4])
+}
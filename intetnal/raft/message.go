@@ -0,0 +1,62 @@
+package raft
+
+import (
+	"context"
+	"jokedb/intetnal/wal"
+)
+
+// Entry is a record of the replicated log.
+type Entry struct {
+	Index uint64
+	Term  uint64
+	// Log is the write, a new leader appends an entry with the zero Log to commit the entries of the previous terms.
+	Log wal.LogData
+}
+
+type VoteRequest struct {
+	Term      uint64
+	Candidate string
+	LastIndex uint64
+	LastTerm  uint64
+}
+
+type VoteResponse struct {
+	Term    uint64
+	Granted bool
+}
+
+type AppendRequest struct {
+	Term      uint64
+	Leader    string
+	PrevIndex uint64
+	PrevTerm  uint64
+	Entries   []Entry
+	Commit    uint64
+}
+
+// AppendResponse tells a leader where the logs diverge when Success is false: ConflictTerm is the term
+// of the follower's entry at PrevIndex and ConflictIndex the first index of that term, or the end of the
+// follower's log with ConflictTerm 0.
+type AppendResponse struct {
+	Term          uint64
+	Success       bool
+	ConflictIndex uint64
+	ConflictTerm  uint64
+}
+
+type SnapshotRequest struct {
+	Term     uint64
+	Leader   string
+	Snapshot Snapshot
+}
+
+type SnapshotResponse struct {
+	Term uint64
+}
+
+// Transport delivers the requests of a node to its peers by their IDs.
+type Transport interface {
+	RequestVote(ctx context.Context, to string, req *VoteRequest) (*VoteResponse, error)
+	AppendEntries(ctx context.Context, to string, req *AppendRequest) (*AppendResponse, error)
+	InstallSnapshot(ctx context.Context, to string, req *SnapshotRequest) (*SnapshotResponse, error)
+}
@@ -0,0 +1,105 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+var ErrUnreachable = errors.New("peer is unreachable")
+
+// Network is an in-process Transport for tests: it calls the handlers of the registered
+// nodes directly and can cut nodes off and drop a share of the messages.
+type Network struct {
+	mu           sync.RWMutex
+	nodes        map[string]*Node
+	disconnected map[string]bool
+	dropRate     float64
+}
+
+func NewNetwork() *Network {
+	return &Network{nodes: map[string]*Node{}, disconnected: map[string]bool{}}
+}
+
+// Register delivers the messages for the node's ID to node, replacing a previous one.
+func (n *Network) Register(node *Node) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.nodes[node.ID()] = node
+}
+
+// Transport returns the transport of the node from.
+func (n *Network) Transport(from string) Transport {
+	return &networkTransport{network: n, from: from}
+}
+
+// Disconnect drops every message from and to the node until Connect.
+func (n *Network) Disconnect(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.disconnected[id] = true
+}
+
+func (n *Network) Connect(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.disconnected, id)
+}
+
+// SetDropRate drops the share rate, from 0 to 1, of the messages of connected nodes.
+func (n *Network) SetDropRate(rate float64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.dropRate = rate
+}
+
+func (n *Network) deliver(from, to string) (*Node, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	node, ok := n.nodes[to]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("%w: unknown node %s", ErrUnreachable, to)
+	case n.disconnected[from] || n.disconnected[to]:
+		return nil, fmt.Errorf("%w: %s -> %s disconnected", ErrUnreachable, from, to)
+	case n.dropRate > 0 && rand.Float64() < n.dropRate:
+		return nil, fmt.Errorf("%w: %s -> %s dropped", ErrUnreachable, from, to)
+	}
+
+	return node, nil
+}
+
+type networkTransport struct {
+	network *Network
+	from    string
+}
+
+// call delivers the request to the node to and its response back, either can be lost.
+func call[Req, Resp any](t *networkTransport, to string, req Req, handle func(*Node, Req) Resp) (Resp, error) {
+	var zero Resp
+	node, err := t.network.deliver(t.from, to)
+	if err != nil {
+		return zero, err
+	}
+	resp := handle(node, req)
+	if _, err = t.network.deliver(to, t.from); err != nil {
+		return zero, err
+	}
+
+	return resp, nil
+}
+
+func (t *networkTransport) RequestVote(_ context.Context, to string, req *VoteRequest) (*VoteResponse, error) {
+	return call(t, to, req, (*Node).HandleRequestVote)
+}
+
+func (t *networkTransport) AppendEntries(_ context.Context, to string, req *AppendRequest) (*AppendResponse, error) {
+	return call(t, to, req, (*Node).HandleAppendEntries)
+}
+
+func (t *networkTransport) InstallSnapshot(_ context.Context, to string, req *SnapshotRequest) (*SnapshotResponse, error) {
+	return call(t, to, req, (*Node).HandleInstallSnapshot)
+}
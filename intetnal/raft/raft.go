@@ -0,0 +1,908 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jokedb/intetnal/wal"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// maxAppendEntries bounds the entries of one AppendEntries request.
+const maxAppendEntries = 512
+
+var (
+	ErrStopped = errors.New("raft node is stopped")
+	// ErrLeadershipLost is returned for a write of a leader that stepped down before applying it,
+	// the next leader may still commit it.
+	ErrLeadershipLost = errors.New("leadership lost before the write was applied, it may or may not be committed")
+	// ErrReplicasUnconfirmed is returned by WaitReplicas on a leader that stepped down before the
+	// replicas confirmed the write.
+	ErrReplicasUnconfirmed = errors.New("leadership lost before the replicas confirmed the write")
+)
+
+// NotLeaderError is returned by Propose on a node that is not the leader.
+type NotLeaderError struct {
+	// Leader is the known leader, the zero Peer during an election.
+	Leader Peer
+}
+
+func (e *NotLeaderError) Error() string {
+	switch {
+	case e.Leader.ID == "":
+		return "not the leader, no leader is elected"
+	case e.Leader.ClientAddr != "":
+		return fmt.Sprintf("not the leader, the leader is %s at %s", e.Leader.ID, e.Leader.ClientAddr)
+	default:
+		return "not the leader, the leader is " + e.Leader.ID
+	}
+}
+
+type Peer struct {
+	ID string
+	// Addr is the address of the raft RPCs, ClientAddr the one clients are sent to by NotLeaderError.
+	Addr       string
+	ClientAddr string
+}
+
+type Config struct {
+	ID string
+	// Peers are all the nodes of the cluster including this one.
+	Peers []Peer
+	// ElectionTimeout is the minimum time without a leader before an election, each node
+	// waits a random time between it and twice it. HeartbeatInterval must be well below it.
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration
+	// SnapshotThreshold is the number of applied entries after the last snapshot that triggers
+	// a new one and the compaction of the log, 0 disables snapshots.
+	SnapshotThreshold uint64
+}
+
+func (c Config) validate() error {
+	found := false
+	for _, p := range c.Peers {
+		found = found || p.ID == c.ID
+	}
+	switch {
+	case !found:
+		return fmt.Errorf("node %q is not one of the peers", c.ID)
+	case c.ElectionTimeout <= 0:
+		return errors.New("election timeout must be greater than 0")
+	case c.HeartbeatInterval <= 0 || c.HeartbeatInterval >= c.ElectionTimeout:
+		return errors.New("heartbeat interval must be greater than 0 and less than the election timeout")
+	}
+
+	return nil
+}
+
+// StateMachine is what the log is applied to.
+type StateMachine interface {
+	// Apply applies a committed write, the writes are applied in log order from one goroutine.
+	Apply(log wal.LogData) error
+	// Snapshot and Restore are called from the goroutine of Apply.
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+type Logger interface {
+	Infof(template string, args ...interface{})
+	Errorf(template string, args ...interface{})
+}
+
+type State int8
+
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+func (s State) String() string {
+	switch s {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	default:
+		return fmt.Sprintf("State(%d)", s)
+	}
+}
+
+type Status struct {
+	ID            string
+	State         State
+	Term          uint64
+	Leader        string
+	CommitIndex   uint64
+	LastApplied   uint64
+	LastIndex     uint64
+	SnapshotIndex uint64
+	// Match is the last index replicated to each follower, set on the leader only.
+	Match map[string]uint64
+}
+
+type waiter struct {
+	term uint64
+	done chan error
+}
+
+// Node is a member of a Raft cluster replicating wal.LogData writes to a StateMachine.
+type Node struct {
+	cfg       Config
+	peers     map[string]Peer
+	store     Store
+	transport Transport
+	logger    Logger
+	sm        StateMachine
+
+	mu        sync.Mutex
+	applyCond *sync.Cond
+	// progressCond is signaled when the commit index or the match index of a follower moves.
+	progressCond *sync.Cond
+	state        State
+	term         uint64
+	votedFor     string
+	leader       string
+	// log[0] holds the index and term of the snapshot, the entries after it follow.
+	log         []Entry
+	snapshot    Snapshot
+	commitIndex uint64
+	lastApplied uint64
+	// restore is an installed snapshot the state machine has not restored yet.
+	restore     *Snapshot
+	deadline    time.Time
+	heartbeatAt time.Time
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	inflight    map[string]bool
+	again       map[string]bool
+	waiters     map[uint64]waiter
+	stopped     bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewNode loads the node from store, Start begins the participation in the cluster.
+func NewNode(cfg Config, store Store, transport Transport, logger Logger) (*Node, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	state, snapshot, entries, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{
+		cfg:        cfg,
+		peers:      make(map[string]Peer, len(cfg.Peers)),
+		store:      store,
+		transport:  transport,
+		logger:     logger,
+		term:       state.Term,
+		votedFor:   state.VotedFor,
+		log:        append([]Entry{{Index: snapshot.Index, Term: snapshot.Term}}, entries...),
+		snapshot:   snapshot,
+		nextIndex:  map[string]uint64{},
+		matchIndex: map[string]uint64{},
+		inflight:   map[string]bool{},
+		again:      map[string]bool{},
+		waiters:    map[uint64]waiter{},
+		stop:       make(chan struct{}),
+	}
+	n.applyCond = sync.NewCond(&n.mu)
+	n.progressCond = sync.NewCond(&n.mu)
+	for _, p := range cfg.Peers {
+		n.peers[p.ID] = p
+	}
+	for i, e := range n.log[1:] {
+		if e.Index != snapshot.Index+uint64(i)+1 {
+			return nil, fmt.Errorf("raft log has entry %d at position of %d", e.Index, snapshot.Index+uint64(i)+1)
+		}
+	}
+
+	return n, nil
+}
+
+func (n *Node) ID() string {
+	return n.cfg.ID
+}
+
+// Start restores the state machine from the last snapshot, the entries after it are applied
+// again once the node learns they are committed.
+func (n *Node) Start(sm StateMachine) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.sm = sm
+	if n.snapshot.Index > 0 {
+		if err := sm.Restore(n.snapshot.Data); err != nil {
+			return fmt.Errorf("restore snapshot %d: %w", n.snapshot.Index, err)
+		}
+		n.commitIndex = n.snapshot.Index
+		n.lastApplied = n.snapshot.Index
+	}
+	n.resetDeadlineLocked()
+
+	n.wg.Add(2)
+	go n.run()
+	go n.applyLoop()
+
+	return nil
+}
+
+// Stop stops the node, the pending writes fail with ErrStopped.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	n.stopped = true
+	close(n.stop)
+	n.failWaitersLocked(ErrStopped)
+	n.applyCond.Broadcast()
+	n.progressCond.Broadcast()
+	n.mu.Unlock()
+
+	n.wg.Wait()
+}
+
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	s := Status{
+		ID:            n.cfg.ID,
+		State:         n.state,
+		Term:          n.term,
+		Leader:        n.leader,
+		CommitIndex:   n.commitIndex,
+		LastApplied:   n.lastApplied,
+		LastIndex:     n.lastIndex(),
+		SnapshotIndex: n.log[0].Index,
+	}
+	if n.state == Leader {
+		s.Match = make(map[string]uint64, len(n.matchIndex))
+		for id, m := range n.matchIndex {
+			s.Match[id] = m
+		}
+	}
+
+	return s
+}
+
+// Propose appends the write to the log and returns its index after it is committed on a majority
+// and applied by this node, with the error of Apply. The LSN of the applied write is its index.
+// A canceled ctx returns at once, the write may still be committed.
+func (n *Node) Propose(ctx context.Context, log wal.LogData) (uint64, error) {
+	n.mu.Lock()
+	index, err := n.proposeLocked(log)
+	if err != nil {
+		n.mu.Unlock()
+		return 0, err
+	}
+	done := make(chan error, 1)
+	n.waiters[index] = waiter{term: n.term, done: done}
+	n.mu.Unlock()
+
+	select {
+	case err = <-done:
+		return index, err
+	case <-ctx.Done():
+		n.mu.Lock()
+		delete(n.waiters, index)
+		n.mu.Unlock()
+		return index, ctx.Err()
+	}
+}
+
+// Append appends the write to the log of the leader and returns its index without waiting for the
+// commit, every node applies it once it is committed. The error of Apply is not reported.
+func (n *Node) Append(_ context.Context, log wal.LogData) (uint64, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.proposeLocked(log)
+}
+
+func (n *Node) proposeLocked(log wal.LogData) (uint64, error) {
+	if n.stopped {
+		return 0, ErrStopped
+	}
+	if n.state != Leader {
+		return 0, &NotLeaderError{Leader: n.peers[n.leader]}
+	}
+
+	index := n.lastIndex() + 1
+	log.LSN = index
+	log.Timestamp = time.Now().UnixNano()
+	if err := n.appendLocked([]Entry{{Index: index, Term: n.term, Log: log}}); err != nil {
+		return 0, err
+	}
+	n.broadcastLocked()
+	n.advanceCommitLocked()
+
+	return index, nil
+}
+
+// WaitReplicas returns when the commit index reaches lsn and count followers have the entry at lsn.
+// It waits on the leader that appended the entry.
+func (n *Node) WaitReplicas(ctx context.Context, lsn uint64, count int) error {
+	stop := context.AfterFunc(ctx, func() {
+		n.mu.Lock()
+		n.progressCond.Broadcast()
+		n.mu.Unlock()
+	})
+	defer stop()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.state != Leader {
+		return &NotLeaderError{Leader: n.peers[n.leader]}
+	}
+	term := n.term
+	for {
+		switch {
+		case n.stopped:
+			return ErrStopped
+		case n.state != Leader || n.term != term:
+			return ErrReplicasUnconfirmed
+		case n.commitIndex >= lsn && n.matchedLocked(lsn) >= count:
+			return nil
+		case ctx.Err() != nil:
+			return ctx.Err()
+		}
+		n.progressCond.Wait()
+	}
+}
+
+// matchedLocked returns the number of followers known to have the entry at index.
+func (n *Node) matchedLocked(index uint64) int {
+	matched := 0
+	for _, match := range n.matchIndex {
+		if match >= index {
+			matched++
+		}
+	}
+	return matched
+}
+
+func (n *Node) run() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.cfg.HeartbeatInterval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+			n.tick()
+		}
+	}
+}
+
+func (n *Node) tick() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	switch {
+	case n.state == Leader:
+		if !now.Before(n.heartbeatAt) {
+			n.heartbeatAt = now.Add(n.cfg.HeartbeatInterval)
+			n.broadcastLocked()
+		}
+	case now.After(n.deadline):
+		n.startElectionLocked()
+	}
+}
+
+func (n *Node) resetDeadlineLocked() {
+	timeout := n.cfg.ElectionTimeout + time.Duration(rand.Int63n(int64(n.cfg.ElectionTimeout)))
+	n.deadline = time.Now().Add(timeout)
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+func (n *Node) lastTerm() uint64 {
+	return n.log[len(n.log)-1].Term
+}
+
+// termAt returns the term of the entry at index, false if it is compacted or not in the log.
+func (n *Node) termAt(index uint64) (uint64, bool) {
+	if index < n.log[0].Index || index > n.lastIndex() {
+		return 0, false
+	}
+
+	return n.log[index-n.log[0].Index].Term, true
+}
+
+func (n *Node) persistStateLocked() error {
+	return n.store.SetState(HardState{Term: n.term, VotedFor: n.votedFor})
+}
+
+func (n *Node) appendLocked(entries []Entry) error {
+	if err := n.store.Append(entries); err != nil {
+		return err
+	}
+	n.log = append(n.log, entries...)
+
+	return nil
+}
+
+func (n *Node) failWaitersLocked(err error) {
+	for index, w := range n.waiters {
+		w.done <- err
+		delete(n.waiters, index)
+	}
+}
+
+func (n *Node) startElectionLocked() {
+	n.state = Candidate
+	n.term++
+	n.votedFor = n.cfg.ID
+	n.leader = ""
+	n.resetDeadlineLocked()
+	if err := n.persistStateLocked(); err != nil {
+		n.logger.Errorf("raft %s: persist state: %v", n.cfg.ID, err)
+		return
+	}
+	n.logger.Infof("raft %s: election for term %d", n.cfg.ID, n.term)
+
+	if len(n.peers) == 1 {
+		n.becomeLeaderLocked()
+		return
+	}
+
+	req := &VoteRequest{Term: n.term, Candidate: n.cfg.ID, LastIndex: n.lastIndex(), LastTerm: n.lastTerm()}
+	votes := 1
+	for id := range n.peers {
+		if id == n.cfg.ID {
+			continue
+		}
+		go func(id string) {
+			ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+			resp, err := n.transport.RequestVote(ctx, id, req)
+			cancel()
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if n.stopped {
+				return
+			}
+			if resp.Term > n.term {
+				n.becomeFollowerLocked(resp.Term, "")
+				return
+			}
+			if n.state != Candidate || n.term != req.Term || !resp.Granted {
+				return
+			}
+			votes++
+			if votes*2 > len(n.peers) {
+				n.becomeLeaderLocked()
+			}
+		}(id)
+	}
+}
+
+func (n *Node) becomeLeaderLocked() {
+	n.state = Leader
+	n.leader = n.cfg.ID
+	for id := range n.peers {
+		n.nextIndex[id] = n.lastIndex() + 1
+		n.matchIndex[id] = 0
+	}
+	delete(n.nextIndex, n.cfg.ID)
+	delete(n.matchIndex, n.cfg.ID)
+	n.logger.Infof("raft %s: leader of term %d", n.cfg.ID, n.term)
+
+	// commits the entries of the previous terms, which a leader cannot commit by counting replicas
+	if err := n.appendLocked([]Entry{{Index: n.lastIndex() + 1, Term: n.term}}); err != nil {
+		n.logger.Errorf("raft %s: append: %v", n.cfg.ID, err)
+	}
+	n.heartbeatAt = time.Now().Add(n.cfg.HeartbeatInterval)
+	n.broadcastLocked()
+	n.advanceCommitLocked()
+}
+
+// becomeFollowerLocked moves to term, if it is newer, and follows leader, empty if unknown.
+func (n *Node) becomeFollowerLocked(term uint64, leader string) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		if err := n.persistStateLocked(); err != nil {
+			n.logger.Errorf("raft %s: persist state: %v", n.cfg.ID, err)
+		}
+	}
+	if n.state == Leader {
+		n.logger.Infof("raft %s: stepped down in term %d", n.cfg.ID, n.term)
+		n.failWaitersLocked(ErrLeadershipLost)
+		n.progressCond.Broadcast()
+	}
+	if n.state != Follower {
+		n.resetDeadlineLocked()
+	}
+	n.state = Follower
+	n.leader = leader
+}
+
+func (n *Node) broadcastLocked() {
+	for id := range n.peers {
+		if id != n.cfg.ID {
+			n.sendLocked(id)
+		}
+	}
+}
+
+// sendLocked sends the entries the peer misses, or the snapshot if they are compacted.
+// One request per peer is in flight, a send during it is done after its response.
+func (n *Node) sendLocked(id string) {
+	if n.stopped {
+		return
+	}
+	if n.inflight[id] {
+		n.again[id] = true
+		return
+	}
+	n.inflight[id] = true
+	n.again[id] = false
+
+	next := n.nextIndex[id]
+	if next <= n.log[0].Index {
+		go n.sendSnapshot(id, &SnapshotRequest{Term: n.term, Leader: n.cfg.ID, Snapshot: n.snapshot})
+		return
+	}
+
+	prevTerm, _ := n.termAt(next - 1)
+	from := next - n.log[0].Index
+	to := min(uint64(len(n.log)), from+maxAppendEntries)
+	go n.sendAppend(id, &AppendRequest{
+		Term:      n.term,
+		Leader:    n.cfg.ID,
+		PrevIndex: next - 1,
+		PrevTerm:  prevTerm,
+		Entries:   append([]Entry(nil), n.log[from:to]...),
+		Commit:    n.commitIndex,
+	})
+}
+
+func (n *Node) sendAppend(id string, req *AppendRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+	resp, err := n.transport.AppendEntries(ctx, id, req)
+	cancel()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.inflight[id] = false
+	if err != nil || n.stopped {
+		// retried by the next heartbeat
+		return
+	}
+
+	if resp.Term > n.term {
+		n.becomeFollowerLocked(resp.Term, "")
+		return
+	}
+	if n.state != Leader || req.Term != n.term {
+		return
+	}
+
+	if resp.Success {
+		n.matchIndex[id] = max(n.matchIndex[id], req.PrevIndex+uint64(len(req.Entries)))
+		n.nextIndex[id] = n.matchIndex[id] + 1
+		n.progressCond.Broadcast()
+		n.advanceCommitLocked()
+		if n.again[id] || n.nextIndex[id] <= n.lastIndex() {
+			n.sendLocked(id)
+		}
+		return
+	}
+
+	next := resp.ConflictIndex
+	if resp.ConflictTerm > 0 {
+		if last, ok := n.lastIndexOfTermLocked(resp.ConflictTerm); ok {
+			next = last + 1
+		}
+	}
+	n.nextIndex[id] = max(1, min(next, n.lastIndex()+1))
+	n.sendLocked(id)
+}
+
+func (n *Node) sendSnapshot(id string, req *SnapshotRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+	resp, err := n.transport.InstallSnapshot(ctx, id, req)
+	cancel()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.inflight[id] = false
+	if err != nil || n.stopped {
+		return
+	}
+
+	if resp.Term > n.term {
+		n.becomeFollowerLocked(resp.Term, "")
+		return
+	}
+	if n.state != Leader || req.Term != n.term {
+		return
+	}
+	n.matchIndex[id] = max(n.matchIndex[id], req.Snapshot.Index)
+	n.nextIndex[id] = n.matchIndex[id] + 1
+	n.progressCond.Broadcast()
+	n.advanceCommitLocked()
+	n.sendLocked(id)
+}
+
+func (n *Node) lastIndexOfTermLocked(term uint64) (uint64, bool) {
+	for i := len(n.log) - 1; i >= 0; i-- {
+		switch {
+		case n.log[i].Term == term:
+			return n.log[i].Index, true
+		case n.log[i].Term < term:
+			return 0, false
+		}
+	}
+
+	return 0, false
+}
+
+// advanceCommitLocked commits the last entry of the current term replicated on a majority.
+func (n *Node) advanceCommitLocked() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if term, _ := n.termAt(index); term != n.term {
+			return
+		}
+
+		if (n.matchedLocked(index)+1)*2 > len(n.peers) {
+			n.commitIndex = index
+			n.applyCond.Broadcast()
+			n.progressCond.Broadcast()
+			return
+		}
+	}
+}
+
+func (n *Node) HandleRequestVote(req *VoteRequest) *VoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return &VoteResponse{Term: n.term}
+	}
+	if req.Term > n.term {
+		n.becomeFollowerLocked(req.Term, "")
+	}
+
+	resp := &VoteResponse{Term: n.term}
+	switch {
+	case req.Term < n.term:
+		return resp
+	case n.votedFor != "" && n.votedFor != req.Candidate:
+		return resp
+	case req.LastTerm < n.lastTerm() || (req.LastTerm == n.lastTerm() && req.LastIndex < n.lastIndex()):
+		return resp
+	}
+
+	n.votedFor = req.Candidate
+	if err := n.persistStateLocked(); err != nil {
+		n.votedFor = ""
+		n.logger.Errorf("raft %s: persist state: %v", n.cfg.ID, err)
+		return resp
+	}
+	resp.Granted = true
+	n.resetDeadlineLocked()
+
+	return resp
+}
+
+func (n *Node) HandleAppendEntries(req *AppendRequest) *AppendResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resp := &AppendResponse{Term: n.term}
+	if n.stopped || req.Term < n.term {
+		return resp
+	}
+	n.becomeFollowerLocked(req.Term, req.Leader)
+	n.resetDeadlineLocked()
+	resp.Term = n.term
+
+	entries, prevIndex, prevTerm := req.Entries, req.PrevIndex, req.PrevTerm
+	if snapshot := n.log[0]; prevIndex < snapshot.Index {
+		// the snapshot holds committed entries only, they match the leader's
+		skip := snapshot.Index - prevIndex
+		if uint64(len(entries)) <= skip {
+			resp.Success = true
+			return resp
+		}
+		entries, prevIndex, prevTerm = entries[skip:], snapshot.Index, snapshot.Term
+	}
+
+	if prevIndex > n.lastIndex() {
+		resp.ConflictIndex = n.lastIndex() + 1
+		return resp
+	}
+	if term, _ := n.termAt(prevIndex); term != prevTerm {
+		resp.ConflictTerm = term
+		resp.ConflictIndex = prevIndex
+		for resp.ConflictIndex > n.log[0].Index+1 {
+			if t, _ := n.termAt(resp.ConflictIndex - 1); t != term {
+				break
+			}
+			resp.ConflictIndex--
+		}
+		return resp
+	}
+
+	for i, e := range entries {
+		if term, ok := n.termAt(e.Index); ok {
+			if term == e.Term {
+				continue
+			}
+			if err := n.store.TruncateFrom(e.Index); err != nil {
+				n.logger.Errorf("raft %s: truncate log: %v", n.cfg.ID, err)
+				resp.ConflictIndex = n.lastIndex() + 1
+				return resp
+			}
+			n.log = n.log[:e.Index-n.log[0].Index]
+		}
+		if err := n.appendLocked(append([]Entry(nil), entries[i:]...)); err != nil {
+			n.logger.Errorf("raft %s: append: %v", n.cfg.ID, err)
+			resp.ConflictIndex = n.lastIndex() + 1
+			return resp
+		}
+		break
+	}
+
+	resp.Success = true
+	if last := prevIndex + uint64(len(entries)); req.Commit > n.commitIndex {
+		n.commitIndex = max(n.commitIndex, min(req.Commit, last))
+		n.applyCond.Broadcast()
+	}
+
+	return resp
+}
+
+func (n *Node) HandleInstallSnapshot(req *SnapshotRequest) *SnapshotResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	resp := &SnapshotResponse{Term: n.term}
+	if n.stopped || req.Term < n.term {
+		return resp
+	}
+	n.becomeFollowerLocked(req.Term, req.Leader)
+	n.resetDeadlineLocked()
+	resp.Term = n.term
+
+	snapshot := req.Snapshot
+	if snapshot.Index <= n.commitIndex {
+		return resp
+	}
+
+	if term, ok := n.termAt(snapshot.Index); !ok || term != snapshot.Term {
+		// the log conflicts with the snapshot or ends before it, none of it is kept
+		if err := n.store.TruncateFrom(0); err != nil {
+			n.logger.Errorf("raft %s: truncate log: %v", n.cfg.ID, err)
+			return resp
+		}
+		n.log = n.log[:1]
+	}
+	if err := n.store.SaveSnapshot(snapshot); err != nil {
+		n.logger.Errorf("raft %s: save snapshot: %v", n.cfg.ID, err)
+		return resp
+	}
+	n.compactLocked(snapshot)
+	n.commitIndex = snapshot.Index
+	n.restore = &snapshot
+	n.applyCond.Broadcast()
+	n.logger.Infof("raft %s: installed snapshot %d from %s", n.cfg.ID, snapshot.Index, req.Leader)
+
+	return resp
+}
+
+// compactLocked drops the entries up to the snapshot from the log.
+func (n *Node) compactLocked(snapshot Snapshot) {
+	log := []Entry{{Index: snapshot.Index, Term: snapshot.Term}}
+	if snapshot.Index < n.lastIndex() {
+		log = append(log, n.log[snapshot.Index-n.log[0].Index+1:]...)
+	}
+	n.log = log
+	n.snapshot = snapshot
+}
+
+// applyLoop applies the committed entries and restores the installed snapshots.
+func (n *Node) applyLoop() {
+	defer n.wg.Done()
+
+	for {
+		n.mu.Lock()
+		for !n.stopped && n.restore == nil && n.lastApplied >= n.commitIndex {
+			n.applyCond.Wait()
+		}
+		if n.stopped {
+			n.mu.Unlock()
+			return
+		}
+
+		if snapshot := n.restore; snapshot != nil {
+			n.restore = nil
+			n.mu.Unlock()
+			if err := n.sm.Restore(snapshot.Data); err != nil {
+				n.logger.Errorf("raft %s: restore snapshot %d: %v", n.cfg.ID, snapshot.Index, err)
+			}
+			n.mu.Lock()
+			n.lastApplied = max(n.lastApplied, snapshot.Index)
+			n.mu.Unlock()
+			continue
+		}
+
+		from := n.lastApplied + 1 - n.log[0].Index
+		entries := append([]Entry(nil), n.log[from:n.commitIndex-n.log[0].Index+1]...)
+		n.mu.Unlock()
+
+		results := make([]error, len(entries))
+		for i, e := range entries {
+			if e.Log.Action != 0 {
+				results[i] = n.sm.Apply(e.Log)
+			}
+		}
+
+		n.mu.Lock()
+		last := entries[len(entries)-1]
+		n.lastApplied = max(n.lastApplied, last.Index)
+		for i, e := range entries {
+			w, ok := n.waiters[e.Index]
+			if !ok {
+				continue
+			}
+			delete(n.waiters, e.Index)
+			if w.term == e.Term {
+				w.done <- results[i]
+			} else {
+				w.done <- ErrLeadershipLost
+			}
+		}
+		snapshotDue := n.cfg.SnapshotThreshold > 0 && n.lastApplied-n.log[0].Index >= n.cfg.SnapshotThreshold
+		n.mu.Unlock()
+
+		if snapshotDue {
+			n.takeSnapshot(last.Index, last.Term)
+		}
+	}
+}
+
+// takeSnapshot saves the state machine, which has applied the entries up to index, and compacts the log.
+func (n *Node) takeSnapshot(index, term uint64) {
+	data, err := n.sm.Snapshot()
+	if err != nil {
+		n.logger.Errorf("raft %s: snapshot: %v", n.cfg.ID, err)
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if index <= n.log[0].Index {
+		return
+	}
+
+	snapshot := Snapshot{Index: index, Term: term, Data: data}
+	if err = n.store.SaveSnapshot(snapshot); err != nil {
+		n.logger.Errorf("raft %s: save snapshot: %v", n.cfg.ID, err)
+		return
+	}
+	n.compactLocked(snapshot)
+}
@@ -0,0 +1,459 @@
+package raft_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"jokedb/intetnal/raft"
+	"jokedb/intetnal/storage/engine"
+	"jokedb/intetnal/wal"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const (
+	electionTimeout   = 100 * time.Millisecond
+	heartbeatInterval = 10 * time.Millisecond
+	waitFor           = 5 * time.Second
+	tick              = 5 * time.Millisecond
+)
+
+// machine records the applied writes.
+type machine struct {
+	mu       sync.Mutex
+	logs     []wal.LogData
+	restores int
+}
+
+func (m *machine) Apply(log wal.LogData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.logs = append(m.logs, log)
+	return nil
+}
+
+func (m *machine) Snapshot() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(m.logs)
+	return buf.Bytes(), err
+}
+
+func (m *machine) Restore(data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.logs = nil
+	m.restores++
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(&m.logs)
+}
+
+func (m *machine) keys() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]string, 0, len(m.logs))
+	for _, log := range m.logs {
+		keys = append(keys, log.Key)
+	}
+	return keys
+}
+
+type cluster struct {
+	t        *testing.T
+	network  *raft.Network
+	peers    []raft.Peer
+	stores   map[string]raft.Store
+	nodes    map[string]*raft.Node
+	machines map[string]*machine
+}
+
+func newCluster(t *testing.T, size int, snapshotThreshold uint64) *cluster {
+	t.Helper()
+	c := &cluster{
+		t:        t,
+		network:  raft.NewNetwork(),
+		stores:   map[string]raft.Store{},
+		nodes:    map[string]*raft.Node{},
+		machines: map[string]*machine{},
+	}
+	for i := 1; i <= size; i++ {
+		c.peers = append(c.peers, raft.Peer{ID: "n" + strconv.Itoa(i)})
+	}
+	for _, p := range c.peers {
+		c.stores[p.ID] = raft.NewMemoryStore()
+		c.start(p.ID, snapshotThreshold)
+	}
+	t.Cleanup(func() {
+		for _, n := range c.nodes {
+			n.Stop()
+		}
+	})
+
+	return c
+}
+
+func (c *cluster) start(id string, snapshotThreshold uint64) {
+	c.t.Helper()
+	node, err := raft.NewNode(raft.Config{
+		ID:                id,
+		Peers:             c.peers,
+		ElectionTimeout:   electionTimeout,
+		HeartbeatInterval: heartbeatInterval,
+		SnapshotThreshold: snapshotThreshold,
+	}, c.stores[id], c.network.Transport(id), zap.NewNop().Sugar())
+	require.NoError(c.t, err)
+
+	m := &machine{}
+	c.network.Register(node)
+	require.NoError(c.t, node.Start(m))
+	c.nodes[id], c.machines[id] = node, m
+}
+
+// leader waits for a single leader among the connected nodes in except's absence.
+func (c *cluster) leader(except ...string) *raft.Node {
+	c.t.Helper()
+	var leader *raft.Node
+	require.Eventually(c.t, func() bool {
+		leader = nil
+		for id, n := range c.nodes {
+			if contains(except, id) || n.Status().State != raft.Leader {
+				continue
+			}
+			if leader != nil {
+				return false
+			}
+			leader = n
+		}
+		return leader != nil
+	}, waitFor, tick)
+
+	return leader
+}
+
+// propose writes keys through the current leader, a write is retried while the leadership changes
+// and then may be applied twice.
+func (c *cluster) propose(keys ...string) {
+	c.t.Helper()
+	for _, key := range keys {
+		require.Eventually(c.t, func() bool {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*electionTimeout)
+			defer cancel()
+			_, err := c.leader().Propose(ctx, wal.LogData{Action: engine.SET, Key: key, Value: "v"})
+			var notLeader *raft.NotLeaderError
+			if err != nil && !errors.As(err, &notLeader) && !errors.Is(err, raft.ErrLeadershipLost) &&
+				!errors.Is(err, context.DeadlineExceeded) {
+				require.NoError(c.t, err)
+			}
+			return err == nil
+		}, waitFor, tick)
+	}
+}
+
+// converged waits for every node to have applied the same writes, which include keys in order.
+func (c *cluster) converged(keys []string) {
+	c.t.Helper()
+	var applied []string
+	require.Eventually(c.t, func() bool {
+		applied = nil
+		for _, m := range c.machines {
+			if got := m.keys(); applied == nil {
+				applied = got
+			} else if fmt.Sprint(got) != fmt.Sprint(applied) {
+				return false
+			}
+		}
+		return true
+	}, waitFor, tick)
+
+	next := 0
+	for _, key := range applied {
+		if next < len(keys) && key == keys[next] {
+			next++
+		}
+	}
+	require.Equal(c.t, len(keys), next, "applied %v, want %v", applied, keys)
+}
+
+func contains(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func keyRange(from, to int) []string {
+	keys := make([]string, 0, to-from)
+	for i := from; i < to; i++ {
+		keys = append(keys, "k"+strconv.Itoa(i))
+	}
+	return keys
+}
+
+func TestNode_Election(t *testing.T) {
+	t.Parallel()
+	c := newCluster(t, 3, 0)
+
+	first := c.leader()
+	term := first.Status().Term
+
+	c.network.Disconnect(first.ID())
+	second := c.leader(first.ID())
+	require.NotEqual(t, first.ID(), second.ID())
+	require.Greater(t, second.Status().Term, term)
+
+	c.network.Connect(first.ID())
+	require.Eventually(t, func() bool {
+		s := first.Status()
+		return s.State == raft.Follower && s.Term >= second.Status().Term
+	}, waitFor, tick)
+	c.leader()
+}
+
+func TestNode_Replication(t *testing.T) {
+	t.Parallel()
+	c := newCluster(t, 3, 0)
+
+	keys := keyRange(0, 50)
+	c.propose(keys...)
+	c.converged(keys)
+	leader := c.leader()
+
+	for id, n := range c.nodes {
+		if id == leader.ID() {
+			continue
+		}
+		_, err := n.Propose(context.Background(), wal.LogData{Action: engine.SET, Key: "x", Value: "v"})
+		var notLeader *raft.NotLeaderError
+		require.ErrorAs(t, err, &notLeader)
+		require.Equal(t, leader.ID(), notLeader.Leader.ID)
+	}
+
+	s := leader.Status()
+	require.Equal(t, s.LastIndex, s.CommitIndex)
+	for _, match := range s.Match {
+		require.Equal(t, s.LastIndex, match)
+	}
+}
+
+func TestNode_Minority(t *testing.T) {
+	t.Parallel()
+	c := newCluster(t, 5, 0)
+	c.propose("a")
+	leader := c.leader()
+
+	var followers []string
+	for id := range c.nodes {
+		if id != leader.ID() && len(followers) < 3 {
+			followers = append(followers, id)
+			c.network.Disconnect(id)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*electionTimeout)
+	defer cancel()
+	_, err := leader.Propose(ctx, wal.LogData{Action: engine.SET, Key: "lost", Value: "v"})
+	require.True(t, errors.Is(err, context.DeadlineExceeded) || errors.Is(err, raft.ErrLeadershipLost), err)
+
+	for _, id := range followers {
+		c.network.Connect(id)
+	}
+
+	// the uncommitted write is kept or overwritten depending on who wins, but all logs agree
+	c.propose("b")
+	c.converged([]string{"a", "b"})
+}
+
+func TestNode_WaitReplicas(t *testing.T) {
+	t.Parallel()
+	c := newCluster(t, 3, 0)
+	leader := c.leader()
+
+	index, err := leader.Append(context.Background(), wal.LogData{Action: engine.SET, Key: "a", Value: "v"})
+	require.NoError(t, err)
+	require.NoError(t, leader.WaitReplicas(context.Background(), index, 2))
+	c.converged([]string{"a"})
+
+	for id, n := range c.nodes {
+		if id != leader.ID() {
+			var notLeader *raft.NotLeaderError
+			require.ErrorAs(t, n.WaitReplicas(context.Background(), index, 1), &notLeader)
+			_, err = n.Append(context.Background(), wal.LogData{Action: engine.SET, Key: "x", Value: "v"})
+			require.ErrorAs(t, err, &notLeader)
+		}
+	}
+
+	var lagging string
+	for id := range c.nodes {
+		if id != leader.ID() {
+			lagging = id
+			break
+		}
+	}
+	c.network.Disconnect(lagging)
+
+	// a majority commits the write, the disconnected follower never confirms it
+	index, err = leader.Propose(context.Background(), wal.LogData{Action: engine.SET, Key: "b", Value: "v"})
+	require.NoError(t, err)
+	require.NoError(t, leader.WaitReplicas(context.Background(), index, 1))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*electionTimeout)
+	defer cancel()
+	require.ErrorIs(t, leader.WaitReplicas(ctx, index, 2), context.DeadlineExceeded)
+
+	// the follower may have started an election meanwhile and then deposes the leader
+	c.network.Connect(lagging)
+	err = leader.WaitReplicas(context.Background(), index, 2)
+	require.True(t, err == nil || errors.Is(err, raft.ErrReplicasUnconfirmed), err)
+}
+
+func TestNode_Snapshot(t *testing.T) {
+	t.Parallel()
+	c := newCluster(t, 3, 10)
+	leader := c.leader()
+
+	var lagging string
+	for id := range c.nodes {
+		if id != leader.ID() {
+			lagging = id
+			break
+		}
+	}
+	c.network.Disconnect(lagging)
+
+	keys := keyRange(0, 60)
+	c.propose(keys...)
+	require.Eventually(t, func() bool {
+		return c.leader(lagging).Status().SnapshotIndex > 0
+	}, waitFor, tick)
+
+	c.network.Connect(lagging)
+	c.converged(keys)
+	m := c.machines[lagging]
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	require.Positive(t, m.restores)
+}
+
+func TestNode_Restart(t *testing.T) {
+	t.Parallel()
+	c := newCluster(t, 3, 20)
+
+	keys := keyRange(0, 30)
+	c.propose(keys...)
+	c.converged(keys)
+
+	// a restarted node restores its snapshot and is sent the committed entries after it
+	for id, n := range c.nodes {
+		n.Stop()
+		c.start(id, 20)
+	}
+	c.propose("after")
+	c.converged(append(keys, "after"))
+}
+
+func TestNode_DropMessages(t *testing.T) {
+	t.Parallel()
+	c := newCluster(t, 3, 0)
+	c.network.SetDropRate(0.2)
+
+	keys := keyRange(0, 30)
+	c.propose(keys...)
+	c.network.SetDropRate(0)
+	c.converged(keys)
+}
+
+func TestFileStore(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	s, err := raft.OpenFileStore(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.SetState(raft.HardState{Term: 3, VotedFor: "n2"}))
+	var entries []raft.Entry
+	for i := uint64(1); i <= 10; i++ {
+		entries = append(entries, raft.Entry{Index: i, Term: 3, Log: wal.LogData{Action: engine.SET, Key: strconv.Itoa(int(i))}})
+	}
+	require.NoError(t, s.Append(entries[:5]))
+	require.NoError(t, s.Append(entries[5:]))
+	require.NoError(t, s.TruncateFrom(9))
+	require.NoError(t, s.SaveSnapshot(raft.Snapshot{Index: 4, Term: 3, Data: []byte("data")}))
+	require.NoError(t, s.Append([]raft.Entry{{Index: 9, Term: 4}}))
+	require.NoError(t, s.Close())
+
+	s, err = raft.OpenFileStore(dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	state, snapshot, loaded, err := s.Load()
+	require.NoError(t, err)
+	require.Equal(t, raft.HardState{Term: 3, VotedFor: "n2"}, state)
+	require.Equal(t, raft.Snapshot{Index: 4, Term: 3, Data: []byte("data")}, snapshot)
+	require.Equal(t, append(entries[4:8:8], raft.Entry{Index: 9, Term: 4}), loaded)
+}
+
+func TestRPCTransport(t *testing.T) {
+	t.Parallel()
+
+	var peers []raft.Peer
+	var listeners []net.Listener
+	for i := 1; i <= 3; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		listeners = append(listeners, l)
+		peers = append(peers, raft.Peer{ID: "n" + strconv.Itoa(i), Addr: l.Addr().String()})
+	}
+
+	nodes := make([]*raft.Node, 0, len(peers))
+	machines := make([]*machine, 0, len(peers))
+	for i, p := range peers {
+		transport := raft.NewRPCTransport(peers)
+		node, err := raft.NewNode(raft.Config{
+			ID:                p.ID,
+			Peers:             peers,
+			ElectionTimeout:   4 * electionTimeout,
+			HeartbeatInterval: 4 * heartbeatInterval,
+		}, raft.NewMemoryStore(), transport, zap.NewNop().Sugar())
+		require.NoError(t, err)
+		m := &machine{}
+		require.NoError(t, node.Start(m))
+		go raft.Serve(listeners[i], node)
+		t.Cleanup(func() {
+			node.Stop()
+			transport.Close()
+		})
+		nodes, machines = append(nodes, node), append(machines, m)
+	}
+	t.Cleanup(func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	})
+
+	var leader *raft.Node
+	require.Eventually(t, func() bool {
+		for _, n := range nodes {
+			if n.Status().State == raft.Leader {
+				leader = n
+				return true
+			}
+		}
+		return false
+	}, waitFor, tick)
+
+	_, err := leader.Propose(context.Background(), wal.LogData{Action: engine.SET, Key: "a", Value: "1"})
+	require.NoError(t, err)
+	for _, m := range machines {
+		require.Eventually(t, func() bool { return fmt.Sprint(m.keys()) == "[a]" }, waitFor, tick)
+	}
+}
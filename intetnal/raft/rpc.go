@@ -0,0 +1,149 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+)
+
+const rpcService = "Raft"
+
+// Serve answers the raft requests for node on l with net/rpc until l is closed.
+func Serve(l net.Listener, node *Node) error {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(rpcService, &rpcHandler{node: node}); err != nil {
+		return err
+	}
+	srv.Accept(l)
+
+	return nil
+}
+
+type rpcHandler struct {
+	node *Node
+}
+
+func (h *rpcHandler) RequestVote(req *VoteRequest, resp *VoteResponse) error {
+	*resp = *h.node.HandleRequestVote(req)
+	return nil
+}
+
+func (h *rpcHandler) AppendEntries(req *AppendRequest, resp *AppendResponse) error {
+	*resp = *h.node.HandleAppendEntries(req)
+	return nil
+}
+
+func (h *rpcHandler) InstallSnapshot(req *SnapshotRequest, resp *SnapshotResponse) error {
+	*resp = *h.node.HandleInstallSnapshot(req)
+	return nil
+}
+
+// RPCTransport sends the requests with net/rpc to the Addr of the peers,
+// a connection is dialed on the first request and again after a failure.
+type RPCTransport struct {
+	addrs   map[string]string
+	mu      sync.Mutex
+	clients map[string]*rpc.Client
+}
+
+func NewRPCTransport(peers []Peer) *RPCTransport {
+	t := &RPCTransport{addrs: make(map[string]string, len(peers)), clients: map[string]*rpc.Client{}}
+	for _, p := range peers {
+		t.addrs[p.ID] = p.Addr
+	}
+
+	return t
+}
+
+func (t *RPCTransport) RequestVote(ctx context.Context, to string, req *VoteRequest) (*VoteResponse, error) {
+	resp := &VoteResponse{}
+	return resp, t.call(ctx, to, "RequestVote", req, resp)
+}
+
+func (t *RPCTransport) AppendEntries(ctx context.Context, to string, req *AppendRequest) (*AppendResponse, error) {
+	resp := &AppendResponse{}
+	return resp, t.call(ctx, to, "AppendEntries", req, resp)
+}
+
+func (t *RPCTransport) InstallSnapshot(ctx context.Context, to string, req *SnapshotRequest) (*SnapshotResponse, error) {
+	resp := &SnapshotResponse{}
+	return resp, t.call(ctx, to, "InstallSnapshot", req, resp)
+}
+
+func (t *RPCTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var errs []error
+	for id, c := range t.clients {
+		errs = append(errs, c.Close())
+		delete(t.clients, id)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (t *RPCTransport) call(ctx context.Context, to, method string, req, resp any) error {
+	client, err := t.client(ctx, to)
+	if err != nil {
+		return err
+	}
+
+	call := client.Go(rpcService+"."+method, req, resp, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		var serverErr rpc.ServerError
+		if call.Error != nil && !errors.As(call.Error, &serverErr) {
+			t.drop(to, client)
+		}
+		return call.Error
+	case <-ctx.Done():
+		// the connection may be stuck, the next request dials a new one
+		t.drop(to, client)
+		return ctx.Err()
+	}
+}
+
+func (t *RPCTransport) client(ctx context.Context, to string) (*rpc.Client, error) {
+	t.mu.Lock()
+	c, ok := t.clients[to]
+	addr, known := t.addrs[to]
+	t.mu.Unlock()
+	if ok {
+		return c, nil
+	}
+	if !known {
+		return nil, fmt.Errorf("%w: unknown node %s", ErrUnreachable, to)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, ok = t.clients[to]; ok {
+		// dialed concurrently by another request
+		conn.Close()
+		return c, nil
+	}
+	c = rpc.NewClient(conn)
+	t.clients[to] = c
+
+	return c, nil
+}
+
+func (t *RPCTransport) drop(to string, c *rpc.Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.clients[to] == c {
+		delete(t.clients, to)
+	}
+	c.Close()
+}
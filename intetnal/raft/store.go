@@ -0,0 +1,313 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	stateFile    = "state.json"
+	logFile      = "log"
+	snapshotFile = "snapshot"
+)
+
+// HardState is the part of the node state that must survive a restart besides the log.
+type HardState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"votedFor"`
+}
+
+// Snapshot is the state machine after applying the entries up to Index.
+type Snapshot struct {
+	Index uint64
+	Term  uint64
+	Data  []byte
+}
+
+// Store persists a node, every method returns after the data is durable.
+type Store interface {
+	// Load returns the state, the last snapshot and the entries after it.
+	Load() (HardState, Snapshot, []Entry, error)
+	SetState(s HardState) error
+	Append(entries []Entry) error
+	// TruncateFrom drops the entries from index on.
+	TruncateFrom(index uint64) error
+	// SaveSnapshot replaces the snapshot and drops the entries up to its index.
+	SaveSnapshot(s Snapshot) error
+}
+
+// MemoryStore keeps everything in memory, a node restarted with the same store continues
+// from where the previous one stopped.
+type MemoryStore struct {
+	mu       sync.Mutex
+	state    HardState
+	snapshot Snapshot
+	entries  []Entry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (m *MemoryStore) Load() (HardState, Snapshot, []Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state, m.snapshot, append([]Entry(nil), m.entries...), nil
+}
+
+func (m *MemoryStore) SetState(s HardState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = s
+	return nil
+}
+
+func (m *MemoryStore) Append(entries []Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, entries...)
+	return nil
+}
+
+func (m *MemoryStore) TruncateFrom(index uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = truncateFrom(m.entries, index)
+	return nil
+}
+
+func (m *MemoryStore) SaveSnapshot(s Snapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshot = s
+	m.entries = compact(m.entries, s.Index)
+	return nil
+}
+
+// FileStore keeps a node in a directory: the state as JSON, the snapshot, and the log as
+// appended gob batches that are rewritten on truncation and compaction.
+type FileStore struct {
+	mu      sync.Mutex
+	dir     string
+	log     *os.File
+	entries []Entry
+}
+
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	entries, size, err := readLog(filepath.Join(dir, logFile))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// drops a batch torn by a crash, it was never acknowledged
+	if err = f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err = f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &FileStore{dir: dir, log: f, entries: entries}, nil
+}
+
+// readLog returns the entries of the log file and the size of its decodable part.
+func readLog(path string) ([]Entry, int64, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entries []Entry
+	var size int64
+	buf := bytes.NewBuffer(data)
+	for buf.Len() > 0 {
+		var batch []Entry
+		if err = gob.NewDecoder(buf).Decode(&batch); err != nil {
+			break
+		}
+		entries = append(entries, batch...)
+		size = int64(len(data) - buf.Len())
+	}
+
+	return entries, size, nil
+}
+
+func (f *FileStore) Load() (HardState, Snapshot, []Entry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var state HardState
+	data, err := os.ReadFile(filepath.Join(f.dir, stateFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return HardState{}, Snapshot{}, nil, err
+	default:
+		if err = json.Unmarshal(data, &state); err != nil {
+			return HardState{}, Snapshot{}, nil, fmt.Errorf("decode %s: %w", stateFile, err)
+		}
+	}
+
+	var snapshot Snapshot
+	data, err = os.ReadFile(filepath.Join(f.dir, snapshotFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return HardState{}, Snapshot{}, nil, err
+	default:
+		if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+			return HardState{}, Snapshot{}, nil, fmt.Errorf("decode %s: %w", snapshotFile, err)
+		}
+	}
+
+	return state, snapshot, compact(append([]Entry(nil), f.entries...), snapshot.Index), nil
+}
+
+func (f *FileStore) SetState(s HardState) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return writeFileSync(filepath.Join(f.dir, stateFile), data)
+}
+
+func (f *FileStore) Append(entries []Entry) error {
+	data, err := encodeEntries(entries)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err = f.log.Write(data); err != nil {
+		return err
+	}
+	if err = f.log.Sync(); err != nil {
+		return err
+	}
+	f.entries = append(f.entries, entries...)
+
+	return nil
+}
+
+func (f *FileStore) TruncateFrom(index uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rewrite(truncateFrom(f.entries, index))
+}
+
+func (f *FileStore) SaveSnapshot(s Snapshot) error {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(s); err != nil {
+		return err
+	}
+	if err := writeFileSync(filepath.Join(f.dir, snapshotFile), buf.Bytes()); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rewrite(compact(f.entries, s.Index))
+}
+
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.log.Close()
+}
+
+// rewrite replaces the log file with entries.
+func (f *FileStore) rewrite(entries []Entry) error {
+	var data []byte
+	if len(entries) > 0 {
+		var err error
+		if data, err = encodeEntries(entries); err != nil {
+			return err
+		}
+	}
+
+	path := filepath.Join(f.dir, logFile)
+	if err := writeFileSync(path, data); err != nil {
+		return err
+	}
+	log, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	f.log.Close()
+	f.log = log
+	f.entries = entries
+
+	return nil
+}
+
+func encodeEntries(entries []Entry) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(entries); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeFileSync replaces the file through a synced temporary file.
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func truncateFrom(entries []Entry, index uint64) []Entry {
+	for i, e := range entries {
+		if e.Index >= index {
+			return entries[:i:i]
+		}
+	}
+
+	return entries
+}
+
+func compact(entries []Entry, index uint64) []Entry {
+	for i, e := range entries {
+		if e.Index > index {
+			return append([]Entry(nil), entries[i:]...)
+		}
+	}
+
+	return nil
+}
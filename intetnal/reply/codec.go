@@ -0,0 +1,127 @@
+package reply
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrIncomplete is returned by Decode for the beginning of a reply, the rest is still to be read.
+var ErrIncomplete = errors.New("incomplete reply")
+
+// Encode writes the reply, every part ends with a newline:
+//
+//	+<status>   $<length>\n<value>   _   :<integer>   *<count>\n<items>   -<CODE> <message>
+//
+// The newlines of a status or an error message are written as spaces.
+func (r Reply) Encode() []byte {
+	return r.AppendTo(nil)
+}
+
+func (r Reply) AppendTo(b []byte) []byte {
+	b = append(b, byte(r.Kind))
+	switch r.Kind {
+	case KindOK:
+		b = append(b, oneLine(r.Text)...)
+	case KindValue:
+		b = strconv.AppendInt(b, int64(len(r.Text)), 10)
+		b = append(b, '\n')
+		b = append(b, r.Text...)
+	case KindNil:
+	case KindInt:
+		b = strconv.AppendInt(b, r.Int, 10)
+	case KindArray:
+		b = strconv.AppendInt(b, int64(len(r.Array)), 10)
+		b = append(b, '\n')
+		for _, item := range r.Array {
+			b = item.AppendTo(b)
+		}
+		return b
+	case KindError:
+		b = append(b, oneLine(r.Err.Error())...)
+	}
+
+	return append(b, '\n')
+}
+
+func oneLine(s string) string {
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+// Decode reads the reply at the beginning of b and returns the number of bytes it takes.
+func Decode(b []byte) (Reply, int, error) {
+	line, n, err := readLine(b)
+	if err != nil {
+		return Reply{}, 0, err
+	}
+	if len(line) == 0 {
+		return Reply{}, 0, errors.New("empty reply")
+	}
+
+	kind, text := Kind(line[0]), string(line[1:])
+	switch kind {
+	case KindOK:
+		return OK(text), n, nil
+	case KindNil:
+		return Nil(), n, nil
+	case KindError:
+		return Reply{Kind: KindError, Err: parseError(text)}, n, nil
+	case KindInt:
+		i, errInt := strconv.ParseInt(text, 10, 64)
+		if errInt != nil {
+			return Reply{}, 0, fmt.Errorf("integer reply %q", text)
+		}
+		return Int(i), n, nil
+	case KindValue:
+		size, errSize := strconv.Atoi(text)
+		if errSize != nil || size < 0 {
+			return Reply{}, 0, fmt.Errorf("value length %q", text)
+		}
+		if len(b) < n+size+1 {
+			return Reply{}, 0, ErrIncomplete
+		}
+		if b[n+size] != '\n' {
+			return Reply{}, 0, errors.New("value does not end with a newline")
+		}
+		return Value(string(b[n : n+size])), n + size + 1, nil
+	case KindArray:
+		count, errCount := strconv.Atoi(text)
+		if errCount != nil || count < 0 {
+			return Reply{}, 0, fmt.Errorf("array length %q", text)
+		}
+		items := make([]Reply, 0, min(count, 1024))
+		for i := 0; i < count; i++ {
+			item, size, errItem := Decode(b[n:])
+			if errItem != nil {
+				return Reply{}, 0, errItem
+			}
+			items = append(items, item)
+			n += size
+		}
+		return Array(items...), n, nil
+	default:
+		return Reply{}, 0, fmt.Errorf("unknown reply type %q", line[0])
+	}
+}
+
+// Parse decodes b that holds exactly one reply.
+func Parse(b []byte) (Reply, error) {
+	r, n, err := Decode(b)
+	if err != nil {
+		return r, err
+	}
+	if n != len(b) {
+		return r, fmt.Errorf("%d bytes after the reply", len(b)-n)
+	}
+	return r, nil
+}
+
+func readLine(b []byte) ([]byte, int, error) {
+	i := bytes.IndexByte(b, '\n')
+	if i < 0 {
+		return nil, 0, ErrIncomplete
+	}
+	return b[:i], i + 1, nil
+}
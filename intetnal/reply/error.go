@@ -0,0 +1,60 @@
+package reply
+
+import "strings"
+
+// Code tells the kind of an error reply, it is the first word of the error on the wire.
+type Code string
+
+const (
+	// CodeErr is a failure that has no code of its own.
+	CodeErr  Code = "ERR"
+	NotFound Code = "NOTFOUND"
+	// Syntax is a command that cannot be parsed or has wrong arguments.
+	Syntax    Code = "SYNTAX"
+	WrongType Code = "WRONGTYPE"
+	// Busy is a write refused by an overloaded server, it can be retried.
+	Busy   Code = "BUSY"
+	NoAuth Code = "NOAUTH"
+	// ReadOnly is a write sent to a node that does not accept writes, such as a raft follower.
+	ReadOnly Code = "READONLY"
+
+	Moved       Code = "MOVED"
+	Ask         Code = "ASK"
+	ClusterDown Code = "CLUSTERDOWN"
+)
+
+var (
+	ErrNotFound  = &Error{Code: NotFound}
+	ErrSyntax    = &Error{Code: Syntax}
+	ErrWrongType = &Error{Code: WrongType}
+	ErrBusy      = &Error{Code: Busy}
+	ErrNoAuth    = &Error{Code: NoAuth}
+	ErrReadOnly  = &Error{Code: ReadOnly}
+)
+
+// Error is an error reply, errors.Is matches it with an Error of the same code without a message.
+type Error struct {
+	Code    Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return string(e.Code)
+	}
+	return string(e.Code) + " " + e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code && (t.Message == "" || t.Message == e.Message)
+}
+
+// parseError reads "CODE message", a line without a known code is a CodeErr.
+func parseError(line string) *Error {
+	code, message, _ := strings.Cut(line, " ")
+	if code == "" || strings.ToUpper(code) != code {
+		return &Error{Code: CodeErr, Message: line}
+	}
+	return &Error{Code: Code(code), Message: message}
+}
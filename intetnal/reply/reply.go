@@ -0,0 +1,91 @@
+package reply
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Kind is the type of a reply, it is also its first byte on the wire.
+type Kind byte
+
+const (
+	KindOK    Kind = '+'
+	KindValue Kind = '$'
+	KindNil   Kind = '_'
+	KindInt   Kind = ':'
+	KindArray Kind = '*'
+	KindError Kind = '-'
+)
+
+// Reply is the answer to a command: an ok status, a value, nil, an integer, an array of replies
+// or an error with a code.
+type Reply struct {
+	Kind Kind
+	// Text is the status of KindOK and the value of KindValue.
+	Text  string
+	Int   int64
+	Array []Reply
+	Err   *Error
+}
+
+// OK is a status such as "SET ok", it is one line.
+func OK(status string) Reply {
+	return Reply{Kind: KindOK, Text: status}
+}
+
+func Value(v string) Reply {
+	return Reply{Kind: KindValue, Text: v}
+}
+
+func Nil() Reply {
+	return Reply{Kind: KindNil}
+}
+
+func Int(n int64) Reply {
+	return Reply{Kind: KindInt, Int: n}
+}
+
+func Array(items ...Reply) Reply {
+	if items == nil {
+		items = []Reply{}
+	}
+	return Reply{Kind: KindArray, Array: items}
+}
+
+// Values is an array of the values.
+func Values(values []string) Reply {
+	items := make([]Reply, len(values))
+	for i, v := range values {
+		items[i] = Value(v)
+	}
+	return Array(items...)
+}
+
+// Fail is an error reply.
+func Fail(code Code, message string) Reply {
+	return Reply{Kind: KindError, Err: &Error{Code: code, Message: message}}
+}
+
+// String formats the reply as text: nil is (nil), an empty array (empty) and the items of an array are
+// one per line.
+func (r Reply) String() string {
+	switch r.Kind {
+	case KindNil:
+		return "(nil)"
+	case KindInt:
+		return strconv.FormatInt(r.Int, 10)
+	case KindArray:
+		if len(r.Array) == 0 {
+			return "(empty)"
+		}
+		lines := make([]string, len(r.Array))
+		for i, item := range r.Array {
+			lines[i] = item.String()
+		}
+		return strings.Join(lines, "\n")
+	case KindError:
+		return r.Err.Error()
+	default:
+		return r.Text
+	}
+}
@@ -0,0 +1,86 @@
+package reply_test
+
+import (
+	"errors"
+	"jokedb/intetnal/reply"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		r    reply.Reply
+		wire string
+		text string
+	}{
+		"ok":      {r: reply.OK("SET ok"), wire: "+SET ok\n", text: "SET ok"},
+		"value":   {r: reply.Value("no key"), wire: "$6\nno key\n", text: "no key"},
+		"lines":   {r: reply.Value("a\nb"), wire: "$3\na\nb\n", text: "a\nb"},
+		"nil":     {r: reply.Nil(), wire: "_\n", text: "(nil)"},
+		"integer": {r: reply.Int(-42), wire: ":-42\n", text: "-42"},
+		"empty":   {r: reply.Array(), wire: "*0\n", text: "(empty)"},
+		"array": {
+			r:    reply.Array(reply.Int(1), reply.Values([]string{"a", ""}), reply.Nil()),
+			wire: "*3\n:1\n*2\n$1\na\n$0\n\n_\n",
+			text: "1\na\n\n(nil)",
+		},
+		"error": {r: reply.Fail(reply.NotFound, "GET query :no key"), wire: "-NOTFOUND GET query :no key\n",
+			text: "NOTFOUND GET query :no key"},
+	}
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.wire, string(tt.r.Encode()))
+			require.Equal(t, tt.text, tt.r.String())
+
+			got, err := reply.Parse([]byte(tt.wire))
+			require.NoError(t, err)
+			require.Equal(t, tt.r, got)
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+	wire := reply.Array(reply.Value("v"), reply.OK("ok")).Encode()
+	for i := range wire {
+		_, _, err := reply.Decode(wire[:i])
+		require.ErrorIs(t, err, reply.ErrIncomplete, i)
+	}
+
+	// replies one after another, as the pushed messages of a subscriber
+	two := append(reply.Int(1).Encode(), reply.Nil().Encode()...)
+	r, n, err := reply.Decode(two)
+	require.NoError(t, err)
+	require.Equal(t, reply.Int(1), r)
+	_, err = reply.Parse(two)
+	require.Error(t, err)
+	r, err = reply.Parse(two[n:])
+	require.NoError(t, err)
+	require.Equal(t, reply.Nil(), r)
+
+	for _, bad := range []string{"\n", "?x\n", ":x\n", "$-1\n", "$3\nabcd", "*x\n"} {
+		_, err = reply.Parse([]byte(bad))
+		require.Error(t, err, bad)
+		require.NotErrorIs(t, err, reply.ErrIncomplete, bad)
+	}
+}
+
+func TestError(t *testing.T) {
+	t.Parallel()
+	r, err := reply.Parse([]byte("-BUSY pending write queue is full\n"))
+	require.NoError(t, err)
+	var target error = r.Err
+	require.ErrorIs(t, target, reply.ErrBusy)
+	require.NotErrorIs(t, target, reply.ErrNotFound)
+	require.Equal(t, "pending write queue is full", r.Err.Message)
+
+	wrapped := errors.Join(errors.New("send"), &reply.Error{Code: reply.ReadOnly, Message: "not the leader"})
+	require.ErrorIs(t, wrapped, reply.ErrReadOnly)
+	require.ErrorIs(t, wrapped, &reply.Error{Code: reply.ReadOnly, Message: "not the leader"})
+
+	r, err = reply.Parse([]byte("-something failed\n"))
+	require.NoError(t, err)
+	require.Equal(t, &reply.Error{Code: reply.CodeErr, Message: "something failed"}, r.Err)
+}
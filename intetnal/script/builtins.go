@@ -0,0 +1,183 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type builtin func(args []Value) (Value, error)
+
+var builtins = map[string]builtin{
+	// call is evaluated by the interpreter, it is here to be known as a function
+	"call": nil,
+
+	"+":   arith(func(a, b int64) (int64, error) { return a + b, nil }),
+	"-":   arith(func(a, b int64) (int64, error) { return a - b, nil }),
+	"*":   arith(func(a, b int64) (int64, error) { return a * b, nil }),
+	"/":   arith(div),
+	"mod": arith(mod),
+
+	"=":  equal,
+	"!=": func(args []Value) (Value, error) { v, err := equal(args); return v == false, err },
+	"<":  compare(func(a, b int64) bool { return a < b }),
+	">":  compare(func(a, b int64) bool { return a > b }),
+	"<=": compare(func(a, b int64) bool { return a <= b }),
+	">=": compare(func(a, b int64) bool { return a >= b }),
+	"not": func(args []Value) (Value, error) {
+		if len(args) != 1 {
+			return nil, errors.New("takes one argument")
+		}
+		return !truthy(args[0]), nil
+	},
+	"nil?": func(args []Value) (Value, error) {
+		if len(args) != 1 {
+			return nil, errors.New("takes one argument")
+		}
+		return args[0] == nil, nil
+	},
+
+	"str": func(args []Value) (Value, error) {
+		b := strings.Builder{}
+		for _, v := range args {
+			b.WriteString(String(v))
+		}
+		return b.String(), nil
+	},
+	"int": func(args []Value) (Value, error) {
+		if len(args) != 1 {
+			return nil, errors.New("takes one argument")
+		}
+		return toInt(args[0])
+	},
+	"len": func(args []Value) (Value, error) {
+		if len(args) != 1 {
+			return nil, errors.New("takes one argument")
+		}
+		switch v := args[0].(type) {
+		case nil:
+			return int64(0), nil
+		case []Value:
+			return int64(len(v)), nil
+		default:
+			return int64(len(String(v))), nil
+		}
+	},
+	"list": func(args []Value) (Value, error) {
+		return append([]Value{}, args...), nil
+	},
+	"nth": func(args []Value) (Value, error) {
+		if len(args) != 2 {
+			return nil, errors.New("takes a list and an index")
+		}
+		items, ok := args[0].([]Value)
+		if !ok && args[0] != nil {
+			return nil, errors.New("the first argument must be a list")
+		}
+		i, err := toInt(args[1])
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(items)) {
+			return nil, nil
+		}
+		return items[i], nil
+	},
+	"split": func(args []Value) (Value, error) {
+		if len(args) != 2 {
+			return nil, errors.New("takes a string and a separator")
+		}
+		if args[0] == nil {
+			return []Value{}, nil
+		}
+		return strings2values(strings.Split(String(args[0]), String(args[1]))), nil
+	},
+	"error": func(args []Value) (Value, error) {
+		msg := make([]string, len(args))
+		for i, v := range args {
+			msg[i] = String(v)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrScript, strings.Join(msg, " "))
+	},
+}
+
+func toInt(v Value) (int64, error) {
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not an integer", v)
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("%s is not an integer", String(v))
+	}
+}
+
+func arith(op func(a, b int64) (int64, error)) builtin {
+	return func(args []Value) (Value, error) {
+		if len(args) == 0 {
+			return nil, errors.New("takes at least one argument")
+		}
+		acc, err := toInt(args[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, arg := range args[1:] {
+			n, errInt := toInt(arg)
+			if errInt != nil {
+				return nil, errInt
+			}
+			if acc, err = op(acc, n); err != nil {
+				return nil, err
+			}
+		}
+		return acc, nil
+	}
+}
+
+func div(a, b int64) (int64, error) {
+	if b == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return a / b, nil
+}
+
+func mod(a, b int64) (int64, error) {
+	if b == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return a % b, nil
+}
+
+// equal compares the values as strings, nil equals only nil.
+func equal(args []Value) (Value, error) {
+	if len(args) != 2 {
+		return nil, errors.New("takes two arguments")
+	}
+	a, b := args[0], args[1]
+	if a == nil || b == nil {
+		return a == nil && b == nil, nil
+	}
+	return String(a) == String(b), nil
+}
+
+func compare(op func(a, b int64) bool) builtin {
+	return func(args []Value) (Value, error) {
+		if len(args) != 2 {
+			return nil, errors.New("takes two arguments")
+		}
+		a, err := toInt(args[0])
+		if err != nil {
+			return nil, err
+		}
+		b, err := toInt(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return op(a, b), nil
+	}
+}
@@ -0,0 +1,91 @@
+package script
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+)
+
+var ErrNoScript = errors.New("no script with this sha, use EVAL or SCRIPT LOAD")
+
+// SHA returns the hex SHA1 of the source of a script, its name in the Cache.
+func SHA(src string) string {
+	sum := sha1.Sum([]byte(src))
+	return hex.EncodeToString(sum[:])
+}
+
+// Cache keeps the parsed scripts by their SHA until Flush. Once the sources of the kept scripts are
+// over its size, a new script evicts others, clients load it again with EVAL.
+type Cache struct {
+	mu      sync.RWMutex
+	scripts map[string]cached
+	size    int
+	maxSize int
+}
+
+type cached struct {
+	script *Script
+	size   int
+}
+
+// NewCache returns a cache of scripts whose sources are up to maxSize bytes, 0 for no limit.
+func NewCache(maxSize int) *Cache {
+	return &Cache{scripts: map[string]cached{}, maxSize: maxSize}
+}
+
+// Load parses src and keeps it, a script that is kept already is not parsed again. A script
+// longer than the size of the cache runs without being kept.
+func (c *Cache) Load(src string) (string, *Script, error) {
+	sha := SHA(src)
+	if s, err := c.Get(sha); err == nil {
+		return sha, s, nil
+	}
+	s, err := Parse(src)
+	if err != nil {
+		return "", nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.scripts[sha]; ok || (c.maxSize > 0 && len(src) > c.maxSize) {
+		return sha, s, nil
+	}
+	// the map order is random, the evicted scripts are too
+	for key, old := range c.scripts {
+		if c.maxSize <= 0 || c.size+len(src) <= c.maxSize {
+			break
+		}
+		delete(c.scripts, key)
+		c.size -= old.size
+	}
+	c.scripts[sha] = cached{script: s, size: len(src)}
+	c.size += len(src)
+
+	return sha, s, nil
+}
+
+func (c *Cache) Get(sha string) (*Script, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	s, ok := c.scripts[strings.ToLower(sha)]
+	if !ok {
+		return nil, ErrNoScript
+	}
+	return s.script, nil
+}
+
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scripts = map[string]cached{}
+	c.size = 0
+}
+
+func (c *Cache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.scripts)
+}
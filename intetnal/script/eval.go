@@ -0,0 +1,347 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MaxDepth is the deepest nesting of the lists of a script and of the lists it makes, a deeper one
+// is an error rather than a recursion that could exhaust the stack.
+const MaxDepth = 1000
+
+var (
+	ErrSyntax = errors.New("syntax error")
+	// ErrScript is returned by the error function and by a wrong use of the builtins.
+	ErrScript = errors.New("script error")
+	// ErrValueTooLarge stops a script that makes a value over Env.MaxValueSize.
+	ErrValueTooLarge = errors.New("script value too large")
+)
+
+// Value is nil, a bool, an int64, a string or a []Value.
+type Value any
+
+// CallFunc runs a command of the server for the call function. A nil value with a nil error
+// means the command answered nothing, such as GET of a missing key.
+type CallFunc func(ctx context.Context, args []string) (Value, error)
+
+// Env is what a script sees of the server: the KEYS and ARGV lists and the call function.
+type Env struct {
+	Keys []string
+	Argv []string
+	Call CallFunc
+	// MaxValueSize limits the bytes of a value made by a function or answered by call, a list counts
+	// the bytes of its items. 0 is no limit.
+	MaxValueSize int
+}
+
+// Run evaluates the script and returns the value of its last expression. It stops with
+// context.Cause of ctx when ctx is done, before every function call and every loop iteration.
+func (s *Script) Run(ctx context.Context, env Env) (Value, error) {
+	in := &interp{ctx: ctx, call: env.Call, maxSize: env.MaxValueSize}
+	sc := &scope{vars: map[string]Value{"KEYS": strings2values(env.Keys), "ARGV": strings2values(env.Argv)}}
+
+	return in.evalBody(s.body, sc)
+}
+
+func strings2values(s []string) []Value {
+	values := make([]Value, len(s))
+	for i, v := range s {
+		values[i] = v
+	}
+	return values
+}
+
+type scope struct {
+	vars   map[string]Value
+	parent *scope
+}
+
+func (s *scope) lookup(name string) (*scope, bool) {
+	for sc := s; sc != nil; sc = sc.parent {
+		if _, ok := sc.vars[name]; ok {
+			return sc, true
+		}
+	}
+	return nil, false
+}
+
+type interp struct {
+	ctx     context.Context
+	call    CallFunc
+	maxSize int
+	depth   int
+}
+
+func (in *interp) check() error {
+	if in.ctx.Err() != nil {
+		return context.Cause(in.ctx)
+	}
+	return nil
+}
+
+func (in *interp) evalBody(body []*node, sc *scope) (Value, error) {
+	var v Value
+	var err error
+	for _, n := range body {
+		if v, err = in.eval(n, sc); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func (in *interp) eval(n *node, sc *scope) (Value, error) {
+	switch {
+	case n.isName:
+		if owner, ok := sc.lookup(n.name); ok {
+			return owner.vars[n.name], nil
+		}
+		return nil, errorf(n, "unknown name %s", n.name)
+	case !n.isList:
+		return n.value, nil
+	case len(n.list) == 0:
+		return nil, nil
+	}
+	if err := in.check(); err != nil {
+		return nil, err
+	}
+	if in.depth++; in.depth > MaxDepth {
+		return nil, errorf(n, "expressions nested deeper than %d", MaxDepth)
+	}
+	defer func() { in.depth-- }()
+
+	head := n.list[0]
+	if !head.isName {
+		return nil, errorf(n, "want a function name first")
+	}
+	args := n.list[1:]
+	switch head.name {
+	case "do":
+		return in.evalBody(args, &scope{vars: map[string]Value{}, parent: sc})
+	case "if":
+		return in.evalIf(n, args, sc)
+	case "let":
+		return in.evalLet(n, args, sc)
+	case "set!":
+		return in.evalSet(n, args, sc)
+	case "while":
+		return in.evalWhile(n, args, sc)
+	case "and", "or":
+		return in.evalLogic(head.name, args, sc)
+	}
+
+	fn, ok := builtins[head.name]
+	if !ok {
+		return nil, errorf(n, "unknown function %s", head.name)
+	}
+	values := make([]Value, len(args))
+	for i, arg := range args {
+		v, err := in.eval(arg, sc)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	var v Value
+	var err error
+	if head.name == "call" {
+		v, err = in.evalCall(n, values)
+	} else if v, err = fn(values); err != nil && !errors.Is(err, ErrScript) {
+		return nil, errorf(n, "%s: %v", head.name, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return v, in.checkValue(n, head.name, v)
+}
+
+func (in *interp) checkValue(n *node, name string, v Value) error {
+	limit := in.maxSize
+	if limit <= 0 {
+		limit = math.MaxInt
+	}
+	size, ok := valueSize(v, limit, 0)
+	if !ok {
+		return errorf(n, "%s made lists nested deeper than %d", name, MaxDepth)
+	}
+	if size > limit {
+		return fmt.Errorf("%w: line %d: %s made a value over %d bytes", ErrValueTooLarge, n.line, name, in.maxSize)
+	}
+	return nil
+}
+
+// valueSize returns the bytes of v, it stops counting a list once it is over limit. It is not ok
+// for lists nested deeper than MaxDepth, which it does not count.
+func valueSize(v Value, limit, depth int) (int, bool) {
+	switch v := v.(type) {
+	case nil:
+		return 0, true
+	case string:
+		return len(v), true
+	case []Value:
+		if depth++; depth > MaxDepth {
+			return 0, false
+		}
+		size := 0
+		for _, item := range v {
+			n, ok := valueSize(item, limit-size, depth)
+			if !ok {
+				return 0, false
+			}
+			if size += n; size > limit {
+				break
+			}
+		}
+		return size, true
+	default:
+		return len(String(v)), true
+	}
+}
+
+// (if cond then [else])
+func (in *interp) evalIf(n *node, args []*node, sc *scope) (Value, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, errorf(n, "if takes a condition, a then and an optional else")
+	}
+	cond, err := in.eval(args[0], sc)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(cond) {
+		return in.eval(args[1], sc)
+	}
+	if len(args) == 3 {
+		return in.eval(args[2], sc)
+	}
+	return nil, nil
+}
+
+// (let ((name value)...) body...)
+func (in *interp) evalLet(n *node, args []*node, sc *scope) (Value, error) {
+	if len(args) == 0 || !args[0].isList {
+		return nil, errorf(n, "let takes a list of (name value) bindings and a body")
+	}
+	inner := &scope{vars: map[string]Value{}, parent: sc}
+	for _, b := range args[0].list {
+		if !b.isList || len(b.list) != 2 || !b.list[0].isName {
+			return nil, errorf(b, "a let binding is (name value)")
+		}
+		v, err := in.eval(b.list[1], inner)
+		if err != nil {
+			return nil, err
+		}
+		inner.vars[b.list[0].name] = v
+	}
+
+	return in.evalBody(args[1:], inner)
+}
+
+// (set! name value) changes a variable of let.
+func (in *interp) evalSet(n *node, args []*node, sc *scope) (Value, error) {
+	if len(args) != 2 || !args[0].isName {
+		return nil, errorf(n, "set! takes a name and a value")
+	}
+	owner, ok := sc.lookup(args[0].name)
+	if !ok {
+		return nil, errorf(n, "unknown name %s", args[0].name)
+	}
+	v, err := in.eval(args[1], sc)
+	if err != nil {
+		return nil, err
+	}
+	owner.vars[args[0].name] = v
+
+	return v, nil
+}
+
+// (while cond body...) returns the value of the last iteration.
+func (in *interp) evalWhile(n *node, args []*node, sc *scope) (Value, error) {
+	if len(args) == 0 {
+		return nil, errorf(n, "while takes a condition and a body")
+	}
+	var last Value
+	for {
+		if err := in.check(); err != nil {
+			return nil, err
+		}
+		cond, err := in.eval(args[0], sc)
+		if err != nil {
+			return nil, err
+		}
+		if !truthy(cond) {
+			return last, nil
+		}
+		if last, err = in.evalBody(args[1:], sc); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// (and a b...) and (or a b...) return the first value that decides the result.
+func (in *interp) evalLogic(op string, args []*node, sc *scope) (Value, error) {
+	var v Value = op == "and"
+	for _, arg := range args {
+		var err error
+		if v, err = in.eval(arg, sc); err != nil {
+			return nil, err
+		}
+		if truthy(v) == (op == "or") {
+			return v, nil
+		}
+	}
+	return v, nil
+}
+
+// (call "COMMAND" arg...) runs a command of the server.
+func (in *interp) evalCall(n *node, values []Value) (Value, error) {
+	if len(values) == 0 {
+		return nil, errorf(n, "call takes a command")
+	}
+	if in.call == nil {
+		return nil, errorf(n, "call is not available")
+	}
+	args := make([]string, len(values))
+	for i, v := range values {
+		args[i] = String(v)
+	}
+
+	return in.call(in.ctx, args)
+}
+
+func errorf(n *node, format string, args ...any) error {
+	return fmt.Errorf("%w: line %d: %s", ErrScript, n.line, fmt.Sprintf(format, args...))
+}
+
+func truthy(v Value) bool {
+	return v != nil && v != false
+}
+
+// String formats v as an argument of a command: nil is empty, a list is its items separated by spaces.
+func String(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case []Value:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = String(item)
+		}
+		return strings.Join(items, " ")
+	default:
+		return fmt.Sprint(v)
+	}
+}
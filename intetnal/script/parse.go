@@ -0,0 +1,173 @@
+package script
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// node is an expression of a script: a literal, a name or a list.
+type node struct {
+	line   int
+	name   string
+	value  Value
+	list   []*node
+	isList bool
+	isName bool
+}
+
+// Script is a parsed program, the value of its last expression is its result.
+type Script struct {
+	body []*node
+}
+
+// Parse reads the expressions of src. A ; starts a comment to the end of the line.
+func Parse(src string) (*Script, error) {
+	p := &reader{src: []rune(src), line: 1}
+	s := &Script{}
+	for {
+		p.skipSpace()
+		if p.eof() {
+			break
+		}
+		n, err := p.read()
+		if err != nil {
+			return nil, err
+		}
+		s.body = append(s.body, n)
+	}
+	if len(s.body) == 0 {
+		return nil, fmt.Errorf("%w: empty script", ErrSyntax)
+	}
+
+	return s, nil
+}
+
+type reader struct {
+	src   []rune
+	pos   int
+	line  int
+	depth int
+}
+
+func (r *reader) eof() bool {
+	return r.pos >= len(r.src)
+}
+
+func (r *reader) skipSpace() {
+	for !r.eof() {
+		c := r.src[r.pos]
+		switch {
+		case c == '\n':
+			r.line++
+		case c == ';':
+			for !r.eof() && r.src[r.pos] != '\n' {
+				r.pos++
+			}
+			continue
+		case !unicode.IsSpace(c):
+			return
+		}
+		r.pos++
+	}
+}
+
+func (r *reader) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: line %d: %s", ErrSyntax, r.line, fmt.Sprintf(format, args...))
+}
+
+func (r *reader) read() (*node, error) {
+	line := r.line
+	switch c := r.src[r.pos]; c {
+	case '(':
+		if r.depth++; r.depth > MaxDepth {
+			return nil, r.errorf("lists nested deeper than %d", MaxDepth)
+		}
+		defer func() { r.depth-- }()
+		r.pos++
+		n := &node{line: line, isList: true}
+		for {
+			r.skipSpace()
+			if r.eof() {
+				return nil, r.errorf("unclosed (")
+			}
+			if r.src[r.pos] == ')' {
+				r.pos++
+				return n, nil
+			}
+			item, err := r.read()
+			if err != nil {
+				return nil, err
+			}
+			n.list = append(n.list, item)
+		}
+	case ')':
+		return nil, r.errorf("unexpected )")
+	case '"':
+		return r.readString()
+	default:
+		return r.readAtom()
+	}
+}
+
+func (r *reader) readString() (*node, error) {
+	line := r.line
+	r.pos++
+	b := strings.Builder{}
+	for !r.eof() {
+		c := r.src[r.pos]
+		r.pos++
+		switch c {
+		case '"':
+			return &node{line: line, value: b.String()}, nil
+		case '\n':
+			r.line++
+		case '\\':
+			if r.eof() {
+				return nil, r.errorf("unclosed string")
+			}
+			c = r.src[r.pos]
+			r.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			}
+		}
+		b.WriteRune(c)
+	}
+
+	return nil, r.errorf("unclosed string")
+}
+
+func (r *reader) readAtom() (*node, error) {
+	start := r.pos
+	for !r.eof() {
+		c := r.src[r.pos]
+		if unicode.IsSpace(c) || c == '(' || c == ')' || c == '"' || c == ';' {
+			break
+		}
+		r.pos++
+	}
+	atom := string(r.src[start:r.pos])
+	n := &node{line: r.line}
+	switch atom {
+	case "nil":
+		return n, nil
+	case "true":
+		n.value = true
+		return n, nil
+	case "false":
+		n.value = false
+		return n, nil
+	}
+	if i, err := strconv.ParseInt(atom, 10, 64); err == nil {
+		n.value = i
+		return n, nil
+	}
+	n.name, n.isName = atom, true
+
+	return n, nil
+}
@@ -0,0 +1,235 @@
+package script_test
+
+import (
+	"context"
+	"errors"
+	"jokedb/intetnal/script"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func run(t *testing.T, src string, env script.Env) (script.Value, error) {
+	t.Helper()
+	s, err := script.Parse(src)
+	require.NoError(t, err)
+	return s.Run(context.Background(), env)
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		src  string
+		want script.Value
+	}{
+		"arith":   {src: `(+ 1 (* 2 3) (- 10 4) (/ 9 2) (mod 9 2))`, want: int64(18)},
+		"strings": {src: `(str "a" 1 nil " \"b\"")`, want: `a1 "b"`},
+		"if":      {src: `(if (> (len "abc") 2) "long" "short")`, want: "long"},
+		"if_nil":  {src: `(if false 1)`, want: nil},
+		"let": {src: `
+			; sums 1..10
+			(let ((i 0) (sum 0))
+			  (while (< i 10)
+			    (set! i (+ i 1))
+			    (set! sum (+ sum i)))
+			  sum)`, want: int64(55)},
+		"logic":    {src: `(list (and 1 nil 2) (or nil "x") (not nil) (= "1" 1) (!= nil ""))`, want: []script.Value{nil, "x", true, true, true}},
+		"keys":     {src: `(list (nth KEYS 0) (nth ARGV 1) (nth ARGV 5) (len KEYS))`, want: []script.Value{"k", "b", nil, int64(1)}},
+		"split":    {src: `(nth (split "a,b,c" ",") 2)`, want: "c"},
+		"last":     {src: `1 2 (int "3")`, want: int64(3)},
+		"empty":    {src: `()`, want: nil},
+		"shadowed": {src: `(let ((a 1)) (let ((a 2)) a))`, want: int64(2)},
+	}
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := run(t, tt.src, script.Env{Keys: []string{"k"}, Argv: []string{"a", "b"}})
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	t.Parallel()
+	for _, src := range []string{"(", ")", `"abc`, ""} {
+		_, err := script.Parse(src)
+		require.ErrorIs(t, err, script.ErrSyntax, src)
+	}
+
+	cases := map[string]string{
+		`(undefined 1)`:      "unknown function undefined",
+		`(+ 1 x)`:            "unknown name x",
+		`(+ 1 "a")`:          `"a" is not an integer`,
+		`(/ 1 0)`:            "division by zero",
+		"\n(error \"no\" 1)": "script error: no 1",
+		`(set! y 1)`:         "unknown name y",
+		`(call "GET" "k")`:   "call is not available",
+	}
+	for src, want := range cases {
+		_, err := run(t, src, script.Env{})
+		require.ErrorIs(t, err, script.ErrScript, src)
+		require.ErrorContains(t, err, want, src)
+	}
+	_, err := run(t, "\n\n(nth 1 2)", script.Env{})
+	require.ErrorContains(t, err, "line 3")
+}
+
+func TestRun_MaxDepth(t *testing.T) {
+	t.Parallel()
+	// a nesting that would exhaust the stack is a syntax error
+	_, err := script.Parse(strings.Repeat("(", 5_000_000))
+	require.ErrorIs(t, err, script.ErrSyntax)
+	require.ErrorContains(t, err, "nested deeper than 1000")
+
+	nested := func(depth int) string {
+		return strings.Repeat("(list ", depth) + "1" + strings.Repeat(")", depth)
+	}
+	v, err := run(t, nested(script.MaxDepth), script.Env{})
+	require.NoError(t, err)
+	require.NotNil(t, v)
+	_, err = script.Parse(nested(script.MaxDepth + 1))
+	require.ErrorIs(t, err, script.ErrSyntax)
+
+	// the lists a loop nests are limited as well
+	_, err = run(t, `(let ((v 1) (i 0)) (while (< i 2000) (set! v (list v)) (set! i (+ i 1))) v)`, script.Env{})
+	require.ErrorIs(t, err, script.ErrScript)
+	require.ErrorContains(t, err, "list made lists nested deeper than 1000")
+}
+
+func TestRun_Call(t *testing.T) {
+	t.Parallel()
+	data := map[string]string{"src": "v"}
+	var calls []string
+	env := script.Env{
+		Keys: []string{"src", "dst"},
+		Call: func(_ context.Context, args []string) (script.Value, error) {
+			calls = append(calls, strings.Join(args, " "))
+			switch args[0] {
+			case "GET":
+				if v, ok := data[args[1]]; ok {
+					return v, nil
+				}
+				return nil, nil
+			case "SET":
+				data[args[1]] = args[2]
+				return "SET ok", nil
+			case "DEL":
+				delete(data, args[1])
+				return "DEL ok", nil
+			}
+			return nil, errors.New("unknown command")
+		},
+	}
+	got, err := run(t, `
+		(let ((v (call "GET" (nth KEYS 0))))
+		  (if (= v "v")
+		    (do (call "DEL" (nth KEYS 0)) (call "SET" (nth KEYS 1) v) 1)
+		    0))`, env)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), got)
+	require.Equal(t, map[string]string{"dst": "v"}, data)
+	require.Equal(t, []string{"GET src", "DEL src", "SET dst v"}, calls)
+
+	_, err = run(t, `(call "INCR" "k")`, env)
+	require.EqualError(t, err, "unknown command")
+}
+
+func TestRun_MaxValueSize(t *testing.T) {
+	t.Parallel()
+	env := script.Env{
+		MaxValueSize: 10,
+		Call: func(_ context.Context, args []string) (script.Value, error) {
+			return strings.Repeat("v", len(args[1])), nil
+		},
+	}
+
+	got, err := run(t, `(list (str "ab" "cde") (call "GET" "01234"))`, env)
+	require.NoError(t, err)
+	require.Equal(t, []script.Value{"abcde", "vvvvv"}, got)
+
+	for _, src := range []string{
+		`(str "abcde" "fghijk")`,
+		`(let ((s "a")) (while true (set! s (str s s))))`,
+		`(list "abcde" "fghijk")`,
+		`(call "GET" "01234567890")`,
+	} {
+		_, err = run(t, src, env)
+		require.ErrorIs(t, err, script.ErrValueTooLarge, src)
+	}
+
+	env.MaxValueSize = 0
+	got, err = run(t, `(len (str "abcde" "fghijk"))`, env)
+	require.NoError(t, err)
+	require.Equal(t, int64(11), got)
+}
+
+func TestRun_Timeout(t *testing.T) {
+	t.Parallel()
+	s, err := script.Parse(`(while true 1)`)
+	require.NoError(t, err)
+
+	errKilled := errors.New("killed")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	time.AfterFunc(10*time.Millisecond, func() { cancel(errKilled) })
+	_, err = s.Run(ctx, script.Env{})
+	require.ErrorIs(t, err, errKilled)
+
+	ctx, cancelTimeout := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelTimeout()
+	_, err = s.Run(ctx, script.Env{})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+	c := script.NewCache(0)
+	sha, s, err := c.Load(`(+ 1 2)`)
+	require.NoError(t, err)
+	require.Equal(t, script.SHA(`(+ 1 2)`), sha)
+	require.Len(t, sha, 40)
+
+	got, err := c.Get(strings.ToUpper(sha))
+	require.NoError(t, err)
+	require.Same(t, s, got)
+	_, _, err = c.Load(`(+ 1`)
+	require.ErrorIs(t, err, script.ErrSyntax)
+	require.Equal(t, 1, c.Len())
+
+	c.Flush()
+	_, err = c.Get(sha)
+	require.ErrorIs(t, err, script.ErrNoScript)
+}
+
+func TestCache_MaxSize(t *testing.T) {
+	t.Parallel()
+	c := script.NewCache(20)
+	first, _, err := c.Load(`(+ 1 1)`)
+	require.NoError(t, err)
+	_, _, err = c.Load(`(+ 1 2)`)
+	require.NoError(t, err)
+	require.Equal(t, 2, c.Len())
+
+	// a third script is over the size, it evicts one of the others
+	third, _, err := c.Load(`(+ 1 3)`)
+	require.NoError(t, err)
+	require.Equal(t, 2, c.Len())
+	_, err = c.Get(third)
+	require.NoError(t, err)
+
+	// a script longer than the cache runs without evicting any
+	long := `(str "` + strings.Repeat("a", 30) + `")`
+	sha, s, err := c.Load(long)
+	require.NoError(t, err)
+	require.NotNil(t, s)
+	_, err = c.Get(sha)
+	require.ErrorIs(t, err, script.ErrNoScript)
+	require.Equal(t, 2, c.Len())
+
+	c.Flush()
+	_, _, err = c.Load(`(+ 1 1)`)
+	require.NoError(t, err)
+	_, err = c.Get(first)
+	require.NoError(t, err)
+}
@@ -1,11 +1,15 @@
 package semaphore
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 type Semaphore struct {
-	limit uint
-	max   uint
-	cond  *sync.Cond
+	limit   uint
+	max     uint
+	waiting uint
+	cond    *sync.Cond
 }
 
 func New(limit uint) *Semaphore {
@@ -19,16 +23,79 @@ func (s *Semaphore) Acquire() {
 	s.cond.L.Lock()
 	defer s.cond.L.Unlock()
 
+	s.waiting++
 	for s.max >= s.limit {
 		s.cond.Wait()
 	}
+	s.waiting--
 
 	s.max++
 }
 
+// AcquireContext waits for a slot until ctx is done, then it returns the error of ctx.
+func (s *Semaphore) AcquireContext(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		s.cond.L.Lock()
+		defer s.cond.L.Unlock()
+		s.cond.Broadcast()
+	})
+	defer stop()
+
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+
+	if err := ctx.Err(); err != nil && s.max >= s.limit {
+		return err
+	}
+
+	s.waiting++
+	defer func() { s.waiting-- }()
+	for s.max >= s.limit {
+		s.cond.Wait()
+		if err := ctx.Err(); err != nil {
+			if s.max < s.limit {
+				// pass on a Release signal this waiter may have taken
+				s.cond.Signal()
+			}
+			return err
+		}
+	}
+
+	s.max++
+	return nil
+}
+
+// TryAcquire takes a slot if one is free without waiting.
+func (s *Semaphore) TryAcquire() bool {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+
+	if s.max >= s.limit {
+		return false
+	}
+	s.max++
+	return true
+}
+
 func (s *Semaphore) Release() {
 	s.cond.L.Lock()
 	defer s.cond.L.Unlock()
 	s.max--
 	s.cond.Signal()
 }
+
+// SetLimit changes the limit. Holders above a lowered limit keep their slots
+// until they release them.
+func (s *Semaphore) SetLimit(limit uint) {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	s.limit = limit
+	s.cond.Broadcast()
+}
+
+// Stats returns the number of the held slots and of the callers waiting for one.
+func (s *Semaphore) Stats() (active, waiting uint) {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	return s.max, s.waiting
+}
@@ -0,0 +1,38 @@
+package semaphore_test
+
+import (
+	"context"
+	"jokedb/intetnal/semaphore"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSemaphore(t *testing.T) {
+	s := semaphore.New(1)
+	require.True(t, s.TryAcquire())
+	require.False(t, s.TryAcquire())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, s.AcquireContext(ctx), context.DeadlineExceeded)
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- s.AcquireContext(context.Background())
+	}()
+	require.Eventually(t, func() bool {
+		_, waiting := s.Stats()
+		return waiting == 1
+	}, time.Second, time.Millisecond)
+
+	s.Release()
+	require.NoError(t, <-acquired)
+	active, waiting := s.Stats()
+	require.Equal(t, uint(1), active)
+	require.Equal(t, uint(0), waiting)
+
+	s.SetLimit(2)
+	require.True(t, s.TryAcquire())
+}
@@ -0,0 +1,118 @@
+package slowlog
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Entry struct {
+	ID         uint64
+	Time       time.Time
+	Duration   time.Duration
+	ClientAddr string
+	Args       []string
+}
+
+// Log keeps the last commands slower than the threshold in a bounded ring.
+type Log struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	maxArgLen int
+	entries   []Entry
+	next      int
+	size      int
+	lastID    uint64
+}
+
+func New(threshold time.Duration, maxLen, maxArgLen int) *Log {
+	return &Log{
+		threshold: threshold,
+		maxArgLen: maxArgLen,
+		entries:   make([]Entry, maxLen),
+	}
+}
+
+// Record adds the command to the log when the duration exceeds the threshold.
+// A negative threshold disables the log.
+func (l *Log) Record(at time.Time, duration time.Duration, clientAddr string, args []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.threshold < 0 || duration < l.threshold || len(l.entries) == 0 {
+		return
+	}
+
+	l.lastID++
+	l.entries[l.next] = Entry{
+		ID:         l.lastID,
+		Time:       at,
+		Duration:   duration,
+		ClientAddr: clientAddr,
+		Args:       l.truncate(args),
+	}
+	l.next = (l.next + 1) % len(l.entries)
+	if l.size < len(l.entries) {
+		l.size++
+	}
+}
+
+// Get returns up to n entries, newest first. A negative n returns all of them.
+func (l *Log) Get(n int) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if n < 0 || n > l.size {
+		n = l.size
+	}
+	entries := make([]Entry, 0, n)
+	for i := 1; i <= n; i++ {
+		entries = append(entries, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+
+	return entries
+}
+
+func (l *Log) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.size
+}
+
+func (l *Log) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.size = 0
+	l.next = 0
+}
+
+// Configure changes the threshold and the ring size, the newest entries are kept.
+func (l *Log) Configure(threshold time.Duration, maxLen, maxArgLen int) {
+	entries := l.Get(maxLen)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.threshold = threshold
+	l.maxArgLen = maxArgLen
+	l.entries = make([]Entry, maxLen)
+	l.size = len(entries)
+	l.next = 0
+	if maxLen > 0 {
+		l.next = len(entries) % maxLen
+	}
+	for i, e := range entries {
+		l.entries[len(entries)-1-i] = e
+	}
+}
+
+func (l *Log) truncate(args []string) []string {
+	truncated := make([]string, len(args))
+	for i, arg := range args {
+		if l.maxArgLen > 0 && len(arg) > l.maxArgLen {
+			arg = arg[:l.maxArgLen] + "... (" + strconv.Itoa(len(arg)-l.maxArgLen) + " more bytes)"
+		}
+		truncated[i] = arg
+	}
+
+	return truncated
+}
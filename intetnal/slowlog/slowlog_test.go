@@ -0,0 +1,60 @@
+package slowlog_test
+
+import (
+	"jokedb/intetnal/slowlog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog(t *testing.T) {
+	now := time.Now()
+
+	t.Run("threshold", func(t *testing.T) {
+		l := slowlog.New(10*time.Millisecond, 4, 0)
+		l.Record(now, time.Millisecond, "", []string{"GET", "fast"})
+		l.Record(now, 20*time.Millisecond, "127.0.0.1:1", []string{"GET", "slow"})
+
+		require.Equal(t, 1, l.Len())
+		entries := l.Get(-1)
+		require.Equal(t, []string{"GET", "slow"}, entries[0].Args)
+		require.Equal(t, "127.0.0.1:1", entries[0].ClientAddr)
+	})
+
+	t.Run("bounded_ring", func(t *testing.T) {
+		l := slowlog.New(0, 3, 0)
+		for _, key := range []string{"k1", "k2", "k3", "k4", "k5"} {
+			l.Record(now, time.Millisecond, "", []string{"GET", key})
+		}
+
+		require.Equal(t, 3, l.Len())
+		entries := l.Get(2)
+		require.Len(t, entries, 2)
+		require.Equal(t, "k5", entries[0].Args[1])
+		require.Equal(t, "k4", entries[1].Args[1])
+		require.Equal(t, uint64(5), entries[0].ID)
+
+		l.Configure(0, 2, 0)
+		entries = l.Get(-1)
+		require.Len(t, entries, 2)
+		require.Equal(t, "k5", entries[0].Args[1])
+
+		l.Reset()
+		require.Equal(t, 0, l.Len())
+		require.Empty(t, l.Get(-1))
+	})
+
+	t.Run("truncate_values", func(t *testing.T) {
+		l := slowlog.New(0, 1, 4)
+		l.Record(now, time.Millisecond, "", []string{"SET", "key", "long_value"})
+
+		require.Equal(t, []string{"SET", "key", "long... (6 more bytes)"}, l.Get(1)[0].Args)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		l := slowlog.New(-1, 4, 0)
+		l.Record(now, time.Second, "", []string{"GET", "key"})
+		require.Equal(t, 0, l.Len())
+	})
+}
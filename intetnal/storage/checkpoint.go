@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"jokedb/intetnal/storage/engine"
+	"jokedb/intetnal/wal"
+	"sort"
+)
+
+var ErrNoWAL = errors.New("storage has no WAL")
+
+// Checkpoint is a consistent state of the storage: replaying the sealed
+// Segments gives the same data as the Snapshot records.
+type Checkpoint struct {
+	Databases int
+	// LSN is the last record included in the checkpoint.
+	LSN      uint64
+	Snapshot []wal.LogData
+	Segments []string
+}
+
+// Checkpoint blocks the writes for the time of sealing the active WAL segment
+// and copying the databases. The sealed segments are not written anymore.
+// The queued writes are written first, an applied async write is in the sealed segments.
+func (s *Storage) Checkpoint(ctx context.Context) (Checkpoint, error) {
+	if s.wal == nil {
+		return Checkpoint{}, ErrNoWAL
+	}
+	if err := ctx.Err(); err != nil {
+		return Checkpoint{}, err
+	}
+
+	s.applyMu.Lock()
+	s.flushPending()
+	sealed, err := s.wal.Rotate()
+	if err != nil {
+		s.applyMu.Unlock()
+		return Checkpoint{}, err
+	}
+	snapshots := s.snapshotLocked()
+	lsn := s.wal.LastLSN()
+	s.applyMu.Unlock()
+
+	c := Checkpoint{
+		Databases: len(snapshots),
+		LSN:       lsn,
+		Snapshot:  SnapshotLogs(snapshots),
+	}
+	for _, id := range sealed {
+		c.Segments = append(c.Segments, wal.SegmentFileName(s.wal.DirPath(), id))
+	}
+
+	return c, nil
+}
+
+// Dump returns a copy of every database.
+func (s *Storage) Dump() []map[string]string {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+	return s.snapshotLocked()
+}
+
+func (s *Storage) snapshotLocked() []map[string]string {
+	s.dbsMu.RLock()
+	defer s.dbsMu.RUnlock()
+
+	snapshots := make([]map[string]string, len(s.dbs))
+	for i, db := range s.dbs {
+		snapshots[i] = db.Snapshot()
+	}
+
+	return snapshots
+}
+
+// SnapshotLogs converts the databases to SET records ordered by database and key.
+func SnapshotLogs(snapshots []map[string]string) []wal.LogData {
+	var logs []wal.LogData
+	for db, snapshot := range snapshots {
+		keys := make([]string, 0, len(snapshot))
+		for k := range snapshot {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			logs = append(logs, wal.LogData{Action: engine.SET, DB: db, Key: k, Value: snapshot[k]})
+		}
+	}
+
+	return logs
+}
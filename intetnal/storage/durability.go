@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Durability tells when a write is acknowledged.
+type Durability int8
+
+const (
+	// DurabilityLocal acknowledges after the group commit wrote the record to the WAL.
+	DurabilityLocal Durability = iota
+	// DurabilityAsync acknowledges after the engine apply, the record is written to the WAL later
+	// and is lost if the server stops before that.
+	DurabilityAsync
+	// DurabilityReplicated acknowledges after the WAL write and the replicas confirmed the record.
+	DurabilityReplicated
+)
+
+var ErrNoReplication = errors.New("replicated durability needs replication, none is configured")
+
+func (d Durability) String() string {
+	switch d {
+	case DurabilityLocal:
+		return "local"
+	case DurabilityAsync:
+		return "async"
+	case DurabilityReplicated:
+		return "replicated"
+	default:
+		return fmt.Sprintf("Durability(%d)", d)
+	}
+}
+
+func ParseDurability(s string) (Durability, error) {
+	switch s {
+	case "local":
+		return DurabilityLocal, nil
+	case "async":
+		return DurabilityAsync, nil
+	case "replicated":
+		return DurabilityReplicated, nil
+	default:
+		return 0, fmt.Errorf("unknown durability %q, want async, local or replicated", s)
+	}
+}
+
+// ReplicaWaiter is implemented by the replication to let writes wait for the replicas.
+type ReplicaWaiter interface {
+	// WaitReplicas returns when n replicas have the records up to lsn.
+	WaitReplicas(ctx context.Context, lsn uint64, n int) error
+}
+
+// WithReplicas makes the replicated writes wait for n replicas of waiter.
+func WithReplicas(waiter ReplicaWaiter, n int) Option {
+	return func(o *options) {
+		o.replicas = waiter
+		o.minReplicas = n
+	}
+}
+
+type durabilityKey struct{}
+
+// WithDurability returns a context the writes of which are acknowledged with d.
+func WithDurability(ctx context.Context, d Durability) context.Context {
+	return context.WithValue(ctx, durabilityKey{}, d)
+}
+
+// DurabilityFromContext returns the durability set by WithDurability, DurabilityLocal by default.
+func DurabilityFromContext(ctx context.Context) Durability {
+	d, _ := ctx.Value(durabilityKey{}).(Durability)
+	return d
+}
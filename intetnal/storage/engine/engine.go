@@ -3,6 +3,9 @@ package engine
 import (
 	"context"
 	"errors"
+	"path"
+	"sort"
+	"strconv"
 	"sync"
 )
 
@@ -12,8 +15,54 @@ const (
 	SET ActionType = iota + 1
 	GET
 	DEL
+	SLOWLOG
+	SELECT
+	MOVE
+	FLUSHDB
+	SWAPDB
+	SUBSCRIBE
+	PSUBSCRIBE
+	UNSUBSCRIBE
+	BACKUP
+	LSN
+	DURABILITY
+	PING
+	CLIENT
+	INFO
+	KEYS
+	ASKING
+	CLUSTER
+	MIGRATE
+	BEGIN
+	COMMIT
+	ROLLBACK
+	SCAN
+	EVAL
+	EVALSHA
+	SCRIPT
+	HELP
+	COMMAND
 )
 
+func (t ActionType) String() string {
+	switch t {
+	case SET:
+		return "SET"
+	case GET:
+		return "GET"
+	case DEL:
+		return "DEL"
+	case MOVE:
+		return "MOVE"
+	case FLUSHDB:
+		return "FLUSHDB"
+	case SWAPDB:
+		return "SWAPDB"
+	default:
+		return "ActionType(" + strconv.Itoa(int(t)) + ")"
+	}
+}
+
 type KV struct {
 	Key   string
 	Value string
@@ -21,15 +70,34 @@ type KV struct {
 
 var ErrNoKey = errors.New("no key")
 
+// version is a value of a key written at the commit sequence seq, a deleted version is a tombstone.
+type version struct {
+	seq     uint64
+	value   string
+	deleted bool
+}
+
+// Engine keeps the versions of every key, oldest first. A write is stamped with the next commit
+// sequence, a View reads the versions up to the sequence it was opened at. The versions no open view
+// can see anymore are pruned on the next write of the key and by GC.
 type Engine struct {
 	mu      sync.RWMutex
-	storage map[string]string
+	storage map[string][]version
+	seq     uint64
+	// live is the number of keys the latest version of which is not deleted.
+	live int
+	// views counts the open views per sequence, horizon is the oldest of them or seq without views.
+	views   map[uint64]int
+	horizon uint64
+	// garbage counts the versions kept for the open views only.
+	garbage int
 }
 
 func New() *Engine {
 	return &Engine{
 		mu:      sync.RWMutex{},
-		storage: map[string]string{},
+		storage: map[string][]version{},
+		views:   map[uint64]int{},
 	}
 }
 
@@ -41,7 +109,8 @@ func (e *Engine) Upsert(ctx context.Context, kv KV) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	e.storage[kv.Key] = kv.Value
+	e.seq++
+	e.write(kv.Key, version{seq: e.seq, value: kv.Value})
 
 	return nil
 }
@@ -54,11 +123,7 @@ func (e *Engine) Get(ctx context.Context, k string) (string, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
-	v, ok := e.storage[k]
-	if !ok {
-		return "", ErrNoKey
-	}
-	return v, nil
+	return visible(e.storage[k], e.seq)
 }
 
 func (e *Engine) Del(ctx context.Context, k string) error {
@@ -69,13 +134,202 @@ func (e *Engine) Del(ctx context.Context, k string) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	delete(e.storage, k)
+	if !e.exists(k) {
+		return nil
+	}
+	e.seq++
+	e.write(k, version{seq: e.seq, deleted: true})
+
+	return nil
+}
+
+// Write is a SET or, with Deleted, a DEL of a Commit.
+type Write struct {
+	Key     string
+	Value   string
+	Deleted bool
+}
+
+// Commit applies the writes with one commit sequence, a view sees all of them or none.
+func (e *Engine) Commit(ctx context.Context, writes []Write) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.seq++
+	for _, w := range writes {
+		if w.Deleted && !e.exists(w.Key) {
+			continue
+		}
+		e.write(w.Key, version{seq: e.seq, value: w.Value, deleted: w.Deleted})
+	}
 
 	return nil
 }
 
+// LastSeq returns the commit sequence of the latest version of the key, 0 if it was never written
+// or its versions were dropped. It detects the writes after a view for the snapshot transactions.
+func (e *Engine) LastSeq(k string) uint64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if chain := e.storage[k]; len(chain) > 0 {
+		return chain[len(chain)-1].seq
+	}
+	return 0
+}
+
+// Flush deletes every key, the open views keep seeing them.
 func (e *Engine) Flush() {
 	e.mu.Lock()
 	defer e.mu.Unlock()
-	e.storage = map[string]string{}
+
+	e.seq++
+	if len(e.views) == 0 {
+		e.storage = map[string][]version{}
+		e.live, e.garbage = 0, 0
+		e.horizon = e.seq
+		return
+	}
+	for k := range e.storage {
+		if e.exists(k) {
+			e.write(k, version{seq: e.seq, deleted: true})
+		}
+	}
+}
+
+func (e *Engine) exists(k string) bool {
+	chain := e.storage[k]
+	return len(chain) > 0 && !chain[len(chain)-1].deleted
+}
+
+// write appends v to the versions of k and prunes the ones no view can see.
+func (e *Engine) write(k string, v version) {
+	chain := e.storage[k]
+	wasLive := len(chain) > 0 && !chain[len(chain)-1].deleted
+	switch {
+	case wasLive && v.deleted:
+		e.live--
+	case !wasLive && !v.deleted:
+		e.live++
+	}
+	if len(e.views) == 0 {
+		e.horizon = e.seq
+	}
+
+	e.garbage -= max(len(chain)-1, 0)
+	chain = e.prune(append(chain, v))
+	e.garbage += max(len(chain)-1, 0)
+	if len(chain) == 0 {
+		delete(e.storage, k)
+		return
+	}
+	e.storage[k] = chain
+}
+
+// prune drops the versions older than the one visible at the horizon, and the chain itself
+// if that one is the latest and a tombstone.
+func (e *Engine) prune(chain []version) []version {
+	keep := 0
+	for i := len(chain) - 1; i >= 0; i-- {
+		if chain[i].seq <= e.horizon {
+			keep = i
+			break
+		}
+	}
+	if keep > 0 {
+		chain = append(chain[:0:0], chain[keep:]...)
+	}
+	if len(chain) == 1 && chain[0].deleted && chain[0].seq <= e.horizon {
+		return nil
+	}
+
+	return chain
+}
+
+// visible returns the value of the latest version up to seq.
+func visible(chain []version, seq uint64) (string, error) {
+	for i := len(chain) - 1; i >= 0; i-- {
+		if chain[i].seq > seq {
+			continue
+		}
+		if chain[i].deleted {
+			return "", ErrNoKey
+		}
+		return chain[i].value, nil
+	}
+
+	return "", ErrNoKey
+}
+
+func (e *Engine) Len() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.live
+}
+
+// Keys returns the keys matching the path.Match pattern in sorted order.
+func (e *Engine) Keys(ctx context.Context, pattern string) ([]string, error) {
+	e.mu.RLock()
+	seq := e.seq
+	e.mu.RUnlock()
+
+	return e.keys(ctx, pattern, seq)
+}
+
+func (e *Engine) keys(ctx context.Context, pattern string, seq uint64) ([]string, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	e.mu.RLock()
+	keys := make([]string, 0)
+	for k, chain := range e.storage {
+		if _, err := visible(chain, seq); err != nil {
+			continue
+		}
+		if ok, _ := path.Match(pattern, k); ok {
+			keys = append(keys, k)
+		}
+	}
+	e.mu.RUnlock()
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Bytes returns the total length of the keys and values, an approximation of the memory they use.
+func (e *Engine) Bytes() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var n int
+	for k, chain := range e.storage {
+		if v := chain[len(chain)-1]; !v.deleted {
+			n += len(k) + len(v.value)
+		}
+	}
+
+	return n
+}
+
+// Snapshot returns a copy of all keys.
+func (e *Engine) Snapshot() map[string]string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	snapshot := make(map[string]string, e.live)
+	for k, chain := range e.storage {
+		if v := chain[len(chain)-1]; !v.deleted {
+			snapshot[k] = v.value
+		}
+	}
+
+	return snapshot
 }
@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"context"
+	"sync"
+)
+
+// View reads the keys as they were when it was opened, the later writes are not visible to it.
+// The versions it can see are kept until Release.
+type View struct {
+	e    *Engine
+	seq  uint64
+	once sync.Once
+}
+
+// View opens a view at the last commit sequence.
+func (e *Engine) View() *View {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.views) == 0 {
+		e.horizon = e.seq
+	}
+	e.views[e.seq]++
+
+	return &View{e: e, seq: e.seq}
+}
+
+// Seq returns the commit sequence the view reads at.
+func (v *View) Seq() uint64 {
+	return v.seq
+}
+
+func (v *View) Get(ctx context.Context, k string) (string, error) {
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	v.e.mu.RLock()
+	defer v.e.mu.RUnlock()
+
+	return visible(v.e.storage[k], v.seq)
+}
+
+// Keys returns the keys of the view matching the path.Match pattern in sorted order.
+func (v *View) Keys(ctx context.Context, pattern string) ([]string, error) {
+	return v.e.keys(ctx, pattern, v.seq)
+}
+
+// Release closes the view, the versions only it could see are collected when it was the oldest one.
+func (v *View) Release() {
+	v.once.Do(func() {
+		e := v.e
+		e.mu.Lock()
+		e.views[v.seq]--
+		if e.views[v.seq] > 0 {
+			e.mu.Unlock()
+			return
+		}
+		delete(e.views, v.seq)
+		oldest := v.seq == e.horizon
+		e.mu.Unlock()
+
+		if oldest {
+			e.GC()
+		}
+	})
+}
+
+// GC drops the versions no open view can see and returns their number.
+func (e *Engine) GC() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.horizon = e.seq
+	for seq := range e.views {
+		e.horizon = min(e.horizon, seq)
+	}
+	if e.garbage == 0 {
+		return 0
+	}
+
+	dropped := 0
+	for k, chain := range e.storage {
+		n := len(chain)
+		chain = e.prune(chain)
+		dropped += n - len(chain)
+		if len(chain) == 0 {
+			delete(e.storage, k)
+			continue
+		}
+		e.storage[k] = chain
+	}
+	e.garbage = 0
+	for _, chain := range e.storage {
+		e.garbage += len(chain) - 1
+	}
+
+	return dropped
+}
+
+// MVCCStats are the counters of the versions for INFO.
+type MVCCStats struct {
+	Seq      uint64
+	Views    int
+	Versions int
+	Garbage  int
+}
+
+func (e *Engine) MVCCStats() MVCCStats {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	views := 0
+	for _, n := range e.views {
+		views += n
+	}
+	versions := 0
+	for _, chain := range e.storage {
+		versions += len(chain)
+	}
+
+	return MVCCStats{Seq: e.seq, Views: views, Versions: versions, Garbage: e.garbage}
+}
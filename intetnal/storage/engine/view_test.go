@@ -0,0 +1,113 @@
+package engine_test
+
+import (
+	"context"
+	"jokedb/intetnal/storage/engine"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestView(t *testing.T) {
+	ctx := context.Background()
+	e := engine.New()
+	require.NoError(t, e.Upsert(ctx, engine.KV{Key: "a", Value: "1"}))
+	require.NoError(t, e.Upsert(ctx, engine.KV{Key: "b", Value: "1"}))
+
+	v := e.View()
+	require.NoError(t, e.Upsert(ctx, engine.KV{Key: "a", Value: "2"}))
+	require.NoError(t, e.Del(ctx, "b"))
+	require.NoError(t, e.Upsert(ctx, engine.KV{Key: "c", Value: "1"}))
+
+	// the view reads the keys as they were when it was opened
+	got, err := v.Get(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, "1", got)
+	got, err = v.Get(ctx, "b")
+	require.NoError(t, err)
+	require.Equal(t, "1", got)
+	_, err = v.Get(ctx, "c")
+	require.ErrorIs(t, err, engine.ErrNoKey)
+	keys, err := v.Keys(ctx, "*")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, keys)
+
+	got, err = e.Get(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, "2", got)
+	_, err = e.Get(ctx, "b")
+	require.ErrorIs(t, err, engine.ErrNoKey)
+	keys, err = e.Keys(ctx, "*")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "c"}, keys)
+	require.Equal(t, 2, e.Len())
+	require.Equal(t, map[string]string{"a": "2", "c": "1"}, e.Snapshot())
+
+	// a flush keeps the keys for the view
+	e.Flush()
+	got, err = v.Get(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, "1", got)
+	require.Zero(t, e.Len())
+
+	stats := e.MVCCStats()
+	require.Equal(t, 1, stats.Views)
+	require.Positive(t, stats.Garbage)
+
+	// releasing the oldest view collects the versions only it could see
+	v.Release()
+	v.Release()
+	stats = e.MVCCStats()
+	require.Zero(t, stats.Views)
+	require.Zero(t, stats.Garbage)
+	require.Zero(t, stats.Versions)
+}
+
+func TestEngine_GC(t *testing.T) {
+	ctx := context.Background()
+	e := engine.New()
+	require.NoError(t, e.Upsert(ctx, engine.KV{Key: "a", Value: "1"}))
+
+	old := e.View()
+	require.NoError(t, e.Upsert(ctx, engine.KV{Key: "a", Value: "2"}))
+	newer := e.View()
+	require.NoError(t, e.Upsert(ctx, engine.KV{Key: "a", Value: "3"}))
+	require.Equal(t, 3, e.MVCCStats().Versions)
+
+	// without views every key has its latest version only
+	require.Zero(t, e.GC())
+	newer.Release()
+	require.Equal(t, 3, e.MVCCStats().Versions)
+	old.Release()
+	require.Equal(t, 1, e.MVCCStats().Versions)
+
+	got, err := e.Get(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, "3", got)
+	require.NoError(t, e.Upsert(ctx, engine.KV{Key: "a", Value: "4"}))
+	require.Equal(t, 1, e.MVCCStats().Versions)
+}
+
+func TestEngine_Commit(t *testing.T) {
+	ctx := context.Background()
+	e := engine.New()
+	require.NoError(t, e.Upsert(ctx, engine.KV{Key: "a", Value: "1"}))
+	v := e.View()
+	defer v.Release()
+
+	require.NoError(t, e.Commit(ctx, []engine.Write{
+		{Key: "a", Deleted: true},
+		{Key: "b", Value: "2"},
+		{Key: "missing", Deleted: true},
+	}))
+	require.Equal(t, e.LastSeq("a"), e.LastSeq("b"))
+	require.Greater(t, e.LastSeq("a"), v.Seq())
+	require.Zero(t, e.LastSeq("missing"))
+
+	keys, err := e.Keys(ctx, "*")
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, keys)
+	keys, err = v.Keys(ctx, "*")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, keys)
+}
@@ -0,0 +1,473 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"jokedb/intetnal/storage/engine"
+	"jokedb/intetnal/wal"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultSnapshotTTL is how long an idle transaction or SCAN cursor keeps its snapshot.
+const DefaultSnapshotTTL = time.Minute
+
+var (
+	ErrConflict     = errors.New("write conflict, the key was changed after the transaction began")
+	ErrTxDone       = errors.New("transaction is committed, rolled back or expired")
+	ErrNoCursor     = errors.New("no cursor, it is finished or expired")
+	ErrReplicatedTx = errors.New("transactions are not supported by the replicated storage")
+)
+
+// WithSnapshotTTL sets how long an idle transaction or SCAN cursor keeps its snapshot before it is released.
+func WithSnapshotTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.snapshotTTL = ttl
+	}
+}
+
+// snapshots are the open transactions and SCAN cursors, the sweeper releases the idle ones.
+type snapshots struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	txs     map[*Tx]struct{}
+	cursors map[uint64]*cursor
+	stop    chan struct{}
+}
+
+func newSnapshots(ttl time.Duration) *snapshots {
+	if ttl <= 0 {
+		ttl = DefaultSnapshotTTL
+	}
+	return &snapshots{
+		ttl:     ttl,
+		txs:     map[*Tx]struct{}{},
+		cursors: map[uint64]*cursor{},
+		stop:    make(chan struct{}),
+	}
+}
+
+func (s *snapshots) sweep() {
+	ticker := time.NewTicker(max(s.ttl/4, 10*time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.expire(now)
+		}
+	}
+}
+
+func (s *snapshots) expire(now time.Time) {
+	s.mu.Lock()
+	var txs []*Tx
+	for tx := range s.txs {
+		txs = append(txs, tx)
+	}
+	for id, c := range s.cursors {
+		if now.Sub(c.used) > s.ttl {
+			delete(s.cursors, id)
+			c.release()
+		}
+	}
+	s.mu.Unlock()
+
+	for _, tx := range txs {
+		tx.expire(now, s.ttl)
+	}
+}
+
+// Tx is a snapshot transaction: it reads the database as it was when the transaction began and its own
+// writes, which are buffered until Commit. Commit fails with ErrConflict if another write changed
+// a key the transaction writes after it began.
+type Tx struct {
+	s    *Storage
+	db   int
+	e    *engine.Engine
+	view *engine.View
+
+	mu     sync.Mutex
+	writes map[string]engine.Write
+	order  []string
+	used   time.Time
+	done   bool
+	// committed are the writes applied by Commit
+	committed []engine.Write
+}
+
+// Begin starts a snapshot transaction on db.
+func (s *Storage) Begin(ctx context.Context, db int) (*Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	e, err := s.db(db)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := &Tx{s: s, db: db, e: e, view: e.View(), writes: map[string]engine.Write{}, used: time.Now()}
+	s.snapshots.mu.Lock()
+	s.snapshots.txs[tx] = struct{}{}
+	s.snapshots.mu.Unlock()
+
+	return tx, nil
+}
+
+// DB returns the database of the transaction.
+func (t *Tx) DB() int {
+	return t.db
+}
+
+func (t *Tx) Get(ctx context.Context, key string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.touch(); err != nil {
+		return "", err
+	}
+
+	if w, ok := t.writes[key]; ok {
+		if w.Deleted {
+			return "", engine.ErrNoKey
+		}
+		return w.Value, nil
+	}
+
+	return t.view.Get(ctx, key)
+}
+
+func (t *Tx) Set(key, value string) error {
+	return t.write(engine.Write{Key: key, Value: value})
+}
+
+func (t *Tx) Del(key string) error {
+	return t.write(engine.Write{Key: key, Deleted: true})
+}
+
+func (t *Tx) write(w engine.Write) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.touch(); err != nil {
+		return err
+	}
+	if t.s.replicator != nil {
+		// the write fails at once rather than on Commit
+		return ErrReplicatedTx
+	}
+
+	if _, ok := t.writes[w.Key]; !ok {
+		t.order = append(t.order, w.Key)
+	}
+	t.writes[w.Key] = w
+
+	return nil
+}
+
+// Keys returns the keys of the snapshot with the writes of the transaction matching the path.Match pattern in sorted order.
+func (t *Tx) Keys(ctx context.Context, pattern string) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.touch(); err != nil {
+		return nil, err
+	}
+
+	return t.keysLocked(ctx, pattern)
+}
+
+func (t *Tx) keysLocked(ctx context.Context, pattern string) ([]string, error) {
+	keys, err := t.view.Keys(ctx, pattern)
+	if err != nil || len(t.writes) == 0 {
+		return keys, err
+	}
+
+	set := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		set[k] = struct{}{}
+	}
+	for k, w := range t.writes {
+		if ok, _ := path.Match(pattern, k); !ok {
+			continue
+		}
+		if w.Deleted {
+			delete(set, k)
+		} else {
+			set[k] = struct{}{}
+		}
+	}
+	keys = keys[:0]
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Writes returns the buffered writes in the order they were made, after Commit the ones it applied.
+func (t *Tx) Writes() []engine.Write {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.committed != nil {
+		return t.committed
+	}
+	writes := make([]engine.Write, 0, len(t.order))
+	for _, k := range t.order {
+		writes = append(writes, t.writes[k])
+	}
+	return writes
+}
+
+// Commit writes the buffered writes to the WAL in one batch and applies them with one commit sequence.
+func (t *Tx) Commit(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.touch(); err != nil {
+		return err
+	}
+	defer t.finish()
+
+	if len(t.order) == 0 {
+		return nil
+	}
+	if t.s.replicator != nil {
+		return ErrReplicatedTx
+	}
+
+	s := t.s
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	e, err := s.db(t.db)
+	if err != nil {
+		return err
+	}
+	if e != t.e {
+		// SWAPDB replaced the database the snapshot was taken from
+		return fmt.Errorf("%w: db %d was swapped", ErrConflict, t.db)
+	}
+	writes := make([]engine.Write, 0, len(t.order))
+	logs := make([]wal.LogData, 0, len(t.order))
+	for _, k := range t.order {
+		w := t.writes[k]
+		if e.LastSeq(k) > t.view.Seq() {
+			return fmt.Errorf("%w: %s", ErrConflict, k)
+		}
+		if _, errGet := e.Get(ctx, k); w.Deleted && errors.Is(errGet, engine.ErrNoKey) {
+			// a DEL of a missing key removes nothing, it is not written and not counted
+			continue
+		}
+		action := engine.SET
+		if w.Deleted {
+			action = engine.DEL
+		}
+		writes = append(writes, w)
+		logs = append(logs, wal.LogData{Action: action, DB: t.db, Key: w.Key, Value: w.Value})
+	}
+	if err = s.pendingWrite(ctx, logs...); err != nil {
+		return err
+	}
+	t.committed = writes
+
+	return e.Commit(ctx, writes)
+}
+
+// Rollback drops the buffered writes and releases the snapshot.
+func (t *Tx) Rollback() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return ErrTxDone
+	}
+	t.finish()
+
+	return nil
+}
+
+func (t *Tx) touch() error {
+	if t.done {
+		return ErrTxDone
+	}
+	t.used = time.Now()
+	return nil
+}
+
+func (t *Tx) finish() {
+	t.done = true
+	t.view.Release()
+	t.s.snapshots.mu.Lock()
+	delete(t.s.snapshots.txs, t)
+	t.s.snapshots.mu.Unlock()
+}
+
+func (t *Tx) expire(now time.Time, ttl time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.done && now.Sub(t.used) > ttl {
+		t.finish()
+	}
+}
+
+// cursor is a SCAN over the keys matched when it was opened, the values are read from its snapshot.
+// It is continued only by its owner on its database.
+type cursor struct {
+	owner   any
+	db      int
+	keys    []string
+	get     func(ctx context.Context, key string) (string, error)
+	release func()
+	used    time.Time
+}
+
+// Scan returns up to count keys of db matching the path.Match pattern, and their values if withValues,
+// from the snapshot taken by the first call with cursor 0. The next call continues with the returned
+// cursor, which is 0 when the scan is finished. The pattern of the first call is used for the whole scan.
+// The cursor is continued only by the comparable owner that opened it and on the same db, the others
+// get ErrNoCursor.
+func (s *Storage) Scan(ctx context.Context, db int, owner any, id uint64, pattern string, count int, withValues bool) (uint64, []engine.KV, error) {
+	if id != 0 {
+		return s.snapshots.scan(ctx, owner, db, id, count, withValues)
+	}
+
+	e, err := s.db(db)
+	if err != nil {
+		return 0, nil, err
+	}
+	view := e.View()
+	keys, err := view.Keys(ctx, pattern)
+	if err != nil {
+		view.Release()
+		return 0, nil, err
+	}
+
+	c := &cursor{owner: owner, db: db, keys: keys, get: view.Get, release: view.Release}
+	return s.snapshots.open(ctx, c, count, withValues)
+}
+
+// Scan is Storage.Scan over the snapshot and the writes of the transaction, its cursors are owned by
+// the transaction.
+func (t *Tx) Scan(ctx context.Context, id uint64, pattern string, count int, withValues bool) (uint64, []engine.KV, error) {
+	t.mu.Lock()
+	if err := t.touch(); err != nil {
+		t.mu.Unlock()
+		return 0, nil, err
+	}
+	var keys []string
+	var err error
+	if id == 0 {
+		keys, err = t.keysLocked(ctx, pattern)
+	}
+	t.mu.Unlock()
+	if err != nil {
+		return 0, nil, err
+	}
+	if id != 0 {
+		return t.s.snapshots.scan(ctx, t, t.db, id, count, withValues)
+	}
+
+	// the snapshot is released by the transaction
+	c := &cursor{owner: t, db: t.db, keys: keys, get: t.Get, release: func() {}}
+	return t.s.snapshots.open(ctx, c, count, withValues)
+}
+
+func (s *snapshots) open(ctx context.Context, c *cursor, count int, withValues bool) (uint64, []engine.KV, error) {
+	s.mu.Lock()
+	id, err := s.newID()
+	if err != nil {
+		s.mu.Unlock()
+		c.release()
+		return 0, nil, err
+	}
+	c.used = time.Now()
+	s.cursors[id] = c
+	s.mu.Unlock()
+
+	return s.scan(ctx, c.owner, c.db, id, count, withValues)
+}
+
+// newID returns a random id no cursor has, so a client cannot guess the cursors of the others.
+func (s *snapshots) newID() (uint64, error) {
+	var b [8]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			return 0, err
+		}
+		// the cursor is answered as a signed integer
+		id := binary.BigEndian.Uint64(b[:]) >> 1
+		if _, exists := s.cursors[id]; id != 0 && !exists {
+			return id, nil
+		}
+	}
+}
+
+func (s *snapshots) scan(ctx context.Context, owner any, db int, id uint64, count int, withValues bool) (uint64, []engine.KV, error) {
+	s.mu.Lock()
+	c, ok := s.cursors[id]
+	if !ok || c.owner != owner || c.db != db {
+		s.mu.Unlock()
+		return 0, nil, ErrNoCursor
+	}
+	// the cursor is taken out while it is read, a concurrent call with the same id gets ErrNoCursor
+	delete(s.cursors, id)
+	s.mu.Unlock()
+
+	n := min(max(count, 1), len(c.keys))
+	items := make([]engine.KV, 0, n)
+	for _, k := range c.keys[:n] {
+		kv := engine.KV{Key: k}
+		if withValues {
+			v, err := c.get(ctx, k)
+			if errors.Is(err, engine.ErrNoKey) {
+				// deleted by the transaction of the cursor after it was opened
+				continue
+			}
+			if err != nil {
+				c.release()
+				return 0, nil, err
+			}
+			kv.Value = v
+		}
+		items = append(items, kv)
+	}
+	c.keys = c.keys[n:]
+	if len(c.keys) == 0 {
+		c.release()
+		return 0, items, nil
+	}
+
+	s.mu.Lock()
+	c.used = time.Now()
+	s.cursors[id] = c
+	s.mu.Unlock()
+
+	return id, items, nil
+}
+
+// SnapshotStats are the open transactions and SCAN cursors and the versions of the databases.
+type SnapshotStats struct {
+	Transactions int
+	Cursors      int
+	Databases    []engine.MVCCStats
+}
+
+func (s *Storage) SnapshotStats() SnapshotStats {
+	s.snapshots.mu.Lock()
+	st := SnapshotStats{Transactions: len(s.snapshots.txs), Cursors: len(s.snapshots.cursors)}
+	s.snapshots.mu.Unlock()
+
+	s.dbsMu.RLock()
+	dbs := append([]*engine.Engine(nil), s.dbs...)
+	s.dbsMu.RUnlock()
+	for _, db := range dbs {
+		st.Databases = append(st.Databases, db.MVCCStats())
+	}
+
+	return st
+}
@@ -0,0 +1,179 @@
+package storage_test
+
+import (
+	"context"
+	"jokedb/intetnal/storage"
+	"jokedb/intetnal/storage/engine"
+	wallog "jokedb/intetnal/wal"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTx(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dir := t.TempDir()
+	wal, err := wallog.Open(wallog.WithDirPath(dir), wallog.WithSync(wallog.SyncAlways, 0))
+	require.NoError(t, err)
+	s, err := storage.New(engine.New(), wal, 1, time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "a", Value: "1"}))
+	require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "b", Value: "1"}))
+
+	tx, err := s.Begin(ctx, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "b", Value: "2"}))
+	require.NoError(t, tx.Set("a", "tx"))
+	require.NoError(t, tx.Del("b"))
+	require.NoError(t, tx.Set("c", "tx"))
+
+	// the transaction reads its snapshot and its own writes, the others do not see them
+	got, err := tx.Get(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, "tx", got)
+	_, err = tx.Get(ctx, "b")
+	require.ErrorIs(t, err, engine.ErrNoKey)
+	keys, err := tx.Keys(ctx, "*")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "c"}, keys)
+	got, err = s.Get(ctx, 0, engine.KV{Key: "a"})
+	require.NoError(t, err)
+	require.Equal(t, "1", got)
+
+	// b was written after the transaction began
+	require.ErrorIs(t, tx.Commit(ctx), storage.ErrConflict)
+	require.ErrorIs(t, tx.Commit(ctx), storage.ErrTxDone)
+	got, err = s.Get(ctx, 0, engine.KV{Key: "a"})
+	require.NoError(t, err)
+	require.Equal(t, "1", got)
+
+	tx, err = s.Begin(ctx, 0)
+	require.NoError(t, err)
+	require.NoError(t, tx.Set("a", "tx"))
+	require.NoError(t, tx.Del("b"))
+	require.NoError(t, tx.Set("c", "tx"))
+	require.NoError(t, tx.Del("missing"))
+	require.Len(t, tx.Writes(), 4)
+	require.NoError(t, tx.Commit(ctx))
+	keys, err = s.Keys(ctx, 0, "*")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "c"}, keys)
+	// the DEL of a missing key is not among the committed writes
+	require.Equal(t, []engine.Write{{Key: "a", Value: "tx"}, {Key: "b", Deleted: true}, {Key: "c", Value: "tx"}}, tx.Writes())
+
+	tx, err = s.Begin(ctx, 0)
+	require.NoError(t, err)
+	require.NoError(t, tx.Set("a", "rolled back"))
+	require.NoError(t, tx.Rollback())
+	require.ErrorIs(t, tx.Rollback(), storage.ErrTxDone)
+	require.Zero(t, s.SnapshotStats().Transactions)
+	s.Close()
+
+	// the writes of a transaction are recovered together
+	wal, err = wallog.Open(wallog.WithDirPath(dir))
+	require.NoError(t, err)
+	s, err = storage.New(engine.New(), wal, 1, time.Millisecond)
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+	keys, err = s.Keys(ctx, 0, "*")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "c"}, keys)
+	got, err = s.Get(ctx, 0, engine.KV{Key: "a"})
+	require.NoError(t, err)
+	require.Equal(t, "tx", got)
+}
+
+func TestScan(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	s, err := storage.New(engine.New(), nil, 1, time.Millisecond, storage.WithSnapshotTTL(50*time.Millisecond))
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "k" + strconv.Itoa(i), Value: "old"}))
+	}
+	require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "other", Value: "old"}))
+
+	// the writes during the scan are not seen by it
+	cursor, items, err := s.Scan(ctx, 0, "client", 0, "k*", 4, true)
+	require.NoError(t, err)
+	require.NotZero(t, cursor)
+	require.Len(t, items, 4)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "k" + strconv.Itoa(i), Value: "new"}))
+	}
+	require.NoError(t, s.Del(ctx, 0, engine.KV{Key: "k9"}))
+	require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "k10", Value: "new"}))
+	for cursor != 0 {
+		var page []engine.KV
+		cursor, page, err = s.Scan(ctx, 0, "client", cursor, "", 4, true)
+		require.NoError(t, err)
+		items = append(items, page...)
+	}
+	require.Len(t, items, 10)
+	for _, kv := range items {
+		require.Equal(t, "old", kv.Value, kv.Key)
+	}
+	st := s.SnapshotStats()
+	require.Zero(t, st.Cursors)
+	require.Zero(t, st.Databases[0].Garbage)
+
+	_, _, err = s.Scan(ctx, 0, "client", 12345, "", 1, false)
+	require.ErrorIs(t, err, storage.ErrNoCursor)
+
+	// an idle cursor releases its snapshot
+	cursor, _, err = s.Scan(ctx, 0, "client", 0, "*", 1, false)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "k0", Value: "newer"}))
+	require.Positive(t, s.SnapshotStats().Databases[0].Garbage)
+	require.Eventually(t, func() bool {
+		st = s.SnapshotStats()
+		return st.Cursors == 0 && st.Databases[0].Garbage == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	_, _, err = s.Scan(ctx, 0, "client", cursor, "", 1, false)
+	require.ErrorIs(t, err, storage.ErrNoCursor)
+}
+
+func TestScan_Owner(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	s, err := storage.New(engine.New(), nil, 1, time.Millisecond, storage.WithDatabases(2))
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "k" + strconv.Itoa(i), Value: "v"}))
+	}
+
+	cursor, _, err := s.Scan(ctx, 0, "a", 0, "*", 1, false)
+	require.NoError(t, err)
+	require.NotZero(t, cursor)
+	// the cursor is not continued by another client or on another database, and stays open for its own
+	_, _, err = s.Scan(ctx, 0, "b", cursor, "", 1, false)
+	require.ErrorIs(t, err, storage.ErrNoCursor)
+	_, _, err = s.Scan(ctx, 1, "a", cursor, "", 1, false)
+	require.ErrorIs(t, err, storage.ErrNoCursor)
+	_, items, err := s.Scan(ctx, 0, "a", cursor, "", 1, false)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+
+	// the cursor of a transaction shows its uncommitted writes, it is continued by the transaction only
+	tx, err := s.Begin(ctx, 0)
+	require.NoError(t, err)
+	require.NoError(t, tx.Set("k0", "uncommitted"))
+	cursor, _, err = tx.Scan(ctx, 0, "*", 1, true)
+	require.NoError(t, err)
+	_, _, err = s.Scan(ctx, 0, "a", cursor, "", 4, true)
+	require.ErrorIs(t, err, storage.ErrNoCursor)
+	other, err := s.Begin(ctx, 0)
+	require.NoError(t, err)
+	_, _, err = other.Scan(ctx, cursor, "", 4, true)
+	require.ErrorIs(t, err, storage.ErrNoCursor)
+	_, items, err = tx.Scan(ctx, cursor, "", 4, true)
+	require.NoError(t, err)
+	require.Len(t, items, 3)
+	require.NoError(t, tx.Rollback())
+	require.NoError(t, other.Rollback())
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const defaultOverloadTimeout = time.Second
+
+// OverloadPolicy tells what a write does when the pending queue of the WAL is full.
+type OverloadPolicy int8
+
+const (
+	// OverloadBlock waits for a free slot until the context is done or the overload timeout passes.
+	OverloadBlock OverloadPolicy = iota
+	// OverloadReject fails the write with a *BusyError at once.
+	OverloadReject
+)
+
+func ParseOverloadPolicy(s string) (OverloadPolicy, error) {
+	switch s {
+	case "block":
+		return OverloadBlock, nil
+	case "reject":
+		return OverloadReject, nil
+	default:
+		return 0, fmt.Errorf("unknown overload policy %q, want block or reject", s)
+	}
+}
+
+var ErrBusy = errors.New("busy")
+
+// BusyError is returned when a write could not be queued to the WAL. The write
+// was not applied, so the client can retry it.
+type BusyError struct {
+	QueueSize int
+	Waited    time.Duration
+}
+
+func (e *BusyError) Error() string {
+	if e.Waited > 0 {
+		return fmt.Sprintf("BUSY pending write queue is full (%d entries) after waiting %s, try again later",
+			e.QueueSize, e.Waited.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("BUSY pending write queue is full (%d entries), try again later", e.QueueSize)
+}
+
+func (e *BusyError) Is(target error) bool {
+	return target == ErrBusy
+}
+
+// WithPendingQueue sets the capacity of the queue of writes waiting for the WAL
+// and what a write does when it is full. timeout limits OverloadBlock, 0 waits for the context only.
+func WithPendingQueue(size int, policy OverloadPolicy, timeout time.Duration) Option {
+	return func(o *options) {
+		o.pendingSize = size
+		o.overload = policy
+		o.overloadTimeout = timeout
+	}
+}
+
+type PendingStats struct {
+	// Depth is the number of writes in the queue, Capacity is its size.
+	Depth    int
+	Capacity int
+	// Blocked counts the writes that waited for a free slot, Rejected the ones that failed with BUSY.
+	Blocked  uint64
+	Rejected uint64
+	// Failed counts the queued writes the WAL failed to write, the only trace of a failed async write.
+	Failed uint64
+}
+
+func (s *Storage) PendingStats() PendingStats {
+	return PendingStats{
+		Depth:    len(s.pending),
+		Capacity: cap(s.pending),
+		Blocked:  s.blocked.Load(),
+		Rejected: s.rejected.Load(),
+		Failed:   s.failed.Load(),
+	}
+}
+
+// enqueue puts the write to the pending queue following the overload policy.
+func (s *Storage) enqueue(ctx context.Context, p PendingLog) error {
+	select {
+	case s.pending <- p:
+		return nil
+	default:
+	}
+
+	if s.overload == OverloadReject {
+		s.rejected.Add(1)
+		return &BusyError{QueueSize: cap(s.pending)}
+	}
+
+	s.blocked.Add(1)
+	start := time.Now()
+	var timeout <-chan time.Time
+	if s.overloadTimeout > 0 {
+		timer := time.NewTimer(s.overloadTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case s.pending <- p:
+		return nil
+	case <-ctx.Done():
+		// the client gave up, the write is not rejected by the overload
+		return ctx.Err()
+	case <-timeout:
+		s.rejected.Add(1)
+		return &BusyError{QueueSize: cap(s.pending), Waited: time.Since(start)}
+	}
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage_enqueue(t *testing.T) {
+	full := func(policy OverloadPolicy, timeout time.Duration) *Storage {
+		s := &Storage{pending: make(chan PendingLog, 1), overload: policy, overloadTimeout: timeout}
+		s.pending <- PendingLog{}
+		return s
+	}
+	ctx := context.Background()
+
+	t.Run("reject", func(t *testing.T) {
+		s := full(OverloadReject, time.Second)
+		err := s.enqueue(ctx, PendingLog{})
+		require.ErrorIs(t, err, ErrBusy)
+		require.Equal(t, "BUSY pending write queue is full (1 entries), try again later", err.Error())
+		require.Equal(t, PendingStats{Depth: 1, Capacity: 1, Rejected: 1}, s.PendingStats())
+	})
+
+	t.Run("block_until_free", func(t *testing.T) {
+		s := full(OverloadBlock, time.Second)
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			<-s.pending
+		}()
+		require.NoError(t, s.enqueue(ctx, PendingLog{}))
+		require.Equal(t, PendingStats{Depth: 1, Capacity: 1, Blocked: 1}, s.PendingStats())
+	})
+
+	t.Run("block_timeout", func(t *testing.T) {
+		s := full(OverloadBlock, 10*time.Millisecond)
+		err := s.enqueue(ctx, PendingLog{})
+		var busy *BusyError
+		require.ErrorAs(t, err, &busy)
+		require.GreaterOrEqual(t, busy.Waited, 10*time.Millisecond)
+		require.Equal(t, uint64(1), s.PendingStats().Rejected)
+	})
+
+	t.Run("block_deadline", func(t *testing.T) {
+		s := full(OverloadBlock, 0)
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		err := s.enqueue(ctx, PendingLog{})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.NotErrorIs(t, err, ErrBusy)
+
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		require.ErrorIs(t, s.enqueue(ctx, PendingLog{}), context.Canceled)
+
+		// the client gave up, the overload rejected nothing
+		require.Equal(t, PendingStats{Depth: 1, Capacity: 1, Blocked: 2}, s.PendingStats())
+	})
+}
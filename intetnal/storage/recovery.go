@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"fmt"
+	"jokedb/intetnal/wal"
+	"time"
+)
+
+// RecoveryTarget is the point the replay of the WAL stops at: the records after LSN
+// or written after Time are not applied. Records without an LSN are always applied.
+// The replay stops before the whole WAL batch of the first such record, a batch holds whole
+// transactions, so one is never applied in part.
+type RecoveryTarget struct {
+	LSN  uint64
+	Time time.Time
+}
+
+func (t RecoveryTarget) IsZero() bool {
+	return t.LSN == 0 && t.Time.IsZero()
+}
+
+func (t RecoveryTarget) String() string {
+	switch {
+	case t.IsZero():
+		return ""
+	case t.Time.IsZero():
+		return fmt.Sprintf("lsn %d", t.LSN)
+	case t.LSN == 0:
+		return "time " + t.Time.UTC().Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprintf("lsn %d time %s", t.LSN, t.Time.UTC().Format(time.RFC3339Nano))
+	}
+}
+
+// reachedIn reports whether a record of the batch is after the target.
+func (t RecoveryTarget) reachedIn(batch wal.Batch) bool {
+	for _, log := range batch.Logs {
+		if t.reached(log) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t RecoveryTarget) reached(log wal.LogData) bool {
+	if log.LSN == 0 {
+		return false
+	}
+
+	return (t.LSN > 0 && log.LSN > t.LSN) ||
+		(!t.Time.IsZero() && log.Timestamp > t.Time.UnixNano())
+}
+
+// Recovery describes the replay of the WAL done by New.
+type Recovery struct {
+	Records int
+	// LSN is the last replayed LSN.
+	LSN uint64
+	// Stopped is set when the replay stopped at the recovery target.
+	Stopped bool
+	// Timeline is set when the WAL was cut at the recovery target.
+	Timeline *wal.Timeline
+}
+
+func (s *Storage) Recovery() Recovery {
+	return s.recovery
+}
+
+// LSN returns the LSN of the last record written to the WAL, or applied from the
+// replicated log with a Replicator, 0 without either.
+func (s *Storage) LSN() uint64 {
+	if s.replicator != nil {
+		return s.applied.Load()
+	}
+	if s.wal == nil {
+		return 0
+	}
+
+	return s.wal.LastLSN()
+}
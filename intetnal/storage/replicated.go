@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"jokedb/intetnal/storage/engine"
+	"jokedb/intetnal/wal"
+)
+
+var ErrReplicatedWAL = errors.New("a replicated storage has no WAL, the replicated log replaces it")
+
+// Replicator commits the writes in a replicated log, see WithReplicator.
+type Replicator interface {
+	// Propose returns after the write is committed and applied by Apply, with its error and
+	// its index in the log.
+	Propose(ctx context.Context, log wal.LogData) (uint64, error)
+	// Append returns after the write is added to the log, before it is committed.
+	Append(ctx context.Context, log wal.LogData) (uint64, error)
+}
+
+// WithReplicator sends the writes to r instead of applying them, r applies the committed
+// writes in log order with Apply on every node and compacts its log with Snapshot and Restore.
+// The storage must be created without a WAL. An async write is acknowledged once it is in the
+// log, a local one after the commit and a replicated one after the commit is on the replicas
+// of WithReplicas as well.
+func WithReplicator(r Replicator) Option {
+	return func(o *options) {
+		o.replicator = r
+	}
+}
+
+type replicatedSnapshot struct {
+	Databases int
+	LSN       uint64
+	Logs      []wal.LogData
+}
+
+func (s *Storage) propose(ctx context.Context, log wal.LogData) error {
+	if _, err := s.db(log.DB); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	switch DurabilityFromContext(ctx) {
+	case DurabilityAsync:
+		_, err := s.replicator.Append(ctx, log)
+		return err
+	case DurabilityReplicated:
+		if s.replicas == nil {
+			return ErrNoReplication
+		}
+		index, err := s.replicator.Propose(ctx, log)
+		if err != nil {
+			return err
+		}
+		return s.replicas.WaitReplicas(ctx, index, s.minReplicas)
+	default:
+		_, err := s.replicator.Propose(ctx, log)
+		return err
+	}
+}
+
+// Apply applies a write committed by the Replicator, its LSN is the position in the replicated log.
+func (s *Storage) Apply(log wal.LogData) error {
+	ctx := context.Background()
+	switch log.Action {
+	case engine.MOVE, engine.FLUSHDB, engine.SWAPDB:
+		s.applyMu.Lock()
+		defer s.applyMu.Unlock()
+	default:
+		s.applyMu.RLock()
+		defer s.applyMu.RUnlock()
+	}
+	defer s.applied.Store(log.LSN)
+
+	if log.Action == engine.MOVE {
+		// MOVE was checked before it was proposed, a write committed in between can make it
+		// invalid, every node then rejects it the same way
+		value, err := s.checkMove(ctx, log.DB, log.Key, log.TargetDB)
+		if err != nil {
+			return err
+		}
+		log.Value = value
+	}
+
+	return s.apply(ctx, log)
+}
+
+// Snapshot encodes the databases for the Replicator.
+func (s *Storage) Snapshot() ([]byte, error) {
+	s.applyMu.Lock()
+	snapshot := replicatedSnapshot{LSN: s.applied.Load(), Logs: SnapshotLogs(s.snapshotLocked())}
+	s.applyMu.Unlock()
+	snapshot.Databases = s.Databases()
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(snapshot); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Restore replaces the databases with a Snapshot.
+func (s *Storage) Restore(data []byte) error {
+	var snapshot replicatedSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return err
+	}
+	if snapshot.Databases != s.Databases() {
+		return fmt.Errorf("snapshot has %d databases, the storage %d", snapshot.Databases, s.Databases())
+	}
+
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	ctx := context.Background()
+	for _, db := range s.dbs {
+		db.Flush()
+	}
+	for _, log := range snapshot.Logs {
+		if err := s.apply(ctx, log); err != nil {
+			return err
+		}
+	}
+	s.applied.Store(snapshot.LSN)
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"jokedb/intetnal/storage/engine"
+	"jokedb/intetnal/wal"
+)
+
+type DBStats struct {
+	Keys int
+	// Bytes is the total length of the keys and values.
+	Bytes int
+}
+
+type Stats struct {
+	Databases []DBStats
+	Pending   PendingStats
+	// WAL is nil for a storage without a WAL.
+	WAL      *wal.Stats
+	Recovery Recovery
+}
+
+// Stats returns the key counts of the databases and the state of the WAL.
+func (s *Storage) Stats() Stats {
+	s.dbsMu.RLock()
+	dbs := append([]*engine.Engine(nil), s.dbs...)
+	s.dbsMu.RUnlock()
+
+	st := Stats{
+		Databases: make([]DBStats, len(dbs)),
+		Pending:   s.PendingStats(),
+		Recovery:  s.recovery,
+	}
+	for i, db := range dbs {
+		st.Databases[i] = DBStats{Keys: db.Len(), Bytes: db.Bytes()}
+	}
+	if s.wal != nil {
+		walStats := s.wal.Stats()
+		st.WAL = &walStats
+	}
+
+	return st
+}
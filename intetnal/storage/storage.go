@@ -12,72 +12,332 @@ import (
 	"time"
 )
 
-const pendingSize = 32 * 1024
+const defaultPendingSize = 32 * 1024
+
+var (
+	ErrInvalidDB = errors.New("invalid db index")
+	ErrKeyExists = errors.New("key exists in target db")
+	ErrSameDB    = errors.New("source and target db are the same")
+)
 
 type Storage struct {
-	engine               *engine.Engine
+	dbs                  []*engine.Engine
 	wal                  *wal.WAL
 	pending              chan PendingLog
-	flushingBatchSize    uint32
-	flushingBatchTimeout time.Duration
+	flushingBatchSize    atomic.Uint32
+	flushingBatchTimeout atomic.Int64
+	reconfigure          chan struct{}
+	flush                chan chan struct{}
+	done                 chan struct{}
 	isStop               atomic.Bool
 	mu                   sync.RWMutex
+	// applyMu is held shared by the key writes between the WAL write and the engine apply,
+	// and exclusively by the writes that change whole databases.
+	applyMu  sync.RWMutex
+	dbsMu    sync.RWMutex
+	target   RecoveryTarget
+	recovery Recovery
+
+	overload        OverloadPolicy
+	overloadTimeout time.Duration
+	blocked         atomic.Uint64
+	rejected        atomic.Uint64
+	failed          atomic.Uint64
+
+	replicas    ReplicaWaiter
+	minReplicas int
+
+	replicator Replicator
+	// applied is the LSN of the last write applied from the replicated log.
+	applied atomic.Uint64
+
+	snapshots *snapshots
+}
+
+type options struct {
+	databases       int
+	target          RecoveryTarget
+	pendingSize     int
+	overload        OverloadPolicy
+	overloadTimeout time.Duration
+	replicas        ReplicaWaiter
+	minReplicas     int
+	replicator      Replicator
+	snapshotTTL     time.Duration
+}
+
+type Option func(o *options)
+
+// WithDatabases sets the number of logical databases, the engine passed to New is the database 0.
+func WithDatabases(n int) Option {
+	return func(o *options) {
+		o.databases = n
+	}
+}
+
+// WithRecoveryTarget stops the replay of the WAL at the target and continues the WAL as a new timeline from there.
+func WithRecoveryTarget(target RecoveryTarget) Option {
+	return func(o *options) {
+		o.target = target
+	}
 }
 
 func New(
 	engine *engine.Engine, wal *wal.WAL,
 	flushingBatchSize uint32,
 	flushingBatchTimeout time.Duration,
+	opts ...Option,
 ) (*Storage, error) {
+	o := options{databases: 1, pendingSize: defaultPendingSize, overloadTimeout: defaultOverloadTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.pendingSize < 1 {
+		o.pendingSize = defaultPendingSize
+	}
+	if o.replicator != nil && wal != nil {
+		return nil, ErrReplicatedWAL
+	}
+
 	s := &Storage{
-		engine:               engine,
-		wal:                  wal,
-		pending:              make(chan PendingLog, pendingSize),
-		flushingBatchSize:    flushingBatchSize,
-		flushingBatchTimeout: flushingBatchTimeout,
+		dbs:         newDatabases(engine, o.databases),
+		wal:         wal,
+		pending:     make(chan PendingLog, o.pendingSize),
+		reconfigure: make(chan struct{}, 1),
+		flush:       make(chan chan struct{}),
+		done:        make(chan struct{}),
+		target:      o.target,
+
+		overload:        o.overload,
+		overloadTimeout: o.overloadTimeout,
+
+		replicas:    o.replicas,
+		minReplicas: o.minReplicas,
+		replicator:  o.replicator,
+
+		snapshots: newSnapshots(o.snapshotTTL),
 	}
+	s.flushingBatchSize.Store(flushingBatchSize)
+	s.flushingBatchTimeout.Store(int64(flushingBatchTimeout))
 
-	if err := s.recovery(); err != nil {
+	if err := s.recover(); err != nil {
 		return nil, err
 	}
 
 	go s.run()
+	go s.snapshots.sweep()
 
 	return s, nil
 }
 
-func (s *Storage) Put(ctx context.Context, kv engine.KV) error {
-	if err := s.pendingWrite(
-		ctx,
-		wal.LogData{
-			Action: engine.SET,
-			Key:    kv.Key,
-			Value:  kv.Value,
-		}); err != nil {
+func newDatabases(first *engine.Engine, n int) []*engine.Engine {
+	dbs := make([]*engine.Engine, max(n, 1))
+	dbs[0] = first
+	for i := 1; i < len(dbs); i++ {
+		dbs[i] = engine.New()
+	}
+
+	return dbs
+}
+
+func (s *Storage) Databases() int {
+	return len(s.dbs)
+}
+
+func (s *Storage) Put(ctx context.Context, db int, kv engine.KV) error {
+	return s.write(ctx, wal.LogData{
+		Action: engine.SET,
+		DB:     db,
+		Key:    kv.Key,
+		Value:  kv.Value,
+	})
+}
+
+func (s *Storage) Del(ctx context.Context, db int, kv engine.KV) error {
+	return s.write(ctx, wal.LogData{
+		Action: engine.DEL,
+		DB:     db,
+		Key:    kv.Key,
+		Value:  kv.Value,
+	})
+}
+
+func (s *Storage) Get(ctx context.Context, db int, kv engine.KV) (string, error) {
+	e, err := s.db(db)
+	if err != nil {
+		return "", err
+	}
+
+	return e.Get(ctx, kv.Key)
+}
+
+// Keys returns the keys of db matching the path.Match pattern in sorted order.
+func (s *Storage) Keys(ctx context.Context, db int, pattern string) ([]string, error) {
+	e, err := s.db(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Keys(ctx, pattern)
+}
+
+// Move moves the key from db to targetDB, the key must not exist in targetDB.
+func (s *Storage) Move(ctx context.Context, db int, key string, targetDB int) error {
+	if _, err := s.db(targetDB); err != nil {
+		return err
+	}
+	if db == targetDB {
+		return ErrSameDB
+	}
+
+	if s.replicator != nil {
+		value, err := s.checkMove(ctx, db, key, targetDB)
+		if err != nil {
+			return err
+		}
+		return s.propose(ctx, wal.LogData{Action: engine.MOVE, DB: db, TargetDB: targetDB, Key: key, Value: value})
+	}
+
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	value, err := s.checkMove(ctx, db, key, targetDB)
+	if err != nil {
 		return err
 	}
 
-	return s.engine.Upsert(ctx, kv)
+	return s.writeLocked(ctx, wal.LogData{
+		Action:   engine.MOVE,
+		DB:       db,
+		TargetDB: targetDB,
+		Key:      key,
+		Value:    value,
+	})
+}
+
+// checkMove returns the value of the key in db if it can be moved to targetDB.
+func (s *Storage) checkMove(ctx context.Context, db int, key string, targetDB int) (string, error) {
+	value, err := s.Get(ctx, db, engine.KV{Key: key})
+	if err != nil {
+		return "", err
+	}
+	_, err = s.Get(ctx, targetDB, engine.KV{Key: key})
+	switch {
+	case err == nil:
+		return "", ErrKeyExists
+	case !errors.Is(err, engine.ErrNoKey):
+		return "", err
+	}
+
+	return value, nil
 }
 
-func (s *Storage) Del(ctx context.Context, kv engine.KV) error {
-	if err := s.pendingWrite(
-		ctx,
-		wal.LogData{
-			Action: engine.DEL,
-			Key:    kv.Key,
-			Value:  kv.Value,
-		}); err != nil {
+func (s *Storage) FlushDB(ctx context.Context, db int) error {
+	if s.replicator != nil {
+		return s.propose(ctx, wal.LogData{Action: engine.FLUSHDB, DB: db})
+	}
+
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	return s.writeLocked(ctx, wal.LogData{
+		Action: engine.FLUSHDB,
+		DB:     db,
+	})
+}
+
+// SwapDB atomically exchanges the contents of two databases.
+func (s *Storage) SwapDB(ctx context.Context, db, targetDB int) error {
+	if _, err := s.db(targetDB); err != nil {
 		return err
 	}
-	return s.engine.Del(ctx, kv.Key)
+	if s.replicator != nil {
+		return s.propose(ctx, wal.LogData{Action: engine.SWAPDB, DB: db, TargetDB: targetDB})
+	}
+
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	return s.writeLocked(ctx, wal.LogData{
+		Action:   engine.SWAPDB,
+		DB:       db,
+		TargetDB: targetDB,
+	})
 }
 
-func (s *Storage) Get(ctx context.Context, kv engine.KV) (string, error) {
-	return s.engine.Get(ctx, kv.Key)
+func (s *Storage) write(ctx context.Context, log wal.LogData) error {
+	if s.replicator != nil {
+		return s.propose(ctx, log)
+	}
+
+	s.applyMu.RLock()
+	defer s.applyMu.RUnlock()
+
+	return s.writeLocked(ctx, log)
 }
 
-func (s *Storage) pendingWrite(ctx context.Context, log wal.LogData) error {
+func (s *Storage) writeLocked(ctx context.Context, log wal.LogData) error {
+	if _, err := s.db(log.DB); err != nil {
+		return err
+	}
+	if err := s.pendingWrite(ctx, log); err != nil {
+		return err
+	}
+
+	return s.apply(ctx, log)
+}
+
+func (s *Storage) db(db int) (*engine.Engine, error) {
+	s.dbsMu.RLock()
+	defer s.dbsMu.RUnlock()
+
+	if db < 0 || db >= len(s.dbs) {
+		return nil, fmt.Errorf("%w: %d, databases: %d", ErrInvalidDB, db, len(s.dbs))
+	}
+
+	return s.dbs[db], nil
+}
+
+func (s *Storage) apply(ctx context.Context, log wal.LogData) error {
+	e, err := s.db(log.DB)
+	if err != nil {
+		return err
+	}
+
+	switch log.Action {
+	case engine.SET:
+		return e.Upsert(ctx, engine.KV{Key: log.Key, Value: log.Value})
+	case engine.DEL:
+		return e.Del(ctx, log.Key)
+	case engine.FLUSHDB:
+		e.Flush()
+		return nil
+	case engine.MOVE:
+		target, errTarget := s.db(log.TargetDB)
+		if errTarget != nil {
+			return errTarget
+		}
+		if err = target.Upsert(ctx, engine.KV{Key: log.Key, Value: log.Value}); err != nil {
+			return err
+		}
+		return e.Del(ctx, log.Key)
+	case engine.SWAPDB:
+		if _, err = s.db(log.TargetDB); err != nil {
+			return err
+		}
+		s.dbsMu.Lock()
+		s.dbs[log.DB], s.dbs[log.TargetDB] = s.dbs[log.TargetDB], s.dbs[log.DB]
+		s.dbsMu.Unlock()
+		return nil
+	case engine.GET:
+		return nil
+	default:
+		return errors.New("не известный тип лога")
+	}
+}
+
+// pendingWrite writes the logs to the WAL in one batch, they are recovered all or none.
+func (s *Storage) pendingWrite(ctx context.Context, logs ...wal.LogData) error {
 	if s.wal == nil {
 		return nil
 	}
@@ -89,106 +349,214 @@ func (s *Storage) pendingWrite(ctx context.Context, log wal.LogData) error {
 		return nil
 	}
 
-	p := syncutils.NewPromise[error]()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	durability := DurabilityFromContext(ctx)
+	if durability == DurabilityReplicated && s.replicas == nil {
+		return ErrNoReplication
+	}
+
+	p := syncutils.NewPromise[written]()
+	if err := s.enqueue(ctx, PendingLog{Logs: logs, promise: p}); err != nil {
+		return err
+	}
+	if durability == DurabilityAsync {
+		// the promise is buffered, the result of the WAL write is counted by flushBatch only
+		return nil
+	}
+
+	future := p.GetFuture()
 
+	var w written
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
-	case s.pending <- PendingLog{
-		LogData: log,
-		promise: p,
-	}:
 	default:
-		p.Set(fmt.Errorf("канал для приема событий заполнен. Размер канала: %d", s.flushingBatchSize))
+		if w = future.Get(); w.err != nil {
+			return w.err
+		}
+	}
+	if durability == DurabilityReplicated {
+		return s.replicas.WaitReplicas(ctx, w.lsn, s.minReplicas)
 	}
 
-	future := p.GetFuture()
+	return nil
+}
+
+// SetFlushing changes the group commit batch size and timeout of a running storage.
+func (s *Storage) SetFlushing(batchSize uint32, batchTimeout time.Duration) {
+	s.flushingBatchSize.Store(batchSize)
+	s.flushingBatchTimeout.Store(int64(batchTimeout))
 
 	select {
-	case <-ctx.Done():
-		return ctx.Err()
+	case s.reconfigure <- struct{}{}:
 	default:
-		return future.Get()
 	}
 }
 
 func (s *Storage) run() {
-	batch, promises := s.makeBatches()
-	ticker := time.NewTicker(s.flushingBatchTimeout)
+	defer close(s.done)
+
+	batch := s.makeBatch()
+	ticker := time.NewTicker(time.Duration(s.flushingBatchTimeout.Load()))
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-s.reconfigure:
+			ticker.Reset(time.Duration(s.flushingBatchTimeout.Load()))
+			if uint32(len(batch)) >= s.flushingBatchSize.Load() {
+				s.flushBatch(batch)
+				batch = s.makeBatch()
+			}
 		case <-ticker.C:
-			s.flushBatch(batch, promises)
-			batch, promises = s.makeBatches()
+			s.flushBatch(batch)
+			batch = s.makeBatch()
+		case flushed := <-s.flush:
+			open := true
+			for drained := false; !drained && open; {
+				select {
+				case v, ok := <-s.pending:
+					if open = ok; ok {
+						batch = append(batch, v)
+					}
+				default:
+					drained = true
+				}
+			}
+			s.flushBatch(batch)
+			batch = s.makeBatch()
+			close(flushed)
+			if !open {
+				return
+			}
 		case v, ok := <-s.pending:
 			if ok {
-				batch = append(batch, v.LogData)
-				promises = append(promises, v.promise)
-				if uint32(len(batch)) == s.flushingBatchSize {
-					s.flushBatch(batch, promises)
-					batch, promises = s.makeBatches()
+				batch = append(batch, v)
+				if uint32(len(batch)) >= s.flushingBatchSize.Load() {
+					s.flushBatch(batch)
+					batch = s.makeBatch()
 				}
 			} else {
-				s.flushBatch(batch, promises)
+				s.flushBatch(batch)
 				return
 			}
 		}
 	}
 }
 
-func (s *Storage) makeBatches() ([]wal.LogData, []syncutils.Promise[error]) {
-	batch := make([]wal.LogData, 0, s.flushingBatchSize)
-	promises := make([]syncutils.Promise[error], 0, s.flushingBatchSize)
-	return batch, promises
+// flushPending makes the run loop write the queued writes to the WAL, the async ones included.
+// The caller holds applyMu exclusively, so no write is queued meanwhile.
+func (s *Storage) flushPending() {
+	flushed := make(chan struct{})
+	select {
+	case s.flush <- flushed:
+		<-flushed
+	case <-s.done:
+	}
 }
 
-func (s *Storage) flushBatch(batch []wal.LogData, promises []syncutils.Promise[error]) {
+func (s *Storage) makeBatch() []PendingLog {
+	return make([]PendingLog, 0, s.flushingBatchSize.Load())
+}
+
+// flushBatch acknowledges the writes after the WAL write returns: with wal.SyncAlways every
+// write is written and synced on its own, with wal.SyncBatch the batch is synced once, with
+// wal.SyncInterval and wal.SyncNever the records are only in the OS page cache on ack.
+func (s *Storage) flushBatch(batch []PendingLog) {
 	if len(batch) == 0 {
 		return
 	}
-	err := s.wal.Write(batch)
-	for _, p := range promises {
-		p.Set(err)
+	if s.wal.SyncPolicy() == wal.SyncAlways {
+		for _, p := range batch {
+			err := s.wal.Write(p.Logs)
+			if err != nil {
+				s.failed.Add(1)
+			}
+			p.promise.Set(written{lsn: s.wal.LastLSN(), err: err})
+		}
+		return
+	}
+
+	logs := make([]wal.LogData, 0, len(batch))
+	for _, p := range batch {
+		logs = append(logs, p.Logs...)
+	}
+	err := s.wal.Write(logs)
+	if err != nil {
+		s.failed.Add(uint64(len(batch)))
+	}
+	// the run loop is the only writer of the WAL, the batch ends at its last LSN
+	lsn := s.wal.LastLSN() - uint64(len(logs))
+	for _, p := range batch {
+		lsn += uint64(len(p.Logs))
+		p.promise.Set(written{lsn: lsn, err: err})
 	}
 }
 
+// PendingLog is a write waiting for the group commit, its logs are never split between WAL writes.
 type PendingLog struct {
-	wal.LogData
-	promise syncutils.Promise[error]
+	Logs    []wal.LogData
+	promise syncutils.Promise[written]
 }
 
-func (s *Storage) recovery() error {
+// written is the result of the WAL write of a PendingLog, lsn is the LSN of its last record.
+type written struct {
+	lsn uint64
+	err error
+}
+
+func (s *Storage) recover() error {
 	if s.wal == nil {
 		return nil
 	}
 
 	ctx := context.Background()
-	logs, err := s.wal.ReadSegments()
+	batches, err := s.wal.ReadBatches()
 	if err != nil {
 		return err
 	}
 
-	s.engine.Flush()
+	target := s.target
+	if !target.IsZero() {
+		timeline, err := s.wal.Timeline()
+		if err != nil {
+			return err
+		}
+		if timeline != nil && timeline.Target == target.String() {
+			// the WAL was already cut at this target, the records after it belong to the new timeline
+			target = RecoveryTarget{}
+		}
+	}
+
+	for _, db := range s.dbs {
+		db.Flush()
+	}
 
-	for _, log := range logs {
+	for _, batch := range batches {
 		if s.isStop.Load() {
 			return nil
 		}
-		kv := engine.KV{Key: log.Key, Value: log.Value}
-		switch log.Action {
-		case engine.SET:
-			if err = s.engine.Upsert(ctx, kv); err != nil {
-				return err
-			}
-		case engine.DEL:
-			if err = s.engine.Del(ctx, kv.Key); err != nil {
+		// the target is checked at the batch boundaries, a transaction is replayed whole or not at all
+		if target.reachedIn(batch) {
+			s.recovery.Stopped = true
+			break
+		}
+		for _, log := range batch.Logs {
+			if err = s.apply(ctx, log); err != nil {
 				return err
 			}
-		case engine.GET:
-		default:
-			return errors.New("не известный тип лога")
+			s.recovery.LSN = max(s.recovery.LSN, log.LSN)
+			s.recovery.Records++
+		}
+	}
+
+	if s.recovery.Stopped {
+		s.recovery.Timeline, err = s.wal.Truncate(s.recovery.LSN, target.String())
+		if err != nil {
+			return fmt.Errorf("start new timeline: %w", err)
 		}
 	}
 
@@ -201,4 +569,6 @@ func (s *Storage) Close() {
 	s.mu.Unlock()
 
 	close(s.pending)
+	<-s.done
+	close(s.snapshots.stop)
 }
@@ -2,9 +2,11 @@ package storage_test
 
 import (
 	"context"
+	"errors"
 	"jokedb/intetnal/storage"
 	"jokedb/intetnal/storage/engine"
 	wallog "jokedb/intetnal/wal"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
@@ -33,7 +35,7 @@ func TestStorage(t *testing.T) {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				err = s.Put(ctx, engine.KV{Key: "key1", Value: "value1"})
+				err = s.Put(ctx, 0, engine.KV{Key: "key1", Value: "value1"})
 				assert.NoError(t, err)
 			}()
 		}
@@ -57,7 +59,7 @@ func TestStorage(t *testing.T) {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				err = s.Del(ctx, engine.KV{Key: "key1", Value: "value1"})
+				err = s.Del(ctx, 0, engine.KV{Key: "key1", Value: "value1"})
 				assert.NoError(t, err)
 			}()
 		}
@@ -76,17 +78,17 @@ func TestStorage(t *testing.T) {
 		t.Cleanup(s.Close)
 		ctx := context.Background()
 
-		err = s.Put(ctx, engine.KV{Key: "key_123", Value: "value_123"})
+		err = s.Put(ctx, 0, engine.KV{Key: "key_123", Value: "value_123"})
 		require.NoError(t, err)
 
-		v, err := s.Get(ctx, engine.KV{Key: "key_123"})
+		v, err := s.Get(ctx, 0, engine.KV{Key: "key_123"})
 		require.NoError(t, err)
 		require.Equal(t, "value_123", v)
 
-		err = s.Del(ctx, engine.KV{Key: "key_123"})
+		err = s.Del(ctx, 0, engine.KV{Key: "key_123"})
 		require.NoError(t, err)
 
-		_, err = s.Get(ctx, engine.KV{Key: "key_123"})
+		_, err = s.Get(ctx, 0, engine.KV{Key: "key_123"})
 		require.ErrorIs(t, err, engine.ErrNoKey)
 	})
 	t.Run("recovery", func(t *testing.T) {
@@ -98,10 +100,314 @@ func TestStorage(t *testing.T) {
 		t.Cleanup(s.Close)
 		ctx := context.Background()
 
-		v, _ := s.Get(ctx, engine.KV{Key: "key_321"})
+		v, _ := s.Get(ctx, 0, engine.KV{Key: "key_321"})
 		require.Equal(t, "value_321", v)
 
-		v, _ = s.Get(ctx, engine.KV{Key: "key_322"})
+		v, _ = s.Get(ctx, 0, engine.KV{Key: "key_322"})
 		require.Equal(t, "value_322", v)
 	})
+	t.Run("databases", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		wal, err := wallog.Open(wallog.WithDirPath(dir))
+		require.NoError(t, err)
+		s, err := storage.New(engine.New(), wal, 1, time.Millisecond, storage.WithDatabases(3))
+		require.NoError(t, err)
+		ctx := context.Background()
+
+		require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "key_1", Value: "value_1"}))
+		require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "key_2", Value: "value_2"}))
+		require.NoError(t, s.Put(ctx, 2, engine.KV{Key: "key_3", Value: "value_3"}))
+
+		require.NoError(t, s.Move(ctx, 0, "key_1", 1))
+		_, err = s.Get(ctx, 0, engine.KV{Key: "key_1"})
+		require.ErrorIs(t, err, engine.ErrNoKey)
+		require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "key_1", Value: "value_0"}))
+		require.ErrorIs(t, s.Move(ctx, 0, "key_1", 1), storage.ErrKeyExists)
+		require.ErrorIs(t, s.Move(ctx, 0, "key_1", 3), storage.ErrInvalidDB)
+
+		require.NoError(t, s.SwapDB(ctx, 1, 2))
+		require.NoError(t, s.FlushDB(ctx, 0))
+		s.Close()
+
+		check := func(s *storage.Storage) {
+			v, errGet := s.Get(ctx, 2, engine.KV{Key: "key_1"})
+			require.NoError(t, errGet)
+			require.Equal(t, "value_1", v)
+			v, errGet = s.Get(ctx, 1, engine.KV{Key: "key_3"})
+			require.NoError(t, errGet)
+			require.Equal(t, "value_3", v)
+			_, errGet = s.Get(ctx, 0, engine.KV{Key: "key_2"})
+			require.ErrorIs(t, errGet, engine.ErrNoKey)
+		}
+		check(s)
+
+		wal, err = wallog.Open(wallog.WithDirPath(dir))
+		require.NoError(t, err)
+		recovered, err := storage.New(engine.New(), wal, 1, time.Millisecond, storage.WithDatabases(3))
+		require.NoError(t, err)
+		t.Cleanup(recovered.Close)
+		check(recovered)
+	})
+	t.Run("durability", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		wal, err := wallog.Open(wallog.WithDirPath(dir))
+		require.NoError(t, err)
+		replicas := &fakeReplicas{}
+		s, err := storage.New(engine.New(), wal, 100, time.Hour, storage.WithReplicas(replicas, 2))
+		require.NoError(t, err)
+		ctx := context.Background()
+
+		// async does not wait for the batch that is flushed by Close only
+		async := storage.WithDurability(ctx, storage.DurabilityAsync)
+		require.NoError(t, s.Put(async, 0, engine.KV{Key: "key_1", Value: "value_1"}))
+		v, err := s.Get(ctx, 0, engine.KV{Key: "key_1"})
+		require.NoError(t, err)
+		require.Equal(t, "value_1", v)
+		require.Equal(t, uint64(0), s.LSN())
+
+		s.SetFlushing(1, time.Hour)
+		replicated := storage.WithDurability(ctx, storage.DurabilityReplicated)
+		require.NoError(t, s.Put(replicated, 0, engine.KV{Key: "key_2", Value: "value_2"}))
+		require.Equal(t, []uint64{2}, replicas.lsns)
+		s.Close()
+
+		wal, err = wallog.Open(wallog.WithDirPath(dir))
+		require.NoError(t, err)
+		s, err = storage.New(engine.New(), wal, 1, time.Millisecond)
+		require.NoError(t, err)
+		t.Cleanup(s.Close)
+		v, err = s.Get(ctx, 0, engine.KV{Key: "key_1"})
+		require.NoError(t, err)
+		require.Equal(t, "value_1", v)
+		require.ErrorIs(t, s.Put(replicated, 0, engine.KV{Key: "key_3"}), storage.ErrNoReplication)
+	})
+	t.Run("point_in_time", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		wal, err := wallog.Open(wallog.WithDirPath(dir), wallog.WithMaxSizeSegment(1024))
+		require.NoError(t, err)
+		s, err := storage.New(engine.New(), wal, 1, time.Millisecond)
+		require.NoError(t, err)
+		ctx := context.Background()
+
+		for i := 0; i < 20; i++ {
+			require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "key", Value: strconv.Itoa(i)}))
+		}
+		require.Equal(t, uint64(20), s.LSN())
+		s.Close()
+		require.NoError(t, wal.Close())
+
+		open := func(target storage.RecoveryTarget) *storage.Storage {
+			wal, errOpen := wallog.Open(wallog.WithDirPath(dir), wallog.WithMaxSizeSegment(1024))
+			require.NoError(t, errOpen)
+			s, errOpen := storage.New(engine.New(), wal, 1, time.Millisecond, storage.WithRecoveryTarget(target))
+			require.NoError(t, errOpen)
+			return s
+		}
+
+		target := storage.RecoveryTarget{LSN: 5}
+		s = open(target)
+		v, err := s.Get(ctx, 0, engine.KV{Key: "key"})
+		require.NoError(t, err)
+		require.Equal(t, "4", v)
+		require.True(t, s.Recovery().Stopped)
+		require.NotNil(t, s.Recovery().Timeline)
+		require.DirExists(t, s.Recovery().Timeline.Archive)
+		require.Equal(t, uint64(5), s.LSN())
+
+		require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "key", Value: "new"}))
+		require.Equal(t, uint64(6), s.LSN())
+		s.Close()
+
+		// the same target again keeps the writes of the new timeline
+		s = open(target)
+		t.Cleanup(s.Close)
+		v, err = s.Get(ctx, 0, engine.KV{Key: "key"})
+		require.NoError(t, err)
+		require.Equal(t, "new", v)
+		require.False(t, s.Recovery().Stopped)
+		require.Equal(t, uint64(6), s.LSN())
+	})
+	t.Run("point_in_time_batch", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		wal, err := wallog.Open(wallog.WithDirPath(dir))
+		require.NoError(t, err)
+		s, err := storage.New(engine.New(), wal, 1, time.Millisecond)
+		require.NoError(t, err)
+		ctx := context.Background()
+
+		require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "a", Value: "1"}))
+		tx, err := s.Begin(ctx, 0)
+		require.NoError(t, err)
+		for _, key := range []string{"x", "y", "z"} {
+			require.NoError(t, tx.Set(key, "tx"))
+		}
+		require.NoError(t, tx.Commit(ctx))
+		require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "b", Value: "2"}))
+		require.Equal(t, uint64(5), s.LSN())
+		s.Close()
+		require.NoError(t, wal.Close())
+
+		// the target is inside the batch of the transaction, none of it is recovered
+		wal, err = wallog.Open(wallog.WithDirPath(dir))
+		require.NoError(t, err)
+		s, err = storage.New(engine.New(), wal, 1, time.Millisecond, storage.WithRecoveryTarget(storage.RecoveryTarget{LSN: 3}))
+		require.NoError(t, err)
+		t.Cleanup(s.Close)
+		require.True(t, s.Recovery().Stopped)
+		require.Equal(t, uint64(1), s.Recovery().LSN)
+		require.Equal(t, uint64(1), s.LSN())
+		for _, key := range []string{"x", "y", "z", "b"} {
+			_, err = s.Get(ctx, 0, engine.KV{Key: key})
+			require.ErrorIs(t, err, engine.ErrNoKey, key)
+		}
+		v, err := s.Get(ctx, 0, engine.KV{Key: "a"})
+		require.NoError(t, err)
+		require.Equal(t, "1", v)
+	})
+	t.Run("replicated", func(t *testing.T) {
+		t.Parallel()
+		wal, err := wallog.Open(wallog.WithDirPath(t.TempDir()))
+		require.NoError(t, err)
+		_, err = storage.New(engine.New(), wal, 1, time.Millisecond, storage.WithReplicator(&fakeReplicator{}))
+		require.ErrorIs(t, err, storage.ErrReplicatedWAL)
+
+		r := &fakeReplicator{}
+		s, err := storage.New(engine.New(), nil, 1, time.Millisecond, storage.WithDatabases(2), storage.WithReplicator(r))
+		require.NoError(t, err)
+		t.Cleanup(s.Close)
+		r.s = s
+		ctx := context.Background()
+
+		require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "key_1", Value: "value_1"}))
+		require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "key_2", Value: "value_2"}))
+		require.NoError(t, s.Move(ctx, 0, "key_1", 1))
+		require.NoError(t, s.Del(ctx, 0, engine.KV{Key: "key_2"}))
+		require.ErrorIs(t, s.Put(ctx, 5, engine.KV{Key: "key_1", Value: "v"}), storage.ErrInvalidDB)
+		require.Len(t, r.logs, 4)
+		require.Equal(t, uint64(4), s.LSN())
+
+		// a transaction may read but not write
+		tx, err := s.Begin(ctx, 1)
+		require.NoError(t, err)
+		v, err := tx.Get(ctx, "key_1")
+		require.NoError(t, err)
+		require.Equal(t, "value_1", v)
+		require.ErrorIs(t, tx.Set("key_1", "v"), storage.ErrReplicatedTx)
+		require.ErrorIs(t, tx.Del("key_1"), storage.ErrReplicatedTx)
+		require.NoError(t, tx.Commit(ctx))
+
+		// a MOVE made invalid by a write committed after its check is rejected by the apply
+		require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "key_1", Value: "value_0"}))
+		require.ErrorIs(t, s.Apply(wallog.LogData{LSN: 6, Action: engine.MOVE, DB: 0, TargetDB: 1, Key: "key_1"}), storage.ErrKeyExists)
+
+		data, err := s.Snapshot()
+		require.NoError(t, err)
+		restored, err := storage.New(engine.New(), nil, 1, time.Millisecond, storage.WithDatabases(2), storage.WithReplicator(r))
+		require.NoError(t, err)
+		t.Cleanup(restored.Close)
+		require.NoError(t, restored.Restore(data))
+		require.Equal(t, s.Dump(), restored.Dump())
+		require.Equal(t, uint64(6), restored.LSN())
+
+		other, err := storage.New(engine.New(), nil, 1, time.Millisecond, storage.WithReplicator(r))
+		require.NoError(t, err)
+		t.Cleanup(other.Close)
+		require.Error(t, other.Restore(data))
+	})
+	t.Run("replicated_durability", func(t *testing.T) {
+		t.Parallel()
+		r := &fakeReplicator{}
+		replicas := &fakeReplicas{}
+		s, err := storage.New(engine.New(), nil, 1, time.Millisecond, storage.WithReplicator(r), storage.WithReplicas(replicas, 2))
+		require.NoError(t, err)
+		t.Cleanup(s.Close)
+		r.s = s
+		ctx := context.Background()
+
+		// async is acknowledged before the commit
+		require.NoError(t, s.Put(storage.WithDurability(ctx, storage.DurabilityAsync), 0, engine.KV{Key: "key_1", Value: "value_1"}))
+		_, err = s.Get(ctx, 0, engine.KV{Key: "key_1"})
+		require.ErrorIs(t, err, engine.ErrNoKey)
+
+		require.NoError(t, s.Put(ctx, 0, engine.KV{Key: "key_2", Value: "value_2"}))
+		v, err := s.Get(ctx, 0, engine.KV{Key: "key_1"})
+		require.NoError(t, err)
+		require.Equal(t, "value_1", v)
+		require.Empty(t, replicas.lsns)
+
+		require.NoError(t, s.Put(storage.WithDurability(ctx, storage.DurabilityReplicated), 0, engine.KV{Key: "key_3", Value: "value_3"}))
+		require.Equal(t, []uint64{3}, replicas.lsns)
+
+		other, err := storage.New(engine.New(), nil, 1, time.Millisecond, storage.WithReplicator(r))
+		require.NoError(t, err)
+		t.Cleanup(other.Close)
+		require.ErrorIs(t, other.Put(storage.WithDurability(ctx, storage.DurabilityReplicated), 0, engine.KV{Key: "key_4"}), storage.ErrNoReplication)
+	})
+}
+
+type fakeReplicas struct {
+	lsns []uint64
+}
+
+func (r *fakeReplicas) WaitReplicas(_ context.Context, lsn uint64, n int) error {
+	if n != 2 {
+		return errors.New("unexpected replica count")
+	}
+	r.lsns = append(r.lsns, lsn)
+	return nil
+}
+
+// fakeReplicator commits every proposed write at once, an appended write is committed with the next proposal.
+type fakeReplicator struct {
+	s       *storage.Storage
+	logs    []wallog.LogData
+	applied int
+}
+
+func (r *fakeReplicator) Propose(ctx context.Context, log wallog.LogData) (uint64, error) {
+	index, _ := r.Append(ctx, log)
+	var err error
+	for ; r.applied < len(r.logs); r.applied++ {
+		err = r.s.Apply(r.logs[r.applied])
+	}
+	return index, err
+}
+
+func (r *fakeReplicator) Append(_ context.Context, log wallog.LogData) (uint64, error) {
+	log.LSN = uint64(len(r.logs) + 1)
+	r.logs = append(r.logs, log)
+	return log.LSN, nil
+}
+
+func BenchmarkStorage_Fsync(b *testing.B) {
+	for _, fsync := range []string{"always", "batch", "interval(100ms)", "never"} {
+		b.Run(fsync, func(b *testing.B) {
+			policy, interval, err := wallog.ParseSyncPolicy(fsync)
+			require.NoError(b, err)
+			wal, err := wallog.Open(wallog.WithDirPath(b.TempDir()), wallog.WithSync(policy, interval))
+			require.NoError(b, err)
+			s, err := storage.New(engine.New(), wal, 100, time.Millisecond)
+			require.NoError(b, err)
+			b.Cleanup(func() {
+				s.Close()
+				_ = wal.Close()
+			})
+			ctx := context.Background()
+
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					if err := s.Put(ctx, 0, engine.KV{Key: strconv.Itoa(i), Value: "value"}); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
 }
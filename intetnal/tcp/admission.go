@@ -0,0 +1,111 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jokedb/intetnal/reply"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// AdmissionPolicy tells what happens to a connection over the max connections.
+type AdmissionPolicy int8
+
+const (
+	// AdmissionQueue keeps the connection waiting for a free slot up to the queue timeout.
+	AdmissionQueue AdmissionPolicy = iota
+	// AdmissionReject answers with an error and closes the connection at once.
+	AdmissionReject
+)
+
+func ParseAdmissionPolicy(s string) (AdmissionPolicy, error) {
+	switch s {
+	case "queue":
+		return AdmissionQueue, nil
+	case "reject":
+		return AdmissionReject, nil
+	default:
+		return 0, fmt.Errorf("unknown admission policy %q, want queue or reject", s)
+	}
+}
+
+// Admission configures the connections over the max connections, QueueTimeout 0 waits without a limit.
+type Admission struct {
+	Policy       AdmissionPolicy
+	QueueTimeout time.Duration
+}
+
+const rejectWriteTimeout = time.Second
+
+type admission struct {
+	policy       atomic.Int32
+	queueTimeout atomic.Int64
+	rejected     atomic.Uint64
+}
+
+func (a *admission) set(v Admission) {
+	a.policy.Store(int32(v.Policy))
+	a.queueTimeout.Store(int64(v.QueueTimeout))
+}
+
+func WithAdmission(a Admission) ServerOption {
+	return func(s *Server) {
+		s.admission.set(a)
+	}
+}
+
+// ConnStats counts the connections holding a slot, waiting for one and rejected since the start.
+type ConnStats struct {
+	Active   uint
+	Queued   uint
+	Rejected uint64
+}
+
+func (s Server) SetAdmission(a Admission) {
+	s.admission.set(a)
+}
+
+func (s Server) ConnStats() ConnStats {
+	active, queued := s.limiter.Stats()
+	return ConnStats{
+		Active:   active,
+		Queued:   queued,
+		Rejected: s.admission.rejected.Load(),
+	}
+}
+
+// admit takes a connection slot following the admission policy. A rejected
+// connection gets an error message and is closed.
+func (s Server) admit(ctx context.Context, conn net.Conn) bool {
+	var err error
+	if AdmissionPolicy(s.admission.policy.Load()) == AdmissionReject {
+		if !s.limiter.TryAcquire() {
+			err = errors.New("max connections reached")
+		}
+	} else {
+		timeout := time.Duration(s.admission.queueTimeout.Load())
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		if err = s.limiter.AcquireContext(ctx); err != nil {
+			err = fmt.Errorf("max connections reached, waited %s", timeout)
+		}
+	}
+	if err == nil {
+		return true
+	}
+
+	s.admission.rejected.Add(1)
+	s.logger.Infof("connection %s rejected: %v", conn.RemoteAddr(), err)
+	_ = conn.SetWriteDeadline(time.Now().Add(rejectWriteTimeout))
+	_, _ = conn.Write(reply.Fail(reply.Busy, err.Error()+", try again later").Encode())
+	if errClose := conn.Close(); errClose != nil {
+		s.logger.Error(errClose)
+	}
+
+	return false
+}
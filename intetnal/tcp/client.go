@@ -1,10 +1,20 @@
 package tcp
 
-import "net"
+import (
+	"errors"
+	"jokedb/intetnal/reply"
+	"net"
+	"time"
+)
+
+// responseBufferSize is the size of a read of the replies, a longer reply takes several reads.
+const responseBufferSize = 64 * 1024
 
 type Client struct {
 	conn   net.Conn
 	logger Logger
+	// pending holds the bytes read after the last decoded reply.
+	pending []byte
 }
 
 func NewClient(addr string, logger Logger) (*Client, error) {
@@ -20,20 +30,84 @@ func NewClient(addr string, logger Logger) (*Client, error) {
 	}, nil
 }
 
-func (c *Client) Send(msg []byte) ([]byte, error) {
-	buffer := make([]byte, bufferSize)
-
-	if _, err := c.conn.Write(msg); err != nil {
+// Do sends a command and decodes its reply. An error reply is returned with its *reply.Error,
+// errors.Is matches it with the errors of the reply package such as reply.ErrNotFound.
+func (c *Client) Do(cmd string) (reply.Reply, error) {
+	if _, err := c.conn.Write(frame([]byte(cmd))); err != nil {
 		c.logger.Error(err)
-		return nil, err
+		return reply.Reply{}, err
+	}
+
+	return c.ReceiveReply()
+}
+
+// ReceiveReply waits for the next reply, such as a notification pushed to a subscriber.
+func (c *Client) ReceiveReply() (reply.Reply, error) {
+	buffer := make([]byte, responseBufferSize)
+	for {
+		r, n, err := reply.Decode(c.pending)
+		if err == nil {
+			c.pending = c.pending[n:]
+			if r.Kind == reply.KindError {
+				return r, r.Err
+			}
+			return r, nil
+		}
+		if !errors.Is(err, reply.ErrIncomplete) {
+			c.pending = nil
+			return reply.Reply{}, err
+		}
+
+		read, errRead := c.conn.Read(buffer)
+		if errRead != nil {
+			return reply.Reply{}, errRead
+		}
+		c.pending = append(c.pending, buffer[:read]...)
 	}
-	n, err := c.conn.Read(buffer)
+}
+
+// Ping checks the connection, it also keeps it from the idle timeout of the server.
+func (c *Client) Ping() error {
+	r, err := c.Do("PING")
 	if err != nil {
-		c.logger.Error(err)
-		return nil, err
+		return err
 	}
+	if r.Text != "PONG" {
+		return errors.New(r.String())
+	}
+
+	return nil
+}
+
+// Durability levels of the writes, see the DURABILITY command.
+const (
+	DurabilityAsync      = "async"
+	DurabilityLocal      = "local"
+	DurabilityReplicated = "replicated"
+)
+
+// SetDurability sets the durability level of the following writes of the connection.
+func (c *Client) SetDurability(level string) error {
+	r, err := c.Do("DURABILITY " + level)
+	if err != nil {
+		return err
+	}
+	if r.Text != "DURABILITY ok" {
+		return errors.New(r.String())
+	}
+
+	return nil
+}
+
+// DoDurable runs a write command that is acknowledged with the durability level
+// instead of the level of the connection.
+func (c *Client) DoDurable(level, cmd string) (reply.Reply, error) {
+	return c.Do("DURABILITY " + level + " " + cmd)
+}
 
-	return buffer[:n], nil
+// SetDeadline limits the following Do and ReceiveReply calls, the zero time removes the limit.
+func (c *Client) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
 }
 
 func (c *Client) Close() {
@@ -0,0 +1,99 @@
+package tcp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	// DefaultMaxRequestSize is the largest request in bytes a server reads unless WithMaxRequestSize
+	// changes it.
+	DefaultMaxRequestSize = 4 << 20
+	// maxHeaderSize fits "$<length>\n" of any request a server may accept.
+	maxHeaderSize = 16
+)
+
+var (
+	errBadFrame        = errors.New("malformed request frame")
+	errRequestTooLarge = errors.New("request is too large")
+)
+
+// frame returns the request as it is sent: a request that does not fit the read buffer of the server
+// is sent as "$<length>\n<request>", the encoding of the value replies, so the server reads it whole.
+// A shorter request is sent as it is, a command never starts with $.
+func frame(request []byte) []byte {
+	if len(request) < bufferSize {
+		return request
+	}
+	framed := make([]byte, 0, len(request)+maxHeaderSize)
+	framed = append(framed, '$')
+	framed = strconv.AppendInt(framed, int64(len(request)), 10)
+	framed = append(framed, '\n')
+	return append(framed, request...)
+}
+
+// readRequest returns the request that begins with data, the bytes of the first read. A framed request
+// is read until its length, a request that is not framed is data itself.
+func (hc *HandlerConn) readRequest(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != '$' {
+		return data, nil
+	}
+
+	if hc.timeouts != nil {
+		if err := hc.conn.SetReadDeadline(deadline(hc.timeouts.get().Read)); err != nil {
+			return nil, err
+		}
+	}
+	data = append([]byte(nil), data...)
+	header, body, ok := bytes.Cut(data, []byte("\n"))
+	for !ok {
+		if len(data) > maxHeaderSize {
+			return nil, errBadFrame
+		}
+		more, err := hc.readMore()
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, more...)
+		header, body, ok = bytes.Cut(data, []byte("\n"))
+	}
+	size, err := strconv.Atoi(string(header[1:]))
+	if err != nil || size < 0 || len(body) > size {
+		return nil, errBadFrame
+	}
+	if limit := int(hc.maxRequestSize.Load()); size > limit {
+		return nil, fmt.Errorf("%w: %d bytes, the limit is %d", errRequestTooLarge, size, limit)
+	}
+
+	// the request grows with the bytes that arrive, a length alone does not make the server allocate it
+	var request bytes.Buffer
+	request.Write(body)
+	if _, err = io.CopyN(&request, connReader{hc}, int64(size-len(body))); err != nil {
+		return nil, err
+	}
+
+	return request.Bytes(), nil
+}
+
+// readMore reads the next part of a request into the buffer.
+func (hc *HandlerConn) readMore() ([]byte, error) {
+	n, err := connReader{hc}.Read(hc.buffer)
+	if err != nil {
+		return nil, err
+	}
+	return hc.buffer[:n], nil
+}
+
+// connReader reads the connection and counts the bytes received.
+type connReader struct {
+	hc *HandlerConn
+}
+
+func (r connReader) Read(p []byte) (int, error) {
+	n, err := r.hc.conn.Read(p)
+	r.hc.bytesIn.Add(uint64(n))
+	return n, err
+}
@@ -4,39 +4,212 @@ import (
 	"context"
 	"errors"
 	"io"
+	"jokedb/intetnal/reply"
 	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type HandelQuery func(ctx context.Context, s string) string
 
 type HandlerConn struct {
-	conn   net.Conn
-	buffer []byte
-	logger Logger
+	conn    net.Conn
+	buffer  []byte
+	logger  Logger
+	mu      sync.Mutex
+	session any
+	onClose []func()
+	writeMu sync.Mutex
+
+	timeouts *timeouts
+	noIdle   atomic.Bool
+	// maxRequestSize is the limit of a framed request, shared with the server.
+	maxRequestSize *atomic.Int64
+
+	id          uint64
+	connectedAt time.Time
+	name        string
+	lastCommand string
+	lastActive  atomic.Int64
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+	killed      atomic.Bool
+}
+
+type connKey struct{}
+
+// ConnFromContext returns the connection the query came from.
+func ConnFromContext(ctx context.Context) (*HandlerConn, bool) {
+	hc, ok := ctx.Value(connKey{}).(*HandlerConn)
+	return hc, ok
+}
+
+func (hc *HandlerConn) RemoteAddr() string {
+	return hc.conn.RemoteAddr().String()
+}
+
+// ID is the id of the connection in the registry of the server.
+func (hc *HandlerConn) ID() uint64 {
+	return hc.id
+}
+
+func (hc *HandlerConn) Name() string {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	return hc.name
+}
+
+func (hc *HandlerConn) SetName(name string) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.name = name
+}
+
+func (hc *HandlerConn) Info() ClientInfo {
+	hc.mu.Lock()
+	name, lastCommand := hc.name, hc.lastCommand
+	hc.mu.Unlock()
+
+	return ClientInfo{
+		ID:          hc.id,
+		Addr:        hc.RemoteAddr(),
+		Name:        name,
+		ConnectedAt: hc.connectedAt,
+		LastCommand: lastCommand,
+		Idle:        time.Since(time.Unix(0, hc.lastActive.Load())),
+		BytesIn:     hc.bytesIn.Load(),
+		BytesOut:    hc.bytesOut.Load(),
+	}
+}
+
+// kill closes the connection, the blocked read returns and Handel runs the OnClose functions.
+func (hc *HandlerConn) kill() {
+	hc.killed.Store(true)
+	if err := hc.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		hc.logger.Error(err)
+	}
+}
+
+// Session returns the per-connection state of the query handler set by SetSession.
+func (hc *HandlerConn) Session() any {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	return hc.session
+}
+
+func (hc *HandlerConn) SetSession(session any) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.session = session
+}
+
+// OnClose registers fn to be called after the connection is closed.
+func (hc *HandlerConn) OnClose(fn func()) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.onClose = append(hc.onClose, fn)
+}
+
+// Write sends data to the client, it is safe to call concurrently with the query handling.
+func (hc *HandlerConn) Write(data []byte) (int, error) {
+	hc.writeMu.Lock()
+	defer hc.writeMu.Unlock()
+	if hc.timeouts != nil {
+		if err := hc.conn.SetWriteDeadline(deadline(hc.timeouts.get().Write)); err != nil {
+			return 0, err
+		}
+	}
+	n, err := hc.conn.Write(data)
+	hc.bytesOut.Add(uint64(n))
+	return n, err
+}
+
+// DisableIdleTimeout keeps the connection open without requests, for the clients
+// that only receive pushed messages.
+func (hc *HandlerConn) DisableIdleTimeout() {
+	hc.noIdle.Store(true)
+}
+
+func (hc *HandlerConn) readDeadline() time.Time {
+	if hc.timeouts == nil || hc.noIdle.Load() {
+		return time.Time{}
+	}
+	return deadline(hc.timeouts.get().Idle)
 }
 
 func (hc *HandlerConn) Handel(ctx context.Context, handler HandelQuery) {
+	ctx = context.WithValue(ctx, connKey{}, hc)
+	var reason string
 	defer func(conn net.Conn) {
 		err := conn.Close()
-		if err != nil {
+		if err != nil && !hc.killed.Load() {
 			hc.logger.Error(err)
 		}
+		if hc.killed.Load() {
+			reason = "killed"
+		}
+		hc.logger.Infof("connection %s closed: %s", hc.RemoteAddr(), reason)
+
+		hc.mu.Lock()
+		onClose := hc.onClose
+		hc.mu.Unlock()
+		for _, fn := range onClose {
+			fn()
+		}
 	}(hc.conn)
 
 	for {
+		if err := hc.conn.SetReadDeadline(hc.readDeadline()); err != nil {
+			reason = "set read deadline: " + err.Error()
+			return
+		}
 		n, err := hc.conn.Read(hc.buffer)
 		if err != nil {
-			if !errors.Is(err, io.EOF) {
-				hc.logger.Error(err)
-			}
-
+			reason = disconnectReason("read", err)
+			return
+		}
+		hc.bytesIn.Add(uint64(n))
+		request, err := hc.readRequest(hc.buffer[:n])
+		if errors.Is(err, errBadFrame) || errors.Is(err, errRequestTooLarge) {
+			// the rest of the request cannot be told from the next one
+			_, _ = hc.Write(reply.Fail(reply.Syntax, err.Error()).Encode())
+			reason = err.Error()
+			return
+		}
+		if err != nil {
+			reason = disconnectReason("request read", err)
 			return
 		}
+		hc.lastActive.Store(time.Now().UnixNano())
+		command, _, _ := strings.Cut(string(request), " ")
+		hc.mu.Lock()
+		hc.lastCommand = strings.TrimSpace(command)
+		hc.mu.Unlock()
 
-		_, err = hc.conn.Write([]byte(handler(ctx, string(hc.buffer[:n]))))
+		_, err = hc.Write([]byte(handler(ctx, string(request))))
 		if err != nil {
-			hc.logger.Error(err)
+			reason = disconnectReason("write", err)
 			return
 		}
 	}
 }
+
+func disconnectReason(op string, err error) string {
+	switch {
+	case errors.Is(err, io.EOF):
+		return "closed by client"
+	case errors.Is(err, os.ErrDeadlineExceeded) && op == "read":
+		return "idle timeout"
+	case errors.Is(err, os.ErrDeadlineExceeded) && op == "request read":
+		return "read timeout"
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return "write timeout"
+	case errors.Is(err, net.ErrClosed):
+		return "closed by server"
+	default:
+		return op + " error: " + err.Error()
+	}
+}
@@ -0,0 +1,102 @@
+package tcp
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Registry keeps the live connections of a server.
+type Registry struct {
+	mu     sync.Mutex
+	nextID atomic.Uint64
+	conns  map[uint64]*HandlerConn
+}
+
+func NewRegistry() *Registry {
+	return &Registry{conns: map[uint64]*HandlerConn{}}
+}
+
+func WithRegistry(r *Registry) ServerOption {
+	return func(s *Server) {
+		s.registry = r
+	}
+}
+
+// ClientInfo describes a live connection.
+type ClientInfo struct {
+	ID          uint64
+	Addr        string
+	Name        string
+	ConnectedAt time.Time
+	LastCommand string
+	Idle        time.Duration
+	BytesIn     uint64
+	BytesOut    uint64
+}
+
+// ClientFilter selects the connections to kill, the set fields must all match.
+type ClientFilter struct {
+	ID   uint64
+	Addr string
+	Name string
+}
+
+func (f ClientFilter) match(hc *HandlerConn) bool {
+	return (f.ID == 0 || f.ID == hc.id) &&
+		(f.Addr == "" || f.Addr == hc.RemoteAddr()) &&
+		(f.Name == "" || f.Name == hc.Name())
+}
+
+func (r *Registry) add(hc *HandlerConn) {
+	hc.id = r.nextID.Add(1)
+	hc.connectedAt = time.Now()
+	hc.lastActive.Store(hc.connectedAt.UnixNano())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conns[hc.id] = hc
+}
+
+func (r *Registry) remove(hc *HandlerConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns, hc.id)
+}
+
+// Clients returns the live connections ordered by id.
+func (r *Registry) Clients() []ClientInfo {
+	r.mu.Lock()
+	conns := make([]*HandlerConn, 0, len(r.conns))
+	for _, hc := range r.conns {
+		conns = append(conns, hc)
+	}
+	r.mu.Unlock()
+
+	infos := make([]ClientInfo, 0, len(conns))
+	for _, hc := range conns {
+		infos = append(infos, hc.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+
+	return infos
+}
+
+// Kill closes the connections matching the filter and returns their number.
+func (r *Registry) Kill(f ClientFilter) int {
+	r.mu.Lock()
+	var matched []*HandlerConn
+	for _, hc := range r.conns {
+		if f.match(hc) {
+			matched = append(matched, hc)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, hc := range matched {
+		hc.kill()
+	}
+
+	return len(matched)
+}
@@ -5,27 +5,38 @@ import (
 	"errors"
 	"jokedb/intetnal/semaphore"
 	"net"
+	"sync/atomic"
 )
 
 const bufferSize = 1024
 
 type Logger interface {
 	Error(args ...interface{})
+	Infof(template string, args ...interface{})
 }
 
 type Limiter interface {
 	Acquire()
+	AcquireContext(ctx context.Context) error
+	TryAcquire() bool
 	Release()
+	SetLimit(limit uint)
+	Stats() (active, waiting uint)
 }
 
 type Server struct {
-	listener *net.TCPListener
-	logger   Logger
-	limiter  Limiter
-	handler  func(ctx context.Context, s string) string
+	listener  *net.TCPListener
+	logger    Logger
+	limiter   Limiter
+	handler   func(ctx context.Context, s string) string
+	timeouts  *timeouts
+	admission *admission
+	registry  *Registry
+	// maxRequestSize is the largest request the connections read, changed by SetMaxRequestSize.
+	maxRequestSize *atomic.Int64
 }
 
-func NewServer(addr string, maxConnections uint, logger Logger, handler HandelQuery) (*Server, error) {
+func NewServer(addr string, maxConnections uint, logger Logger, handler HandelQuery, opts ...ServerOption) (*Server, error) {
 	tcpAddr, err := net.ResolveTCPAddr("tcp4", addr)
 	if err != nil {
 		return nil, err
@@ -36,12 +47,53 @@ func NewServer(addr string, maxConnections uint, logger Logger, handler HandelQu
 		return nil, err
 	}
 
-	return &Server{
-		logger:   logger,
-		handler:  handler,
-		limiter:  semaphore.New(maxConnections),
-		listener: listener,
-	}, nil
+	s := &Server{
+		logger:         logger,
+		handler:        handler,
+		limiter:        semaphore.New(maxConnections),
+		listener:       listener,
+		timeouts:       &timeouts{},
+		admission:      &admission{},
+		registry:       NewRegistry(),
+		maxRequestSize: &atomic.Int64{},
+	}
+	s.maxRequestSize.Store(DefaultMaxRequestSize)
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s, nil
+}
+
+// SetTimeouts changes the timeouts of the new requests and connections.
+func (s Server) SetTimeouts(t Timeouts) {
+	s.timeouts.set(t)
+}
+
+// Addr returns the address the server listens on.
+func (s Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Registry returns the live connections of the server.
+func (s Server) Registry() *Registry {
+	return s.registry
+}
+
+func (s Server) SetMaxConnections(maxConnections uint) {
+	s.limiter.SetLimit(maxConnections)
+}
+
+// WithMaxRequestSize limits the bytes of a request, a longer one is refused and its connection closed.
+func WithMaxRequestSize(n int) ServerOption {
+	return func(s *Server) {
+		s.maxRequestSize.Store(int64(n))
+	}
+}
+
+// SetMaxRequestSize changes the request size limit of the next requests.
+func (s Server) SetMaxRequestSize(n int) {
+	s.maxRequestSize.Store(int64(n))
 }
 
 func (s Server) Listen(ctx context.Context) {
@@ -57,15 +109,44 @@ func (s Server) Listen(ctx context.Context) {
 			s.logger.Error(err)
 			continue
 		}
+		s.setKeepAlive(conn)
 		h := HandlerConn{
-			conn:   conn,
-			buffer: make([]byte, bufferSize),
+			conn:           conn,
+			buffer:         make([]byte, bufferSize),
+			logger:         s.logger,
+			timeouts:       s.timeouts,
+			maxRequestSize: s.maxRequestSize,
 		}
 
 		go func() {
-			s.limiter.Acquire()
+			if !s.admit(ctx, conn) {
+				return
+			}
 			defer s.limiter.Release()
+			s.registry.add(&h)
+			defer s.registry.remove(&h)
 			h.Handel(ctx, s.handler)
 		}()
 	}
 }
+
+func (s Server) setKeepAlive(conn net.Conn) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	period := s.timeouts.get().KeepAlive
+	var err error
+	switch {
+	case period < 0:
+		err = tcpConn.SetKeepAlive(false)
+	case period > 0:
+		if err = tcpConn.SetKeepAlive(true); err == nil {
+			err = tcpConn.SetKeepAlivePeriod(period)
+		}
+	}
+	if err != nil {
+		s.logger.Error(err)
+	}
+}
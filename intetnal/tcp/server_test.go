@@ -0,0 +1,148 @@
+package tcp_test
+
+import (
+	"context"
+	"io"
+	"jokedb/intetnal/reply"
+	"jokedb/intetnal/tcp"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestServer_Registry(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	serv, err := tcp.NewServer("127.0.0.1:0", 10, logger, func(ctx context.Context, s string) string {
+		conn, _ := tcp.ConnFromContext(ctx)
+		conn.SetName(s)
+		return string(reply.OK("ok").Encode())
+	})
+	require.NoError(t, err)
+	go serv.Listen(context.Background())
+
+	first, err := tcp.NewClient(serv.Addr().String(), logger)
+	require.NoError(t, err)
+	t.Cleanup(first.Close)
+	second, err := tcp.NewClient(serv.Addr().String(), logger)
+	require.NoError(t, err)
+	t.Cleanup(second.Close)
+
+	_, err = first.Do("first")
+	require.NoError(t, err)
+	_, err = second.Do("second")
+	require.NoError(t, err)
+
+	clients := serv.Registry().Clients()
+	require.Len(t, clients, 2)
+	require.Equal(t, uint64(1), clients[0].ID)
+	require.Equal(t, "first", clients[0].Name)
+	require.Equal(t, "first", clients[0].LastCommand)
+	require.Equal(t, uint64(len("first")), clients[0].BytesIn)
+	require.Equal(t, uint64(len(reply.OK("ok").Encode())), clients[0].BytesOut)
+
+	require.Equal(t, 1, serv.Registry().Kill(tcp.ClientFilter{Name: "second"}))
+	_, err = second.ReceiveReply()
+	require.Error(t, err)
+	require.Eventually(t, func() bool {
+		return len(serv.Registry().Clients()) == 1
+	}, time.Second, time.Millisecond)
+	require.Equal(t, 0, serv.Registry().Kill(tcp.ClientFilter{ID: 1, Name: "second"}))
+}
+
+func TestServer_FramedRequest(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	serv, err := tcp.NewServer("127.0.0.1:0", 10, logger, func(_ context.Context, s string) string {
+		return string(reply.Int(int64(len(s))).Encode())
+	})
+	require.NoError(t, err)
+	go serv.Listen(context.Background())
+
+	cl, err := tcp.NewClient(serv.Addr().String(), logger)
+	require.NoError(t, err)
+	t.Cleanup(cl.Close)
+
+	// a request longer than a read of the server is framed by the client and received whole
+	for _, size := range []int{10, 1023, 1024, 300 * 1024} {
+		r, errDo := cl.Do("SET k " + strings.Repeat("v", size))
+		require.NoError(t, errDo)
+		require.Equal(t, reply.Int(int64(len("SET k ")+size)), r)
+	}
+	r, err := cl.DoDurable(tcp.DurabilityLocal, "SET k "+strings.Repeat("v", 300*1024))
+	require.NoError(t, err)
+	require.Equal(t, reply.Int(int64(len("DURABILITY local SET k ")+300*1024)), r)
+
+	raw, err := net.Dial("tcp", serv.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { raw.Close() })
+	_, err = raw.Write([]byte("$x\nSET k v"))
+	require.NoError(t, err)
+	resp, err := io.ReadAll(raw)
+	require.NoError(t, err)
+	r, err = reply.Parse(resp)
+	require.NoError(t, err)
+	require.ErrorIs(t, r.Err, reply.ErrSyntax)
+}
+
+func TestServer_ReadTimeout(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	serv, err := tcp.NewServer("127.0.0.1:0", 10, logger, func(context.Context, string) string {
+		return "ok"
+	}, tcp.WithTimeouts(tcp.Timeouts{Read: 50 * time.Millisecond}))
+	require.NoError(t, err)
+	go serv.Listen(context.Background())
+
+	raw, err := net.Dial("tcp", serv.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { raw.Close() })
+
+	// no idle timeout, but a request that stops arriving is cut off
+	_, err = raw.Write([]byte("$2000\nSET k "))
+	require.NoError(t, err)
+	start := time.Now()
+	require.NoError(t, raw.SetReadDeadline(time.Now().Add(5*time.Second)))
+	resp, err := io.ReadAll(raw)
+	require.NoError(t, err)
+	require.Empty(t, resp)
+	require.Less(t, time.Since(start), 4*time.Second)
+}
+
+func TestServer_MaxRequestSize(t *testing.T) {
+	logger := zap.NewNop().Sugar()
+	serv, err := tcp.NewServer("127.0.0.1:0", 10, logger, func(_ context.Context, s string) string {
+		return string(reply.Int(int64(len(s))).Encode())
+	}, tcp.WithMaxRequestSize(4096))
+	require.NoError(t, err)
+	go serv.Listen(context.Background())
+
+	cl, err := tcp.NewClient(serv.Addr().String(), logger)
+	require.NoError(t, err)
+	t.Cleanup(cl.Close)
+	r, err := cl.Do("SET k " + strings.Repeat("v", 4000))
+	require.NoError(t, err)
+	require.Equal(t, reply.Int(4006), r)
+
+	// a length over the limit is refused before any of the request is read
+	raw, err := net.Dial("tcp", serv.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { raw.Close() })
+	_, err = raw.Write([]byte("$134217728\n"))
+	require.NoError(t, err)
+	resp, err := io.ReadAll(raw)
+	require.NoError(t, err)
+	r, err = reply.Parse(resp)
+	require.NoError(t, err)
+	require.ErrorIs(t, r.Err, reply.ErrSyntax)
+	require.Contains(t, r.Err.Error(), "the limit is 4096")
+
+	serv.SetMaxRequestSize(64 * 1024)
+	cl2, err := tcp.NewClient(serv.Addr().String(), logger)
+	require.NoError(t, err)
+	t.Cleanup(cl2.Close)
+	r, err = cl2.Do("SET k " + strings.Repeat("v", 60*1024))
+	require.NoError(t, err)
+	require.Equal(t, reply.Int(int64(6+60*1024)), r)
+}
@@ -0,0 +1,58 @@
+package tcp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Timeouts of the server connections, zero disables a timeout.
+type Timeouts struct {
+	// Idle is the read deadline of the next request, reset after every request.
+	Idle time.Duration
+	// Read bounds the read of the rest of a request once its first bytes arrived,
+	// a framed request takes several reads.
+	Read time.Duration
+	// Write is the write deadline of every response and pushed message.
+	Write time.Duration
+	// KeepAlive is the TCP keepalive period, zero keeps the OS default and negative disables keepalive.
+	KeepAlive time.Duration
+}
+
+type timeouts struct {
+	idle      atomic.Int64
+	read      atomic.Int64
+	write     atomic.Int64
+	keepAlive atomic.Int64
+}
+
+func (t *timeouts) set(v Timeouts) {
+	t.idle.Store(int64(v.Idle))
+	t.read.Store(int64(v.Read))
+	t.write.Store(int64(v.Write))
+	t.keepAlive.Store(int64(v.KeepAlive))
+}
+
+func (t *timeouts) get() Timeouts {
+	return Timeouts{
+		Idle:      time.Duration(t.idle.Load()),
+		Read:      time.Duration(t.read.Load()),
+		Write:     time.Duration(t.write.Load()),
+		KeepAlive: time.Duration(t.keepAlive.Load()),
+	}
+}
+
+type ServerOption func(s *Server)
+
+func WithTimeouts(t Timeouts) ServerOption {
+	return func(s *Server) {
+		s.timeouts.set(t)
+	}
+}
+
+// deadline returns the deadline d from now, or no deadline for zero d.
+func deadline(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
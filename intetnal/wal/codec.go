@@ -0,0 +1,65 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Batch is one Write call stored in a segment.
+type Batch struct {
+	Offset int64
+	Size   int
+	Logs   []LogData
+}
+
+// CorruptError reports the offset of the first batch of a segment that cannot be decoded.
+type CorruptError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("corrupt batch at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+// EncodeBatch encodes logs the way Write appends them to a segment.
+func EncodeBatch(logs []LogData) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(logs); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DecodeBatches decodes the batches of segment data. On a corrupt batch it
+// returns the batches before it together with a *CorruptError.
+func DecodeBatches(data []byte) ([]Batch, error) {
+	var batches []Batch
+	buf := bytes.NewBuffer(data)
+	for buf.Len() > 0 {
+		offset := int64(len(data) - buf.Len())
+		var logs []LogData
+		if err := gob.NewDecoder(buf).Decode(&logs); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return batches, &CorruptError{Offset: offset, Err: err}
+		}
+
+		batches = append(batches, Batch{
+			Offset: offset,
+			Size:   len(data) - buf.Len() - int(offset),
+			Logs:   logs,
+		})
+	}
+
+	return batches, nil
+}
@@ -0,0 +1,156 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type SegmentInfo struct {
+	ID      uint
+	Path    string
+	Size    uint32
+	Batches int
+	Records int
+	// Corrupt is set when a batch of the segment cannot be decoded.
+	Corrupt *CorruptError
+}
+
+// OpenSegment opens a segment of the WAL for reading.
+func (w *WAL) OpenSegment(id uint) (*Segment, error) {
+	fd, err := os.Open(SegmentFileName(w.opts.dirPath, id))
+	if err != nil {
+		return nil, err
+	}
+	info, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+
+	return &Segment{
+		fd:             fd,
+		id:             id,
+		size:           uint32(info.Size()),
+		maxSizeSegment: w.opts.maxSizeSegment,
+	}, nil
+}
+
+// ReadSegment decodes the batches of a segment. On a corrupt batch it returns
+// the batches before it together with a *CorruptError.
+func (w *WAL) ReadSegment(id uint) ([]Batch, error) {
+	return readSegment(w.opts.dirPath, id)
+}
+
+// Inspect decodes every segment and returns their sizes and record counts.
+func (w *WAL) Inspect() ([]SegmentInfo, error) {
+	return inspect(w.opts.dirPath, w.SegmentIDs())
+}
+
+// Dir reads the segments of a WAL directory without opening the WAL: unlike Open it creates no
+// directory or segment and does not finish a pending cut, so the files are left as they are.
+type Dir struct {
+	path string
+}
+
+// OpenDir returns the WAL directory at path for reading, it must exist.
+func OpenDir(path string) (*Dir, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", path)
+	}
+
+	return &Dir{path: path}, nil
+}
+
+func (d *Dir) Path() string {
+	return d.path
+}
+
+// SegmentIDs returns the ids of the segments in write order.
+func (d *Dir) SegmentIDs() ([]uint, error) {
+	ids, err := listSegments(d.path)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
+
+// Timeline returns the marker of the last cut, or nil if the WAL was never cut.
+func (d *Dir) Timeline() (*Timeline, error) {
+	return readTimeline(d.path)
+}
+
+// Archives returns the directories of the segments archived by the cuts, the oldest first.
+func (d *Dir) Archives() ([]string, error) {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var archives []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), timelineDirPrefix) {
+			archives = append(archives, filepath.Join(d.path, entry.Name()))
+		}
+	}
+
+	return archives, nil
+}
+
+// ReadSegment decodes the batches of a segment, see WAL.ReadSegment.
+func (d *Dir) ReadSegment(id uint) ([]Batch, error) {
+	return readSegment(d.path, id)
+}
+
+// Inspect decodes every segment, see WAL.Inspect.
+func (d *Dir) Inspect() ([]SegmentInfo, error) {
+	ids, err := d.SegmentIDs()
+	if err != nil {
+		return nil, err
+	}
+	return inspect(d.path, ids)
+}
+
+func readSegment(dirPath string, id uint) ([]Batch, error) {
+	data, err := os.ReadFile(SegmentFileName(dirPath, id))
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeBatches(data)
+}
+
+func inspect(dirPath string, ids []uint) ([]SegmentInfo, error) {
+	var infos []SegmentInfo
+	for _, id := range ids {
+		info := SegmentInfo{ID: id, Path: SegmentFileName(dirPath, id)}
+		batches, err := readSegment(dirPath, id)
+		var corrupt *CorruptError
+		switch {
+		case errors.As(err, &corrupt):
+			info.Corrupt = corrupt
+		case err != nil:
+			return nil, err
+		}
+
+		if stat, errStat := os.Stat(info.Path); errStat == nil {
+			info.Size = uint32(stat.Size())
+		}
+		info.Batches = len(batches)
+		for _, b := range batches {
+			info.Records += len(b.Logs)
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
@@ -1,5 +1,7 @@
 package wal
 
+import "time"
+
 const (
 	maxSizeSegment uint32 = 20971520
 	dirPath        string = "./db/data"
@@ -8,6 +10,8 @@ const (
 type options struct {
 	maxSizeSegment uint32
 	dirPath        string
+	syncPolicy     SyncPolicy
+	syncInterval   time.Duration
 }
 
 type Option func(options *options)
@@ -23,3 +27,11 @@ func WithDirPath(dirPath string) Option {
 		o.dirPath = dirPath
 	}
 }
+
+// WithSync sets the fsync policy, interval is used by SyncInterval only.
+func WithSync(policy SyncPolicy, interval time.Duration) Option {
+	return func(o *options) {
+		o.syncPolicy = policy
+		o.syncInterval = interval
+	}
+}
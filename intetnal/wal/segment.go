@@ -57,6 +57,10 @@ func (s *Segment) isFull(buf int) bool {
 	return (s.size + uint32(buf)) > s.maxSizeSegment
 }
 
+func (s *Segment) Size() uint32 {
+	return s.size
+}
+
 func (s *Segment) ID() uint {
 	return s.id
 }
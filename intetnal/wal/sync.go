@@ -0,0 +1,140 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// SyncPolicy tells when the WAL calls fsync on the active segment.
+type SyncPolicy int8
+
+const (
+	// SyncBatch syncs after every Write, a group commit batch is durable when Write returns.
+	SyncBatch SyncPolicy = iota
+	// SyncAlways syncs after every Write as SyncBatch, the storage writes every record on its own.
+	SyncAlways
+	// SyncInterval syncs in the background, a crash of the machine loses up to the interval of writes.
+	SyncInterval
+	// SyncNever leaves the sync to the OS, the segments are synced only on rotation and Close.
+	SyncNever
+)
+
+const defaultSyncInterval = 100 * time.Millisecond
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncAlways:
+		return "always"
+	case SyncBatch:
+		return "batch"
+	case SyncInterval:
+		return "interval"
+	case SyncNever:
+		return "never"
+	default:
+		return fmt.Sprintf("SyncPolicy(%d)", p)
+	}
+}
+
+// ParseSyncPolicy parses always, batch, interval, interval(<duration>) or never.
+// The interval is 100ms if not given.
+func ParseSyncPolicy(s string) (SyncPolicy, time.Duration, error) {
+	switch s {
+	case "always":
+		return SyncAlways, 0, nil
+	case "batch":
+		return SyncBatch, 0, nil
+	case "never":
+		return SyncNever, 0, nil
+	case "interval":
+		return SyncInterval, defaultSyncInterval, nil
+	}
+
+	if arg, ok := strings.CutPrefix(s, "interval("); ok && strings.HasSuffix(arg, ")") {
+		interval, err := time.ParseDuration(strings.TrimSuffix(arg, ")"))
+		if err != nil {
+			return 0, 0, fmt.Errorf("fsync interval: %w", err)
+		}
+		if interval <= 0 {
+			return 0, 0, fmt.Errorf("fsync interval must be positive, got %s", interval)
+		}
+		return SyncInterval, interval, nil
+	}
+
+	return 0, 0, fmt.Errorf("unknown fsync policy %q, want always, batch, interval(<duration>) or never", s)
+}
+
+// SyncPolicy returns the fsync policy the WAL was opened with.
+func (w *WAL) SyncPolicy() SyncPolicy {
+	return w.opts.syncPolicy
+}
+
+// Sync flushes the written records of the active segment to disk.
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.syncLocked()
+}
+
+func (w *WAL) syncLocked() error {
+	if !w.dirty {
+		return nil
+	}
+	if err := w.activeSegment.Sync(); err != nil {
+		return err
+	}
+	w.dirty = false
+	w.lastSync = time.Now()
+
+	return nil
+}
+
+// syncLoop syncs the active segment every interval until Close. A failed sync
+// is returned by the next Write.
+func (w *WAL) syncLoop(interval time.Duration) {
+	defer close(w.syncDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stopSync:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if err := w.syncLocked(); err != nil {
+				w.syncErr = fmt.Errorf("background sync: %w", err)
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+type Stats struct {
+	Segments   []SegmentInfo
+	LastLSN    uint64
+	SyncPolicy SyncPolicy
+	// LastSync is the time of the last fsync of the active segment, zero if there was none.
+	LastSync time.Time
+}
+
+// Stats returns the segment ids and sizes and the sync state, it does not read the segments.
+func (w *WAL) Stats() Stats {
+	w.mu.Lock()
+	st := Stats{LastLSN: w.lastLSN, SyncPolicy: w.opts.syncPolicy, LastSync: w.lastSync}
+	ids := append(append([]uint(nil), w.oldSegmentIDs...), w.activeSegment.id)
+	w.mu.Unlock()
+
+	for _, id := range ids {
+		info := SegmentInfo{ID: id, Path: SegmentFileName(w.opts.dirPath, id)}
+		if stat, err := os.Stat(info.Path); err == nil {
+			info.Size = uint32(stat.Size())
+		}
+		st.Segments = append(st.Segments, info)
+	}
+
+	return st
+}
@@ -0,0 +1,233 @@
+package wal
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TimelineFile is the marker written to the WAL directory when the WAL was cut at a recovery target.
+const TimelineFile = "TIMELINE"
+
+const timelineDirPrefix = "timeline-"
+
+type Timeline struct {
+	// Target is the recovery target the WAL was cut at.
+	Target string `json:"target"`
+	// LSN is the last record kept, the new timeline continues from LSN+1.
+	LSN uint64 `json:"lsn"`
+	// Archive is the directory with the original segments from the cut one on.
+	Archive   string    `json:"archive"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Segment is the cut segment, the first one archived.
+	Segment uint `json:"segment"`
+	// Pending is set until the segments are archived, Open finishes a pending cut.
+	Pending bool `json:"pending,omitempty"`
+}
+
+// Timeline returns the marker of the last cut, or nil if the WAL was never cut.
+func (w *WAL) Timeline() (*Timeline, error) {
+	return readTimeline(w.opts.dirPath)
+}
+
+func readTimeline(dirPath string) (*Timeline, error) {
+	data, err := os.ReadFile(filepath.Join(dirPath, TimelineFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var t Timeline
+	if err = json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// writeTimeline replaces the marker atomically, it is synced with the directory.
+func writeTimeline(dirPath string, t *Timeline) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dirPath, TimelineFile)
+	if err = writeFileSync(path+".tmp", append(data, '\n')); err != nil {
+		return err
+	}
+	if err = os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	return syncDir(dirPath)
+}
+
+// Truncate drops the records after lsn and starts a new timeline from there.
+// The segments holding the dropped records are moved to an archive directory
+// inside the WAL directory, the kept records of the cut segment are rewritten.
+// The marker is written first, so Open finishes a cut interrupted by a crash.
+// It returns nil if there is nothing after lsn.
+func (w *WAL) Truncate(lsn uint64, target string) (*Timeline, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	segmentIDs := append(append([]uint(nil), w.oldSegmentIDs...), w.activeSegment.id)
+
+	cut := -1
+	for i, id := range segmentIDs {
+		batches, err := w.ReadSegment(id)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range batches {
+			if len(b.Logs) > 0 && b.Logs[len(b.Logs)-1].LSN > lsn {
+				cut = i
+				break
+			}
+		}
+		if cut >= 0 {
+			break
+		}
+	}
+	if cut < 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	t := &Timeline{
+		Target:    target,
+		LSN:       lsn,
+		Archive:   filepath.Join(w.opts.dirPath, timelineDirPrefix+now.Format("20060102T150405.000")),
+		CreatedAt: now,
+		Segment:   segmentIDs[cut],
+		Pending:   true,
+	}
+	if err := writeTimeline(w.opts.dirPath, t); err != nil {
+		return nil, err
+	}
+	if err := w.activeSegment.Close(); err != nil {
+		return nil, err
+	}
+	if err := finishTruncate(w.opts.dirPath, t); err != nil {
+		return nil, err
+	}
+
+	seg, err := openSegmentFile(w.opts.dirPath, segmentIDs[cut], w.opts.maxSizeSegment)
+	if err != nil {
+		return nil, err
+	}
+	w.activeSegment = seg
+	w.dirty = false
+	w.oldSegmentIDs = segmentIDs[:cut]
+	w.lastLSN = lsn
+
+	return t, nil
+}
+
+// finishTruncate moves the segments from the cut one on to the archive, rewrites the records of the
+// cut segment up to the LSN of t and clears Pending of the marker. Its steps can be repeated, nothing
+// is written to the WAL between them.
+func finishTruncate(dirPath string, t *Timeline) error {
+	if err := os.MkdirAll(t.Archive, 0755); err != nil {
+		return err
+	}
+	ids, err := listSegments(dirPath)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id < t.Segment {
+			continue
+		}
+		if _, err = os.Stat(SegmentFileName(t.Archive, id)); err == nil {
+			// the rewritten cut segment
+			continue
+		}
+		if err = os.Rename(SegmentFileName(dirPath, id), SegmentFileName(t.Archive, id)); err != nil {
+			return err
+		}
+	}
+	if err = syncDir(t.Archive); err != nil {
+		return err
+	}
+
+	path := SegmentFileName(dirPath, t.Segment)
+	if _, err = os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if err = rewriteCut(t, path); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	if err = syncDir(dirPath); err != nil {
+		return err
+	}
+
+	t.Pending = false
+	return writeTimeline(dirPath, t)
+}
+
+// rewriteCut writes the records up to the LSN of t of the archived cut segment to path, atomically.
+func rewriteCut(t *Timeline, path string) error {
+	data, err := os.ReadFile(SegmentFileName(t.Archive, t.Segment))
+	if err != nil {
+		return err
+	}
+	batches, err := DecodeBatches(data)
+	if err != nil {
+		return err
+	}
+	var kept []LogData
+	for _, b := range batches {
+		for _, log := range b.Logs {
+			if log.LSN <= t.LSN {
+				kept = append(kept, log)
+			}
+		}
+	}
+
+	var out []byte
+	if len(kept) > 0 {
+		if out, err = EncodeBatch(kept); err != nil {
+			return err
+		}
+	}
+	// the name of the temporary file is not a segment name
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err = writeFileSync(tmp, out); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func writeFileSync(path string, data []byte) error {
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = fd.Write(data); err != nil {
+		_ = fd.Close()
+		return err
+	}
+	if err = fd.Sync(); err != nil {
+		_ = fd.Close()
+		return err
+	}
+
+	return fd.Close()
+}
+
+func syncDir(dir string) error {
+	fd, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	return fd.Sync()
+}
@@ -1,64 +1,149 @@
 package wal
 
 import (
-	"bytes"
-	"encoding/gob"
 	"errors"
 	"fmt"
-	"io"
 	"jokedb/intetnal/storage/engine"
 	"os"
 	"path/filepath"
 	"sort"
+	"sync"
+	"time"
 )
 
 const segmentFileExt = ".seg"
 
 type WAL struct {
+	mu            sync.Mutex
 	opts          options
 	activeSegment *Segment
 	oldSegmentIDs []uint
+	lastLSN       uint64
+	// dirty is set when the active segment has writes that were not synced.
+	dirty    bool
+	lastSync time.Time
+	syncErr  error
+	stopSync chan struct{}
+	syncDone chan struct{}
 }
 
 type LogData struct {
-	Action engine.ActionType
+	// LSN is the log sequence number assigned by Write, it grows monotonically.
+	// Records written before LSNs were introduced have 0.
+	LSN uint64
+	// Timestamp is the wall-clock time of the Write in Unix nanoseconds.
+	Timestamp int64
+	Action    engine.ActionType
+	// DB is the logical database of the record, TargetDB is the second database of MOVE and SWAPDB.
+	DB       int
+	TargetDB int
 	Key,
 	Value string
 }
 
+// Write assigns the next LSNs and the current time to the records and appends them as one batch.
+// With SyncAlways and SyncBatch the batch is on disk when Write returns.
 func (w *WAL) Write(logs []LogData) error {
-	buf := new(bytes.Buffer)
-	enc := gob.NewEncoder(buf)
-	err := enc.Encode(logs)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.syncErr; err != nil {
+		w.syncErr = nil
+		return err
+	}
+
+	now := time.Now().UnixNano()
+	stamped := make([]LogData, len(logs))
+	for i, log := range logs {
+		log.LSN = w.lastLSN + uint64(i) + 1
+		log.Timestamp = now
+		stamped[i] = log
+	}
+	data, err := EncodeBatch(stamped)
 	if err != nil {
 		return err
 	}
 
-	if w.activeSegment.isFull(buf.Len()) {
-		err = w.NewActiveSegment()
+	if w.activeSegment.isFull(len(data)) {
+		err = w.newActiveSegment()
 		if err != nil {
 			return err
 		}
 	}
 
-	err = w.activeSegment.Write(buf.Bytes())
+	err = w.activeSegment.Write(data)
 	if err != nil {
 		return err
 	}
+	w.lastLSN += uint64(len(logs))
+	w.dirty = true
+
+	switch w.opts.syncPolicy {
+	case SyncInterval, SyncNever:
+		return nil
+	default:
+		return w.syncLocked()
+	}
+}
 
-	return w.activeSegment.Sync()
+// LastLSN returns the LSN of the last written record.
+func (w *WAL) LastLSN() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastLSN
 }
 
 func (w *WAL) ActiveSegment() *Segment {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	return w.activeSegment
 }
 
-func (w *WAL) ReadSegments() ([]LogData, error) {
+func (w *WAL) DirPath() string {
+	return w.opts.dirPath
+}
+
+// SegmentIDs returns the ids of all segments in write order, the active one is the last.
+func (w *WAL) SegmentIDs() []uint {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
 	segmentIDs := make([]uint, 0, len(w.oldSegmentIDs)+1)
-	segmentIDs = append(segmentIDs, w.activeSegment.id)
 	segmentIDs = append(segmentIDs, w.oldSegmentIDs...)
+	return append(segmentIDs, w.activeSegment.id)
+}
+
+// Rotate seals the active segment and returns the ids of the sealed segments.
+// The sealed segments are not written anymore and can be copied safely.
+func (w *WAL) Rotate() ([]uint, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.newActiveSegment(); err != nil {
+		return nil, err
+	}
+
+	return append([]uint(nil), w.oldSegmentIDs...), nil
+}
+
+func (w *WAL) ReadSegments() ([]LogData, error) {
+	batches, err := w.ReadBatches()
+	if err != nil {
+		return nil, err
+	}
 
 	var logs []LogData
+	for _, batch := range batches {
+		logs = append(logs, batch.Logs...)
+	}
+	return logs, nil
+}
+
+// ReadBatches returns the batches of all the segments in order, each as it was written by one Write.
+func (w *WAL) ReadBatches() ([]Batch, error) {
+	segmentIDs := w.SegmentIDs()
+
+	var all []Batch
 	var segs []*Segment
 	defer func() {
 		for _, seg := range segs {
@@ -77,30 +162,42 @@ func (w *WAL) ReadSegments() ([]LogData, error) {
 			return nil, err
 		}
 
-		buf := bytes.NewBuffer(data)
-		for {
-			var batch []LogData
-			decoder := gob.NewDecoder(buf)
-			err = decoder.Decode(&batch)
-			if err != nil {
-				if errors.Is(err, io.EOF) {
-					break
-				}
-				return nil, err
-			}
-
-			logs = append(logs, batch...)
+		batches, err := DecodeBatches(data)
+		if err != nil {
+			return nil, fmt.Errorf("segment %s: %w", seg.FileName(), err)
 		}
+		all = append(all, batches...)
 	}
 
-	return logs, nil
+	return all, nil
 }
 
 func (w *WAL) Close() error {
+	if w.stopSync != nil {
+		close(w.stopSync)
+		<-w.syncDone
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.syncLocked(); err != nil {
+		_ = w.activeSegment.fd.Close()
+		return err
+	}
 	return w.activeSegment.fd.Close()
 }
 
 func (w *WAL) NewActiveSegment() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.newActiveSegment()
+}
+
+func (w *WAL) newActiveSegment() error {
+	if err := w.syncLocked(); err != nil {
+		return err
+	}
+
 	newID := w.activeSegment.id + 1
 	seg, err := openSegmentFile(w.opts.dirPath, newID, w.opts.maxSizeSegment)
 	if err != nil {
@@ -132,22 +229,19 @@ func Open(opts ...Option) (*WAL, error) {
 		return nil, err
 	}
 
-	entries, err := os.ReadDir(wal.opts.dirPath)
+	timeline, err := readTimeline(wal.opts.dirPath)
 	if err != nil {
 		return nil, err
 	}
-
-	var segmentIDs []uint
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		var id uint
-		_, err = fmt.Sscanf(entry.Name(), "%d"+segmentFileExt, &id)
-		if err != nil {
-			continue
+	if timeline != nil && timeline.Pending {
+		if err = finishTruncate(wal.opts.dirPath, timeline); err != nil {
+			return nil, fmt.Errorf("finish the cut of the WAL at LSN %d: %w", timeline.LSN, err)
 		}
-		segmentIDs = append(segmentIDs, id)
+	}
+
+	segmentIDs, err := listSegments(wal.opts.dirPath)
+	if err != nil {
+		return nil, err
 	}
 
 	var activeSegment *Segment
@@ -168,10 +262,46 @@ func Open(opts ...Option) (*WAL, error) {
 	}
 
 	wal.activeSegment = activeSegment
+	if wal.lastLSN, err = wal.findLastLSN(); err != nil {
+		return nil, err
+	}
+
+	if o.syncPolicy == SyncInterval {
+		if o.syncInterval <= 0 {
+			o.syncInterval = defaultSyncInterval
+		}
+		wal.stopSync = make(chan struct{})
+		wal.syncDone = make(chan struct{})
+		go wal.syncLoop(o.syncInterval)
+	}
 
 	return wal, nil
 }
 
+// findLastLSN reads the segments from the newest until it finds a record with an LSN.
+func (w *WAL) findLastLSN() (uint64, error) {
+	segmentIDs := w.SegmentIDs()
+	for i := len(segmentIDs) - 1; i >= 0; i-- {
+		batches, err := w.ReadSegment(segmentIDs[i])
+		var corrupt *CorruptError
+		if err != nil && !errors.As(err, &corrupt) {
+			return 0, err
+		}
+
+		var last uint64
+		for _, b := range batches {
+			for _, log := range b.Logs {
+				last = max(last, log.LSN)
+			}
+		}
+		if last > 0 {
+			return last, nil
+		}
+	}
+
+	return 0, nil
+}
+
 func openSegmentFile(dirPath string, id uint, maxSizeSegment uint32) (*Segment, error) {
 	fd, err := os.OpenFile(
 		SegmentFileName(dirPath, id),
@@ -182,14 +312,42 @@ func openSegmentFile(dirPath string, id uint, maxSizeSegment uint32) (*Segment,
 	if err != nil {
 		return nil, err
 	}
+	info, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
 
 	return &Segment{
 		fd:             fd,
 		id:             id,
+		size:           uint32(info.Size()),
 		maxSizeSegment: maxSizeSegment,
 	}, nil
 }
 
+// listSegments returns the IDs of the segment files of dirPath, unsorted.
+func listSegments(dirPath string) ([]uint, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var segmentIDs []uint
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		var id uint
+		if _, err = fmt.Sscanf(entry.Name(), "%d"+segmentFileExt, &id); err != nil {
+			continue
+		}
+		segmentIDs = append(segmentIDs, id)
+	}
+
+	return segmentIDs, nil
+}
+
 func SegmentFileName(dirPath string, id uint) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d"+segmentFileExt, id))
 }
@@ -1,9 +1,11 @@
 package wal_test
 
 import (
+	"encoding/json"
 	"jokedb/intetnal/storage/engine"
 	"jokedb/intetnal/wal"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -60,12 +62,18 @@ func TestWAL(t *testing.T) {
 
 		gotLogs, err := walLog.ReadSegments()
 		require.NoError(t, err)
+		for i := range gotLogs {
+			require.Equal(t, uint64(i+1), gotLogs[i].LSN)
+			require.NotZero(t, gotLogs[i].Timestamp)
+			gotLogs[i].LSN, gotLogs[i].Timestamp = 0, 0
+		}
 
 		require.Equal(t, wantLogs, gotLogs)
+		require.Equal(t, uint64(len(wantLogs)), walLog.LastLSN())
 	})
 
 	t.Run("rotate_segment", func(t *testing.T) {
-		walLog, err := wal.Open(wal.WithDirPath(dirPath), wal.WithMaxSizeSegment(300))
+		walLog, err := wal.Open(wal.WithDirPath(dirPath), wal.WithMaxSizeSegment(450))
 		require.NoError(t, err)
 
 		t.Cleanup(func() {
@@ -86,4 +94,148 @@ func TestWAL(t *testing.T) {
 
 		require.Equal(t, dirPath+"/000000002.seg", walLog.ActiveSegment().FileName())
 	})
+
+	t.Run("inspect_corrupt_tail", func(t *testing.T) {
+		dir := t.TempDir()
+		walLog, err := wal.Open(wal.WithDirPath(dir))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = walLog.Close()
+		})
+
+		err = walLog.Write(logs)
+		require.NoError(t, err)
+		err = walLog.Write(logs)
+		require.NoError(t, err)
+
+		path := wal.SegmentFileName(dir, 1)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, data[:len(data)-3], 0600))
+
+		infos, err := walLog.Inspect()
+		require.NoError(t, err)
+		require.Len(t, infos, 1)
+		require.Equal(t, 1, infos[0].Batches)
+		require.Equal(t, len(logs), infos[0].Records)
+		require.NotNil(t, infos[0].Corrupt)
+		require.Equal(t, int64(len(data)/2), infos[0].Corrupt.Offset)
+
+		batches, err := wal.DecodeBatches(data[:infos[0].Corrupt.Offset])
+		require.NoError(t, err)
+		require.Len(t, batches, 1)
+		require.Len(t, batches[0].Logs, len(logs))
+		require.Equal(t, uint64(len(logs)), batches[0].Logs[len(logs)-1].LSN)
+	})
+	t.Run("truncate_interrupted", func(t *testing.T) {
+		dir := t.TempDir()
+		walLog, err := wal.Open(wal.WithDirPath(dir), wal.WithMaxSizeSegment(450))
+		require.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, walLog.Write(logs))
+		}
+		require.Equal(t, []uint{1, 2}, walLog.SegmentIDs())
+		require.NoError(t, walLog.Close())
+
+		lsns := func(w *wal.WAL) []uint64 {
+			got, errRead := w.ReadSegments()
+			require.NoError(t, errRead)
+			out := make([]uint64, 0, len(got))
+			for _, log := range got {
+				out = append(out, log.LSN)
+			}
+			return out
+		}
+		reopen := func() *wal.WAL {
+			w, errOpen := wal.Open(wal.WithDirPath(dir), wal.WithMaxSizeSegment(450))
+			require.NoError(t, errOpen)
+			t.Cleanup(func() { _ = w.Close() })
+			return w
+		}
+
+		// the crash came after the marker and the move of the last segment
+		archive := filepath.Join(dir, "timeline-test")
+		marker := wal.Timeline{Target: "lsn 4", LSN: 4, Archive: archive, Segment: 1, Pending: true}
+		data, err := json.Marshal(marker)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, wal.TimelineFile), data, 0600))
+		require.NoError(t, os.Mkdir(archive, 0755))
+		require.NoError(t, os.Rename(wal.SegmentFileName(dir, 2), wal.SegmentFileName(archive, 2)))
+
+		walLog = reopen()
+		require.Equal(t, []uint64{1, 2, 3, 4}, lsns(walLog))
+		require.FileExists(t, wal.SegmentFileName(archive, 1))
+		require.FileExists(t, wal.SegmentFileName(archive, 2))
+		timeline, err := walLog.Timeline()
+		require.NoError(t, err)
+		require.False(t, timeline.Pending)
+		require.NoError(t, walLog.Close())
+
+		// the crash came after the rewrite of the cut segment, before the marker was updated
+		require.NoError(t, os.WriteFile(filepath.Join(dir, wal.TimelineFile), data, 0600))
+		walLog = reopen()
+		require.Equal(t, []uint64{1, 2, 3, 4}, lsns(walLog))
+		require.NoError(t, walLog.Write(logs))
+		require.Equal(t, []uint64{1, 2, 3, 4, 5, 6, 7}, lsns(walLog))
+	})
+	t.Run("truncate", func(t *testing.T) {
+		dir := t.TempDir()
+		walLog, err := wal.Open(wal.WithDirPath(dir), wal.WithMaxSizeSegment(450))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = walLog.Close() })
+		for i := 0; i < 3; i++ {
+			require.NoError(t, walLog.Write(logs))
+		}
+
+		timeline, err := walLog.Truncate(2, "lsn 2")
+		require.NoError(t, err)
+		require.Equal(t, uint(1), timeline.Segment)
+		require.False(t, timeline.Pending)
+		require.Equal(t, uint64(2), walLog.LastLSN())
+		got, err := walLog.ReadSegments()
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+
+		saved, err := walLog.Timeline()
+		require.NoError(t, err)
+		require.Equal(t, timeline.Archive, saved.Archive)
+		require.FileExists(t, wal.SegmentFileName(timeline.Archive, 2))
+
+		timeline, err = walLog.Truncate(2, "lsn 2")
+		require.NoError(t, err)
+		require.Nil(t, timeline)
+	})
+	t.Run("sync_policies", func(t *testing.T) {
+		for in, want := range map[string]wal.SyncPolicy{
+			"always":          wal.SyncAlways,
+			"batch":           wal.SyncBatch,
+			"interval":        wal.SyncInterval,
+			"interval(10ms)":  wal.SyncInterval,
+			"never":           wal.SyncNever,
+			"interval(0s)":    -1,
+			"interval(often)": -1,
+			"sometimes":       -1,
+		} {
+			policy, interval, err := wal.ParseSyncPolicy(in)
+			if want < 0 {
+				require.Error(t, err, in)
+				continue
+			}
+			require.NoError(t, err, in)
+			require.Equal(t, want, policy)
+
+			dir := t.TempDir()
+			walLog, err := wal.Open(wal.WithDirPath(dir), wal.WithSync(policy, interval))
+			require.NoError(t, err)
+			require.NoError(t, walLog.Write(logs))
+			require.NoError(t, walLog.Close())
+
+			walLog, err = wal.Open(wal.WithDirPath(dir))
+			require.NoError(t, err)
+			got, err := walLog.ReadSegments()
+			require.NoError(t, err)
+			require.Len(t, got, len(logs), in)
+			require.NoError(t, walLog.Close())
+		}
+	})
 }